@@ -1,7 +1,25 @@
 package main
 
-import "github.com/mojiQAQ/dispatch/modules/ctl"
+import (
+	"fmt"
+	"os"
+
+	"github.com/mojiQAQ/dispatch/modules/ctl"
+)
 
 func main() {
-	ctl.NewServer().Run()
+
+	s := ctl.NewServer()
+
+	// dispatch migrate [up|down [n]|status]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := s.Migrate(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	s.Run()
 }
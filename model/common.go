@@ -10,14 +10,21 @@ type (
 		Message string `json:"message"`
 	}
 
+	// Page 分页查询参数，Cursor 为上一页返回的 NextCursor，为空时查询第一页；
+	// Offset 兼容旧版客户端的偏移分页，仅 Cursor 为空时生效
 	Page struct {
-		Offset int `json:"offset"`
-		Limit  int `json:"limit"`
+		Cursor string `json:"cursor,omitempty"`
+		Offset int    `json:"offset,omitempty"`
+		Limit  int    `json:"limit"`
+		Sort   string `json:"sort"`  // 排序字段
+		Order  string `json:"order"` // 排序方向，asc 或 desc
 	}
 
+	// PageInfo 分页查询结果，NextCursor 为空表示没有更多数据
 	PageInfo struct {
 		*Page
-		Total int `json:"total"`
+		NextCursor string `json:"next_cursor"`
+		Total      int64  `json:"total"` // 符合查询条件的记录总数
 	}
 )
 
@@ -38,11 +38,74 @@ type (
 		COS       COS
 	}
 
+	Session struct {
+		Secret        string // 会话令牌签名密钥，不可提交至版本库，通过环境变量 DISPATCH_SESSION_SECRET 配置
+		Expire        uint   // 访问令牌有效期，单位：分钟
+		RefreshExpire uint   // 刷新令牌有效期，单位：小时
+	}
+
+	OrderConf struct {
+		MaxExtendHours  uint // 进行中订单单次最多延长截止时间，单位：小时，默认 72 小时
+		MaxDurationDays uint // 订单自创建起最长持续时间，单位：天，默认 30 天
+		MaxResubmits    uint // 子订单被驳回后最多重新提交次数，默认 3 次
+		AppealHours     uint // 子订单未通过后可申诉的时间，单位：小时，默认 72 小时
+		AppealSLAHours  uint // 申诉提交后超过该时间未终审则自动支持申诉，单位：小时，默认 72 小时
+
+		ReviewLeaseMinutes uint // 审核员领取待审核子订单的租约时长，单位：分钟，默认 15 分钟
+		ReviewSLAHours     uint // 子订单提交后超过该时间未审核则自动通过，单位：小时，默认 24 小时
+	}
+
+	Payment struct {
+		Provider  string  // 支付实现：wechat（默认，使用真实支付渠道）或 fake（所有渠道均使用模拟支付）
+		NotifyURL string  // 微信支付通知回调地址
+		RefundURL string  // 微信退款结果通知回调地址
+		Fake      FakePay // 模拟支付配置，仅 Provider 为 fake 时生效
+		AllowFake bool    // 显式允许使用模拟支付，未开启时 Provider 为 fake 将拒绝启动，仅用于开发及测试环境
+		Alipay    Alipay  // 支付宝渠道配置
+	}
+
+	Alipay struct {
+		Enable     bool
+		Gateway    string // 支付宝网关
+		AppID      string
+		PrivateKey string // 应用私钥文件路径
+		PublicKey  string // 支付宝公钥文件路径，用于验证回调及响应签名
+		NotifyURL  string // 支付宝异步通知回调地址
+		ReturnURL  string // 支付完成后跳转地址
+	}
+
+	WithdrawConf struct {
+		MaxAmount         int64 // 单笔提现上限，单位：分，默认 20000
+		AutoApproveAmount int64 // 不超过该金额的提现自动审批，单位：分，为 0 时全部需人工审批
+		DailyLimit        int64 // 每日累计提现上限，单位：分，为 0 时不限制
+		MonthlyLimit      int64 // 每月累计提现上限，单位：分，为 0 时不限制
+		FrequentCount     int64 // 24 小时内提现次数达到该值后需人工审批，为 0 时不限制
+		MinCredit         int   // 信誉分低于该值的用户提现需人工审批
+		WorkerMinBalance  int64 // 接单员提现后需保留的最低余额，单位：分，默认 2000
+	}
+
+	ReconConf struct {
+		Enable bool // 是否启用每日对账
+		Hour   uint // 每日对账时间（时），对前一日账单对账，微信账单次日 10 点后可下载
+	}
+
+	FakePay struct {
+		Delay         uint   // 支付通知及转账结果的模拟延迟，单位：秒
+		PayState      string // 模拟支付结果：SUCCESS（默认）、PAYERROR、CLOSED 等
+		TransferState string // 模拟转账结果：SUCCESS（默认）、FAIL 或 PROCESSING（一直处理中）
+		RefundState   string // 模拟退款结果：SUCCESS（默认）、CLOSED 或 ABNORMAL
+	}
+
 	Config struct {
 		*app.ApplicationConfig
 		HTTPSServer HTTPS
 		WXAuth      WXAuth
 		ImageBed    ImageBed
+		Session     Session
+		Order       OrderConf
+		Payment     Payment
+		Recon       ReconConf
+		Withdraw    WithdrawConf
 	}
 
 	ImageBed struct {
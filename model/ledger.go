@@ -0,0 +1,52 @@
+package model
+
+import "gorm.io/gorm"
+
+type (
+	// TLedgerAccount 记账账户，余额为该账户全部分录金额之和
+	TLedgerAccount struct {
+		*gorm.Model
+		Type    AccountType `gorm:"column:type" json:"type"`         // 账户类型
+		OwnerID uint        `gorm:"column:owner_id" json:"owner_id"` // 账户归属，用户钱包为用户 ID，平台账户为 0
+		Balance int64       `gorm:"column:balance" json:"balance"`   // 账户余额，单位：分
+	}
+
+	// TJournalEntry 记账凭证，一笔资金变动对应一条凭证，凭证下所有分录金额之和为 0
+	TJournalEntry struct {
+		*gorm.Model
+		TradeID string    `gorm:"column:trade_id" json:"trade_id"` // 交易 ID
+		Type    TradeType `gorm:"column:type" json:"type"`         // 交易类型
+		Remark  string    `gorm:"column:remark" json:"remark"`     // 备注
+	}
+
+	// TJournalLine 记账分录，金额为正表示账户余额增加，为负表示账户余额减少
+	TJournalLine struct {
+		*gorm.Model
+		EntryID   uint  `gorm:"column:entry_id" json:"entry_id"`     // 关联凭证 ID
+		AccountID uint  `gorm:"column:account_id" json:"account_id"` // 关联账户 ID
+		Amount    int64 `gorm:"column:amount" json:"amount"`         // 金额，单位：分
+		Balance   int64 `gorm:"column:balance" json:"balance"`       // 记账后账户余额，单位：分
+	}
+
+	AccountType int
+)
+
+const (
+	AccountUserWallet     AccountType = iota + 1 // 用户钱包：平台应付用户的余额
+	AccountEscrow                                // 订单托管：按订单记账，已支付订单尚未结算的资金
+	AccountRevenue                               // 平台收入：订单佣金及调账损益
+	AccountWechatClearing                        // 微信清算：与微信支付之间的资金往来，充值时减少，提现时增加
+	AccountOpening                               // 期初权益：启用账本前用户已有余额的来源
+	AccountAlipayClearing                        // 支付宝清算：与支付宝之间的资金往来，充值时减少，提现时增加
+	AccountWithdrawHold                          // 提现冻结：已申请提现、尚未发起转账的资金
+)
+
+var AccountTypeCN = map[AccountType]string{
+	AccountUserWallet:     "用户钱包",
+	AccountEscrow:         "订单托管",
+	AccountRevenue:        "平台收入",
+	AccountWechatClearing: "微信清算",
+	AccountOpening:        "期初权益",
+	AccountAlipayClearing: "支付宝清算",
+	AccountWithdrawHold:   "提现冻结",
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+type (
+	// TSchemaMigration 已执行的数据库迁移版本
+	TSchemaMigration struct {
+		Version   uint      `gorm:"column:version;primaryKey;autoIncrement:false" json:"version"` // 迁移版本号
+		Name      string    `gorm:"column:name" json:"name"`                                      // 迁移名称
+		AppliedAt time.Time `gorm:"column:applied_at" json:"applied_at"`                          // 执行时间
+	}
+)
@@ -0,0 +1,37 @@
+package model
+
+import "gorm.io/gorm"
+
+type (
+	// TPayNotification 支付通知收件箱，保存每一次收到的原始支付通知，用于审计及重放
+	TPayNotification struct {
+		*gorm.Model
+		NotifyID      string      `gorm:"column:notify_id" json:"notify_id"`           // 通知 ID
+		EventType     string      `gorm:"column:event_type" json:"event_type"`         // 通知类型，如 TRANSACTION.SUCCESS
+		OutTradeNo    string      `gorm:"column:out_trade_no" json:"out_trade_no"`     // 商户交易 ID
+		TransactionID string      `gorm:"column:transaction_id" json:"transaction_id"` // 微信交易 ID
+		TradeState    string      `gorm:"column:trade_state" json:"trade_state"`       // 交易状态
+		Payer         string      `gorm:"column:payer" json:"payer"`                   // 付款人 openid
+		Channel       PayChannel  `gorm:"column:channel" json:"channel"`               // 支付渠道
+		Amount        int64       `gorm:"column:amount" json:"amount"`                 // 金额，单位分
+		Payload       string      `gorm:"column:payload" json:"payload"`               // 解密后的通知内容
+		State         NotifyState `gorm:"column:state" json:"state"`                   // 处理状态
+		Result        string      `gorm:"column:result" json:"result"`                 // 处理结果说明
+	}
+
+	NotifyState uint32
+)
+
+const (
+	NotifyStateReceived  NotifyState = iota + 1 // 已接收，待处理
+	NotifyStateProcessed                        // 已入账
+	NotifyStateIgnored                          // 已忽略：非成功状态或重复通知
+	NotifyStateFailed                           // 处理失败，可重放
+)
+
+var NotifyStateCN = map[NotifyState]string{
+	NotifyStateReceived:  "待处理",
+	NotifyStateProcessed: "已入账",
+	NotifyStateIgnored:   "已忽略",
+	NotifyStateFailed:    "处理失败",
+}
@@ -17,6 +17,33 @@ type (
 		*SubOrder
 	}
 
+	// TMasterOrderHistory 进行中订单的变更记录
+	TMasterOrderHistory struct {
+		*gorm.Model
+		MID         uint      `gorm:"column:mid" json:"mid"`                     // 关联订单 ID
+		UserID      uint      `gorm:"column:user_id" json:"user_id"`             // 操作人 ID
+		OldTotal    int64     `gorm:"column:old_total" json:"old_total"`         // 变更前总数量
+		NewTotal    int64     `gorm:"column:new_total" json:"new_total"`         // 变更后总数量
+		OldFinishAt time.Time `gorm:"column:old_finish_at" json:"old_finish_at"` // 变更前截止时间
+		NewFinishAt time.Time `gorm:"column:new_finish_at" json:"new_finish_at"` // 变更后截止时间
+		Amount      int64     `gorm:"column:amount" json:"amount"`               // 补缴金额，单位：分
+	}
+
+	// TOrderEvent 订单事件记录，只追加不修改，记录订单及子订单的操作人、操作及时间，用于还原订单经过
+	TOrderEvent struct {
+		*gorm.Model
+		MID        uint           `gorm:"column:mid" json:"mid"`                 // 关联订单 ID
+		SID        uint           `gorm:"column:sid" json:"sid"`                 // 关联子订单 ID，订单事件为 0
+		Kind       OrderEventKind `gorm:"column:kind" json:"kind"`               // 事件类型
+		From       OrderState     `gorm:"column:from_state" json:"from"`         // 流转前状态，非状态流转事件为 0
+		To         OrderState     `gorm:"column:to_state" json:"to"`             // 流转后状态，非状态流转事件为 0
+		OperatorID uint           `gorm:"column:operator_id" json:"operator_id"` // 操作人 ID，系统自动操作为 0
+		Context    string         `gorm:"column:context" json:"context"`         // 提交凭证快照，提交事件有效
+		Amount     int64          `gorm:"column:amount" json:"amount"`           // 涉及金额，单位：分
+		TradeID    string         `gorm:"column:trade_id" json:"trade_id"`       // 关联交易 ID，对应交易记录的 trade_id
+		Remark     string         `gorm:"column:remark" json:"remark"`           // 备注
+	}
+
 	MasterOrder struct {
 		UUID     string     `gorm:"uuid" json:"uuid"`                            // 订单 UUID
 		Name     string     `gorm:"name" json:"name"`                            // 订单名称
@@ -28,6 +55,11 @@ type (
 		Total    int64      `gorm:"total" json:"total" valid:"required"`         // 总数量
 		Complete int64      `gorm:"complete" json:"complete"`                    // 已完成
 		FinishAt time.Time  `gorm:"finish_at" json:"finish_at" valid:"required"` // 订单截止时间
+
+		Reward    int64 `gorm:"column:reward" json:"reward"`         // 单个任务奖励快照，单位：分，发布时未指定则取平台默认奖励
+		UnitPrice int64 `gorm:"column:unit_price" json:"unit_price"` // 单个任务价格快照（奖励+平台佣金），单位：分
+
+		ReviewMode ReviewMode `gorm:"column:review_mode" json:"review_mode"` // 子订单审核方式，发布时未指定则由平台审核员审核
 	}
 
 	SubOrder struct {
@@ -37,10 +69,21 @@ type (
 		UserID  uint       `gorm:"column:user_id" json:"user_id"` // 创建人 ID
 		State   OrderState `gorm:"column:state" json:"state"`     // 订单状态
 		Context string     `gorm:"column:context" json:"context"` // 截图
+
+		Submits       int          `gorm:"column:submits" json:"submits"`               // 已提交次数
+		RejectReason  RejectReason `gorm:"column:reject_reason" json:"reject_reason"`   // 最近一次驳回原因
+		RejectComment string       `gorm:"column:reject_comment" json:"reject_comment"` // 最近一次驳回说明
+		AppealReason  string       `gorm:"column:appeal_reason" json:"appeal_reason"`   // 申诉理由，不为空表示已申诉
+
+		ReviewerID    uint       `gorm:"column:reviewer_id" json:"reviewer_id"`         // 持有审核租约的审核员 ID
+		LeaseExpireAt *time.Time `gorm:"column:lease_expire_at" json:"lease_expire_at"` // 审核租约到期时间，到期后可由其他审核员领取
 	}
 
-	OrderState int64
-	Platform   int64
+	OrderState     int64
+	Platform       int64
+	OrderEventKind uint32
+	RejectReason   uint32
+	ReviewMode     uint32
 )
 
 const (
@@ -48,10 +91,10 @@ const (
 	/*
 				Created  ----> Cancel
 				   |
-				 Doing
-			       |
-			      / \
-		      Done   Finish
+				 Doing ----> Closing
+			       |            |
+			      / \          / \
+		      Done   Finish   Done  Finish
 	*/
 	//
 	MOrderStateCreated OrderState = iota + 1 // 已创建：此时订单可支付、可修改、可取消或超时未支付自动取消
@@ -59,6 +102,7 @@ const (
 	MOrderStateDoing                         // 进行中：此时订单已支付，接单员可以开始接单
 	MOrderStateDone                          // 已完成：在订单截止时间所有接单人都已完成
 	MOrderStateFinish                        // 已结束：在订单截止时间未全部完成
+	MOrderStateClosing                       // 撤单中：发布人撤单，不再接单，已接受的子订单完成或超时后结束
 )
 
 var MOrderStateCN = map[OrderState]string{
@@ -67,6 +111,14 @@ var MOrderStateCN = map[OrderState]string{
 	MOrderStateDoing:   "进行中",
 	MOrderStateDone:    "已完成",
 	MOrderStateFinish:  "已结束",
+	MOrderStateClosing: "撤单中",
+}
+
+// MOrderTransitions 订单允许的状态流转
+var MOrderTransitions = map[OrderState][]OrderState{
+	MOrderStateCreated: {MOrderStateCancel, MOrderStateDoing},
+	MOrderStateDoing:   {MOrderStateClosing, MOrderStateDone, MOrderStateFinish},
+	MOrderStateClosing: {MOrderStateDone, MOrderStateFinish},
 }
 
 const (
@@ -78,12 +130,16 @@ const (
 			｜			|
 			｜		   / \
 		     -----Reject   Complete
+
+		驳回且不可再提交时为 Failed，可申诉，完整的状态流转见 SOrderTransitions
 	*/
 	SOrderStateAccept   OrderState = iota + 1 // 已接受
 	SOrderStateSubmit                         // 已提交
 	SOrderStateTimeout                        // 超时取消
 	SOrderStateComplete                       // 已完成
-	SOrderStateReject                         // 驳回
+	SOrderStateReject                         // 驳回：可在截止时间前重新提交
+	SOrderStateFailed                         // 未通过：驳回且不可再提交，申诉期内可申诉，申诉期结束后超时取消
+	SOrderStateAppeal                         // 申诉中：由管理员终审
 )
 
 var SOrderStateCN = map[OrderState]string{
@@ -92,6 +148,102 @@ var SOrderStateCN = map[OrderState]string{
 	SOrderStateTimeout:  "超时",
 	SOrderStateComplete: "已完成",
 	SOrderStateReject:   "驳回",
+	SOrderStateFailed:   "未通过",
+	SOrderStateAppeal:   "申诉中",
+}
+
+// SOrderTransitions 子订单允许的状态流转
+var SOrderTransitions = map[OrderState][]OrderState{
+	SOrderStateAccept: {SOrderStateSubmit, SOrderStateTimeout},
+	SOrderStateSubmit: {SOrderStateComplete, SOrderStateReject, SOrderStateFailed},
+	SOrderStateReject: {SOrderStateSubmit, SOrderStateTimeout},
+	SOrderStateFailed: {SOrderStateAppeal, SOrderStateTimeout},
+	SOrderStateAppeal: {SOrderStateComplete, SOrderStateFailed},
+}
+
+const (
+	OrderEventCreated        OrderEventKind = iota + 1 // 创建订单
+	OrderEventPaid                                     // 支付订单
+	OrderEventCancelled                                // 取消订单
+	OrderEventClosing                                  // 撤单
+	OrderEventDone                                     // 订单完成
+	OrderEventFinished                                 // 订单结束
+	OrderEventAmended                                  // 追加订单
+	OrderEventAccepted                                 // 接单
+	OrderEventSubmitted                                // 提交子订单
+	OrderEventApproved                                 // 审核通过
+	OrderEventRejected                                 // 审核驳回
+	OrderEventTimeout                                  // 子订单超时
+	OrderEventRefunded                                 // 退费
+	OrderEventAppealed                                 // 申诉
+	OrderEventAppealApproved                           // 申诉通过
+	OrderEventAppealDenied                             // 申诉驳回
+)
+
+var OrderEventKindCN = map[OrderEventKind]string{
+	OrderEventCreated:        "创建订单",
+	OrderEventPaid:           "支付订单",
+	OrderEventCancelled:      "取消订单",
+	OrderEventClosing:        "撤单",
+	OrderEventDone:           "订单完成",
+	OrderEventFinished:       "订单结束",
+	OrderEventAmended:        "追加订单",
+	OrderEventAccepted:       "接单",
+	OrderEventSubmitted:      "提交",
+	OrderEventApproved:       "审核通过",
+	OrderEventRejected:       "审核驳回",
+	OrderEventTimeout:        "超时",
+	OrderEventRefunded:       "退费",
+	OrderEventAppealed:       "申诉",
+	OrderEventAppealApproved: "申诉通过",
+	OrderEventAppealDenied:   "申诉驳回",
+}
+
+// MOrderStateEvents 订单流转至各状态时记录的事件
+var MOrderStateEvents = map[OrderState]OrderEventKind{
+	MOrderStateDoing:   OrderEventPaid,
+	MOrderStateCancel:  OrderEventCancelled,
+	MOrderStateClosing: OrderEventClosing,
+	MOrderStateDone:    OrderEventDone,
+	MOrderStateFinish:  OrderEventFinished,
+}
+
+// SOrderStateEvents 子订单流转至各状态时记录的事件
+var SOrderStateEvents = map[OrderState]OrderEventKind{
+	SOrderStateSubmit:   OrderEventSubmitted,
+	SOrderStateComplete: OrderEventApproved,
+	SOrderStateReject:   OrderEventRejected,
+	SOrderStateTimeout:  OrderEventTimeout,
+	SOrderStateFailed:   OrderEventRejected,
+	SOrderStateAppeal:   OrderEventAppealed,
+}
+
+const (
+	RejectReasonMismatch   RejectReason = iota + 1 // 凭证与任务要求不符
+	RejectReasonUnclear                            // 凭证不清晰或不完整
+	RejectReasonIncomplete                         // 任务未完成
+	RejectReasonFraud                              // 重复提交或作弊
+	RejectReasonOther                              // 其他
+)
+
+var RejectReasonCN = map[RejectReason]string{
+	RejectReasonMismatch:   "凭证与任务要求不符",
+	RejectReasonUnclear:    "凭证不清晰或不完整",
+	RejectReasonIncomplete: "任务未完成",
+	RejectReasonFraud:      "重复提交或作弊",
+	RejectReasonOther:      "其他",
+}
+
+const (
+	ReviewModeAuditor   ReviewMode = iota + 1 // 平台审核员领取审核
+	ReviewModePublisher                       // 发布人审核自己的订单
+	ReviewModeAuto                            // 提交后自动通过
+)
+
+var ReviewModeCN = map[ReviewMode]string{
+	ReviewModeAuditor:   "平台审核",
+	ReviewModePublisher: "发布人审核",
+	ReviewModeAuto:      "自动通过",
 }
 
 const (
@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+type (
+	// TPlatformPrice 平台定价，由管理员维护
+	TPlatformPrice struct {
+		*gorm.Model
+		*PlatformPrice
+	}
+
+	PlatformPrice struct {
+		Platform       Platform `gorm:"column:platform" json:"platform"`               // 平台
+		BaseReward     int64    `gorm:"column:base_reward" json:"base_reward"`         // 默认单个任务奖励，单位：分
+		MinReward      int64    `gorm:"column:min_reward" json:"min_reward"`           // 发布人自定义奖励下限，单位：分
+		CommissionRate int64    `gorm:"column:commission_rate" json:"commission_rate"` // 平台佣金比例，单位：千分之一
+	}
+)
+
+const (
+	DefaultBaseReward     = 100  // 单位：分
+	DefaultCommissionRate = 1000 // 单位：千分之一
+)
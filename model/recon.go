@@ -0,0 +1,82 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// TReconRun 对账任务，每个渠道每个账单日一条，重新对账时覆盖上次结果
+	TReconRun struct {
+		*gorm.Model
+		Date          string     `gorm:"column:date" json:"date"`                   // 账单日期，如 2006-01-02
+		Channel       PayChannel `gorm:"column:channel" json:"channel"`             // 支付渠道
+		State         ReconState `gorm:"column:state" json:"state"`                 // 对账状态
+		Matched       int        `gorm:"column:matched" json:"matched"`             // 核对一致的记录数
+		Discrepancies int        `gorm:"column:discrepancies" json:"discrepancies"` // 差异记录数
+		Error         string     `gorm:"column:error" json:"error"`                 // 对账失败原因
+	}
+
+	// TReconDiscrepancy 对账差异，管理员核实处理后标记为已处理
+	TReconDiscrepancy struct {
+		*gorm.Model
+		RunID        uint            `gorm:"column:run_id" json:"run_id"`               // 对账任务 ID
+		Date         string          `gorm:"column:date" json:"date"`                   // 账单日期
+		Channel      PayChannel      `gorm:"column:channel" json:"channel"`             // 支付渠道
+		Category     ReconCategory   `gorm:"column:category" json:"category"`           // 业务类别
+		Kind         DiscrepancyKind `gorm:"column:kind" json:"kind"`                   // 差异类型
+		TradeID      string          `gorm:"column:trade_id" json:"trade_id"`           // 本地交易 ID，退款为退款单号
+		RemoteID     string          `gorm:"column:remote_id" json:"remote_id"`         // 渠道单号，如微信订单号、转账批次号
+		LocalAmount  int64           `gorm:"column:local_amount" json:"local_amount"`   // 本地金额，单位分
+		RemoteAmount int64           `gorm:"column:remote_amount" json:"remote_amount"` // 账单金额，单位分
+		LocalState   string          `gorm:"column:local_state" json:"local_state"`     // 本地状态
+		RemoteState  string          `gorm:"column:remote_state" json:"remote_state"`   // 账单状态
+		Resolved     bool            `gorm:"column:resolved" json:"resolved"`           // 是否已处理
+		Resolution   string          `gorm:"column:resolution" json:"resolution"`       // 处理说明
+		ResolvedBy   uint            `gorm:"column:resolved_by" json:"resolved_by"`     // 处理人 ID
+		ResolvedAt   *time.Time      `gorm:"column:resolved_at" json:"resolved_at"`     // 处理时间
+	}
+
+	ReconState      uint32
+	ReconCategory   string
+	DiscrepancyKind uint32
+)
+
+const (
+	ReconStateRunning ReconState = iota + 1 // 对账中
+	ReconStateDone                          // 已完成
+	ReconStateFailed                        // 对账失败
+)
+
+var ReconStateCN = map[ReconState]string{
+	ReconStateRunning: "对账中",
+	ReconStateDone:    "已完成",
+	ReconStateFailed:  "对账失败",
+}
+
+const (
+	ReconCategoryPay      ReconCategory = "pay"      // 充值支付
+	ReconCategoryRefund   ReconCategory = "refund"   // 充值退款
+	ReconCategoryTransfer ReconCategory = "transfer" // 提现转账
+)
+
+var ReconCategoryCN = map[ReconCategory]string{
+	ReconCategoryPay:      "充值",
+	ReconCategoryRefund:   "退款",
+	ReconCategoryTransfer: "提现",
+}
+
+const (
+	DiscrepancyMissingLocal   DiscrepancyKind = iota + 1 // 账单有记录，本地缺失
+	DiscrepancyMissingRemote                             // 本地已成功，账单缺失
+	DiscrepancyAmountMismatch                            // 金额不一致
+	DiscrepancyStateMismatch                             // 状态不一致
+)
+
+var DiscrepancyKindCN = map[DiscrepancyKind]string{
+	DiscrepancyMissingLocal:   "本地缺失",
+	DiscrepancyMissingRemote:  "渠道缺失",
+	DiscrepancyAmountMismatch: "金额不一致",
+	DiscrepancyStateMismatch:  "状态不一致",
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+type (
+	// TReviewSkip 审核员跳过的子订单，仅对跳过时的提交有效，接单员重新提交后可再次分配给该审核员
+	TReviewSkip struct {
+		*gorm.Model
+		SID       uint `gorm:"column:sid" json:"sid"`               // 子订单 ID
+		AuditorID uint `gorm:"column:auditor_id" json:"auditor_id"` // 审核员 ID
+		Submits   int  `gorm:"column:submits" json:"submits"`       // 跳过时子订单的提交次数
+	}
+)
@@ -1,15 +1,23 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type (
+	// TTradeRecord 用户交易记录，由账本按用户钱包分录生成，用于展示用户的资金流水
 	TTradeRecord struct {
 		*gorm.Model
-		TradeID string    `gorm:"column:trade_id" json:"trade_id"` // 交易 ID，如提现信息、充值信息
-		UserID  uint      `gorm:"column:user_id" json:"user_id"`   // 用户 ID
-		Type    TradeType `gorm:"column:type" json:"type"`         // 交易类型
-		Amount  int64     `gorm:"column:amount" json:"amount"`     // 金额
-		Balance int64     `gorm:"column:balance" json:"balance"`   // 余额
+		TradeID string     `gorm:"column:trade_id" json:"trade_id"` // 交易 ID，如提现信息、充值信息
+		UserID  uint       `gorm:"column:user_id" json:"user_id"`   // 用户 ID
+		Type    TradeType  `gorm:"column:type" json:"type"`         // 交易类型
+		Amount  int64      `gorm:"column:amount" json:"amount"`     // 金额
+		Balance int64      `gorm:"column:balance" json:"balance"`   // 余额
+		Remark  string     `gorm:"column:remark" json:"remark"`     // 备注，如调账原因
+		EntryID uint       `gorm:"column:entry_id" json:"entry_id"` // 关联记账凭证 ID，未入账（如充值中）为 0
+		Channel PayChannel `gorm:"column:channel" json:"channel"`   // 支付渠道，充值、提现类交易有效
 	}
 
 	// TWxPayRecord 充值预支付记录
@@ -23,41 +31,107 @@ type (
 		State           WxPayState `gorm:"state" json:"state"`                                // 支付状态
 	}
 
+	// TWxTransferRecord 微信提现转账记录，状态流转见 TransferTransitions
 	TWxTransferRecord struct {
 		*gorm.Model
-		TradeID string     `gorm:"column:trade_id" json:"trade_id"` // 交易 ID，如提现信息、充值信息
-		BatchID string     `gorm:"column:batch_id" json:"batch_id"` // 转账 ID
-		OpenID  string     `gorm:"column:openid" json:"openid"`     // 用户 ID
-		Amount  int64      `gorm:"amount" json:"amount"`            // 金额，单位分
-		State   WxPayState `gorm:"state" json:"state"`              // 转账状态
+		TradeID     string     `gorm:"column:trade_id" json:"trade_id"`           // 交易 ID，如提现信息、充值信息
+		BatchID     string     `gorm:"column:batch_id" json:"batch_id"`           // 转账 ID
+		OpenID      string     `gorm:"column:openid" json:"openid"`               // 用户 ID
+		Amount      int64      `gorm:"amount" json:"amount"`                      // 金额，单位分
+		State       WxPayState `gorm:"state" json:"state"`                        // 转账状态
+		FailReason  string     `gorm:"column:fail_reason" json:"fail_reason"`     // 转账失败原因
+		Attempts    int        `gorm:"column:attempts" json:"attempts"`           // 已查询次数，用于计算查询退避间隔
+		NextQueryAt *time.Time `gorm:"column:next_query_at" json:"next_query_at"` // 下次查询转账结果的时间
+	}
+
+	// TAliPayRecord 支付宝充值预支付记录
+	TAliPayRecord struct {
+		*gorm.Model
+		TradeID    string `gorm:"column:trade_id" json:"trade_id"`         // 交易 ID
+		UserID     uint   `gorm:"column:user_id" json:"user_id"`           // 用户 ID
+		AliTradeNo string `gorm:"column:ali_trade_no" json:"ali_trade_no"` // 支付宝交易号
+		Amount     int64  `gorm:"column:amount" json:"amount"`             // 金额，单位分
+		State      string `gorm:"column:state" json:"state"`               // 支付状态，取值同 TWxPayRecord
+	}
+
+	// TAliTransferRecord 支付宝提现转账记录
+	TAliTransferRecord struct {
+		*gorm.Model
+		TradeID     string     `gorm:"column:trade_id" json:"trade_id"`           // 交易 ID
+		UserID      uint       `gorm:"column:user_id" json:"user_id"`             // 用户 ID
+		OrderID     string     `gorm:"column:order_id" json:"order_id"`           // 支付宝转账单号
+		Account     string     `gorm:"column:account" json:"account"`             // 收款支付宝账号
+		Name        string     `gorm:"column:name" json:"name"`                   // 收款人姓名
+		Amount      int64      `gorm:"column:amount" json:"amount"`               // 金额，单位分
+		State       string     `gorm:"column:state" json:"state"`                 // 转账状态，取值同 TWxTransferRecord
+		FailReason  string     `gorm:"column:fail_reason" json:"fail_reason"`     // 转账失败原因
+		Attempts    int        `gorm:"column:attempts" json:"attempts"`           // 已查询次数
+		NextQueryAt *time.Time `gorm:"column:next_query_at" json:"next_query_at"` // 下次查询转账结果的时间
+	}
+
+	// TRefundRecord 充值退款记录，一笔充值可分多次退款，累计不超过充值金额
+	TRefundRecord struct {
+		*gorm.Model
+		RefundID         string     `gorm:"column:refund_id" json:"refund_id"`                   // 退款单号，同时作为退款交易 ID
+		TradeID          string     `gorm:"column:trade_id" json:"trade_id"`                     // 原充值交易 ID
+		UserID           uint       `gorm:"column:user_id" json:"user_id"`                       // 用户 ID
+		Channel          PayChannel `gorm:"column:channel" json:"channel"`                       // 支付渠道
+		Amount           int64      `gorm:"column:amount" json:"amount"`                         // 退款金额，单位分
+		Total            int64      `gorm:"column:total" json:"total"`                           // 原充值金额，单位分
+		Reason           string     `gorm:"column:reason" json:"reason"`                         // 退款原因
+		ProviderRefundID string     `gorm:"column:provider_refund_id" json:"provider_refund_id"` // 支付渠道退款单号
+		State            string     `gorm:"column:state" json:"state"`                           // 退款状态
 	}
 
 	TradeType  uint32
 	WxPayState string
+	PayChannel string
 )
 
 const (
-	TypeRecharge      TradeType = iota + 1 // 充值
-	TypeWithdraw                           // 提现
-	TypePublishOrder                       // 商家发布订单
-	TypeCompleteOrder                      // 用户完成订单
-	TypeReturnOrder                        // 退费未完成订单
-	TypeRecharging                         // 充值中
-	TypeWithdrawing                        // 提现中
-	TypeRechargeFail                       // 充值失败
-	TypeWithdrawFail                       // 提现失败
+	ChannelWechat PayChannel = "wechat" // 微信支付
+	ChannelAlipay PayChannel = "alipay" // 支付宝
+)
+
+var PayChannelCN = map[PayChannel]string{
+	ChannelWechat: "微信支付",
+	ChannelAlipay: "支付宝",
+}
+
+const (
+	TypeRecharge       TradeType = iota + 1 // 充值
+	TypeWithdraw                            // 提现
+	TypePublishOrder                        // 商家发布订单
+	TypeCompleteOrder                       // 用户完成订单
+	TypeReturnOrder                         // 退费未完成订单
+	TypeRecharging                          // 充值中
+	TypeWithdrawing                         // 提现中
+	TypeRechargeFail                        // 充值失败
+	TypeWithdrawFail                        // 提现失败
+	TypeAdjust                              // 管理员调账
+	TypeWithdrawRefund                      // 提现失败退回
+	TypeOpening                             // 期初余额
+	TypeRefunding                           // 充值退款中
+	TypeRefund                              // 充值已退款
+	TypeRefundFail                          // 充值退款失败
 )
 
 var TradeTypeCN = map[TradeType]string{
-	TypeRecharge:      "充值",
-	TypeWithdraw:      "提现",
-	TypePublishOrder:  "派单",
-	TypeCompleteOrder: "接单",
-	TypeReturnOrder:   "退费",
-	TypeRecharging:    "充值中",
-	TypeWithdrawing:   "提现中",
-	TypeRechargeFail:  "充值失败",
-	TypeWithdrawFail:  "提现失败",
+	TypeRecharge:       "充值",
+	TypeWithdraw:       "提现",
+	TypePublishOrder:   "派单",
+	TypeCompleteOrder:  "接单",
+	TypeReturnOrder:    "退费",
+	TypeRecharging:     "充值中",
+	TypeWithdrawing:    "提现中",
+	TypeRechargeFail:   "充值失败",
+	TypeWithdrawFail:   "提现失败",
+	TypeAdjust:         "调账",
+	TypeWithdrawRefund: "提现退回",
+	TypeOpening:        "期初余额",
+	TypeRefunding:      "退款中",
+	TypeRefund:         "已退款",
+	TypeRefundFail:     "退款失败",
 }
 
 const (
@@ -79,3 +153,29 @@ const (
 
 	WxTransferStateINIT = "INIT"
 )
+
+// 提现转账记录状态：INIT 已创建待提交渠道，ACCEPTED 渠道已受理，PROCESSING 转账中，SUCCESS、FAIL 为终态
+const (
+	TransferStateINIT       = "INIT"
+	TransferStateACCEPTED   = "ACCEPTED"
+	TransferStatePROCESSING = "PROCESSING"
+	TransferStateSUCCESS    = "SUCCESS"
+	TransferStateFAIL       = "FAIL"
+)
+
+// TransferTransitions 提现转账记录允许的状态流转
+var TransferTransitions = map[string][]string{
+	TransferStateINIT:       {TransferStateACCEPTED, TransferStatePROCESSING, TransferStateSUCCESS, TransferStateFAIL},
+	TransferStateACCEPTED:   {TransferStatePROCESSING, TransferStateSUCCESS, TransferStateFAIL},
+	TransferStatePROCESSING: {TransferStateSUCCESS, TransferStateFAIL},
+}
+
+// CanTransitTransfer 校验提现转账记录状态流转是否合法
+func CanTransitTransfer(from, to string) bool {
+	for _, s := range TransferTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
@@ -16,6 +16,7 @@ type (
 		Phone   string `gorm:"column:phone" json:"phone"`     // 电话号码
 		OpenID  string `gorm:"column:openid" json:"openid"`   // 微信 OpenID
 		Credit  int    `gorm:"column:credit" json:"credit"`   // 信誉分
+		Frozen  bool   `gorm:"column:frozen" json:"frozen"`   // 是否冻结
 	}
 
 	Role int
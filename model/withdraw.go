@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// TWithdrawRequest 提现申请，申请时余额转入提现冻结，审批通过后发起转账，驳回则退回余额
+	TWithdrawRequest struct {
+		*gorm.Model
+		TradeID      string        `gorm:"column:trade_id" json:"trade_id"`           // 交易 ID，同时作为转账商户单号
+		UserID       uint          `gorm:"column:user_id" json:"user_id"`             // 用户 ID
+		Channel      PayChannel    `gorm:"column:channel" json:"channel"`             // 提现渠道
+		Amount       int64         `gorm:"column:amount" json:"amount"`               // 金额，单位分
+		Account      string        `gorm:"column:account" json:"account"`             // 收款账号，支付宝有效
+		Name         string        `gorm:"column:name" json:"name"`                   // 收款人姓名，支付宝有效
+		State        WithdrawState `gorm:"column:state" json:"state"`                 // 申请状态
+		ReviewReason string        `gorm:"column:review_reason" json:"review_reason"` // 需人工审批的原因
+		ReviewerID   uint          `gorm:"column:reviewer_id" json:"reviewer_id"`     // 审批人 ID，自动审批为 0
+		ReviewRemark string        `gorm:"column:review_remark" json:"review_remark"` // 审批意见
+		ReviewedAt   *time.Time    `gorm:"column:reviewed_at" json:"reviewed_at"`     // 审批时间
+	}
+
+	WithdrawState uint32
+)
+
+const (
+	WithdrawStatePending     WithdrawState = iota + 1 // 待审批
+	WithdrawStateTransferred                          // 已发起转账，转账结果见提现交易记录
+	WithdrawStateRejected                             // 已驳回
+)
+
+var WithdrawStateCN = map[WithdrawState]string{
+	WithdrawStatePending:     "待审批",
+	WithdrawStateTransferred: "已转账",
+	WithdrawStateRejected:    "已驳回",
+}
@@ -0,0 +1,336 @@
+package alipay
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+)
+
+const ProviderAlipay = "alipay"
+
+type (
+	// Pay 支付宝支付渠道：电脑网站支付充值、单笔转账提现，请求及回调均使用 RSA2 签名
+	Pay struct {
+		*log.Logger
+		Conf   model.Alipay
+		client *http.Client
+
+		privateKey *rsa.PrivateKey
+		publicKey  *rsa.PublicKey
+	}
+
+	// respBase 支付宝接口公共响应参数
+	respBase struct {
+		Code    string `json:"code"`
+		Msg     string `json:"msg"`
+		SubCode string `json:"sub_code"`
+		SubMsg  string `json:"sub_msg"`
+	}
+
+	transferResp struct {
+		respBase
+		OrderID string `json:"order_id"`
+		Status  string `json:"status"`
+	}
+
+	transferQueryResp struct {
+		respBase
+		Status      string `json:"status"`
+		TransAmount string `json:"trans_amount"`
+		FailReason  string `json:"fail_reason"`
+	}
+
+	// apiError 支付宝接口业务错误
+	apiError struct {
+		Method string
+		respBase
+	}
+
+	refundResp struct {
+		respBase
+		TradeNo    string `json:"trade_no"`
+		FundChange string `json:"fund_change"`
+	}
+)
+
+// NewPay 加载应用私钥及支付宝公钥，密钥缺失时返回错误
+func NewPay(logger *log.Logger, cfg model.Alipay) (*Pay, error) {
+
+	privateKey, err := loadPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("load alipay private key failed, err=%s", err.Error())
+	}
+
+	publicKey, err := loadPublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("load alipay public key failed, err=%s", err.Error())
+	}
+
+	return &Pay{
+		Logger:     logger,
+		Conf:       cfg,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}, nil
+}
+
+func (c *Pay) Name() string {
+	return ProviderAlipay
+}
+
+// buildParams 组装公共请求参数并签名
+func (c *Pay) buildParams(method string, biz interface{}, extra map[string]string) (url.Values, error) {
+
+	content, err := json.Marshal(biz)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("app_id", c.Conf.AppID)
+	params.Set("method", method)
+	params.Set("format", "JSON")
+	params.Set("charset", "utf-8")
+	params.Set("sign_type", "RSA2")
+	params.Set("timestamp", time.Now().Format("2006-01-02 15:04:05"))
+	params.Set("version", "1.0")
+	params.Set("biz_content", string(content))
+	for k, v := range extra {
+		params.Set(k, v)
+	}
+
+	sign, err := rsa2Sign(c.privateKey, signContent(params, false))
+	if err != nil {
+		return nil, err
+	}
+
+	params.Set("sign", sign)
+	return params, nil
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("alipay %s failed, code=%s, msg=%s, sub_code=%s, sub_msg=%s",
+		e.Method, e.Code, e.Msg, e.SubCode, e.SubMsg)
+}
+
+// call 调用支付宝接口，校验响应签名后解析响应内容
+func (c *Pay) call(method string, biz interface{}, result interface{}) error {
+
+	params, err := c.buildParams(method, biz, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.Conf.Gateway, "application/x-www-form-urlencoded;charset=utf-8",
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	nodes := make(map[string]json.RawMessage)
+	err = json.Unmarshal(body, &nodes)
+	if err != nil {
+		return err
+	}
+
+	node, ok := nodes[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if !ok {
+		return fmt.Errorf("alipay %s: unexpected response: %s", method, string(body))
+	}
+
+	base := &respBase{}
+	err = json.Unmarshal(node, base)
+	if err != nil {
+		return err
+	}
+
+	if base.Code != "10000" {
+		return &apiError{Method: method, respBase: *base}
+	}
+
+	var sign string
+	err = json.Unmarshal(nodes["sign"], &sign)
+	if err != nil {
+		return fmt.Errorf("alipay %s: missing response sign", method)
+	}
+
+	err = rsa2Verify(c.publicKey, string(node), sign)
+	if err != nil {
+		return fmt.Errorf("alipay %s: verify response sign failed, err=%s", method, err.Error())
+	}
+
+	return json.Unmarshal(node, result)
+}
+
+// Prepay 电脑网站支付：生成签名后的支付链接，由客户端跳转至支付宝完成支付
+func (c *Pay) Prepay(req *payment.PrepayRequest) (*payment.PrepayResult, error) {
+
+	params, err := c.buildParams("alipay.trade.page.pay", map[string]string{
+		"out_trade_no": req.TradeID,
+		"total_amount": fenToYuan(req.Amount),
+		"subject":      req.Desc,
+		"product_code": "FAST_INSTANT_TRADE_PAY",
+	}, map[string]string{
+		"notify_url": c.Conf.NotifyURL,
+		"return_url": c.Conf.ReturnURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.PrepayResult{
+		PrepayID: req.TradeID,
+		PayURL:   c.Conf.Gateway + "?" + params.Encode(),
+	}, nil
+}
+
+// ParseNotify 校验支付宝异步通知签名，交易状态统一转换为微信支付的取值
+func (c *Pay) ParseNotify(req *http.Request) (*payment.Notification, error) {
+
+	err := req.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	params := req.PostForm
+	err = rsa2Verify(c.publicKey, signContent(params, true), params.Get("sign"))
+	if err != nil {
+		return nil, fmt.Errorf("verify alipay notify sign failed, err=%s", err.Error())
+	}
+
+	if params.Get("app_id") != c.Conf.AppID {
+		return nil, fmt.Errorf("alipay notify app_id mismatch: %s", params.Get("app_id"))
+	}
+
+	amount, err := yuanToFen(params.Get("total_amount"))
+	if err != nil {
+		return nil, err
+	}
+
+	state := params.Get("trade_status")
+	switch state {
+	case "TRADE_SUCCESS", "TRADE_FINISHED":
+		state = model.WxPayStateSUCCESS
+	case "TRADE_CLOSED":
+		state = model.WxPayStateCLOSED
+	case "WAIT_BUYER_PAY":
+		state = model.WxPayStateNOTPAY
+	}
+
+	return &payment.Notification{
+		NotifyID:      params.Get("notify_id"),
+		EventType:     params.Get("notify_type"),
+		OutTradeNo:    params.Get("out_trade_no"),
+		TransactionID: params.Get("trade_no"),
+		TradeState:    state,
+		Amount:        amount,
+		Payload:       params.Encode(),
+	}, nil
+}
+
+// Transfer 单笔转账至支付宝账户，收款方为支付宝登录账号，需校验收款人姓名
+func (c *Pay) Transfer(req *payment.TransferRequest) (*payment.TransferResult, error) {
+
+	resp := &transferResp{}
+	err := c.call("alipay.fund.trans.uni.transfer", map[string]interface{}{
+		"out_biz_no":   req.TradeID,
+		"trans_amount": fenToYuan(req.Amount),
+		"product_code": "TRANS_ACCOUNT_NO_PWD",
+		"biz_scene":    "DIRECT_TRANSFER",
+		"order_title":  req.Desc,
+		"payee_info": map[string]string{
+			"identity":      req.Payee,
+			"identity_type": "ALIPAY_LOGON_ID",
+			"name":          req.PayeeName,
+		},
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.TransferResult{BatchID: resp.OrderID}, nil
+}
+
+// QueryTransfer 查询转账结果，转账状态统一转换为微信批量转账的取值
+func (c *Pay) QueryTransfer(tradeID string) (*payment.TransferStatus, error) {
+
+	resp := &transferQueryResp{}
+	err := c.call("alipay.fund.trans.common.query", map[string]string{
+		"out_biz_no":   tradeID,
+		"product_code": "TRANS_ACCOUNT_NO_PWD",
+		"biz_scene":    "DIRECT_TRANSFER",
+	}, resp)
+	if err != nil {
+		var ae *apiError
+		if errors.As(err, &ae) && ae.SubCode == "ORDER_NOT_EXIST" {
+			return nil, payment.ErrTransferNotFound
+		}
+		return nil, err
+	}
+
+	amount, err := yuanToFen(resp.TransAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &payment.TransferStatus{
+		BatchStatus:  payment.TransferStateProcessing,
+		DetailStatus: payment.DetailStateProcessing,
+		Amount:       amount,
+	}
+
+	switch resp.Status {
+	case "SUCCESS":
+		status.BatchStatus, status.DetailStatus = payment.TransferStateFinished, payment.DetailStateSuccess
+	case "FAIL", "CLOSED", "REFUND":
+		status.BatchStatus, status.DetailStatus = payment.TransferStateFinished, payment.DetailStateFail
+		status.FailReason = resp.FailReason
+	}
+
+	return status, nil
+}
+
+// Refund 统一收单交易退款
+func (c *Pay) Refund(req *payment.RefundRequest) (*payment.RefundResult, error) {
+
+	resp := &refundResp{}
+	err := c.call("alipay.trade.refund", map[string]string{
+		"out_trade_no":   req.TradeID,
+		"out_request_no": req.RefundID,
+		"refund_amount":  fenToYuan(req.Amount),
+		"refund_reason":  req.Reason,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &payment.RefundResult{RefundID: resp.TradeNo, Status: payment.DetailStateProcessing}
+	if resp.FundChange == "Y" {
+		result.Status = payment.TradeStateSuccess
+	}
+
+	return result, nil
+}
+
+// ParseRefundNotify 支付宝退款为同步返回结果，不支持退款通知
+func (c *Pay) ParseRefundNotify(req *http.Request) (*payment.RefundNotification, error) {
+	return nil, fmt.Errorf("alipay refund notify is not supported")
+}
@@ -0,0 +1,148 @@
+package alipay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readKey 读取密钥文件，支持 PEM 格式及支付宝开放平台导出的纯 base64 格式
+func readKey(path string) ([]byte, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block != nil {
+		return block.Bytes, nil
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+
+	der, err := readKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return x509.ParsePKCS1PrivateKey(der)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+
+	return rsaKey, nil
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+
+	der, err := readKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+
+	return rsaKey, nil
+}
+
+// signContent 待签名字符串：除 sign 及 sign_type（回调验签时）外的非空参数按参数名排序，以 k=v 形式用 & 拼接
+func signContent(params url.Values, excludeSignType bool) string {
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "sign" || (excludeSignType && k == "sign_type") || len(params.Get(k)) == 0 {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params.Get(k))
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// rsa2Sign RSA2（SHA256WithRSA）签名
+func rsa2Sign(key *rsa.PrivateKey, content string) (string, error) {
+
+	hashed := sha256.Sum256([]byte(content))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// rsa2Verify RSA2（SHA256WithRSA）验签
+func rsa2Verify(key *rsa.PublicKey, content, sign string) error {
+
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(content))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig)
+}
+
+// fenToYuan 分转元，支付宝金额以元为单位并保留两位小数
+func fenToYuan(fen int64) string {
+	return fmt.Sprintf("%d.%02d", fen/100, fen%100)
+}
+
+// yuanToFen 元转分
+func yuanToFen(yuan string) (int64, error) {
+
+	parts := strings.SplitN(yuan, ".", 2)
+	integer, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %s", yuan)
+	}
+
+	var decimal int64
+	if len(parts) == 2 {
+		frac := parts[1]
+		if len(frac) > 2 {
+			return 0, fmt.Errorf("invalid amount: %s", yuan)
+		}
+
+		frac = (frac + "00")[:2]
+		decimal, err = strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount: %s", yuan)
+		}
+	}
+
+	return integer*100 + decimal, nil
+}
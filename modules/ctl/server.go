@@ -7,13 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"git.ucloudadmin.com/unetworks/app/pkg/app"
 	"github.com/gin-gonic/gin"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/alipay"
+	"github.com/mojiQAQ/dispatch/modules/migrate"
 	"github.com/mojiQAQ/dispatch/modules/order"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/price"
+	"github.com/mojiQAQ/dispatch/modules/recon"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 	"github.com/mojiQAQ/dispatch/modules/trade"
 	"github.com/mojiQAQ/dispatch/modules/user"
 )
@@ -25,11 +32,15 @@ type Server struct {
 	cancel context.CancelFunc
 	cfg    *model.Config
 
-	h  *gin.Engine
-	OC *order.Ctl
-	UC *user.Ctl
-	TC *trade.Ctl
-	WC *wechat.Ctl
+	h     *gin.Engine
+	Store repo.Store
+	OC    *order.Ctl
+	PC    *price.Ctl
+	UC    *user.Ctl
+	TC    *trade.Ctl
+	WC    *wechat.Ctl
+	RC    *recon.Ctl
+	PP    map[model.PayChannel]payment.Provider
 }
 
 func NewServer() *Server {
@@ -56,24 +67,172 @@ func (s *Server) Init() {
 		return
 	}
 
+	s.checkMigrations()
+
 	s.h = gin.Default()
 	s.Http = &http.Server{Handler: s.h}
 
 	s.WC = wechat.NewCtl(s.Logger, s.HttpClient(), s.cfg.WXAuth)
-	s.TC = trade.NewCtl(s.Logger, s.Database.Write)
-	s.UC = user.NewCtl(s.Logger, s.Database.Write, s.TC, s.WC)
-	s.OC = order.NewCtl(s.Logger, s.Database.Write, s.UC)
+	s.PP, err = s.newPaymentProviders()
+	if err != nil {
+		s.Panicf("failed init payment provider, err=%s", err.Error())
+		return
+	}
+
+	err = user.LoadSessionSecret(&s.cfg.Session)
+	if err != nil {
+		s.Panicf("failed load session secret, err=%s", err.Error())
+		return
+	}
+
+	s.Store = repo.NewMySQL(s.Database.Write)
+	s.TC = trade.NewCtl(s.Logger, s.Store)
+	s.UC = user.NewCtl(s.Logger, s.Store, s.TC, s.WC, s.PP, s.cfg.Session, s.cfg.Withdraw)
+	s.PC = price.NewCtl(s.Logger, s.Database.Write)
+	s.OC = order.NewCtl(s.Logger, s.Store, s.UC, s.PC, s.TC, s.cfg.Order)
+	s.RC = recon.NewCtl(s.Logger, s.Database.Write, s.UC, s.PP, s.cfg.Recon)
 
 	s.InitRouter()
 	return
 }
 
+// checkMigrations 检查数据库迁移是否已全部执行，未执行时仅记录日志，需通过 migrate 子命令执行
+func (s *Server) checkMigrations() {
+
+	m, err := migrate.NewCtl(s.Logger, s.Database.Write)
+	if err != nil {
+		s.Panicf("failed load migrations, err=%s", err.Error())
+		return
+	}
+
+	pending, err := m.Pending()
+	if err != nil {
+		s.Errorf("check migrations failed, err=%s", err.Error())
+		return
+	}
+
+	if pending != 0 {
+		s.Errorf("database schema is out of date, %d migrations pending, run `migrate up` first", pending)
+	}
+}
+
+// Migrate 执行数据库迁移子命令：up（默认）执行所有未执行的迁移，down [n] 回滚最近 n 个迁移（默认 1），
+// status 查看各版本执行状态
+func (s *Server) Migrate(args []string) error {
+
+	s.Application.Init(s.cfg)
+	err := s.Application.InitDatabase()
+	if err != nil {
+		return fmt.Errorf("failed init database, err=%s", err.Error())
+	}
+
+	m, err := migrate.NewCtl(s.Logger, s.Database.Write)
+	if err != nil {
+		return err
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		done, err := m.Up()
+		for _, d := range done {
+			fmt.Printf("applied %04d_%s\n", d.Version, d.Name)
+		}
+		return err
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid migrate down count: %s", args[1])
+			}
+		}
+
+		done, err := m.Down(n)
+		for _, d := range done {
+			fmt.Printf("reverted %04d_%s\n", d.Version, d.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, st := range status {
+			appliedAt := "pending"
+			if st.Applied {
+				appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-24s %s\n", st.Version, st.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %s, usage: migrate [up|down [n]|status]", cmd)
+	}
+}
+
+// newPaymentProviders 按配置初始化各支付渠道：微信支付默认启用，支付宝按配置启用，
+// Provider 为 fake 时所有渠道均使用本地模拟支付，需显式开启 AllowFake，避免生产环境误用
+func (s *Server) newPaymentProviders() (map[model.PayChannel]payment.Provider, error) {
+
+	cfg := s.cfg.Payment
+	pays := make(map[model.PayChannel]payment.Provider)
+	switch cfg.Provider {
+	case "", payment.ProviderWechat:
+		wx, err := wechat.NewPay(s.Logger, s.cfg.WXAuth, cfg.NotifyURL, cfg.RefundURL)
+		if err != nil {
+			return nil, err
+		}
+		pays[model.ChannelWechat] = wx
+
+		if cfg.Alipay.Enable {
+			ali, err := alipay.NewPay(s.Logger, cfg.Alipay)
+			if err != nil {
+				return nil, err
+			}
+			pays[model.ChannelAlipay] = ali
+		}
+	case payment.ProviderFake:
+		if !cfg.AllowFake {
+			return nil, fmt.Errorf("fake payment provider is not allowed, set Payment.AllowFake in development or test environments")
+		}
+
+		s.Infof("using fake payment provider, notify_url=%s", cfg.NotifyURL)
+		pays[model.ChannelWechat] = payment.NewFake(s.Logger, cfg.NotifyURL, cfg.RefundURL, cfg.Fake)
+		if cfg.Alipay.Enable {
+			pays[model.ChannelAlipay] = payment.NewFake(s.Logger, cfg.Alipay.NotifyURL, "", cfg.Fake)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported payment provider: %s", cfg.Provider)
+	}
+
+	return pays, nil
+}
+
 func (s *Server) InitRouter() {
 	g := s.h.Group("/dispatch")
 	g.StaticFS(s.cfg.ImageBed.RelativePath, http.Dir(s.cfg.ImageBed.Path))
+	s.UC.InitPublicRouter(g)
+
+	// 以下接口均需登录，请求上下文中携带当前用户
+	g.Use(s.UC.Authenticate())
 	s.OC.InitRouter(g)
 	s.UC.InitRouter(g)
-	s.TC.InitRouter(g)
+	s.TC.InitRouter(g.Group("", s.UC.Permit(user.ActionGetTrades)))
+	s.PC.InitRouter(g.Group("", s.UC.Permit(user.ActionGetPrices)))
+
+	// 管理员接口
+	admin := g.Group("/admin", s.UC.Permit(user.ActionAdmin))
+	s.UC.InitAdminRouter(admin)
+	s.OC.InitAdminRouter(admin)
+	s.PC.InitAdminRouter(admin)
+	s.TC.InitAdminRouter(admin)
+	s.RC.InitAdminRouter(admin)
 }
 
 func (s *Server) Start() {
@@ -94,7 +253,9 @@ func (s *Server) Start() {
 			panic(err)
 		}
 	}()
+	s.UC.Start()
 	s.OC.Start()
+	s.RC.Start()
 }
 
 func (s *Server) Stop() {
@@ -0,0 +1,139 @@
+package migrate
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+	"gorm.io/gorm"
+)
+
+// scripts 迁移脚本，文件名格式为 <版本号>_<名称>.up.sql / <版本号>_<名称>.down.sql
+//
+//go:embed sql/*.sql
+var scripts embed.FS
+
+type (
+	// Migration 一个版本的迁移脚本
+	Migration struct {
+		Version uint
+		Name    string
+		Up      string
+		Down    string
+	}
+
+	Ctl struct {
+		*log.Logger
+		db *gorm.DB
+
+		migrations []*Migration
+	}
+)
+
+func NewCtl(logger *log.Logger, db *gorm.DB) (*Ctl, error) {
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ctl{
+		Logger:     logger,
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// loadMigrations 读取内嵌的迁移脚本，按版本号升序排列，每个版本必须同时提供 up 与 down 脚本
+func loadMigrations() ([]*Migration, error) {
+
+	files, err := scripts.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[uint]*Migration)
+	for _, f := range files {
+		name := f.Name()
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+
+		version, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version: %s", name)
+		}
+
+		content, err := scripts.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := versions[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: parts[1]}
+			versions[uint(version)] = m
+		}
+
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("conflicting migration names for version %d: %s, %s", version, m.Name, parts[1])
+		}
+
+		switch direction {
+		case ".up":
+			m.Up = string(content)
+		case ".down":
+			m.Down = string(content)
+		default:
+			return nil, fmt.Errorf("invalid migration direction: %s", name)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(versions))
+	for _, m := range versions {
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			return nil, fmt.Errorf("migration %04d_%s requires both up and down scripts", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// splitStatements 按行尾分号拆分脚本中的语句，忽略 -- 开头的注释行
+func splitStatements(script string) []string {
+
+	stmts := make([]string, 0)
+	lines := make([]string, 0)
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		lines = append(lines, line)
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";"))
+			lines = lines[:0]
+		}
+	}
+
+	if len(lines) != 0 {
+		stmts = append(stmts, strings.TrimSpace(strings.Join(lines, "\n")))
+	}
+
+	return stmts
+}
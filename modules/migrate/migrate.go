@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+const (
+	lockName    = "dispatch_schema_migrate"
+	lockTimeout = 10 // 等待迁移锁的时间，单位：秒
+)
+
+type (
+	// Status 迁移版本的执行状态
+	Status struct {
+		Version   uint       `json:"version"`
+		Name      string     `json:"name"`
+		Applied   bool       `json:"applied"`
+		AppliedAt *time.Time `json:"applied_at"`
+	}
+)
+
+const createVersionTable = "CREATE TABLE IF NOT EXISTS `t_schema_migrations` (" +
+	"`version` BIGINT UNSIGNED NOT NULL, " +
+	"`name` VARCHAR(128) NOT NULL DEFAULT '', " +
+	"`applied_at` DATETIME(3) NULL, " +
+	"PRIMARY KEY (`version`)" +
+	") ENGINE = InnoDB DEFAULT CHARSET = utf8mb4"
+
+// withLock 在同一数据库连接上持有迁移锁执行 fn，避免多个实例同时迁移
+func (c *Ctl) withLock(fn func(conn *gorm.DB) error) error {
+
+	return c.db.Connection(func(conn *gorm.DB) error {
+
+		var got int
+		err := conn.Raw("SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&got).Error
+		if err != nil {
+			return err
+		}
+
+		if got != 1 {
+			return fmt.Errorf("another migration is in progress")
+		}
+
+		defer conn.Exec("SELECT RELEASE_LOCK(?)", lockName)
+
+		err = conn.Exec(createVersionTable).Error
+		if err != nil {
+			return err
+		}
+
+		return fn(conn)
+	})
+}
+
+// applied 查询已执行的迁移版本
+func (c *Ctl) applied(conn *gorm.DB) (map[uint]*model.TSchemaMigration, error) {
+
+	records := make([]*model.TSchemaMigration, 0)
+	err := conn.Model(model.TSchemaMigration{}).Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[uint]*model.TSchemaMigration)
+	for _, r := range records {
+		versions[r.Version] = r
+	}
+
+	return versions, nil
+}
+
+// exec 依次执行脚本中的语句，MySQL 的 DDL 语句会隐式提交，因此迁移不在事务中执行，
+// 中途失败时需根据错误手动修复后重新执行
+func (c *Ctl) exec(conn *gorm.DB, m *Migration, script string) error {
+
+	for _, stmt := range splitStatements(script) {
+		err := conn.Exec(stmt).Error
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %s", m.Version, m.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Up 按版本号升序执行所有未执行的迁移，返回本次执行的迁移
+func (c *Ctl) Up() ([]*Migration, error) {
+
+	done := make([]*Migration, 0)
+	err := c.withLock(func(conn *gorm.DB) error {
+
+		versions, err := c.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range c.migrations {
+			if _, ok := versions[m.Version]; ok {
+				continue
+			}
+
+			c.Infof("applying migration %04d_%s", m.Version, m.Name)
+			err = c.exec(conn, m, m.Up)
+			if err != nil {
+				return err
+			}
+
+			err = conn.Model(model.TSchemaMigration{}).Create(&model.TSchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			done = append(done, m)
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.Errorf("migrate up failed, err=%s", err.Error())
+		return done, err
+	}
+
+	return done, nil
+}
+
+// Down 按版本号降序回滚最近执行的 n 个迁移，返回本次回滚的迁移
+func (c *Ctl) Down(n int) ([]*Migration, error) {
+
+	done := make([]*Migration, 0)
+	err := c.withLock(func(conn *gorm.DB) error {
+
+		versions, err := c.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(c.migrations) - 1; i >= 0 && len(done) < n; i-- {
+			m := c.migrations[i]
+			if _, ok := versions[m.Version]; !ok {
+				continue
+			}
+
+			c.Infof("reverting migration %04d_%s", m.Version, m.Name)
+			err = c.exec(conn, m, m.Down)
+			if err != nil {
+				return err
+			}
+
+			err = conn.Where("version = ?", m.Version).Delete(&model.TSchemaMigration{}).Error
+			if err != nil {
+				return err
+			}
+
+			done = append(done, m)
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.Errorf("migrate down failed, err=%s", err.Error())
+		return done, err
+	}
+
+	return done, nil
+}
+
+// Status 查询所有迁移版本的执行状态
+func (c *Ctl) Status() ([]*Status, error) {
+
+	status := make([]*Status, 0)
+	err := c.withLock(func(conn *gorm.DB) error {
+
+		versions, err := c.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range c.migrations {
+			s := &Status{Version: m.Version, Name: m.Name}
+			if r, ok := versions[m.Version]; ok {
+				s.Applied = true
+				s.AppliedAt = &r.AppliedAt
+			}
+			status = append(status, s)
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.Errorf("get migration status failed, err=%s", err.Error())
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Pending 查询未执行的迁移数量
+func (c *Ctl) Pending() (int, error) {
+
+	status, err := c.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, s := range status {
+		if !s.Applied {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `t_wx_transfer_records`;
+DROP TABLE IF EXISTS `t_wx_pay_records`;
+DROP TABLE IF EXISTS `t_trade_records`;
+DROP TABLE IF EXISTS `t_sub_orders`;
+DROP TABLE IF EXISTS `t_master_orders`;
+DROP TABLE IF EXISTS `t_users`;
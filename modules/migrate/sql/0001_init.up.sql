@@ -0,0 +1,93 @@
+-- 初始表结构：用户、订单、交易及微信支付记录
+
+CREATE TABLE IF NOT EXISTS `t_users` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `name`       VARCHAR(128) NOT NULL DEFAULT '',
+    `avatar`     VARCHAR(512) NOT NULL DEFAULT '',
+    `role`       BIGINT NOT NULL DEFAULT 0,
+    `balance`    BIGINT NOT NULL DEFAULT 0,
+    `phone`      VARCHAR(32) NOT NULL DEFAULT '',
+    `openid`     VARCHAR(64) NOT NULL DEFAULT '',
+    `credit`     BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_users_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_master_orders` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `uuid`       VARCHAR(64) NOT NULL DEFAULT '',
+    `name`       VARCHAR(255) NOT NULL DEFAULT '',
+    `context`    TEXT NULL,
+    `remark`     TEXT NULL,
+    `platform`   BIGINT NOT NULL DEFAULT 0,
+    `user_id`    BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `state`      BIGINT NOT NULL DEFAULT 0,
+    `total`      BIGINT NOT NULL DEFAULT 0,
+    `complete`   BIGINT NOT NULL DEFAULT 0,
+    `finish_at`  DATETIME(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_master_orders_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_sub_orders` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `mid`        BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `uuid`       VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`    BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `state`      BIGINT NOT NULL DEFAULT 0,
+    `context`    TEXT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_sub_orders_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_trade_records` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `trade_id`   VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`    BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `type`       INT UNSIGNED NOT NULL DEFAULT 0,
+    `amount`     BIGINT NOT NULL DEFAULT 0,
+    `balance`    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_trade_records_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_wx_pay_records` (
+    `id`                BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`        DATETIME(3) NULL,
+    `updated_at`        DATETIME(3) NULL,
+    `deleted_at`        DATETIME(3) NULL,
+    `prepay_id`         VARCHAR(128) NOT NULL DEFAULT '',
+    `trade_id`          VARCHAR(64) NOT NULL DEFAULT '',
+    `openid`            VARCHAR(64) NOT NULL DEFAULT '',
+    `wx_transaction_id` VARCHAR(64) NOT NULL DEFAULT '',
+    `amount`            BIGINT NOT NULL DEFAULT 0,
+    `state`             VARCHAR(32) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_wx_pay_records_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_wx_transfer_records` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `trade_id`   VARCHAR(64) NOT NULL DEFAULT '',
+    `batch_id`   VARCHAR(64) NOT NULL DEFAULT '',
+    `openid`     VARCHAR(64) NOT NULL DEFAULT '',
+    `amount`     BIGINT NOT NULL DEFAULT 0,
+    `state`      VARCHAR(32) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_wx_transfer_records_deleted_at` (`deleted_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,22 @@
+ALTER TABLE `t_wx_transfer_records`
+    DROP INDEX `uk_t_wx_transfer_records_trade_id`;
+
+ALTER TABLE `t_wx_pay_records`
+    DROP INDEX `uk_t_wx_pay_records_trade_id`;
+
+ALTER TABLE `t_trade_records`
+    DROP INDEX `idx_t_trade_records_trade_id`,
+    DROP INDEX `idx_t_trade_records_user_id`;
+
+ALTER TABLE `t_sub_orders`
+    DROP INDEX `uk_t_sub_orders_uuid`,
+    DROP INDEX `uk_t_sub_orders_mid_user_id`,
+    DROP INDEX `idx_t_sub_orders_user_id`;
+
+ALTER TABLE `t_master_orders`
+    DROP INDEX `uk_t_master_orders_uuid`,
+    DROP INDEX `idx_t_master_orders_user_id`,
+    DROP INDEX `idx_t_master_orders_state_finish_at`;
+
+ALTER TABLE `t_users`
+    DROP INDEX `idx_t_users_openid`;
@@ -0,0 +1,24 @@
+-- 索引及唯一约束：同一接单员对同一订单只能接一次，交易 ID 在各渠道记录中唯一
+
+ALTER TABLE `t_users`
+    ADD INDEX `idx_t_users_openid` (`openid`);
+
+ALTER TABLE `t_master_orders`
+    ADD UNIQUE INDEX `uk_t_master_orders_uuid` (`uuid`),
+    ADD INDEX `idx_t_master_orders_user_id` (`user_id`),
+    ADD INDEX `idx_t_master_orders_state_finish_at` (`state`, `finish_at`);
+
+ALTER TABLE `t_sub_orders`
+    ADD UNIQUE INDEX `uk_t_sub_orders_uuid` (`uuid`),
+    ADD UNIQUE INDEX `uk_t_sub_orders_mid_user_id` (`mid`, `user_id`),
+    ADD INDEX `idx_t_sub_orders_user_id` (`user_id`);
+
+ALTER TABLE `t_trade_records`
+    ADD INDEX `idx_t_trade_records_trade_id` (`trade_id`),
+    ADD INDEX `idx_t_trade_records_user_id` (`user_id`);
+
+ALTER TABLE `t_wx_pay_records`
+    ADD UNIQUE INDEX `uk_t_wx_pay_records_trade_id` (`trade_id`);
+
+ALTER TABLE `t_wx_transfer_records`
+    ADD UNIQUE INDEX `uk_t_wx_transfer_records_trade_id` (`trade_id`);
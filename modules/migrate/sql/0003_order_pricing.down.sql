@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS `t_platform_prices`;
+DROP TABLE IF EXISTS `t_master_order_histories`;
+
+ALTER TABLE `t_master_orders`
+    DROP COLUMN `reward`,
+    DROP COLUMN `unit_price`;
+
+ALTER TABLE `t_users`
+    DROP COLUMN `frozen`;
@@ -0,0 +1,42 @@
+-- 用户冻结、订单价格快照、订单变更记录及平台定价
+
+ALTER TABLE `t_users`
+    ADD COLUMN `frozen` BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE `t_master_orders`
+    ADD COLUMN `reward`     BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `unit_price` BIGINT NOT NULL DEFAULT 0;
+
+-- 引入价格快照前的订单按原固定价格计费：奖励 1 元，单价 2 元
+UPDATE `t_master_orders` SET `reward` = 100, `unit_price` = 200 WHERE `unit_price` = 0;
+
+CREATE TABLE IF NOT EXISTS `t_master_order_histories` (
+    `id`            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`    DATETIME(3) NULL,
+    `updated_at`    DATETIME(3) NULL,
+    `deleted_at`    DATETIME(3) NULL,
+    `mid`           BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `user_id`       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `old_total`     BIGINT NOT NULL DEFAULT 0,
+    `new_total`     BIGINT NOT NULL DEFAULT 0,
+    `old_finish_at` DATETIME(3) NULL,
+    `new_finish_at` DATETIME(3) NULL,
+    `amount`        BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_master_order_histories_deleted_at` (`deleted_at`),
+    INDEX `idx_t_master_order_histories_mid` (`mid`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_platform_prices` (
+    `id`              BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`      DATETIME(3) NULL,
+    `updated_at`      DATETIME(3) NULL,
+    `deleted_at`      DATETIME(3) NULL,
+    `platform`        BIGINT NOT NULL DEFAULT 0,
+    `base_reward`     BIGINT NOT NULL DEFAULT 0,
+    `min_reward`      BIGINT NOT NULL DEFAULT 0,
+    `commission_rate` BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_platform_prices_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_platform_prices_platform` (`platform`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
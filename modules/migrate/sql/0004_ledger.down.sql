@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS `t_pay_notifications`;
+
+ALTER TABLE `t_trade_records`
+    DROP COLUMN `remark`,
+    DROP COLUMN `entry_id`,
+    DROP COLUMN `channel`;
+
+DROP TABLE IF EXISTS `t_journal_lines`;
+DROP TABLE IF EXISTS `t_journal_entries`;
+DROP TABLE IF EXISTS `t_ledger_accounts`;
@@ -0,0 +1,68 @@
+-- 复式记账账本及支付通知记录，交易记录改由账本分录生成
+
+CREATE TABLE IF NOT EXISTS `t_ledger_accounts` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `type`       BIGINT NOT NULL DEFAULT 0,
+    `owner_id`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `balance`    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_ledger_accounts_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_ledger_accounts_type_owner_id` (`type`, `owner_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_journal_entries` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `trade_id`   VARCHAR(64) NOT NULL DEFAULT '',
+    `type`       INT UNSIGNED NOT NULL DEFAULT 0,
+    `remark`     VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_journal_entries_deleted_at` (`deleted_at`),
+    INDEX `idx_t_journal_entries_trade_id` (`trade_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_journal_lines` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `entry_id`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `account_id` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `amount`     BIGINT NOT NULL DEFAULT 0,
+    `balance`    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_journal_lines_deleted_at` (`deleted_at`),
+    INDEX `idx_t_journal_lines_entry_id` (`entry_id`),
+    INDEX `idx_t_journal_lines_account_id` (`account_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+ALTER TABLE `t_trade_records`
+    ADD COLUMN `remark`   VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN `entry_id` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `channel`  VARCHAR(32) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS `t_pay_notifications` (
+    `id`             BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`     DATETIME(3) NULL,
+    `updated_at`     DATETIME(3) NULL,
+    `deleted_at`     DATETIME(3) NULL,
+    `notify_id`      VARCHAR(64) NOT NULL DEFAULT '',
+    `event_type`     VARCHAR(64) NOT NULL DEFAULT '',
+    `out_trade_no`   VARCHAR(64) NOT NULL DEFAULT '',
+    `transaction_id` VARCHAR(64) NOT NULL DEFAULT '',
+    `trade_state`    VARCHAR(32) NOT NULL DEFAULT '',
+    `payer`          VARCHAR(64) NOT NULL DEFAULT '',
+    `channel`        VARCHAR(32) NOT NULL DEFAULT '',
+    `amount`         BIGINT NOT NULL DEFAULT 0,
+    `payload`        TEXT NULL,
+    `state`          INT UNSIGNED NOT NULL DEFAULT 0,
+    `result`         TEXT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_pay_notifications_deleted_at` (`deleted_at`),
+    INDEX `idx_t_pay_notifications_out_trade_no` (`out_trade_no`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
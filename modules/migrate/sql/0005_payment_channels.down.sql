@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS `t_refund_records`;
+
+ALTER TABLE `t_wx_transfer_records`
+    DROP INDEX `idx_t_wx_transfer_records_state_next_query_at`,
+    DROP COLUMN `fail_reason`,
+    DROP COLUMN `attempts`,
+    DROP COLUMN `next_query_at`;
+
+DROP TABLE IF EXISTS `t_ali_transfer_records`;
+DROP TABLE IF EXISTS `t_ali_pay_records`;
@@ -0,0 +1,64 @@
+-- 支付宝渠道、充值退款及提现转账状态机
+
+CREATE TABLE IF NOT EXISTS `t_ali_pay_records` (
+    `id`           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`   DATETIME(3) NULL,
+    `updated_at`   DATETIME(3) NULL,
+    `deleted_at`   DATETIME(3) NULL,
+    `trade_id`     VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`      BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `ali_trade_no` VARCHAR(64) NOT NULL DEFAULT '',
+    `amount`       BIGINT NOT NULL DEFAULT 0,
+    `state`        VARCHAR(32) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_ali_pay_records_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_ali_pay_records_trade_id` (`trade_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_ali_transfer_records` (
+    `id`            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`    DATETIME(3) NULL,
+    `updated_at`    DATETIME(3) NULL,
+    `deleted_at`    DATETIME(3) NULL,
+    `trade_id`      VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `order_id`      VARCHAR(64) NOT NULL DEFAULT '',
+    `account`       VARCHAR(128) NOT NULL DEFAULT '',
+    `name`          VARCHAR(64) NOT NULL DEFAULT '',
+    `amount`        BIGINT NOT NULL DEFAULT 0,
+    `state`         VARCHAR(32) NOT NULL DEFAULT '',
+    `fail_reason`   VARCHAR(255) NOT NULL DEFAULT '',
+    `attempts`      BIGINT NOT NULL DEFAULT 0,
+    `next_query_at` DATETIME(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_ali_transfer_records_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_ali_transfer_records_trade_id` (`trade_id`),
+    INDEX `idx_t_ali_transfer_records_state_next_query_at` (`state`, `next_query_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+ALTER TABLE `t_wx_transfer_records`
+    ADD COLUMN `fail_reason`   VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN `attempts`      BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `next_query_at` DATETIME(3) NULL,
+    ADD INDEX `idx_t_wx_transfer_records_state_next_query_at` (`state`, `next_query_at`);
+
+CREATE TABLE IF NOT EXISTS `t_refund_records` (
+    `id`                 BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`         DATETIME(3) NULL,
+    `updated_at`         DATETIME(3) NULL,
+    `deleted_at`         DATETIME(3) NULL,
+    `refund_id`          VARCHAR(64) NOT NULL DEFAULT '',
+    `trade_id`           VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`            BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `channel`            VARCHAR(32) NOT NULL DEFAULT '',
+    `amount`             BIGINT NOT NULL DEFAULT 0,
+    `total`              BIGINT NOT NULL DEFAULT 0,
+    `reason`             VARCHAR(255) NOT NULL DEFAULT '',
+    `provider_refund_id` VARCHAR(64) NOT NULL DEFAULT '',
+    `state`              VARCHAR(32) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_refund_records_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_refund_records_refund_id` (`refund_id`),
+    INDEX `idx_t_refund_records_trade_id` (`trade_id`),
+    INDEX `idx_t_refund_records_user_id` (`user_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
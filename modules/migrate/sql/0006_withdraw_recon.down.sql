@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `t_recon_discrepancies`;
+DROP TABLE IF EXISTS `t_recon_runs`;
+DROP TABLE IF EXISTS `t_withdraw_requests`;
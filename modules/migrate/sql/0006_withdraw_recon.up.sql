@@ -0,0 +1,66 @@
+-- 提现审批队列及每日对账
+
+CREATE TABLE IF NOT EXISTS `t_withdraw_requests` (
+    `id`            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`    DATETIME(3) NULL,
+    `updated_at`    DATETIME(3) NULL,
+    `deleted_at`    DATETIME(3) NULL,
+    `trade_id`      VARCHAR(64) NOT NULL DEFAULT '',
+    `user_id`       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `channel`       VARCHAR(32) NOT NULL DEFAULT '',
+    `amount`        BIGINT NOT NULL DEFAULT 0,
+    `account`       VARCHAR(128) NOT NULL DEFAULT '',
+    `name`          VARCHAR(64) NOT NULL DEFAULT '',
+    `state`         INT UNSIGNED NOT NULL DEFAULT 0,
+    `review_reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `reviewer_id`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `review_remark` VARCHAR(255) NOT NULL DEFAULT '',
+    `reviewed_at`   DATETIME(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_withdraw_requests_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_withdraw_requests_trade_id` (`trade_id`),
+    INDEX `idx_t_withdraw_requests_user_id_created_at` (`user_id`, `created_at`),
+    INDEX `idx_t_withdraw_requests_state` (`state`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_recon_runs` (
+    `id`            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`    DATETIME(3) NULL,
+    `updated_at`    DATETIME(3) NULL,
+    `deleted_at`    DATETIME(3) NULL,
+    `date`          VARCHAR(16) NOT NULL DEFAULT '',
+    `channel`       VARCHAR(32) NOT NULL DEFAULT '',
+    `state`         INT UNSIGNED NOT NULL DEFAULT 0,
+    `matched`       BIGINT NOT NULL DEFAULT 0,
+    `discrepancies` BIGINT NOT NULL DEFAULT 0,
+    `error`         TEXT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_recon_runs_deleted_at` (`deleted_at`),
+    INDEX `idx_t_recon_runs_date_channel` (`date`, `channel`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `t_recon_discrepancies` (
+    `id`            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`    DATETIME(3) NULL,
+    `updated_at`    DATETIME(3) NULL,
+    `deleted_at`    DATETIME(3) NULL,
+    `run_id`        BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `date`          VARCHAR(16) NOT NULL DEFAULT '',
+    `channel`       VARCHAR(32) NOT NULL DEFAULT '',
+    `category`      VARCHAR(32) NOT NULL DEFAULT '',
+    `kind`          INT UNSIGNED NOT NULL DEFAULT 0,
+    `trade_id`      VARCHAR(64) NOT NULL DEFAULT '',
+    `remote_id`     VARCHAR(64) NOT NULL DEFAULT '',
+    `local_amount`  BIGINT NOT NULL DEFAULT 0,
+    `remote_amount` BIGINT NOT NULL DEFAULT 0,
+    `local_state`   VARCHAR(32) NOT NULL DEFAULT '',
+    `remote_state`  VARCHAR(32) NOT NULL DEFAULT '',
+    `resolved`      BOOLEAN NOT NULL DEFAULT FALSE,
+    `resolution`    VARCHAR(512) NOT NULL DEFAULT '',
+    `resolved_by`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `resolved_at`   DATETIME(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_recon_discrepancies_deleted_at` (`deleted_at`),
+    INDEX `idx_t_recon_discrepancies_date_channel` (`date`, `channel`),
+    INDEX `idx_t_recon_discrepancies_trade_id` (`trade_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
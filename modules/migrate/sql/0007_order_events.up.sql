@@ -0,0 +1,18 @@
+-- 订单及子订单状态流转记录
+
+CREATE TABLE IF NOT EXISTS `t_order_events` (
+    `id`          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at`  DATETIME(3) NULL,
+    `updated_at`  DATETIME(3) NULL,
+    `deleted_at`  DATETIME(3) NULL,
+    `mid`         BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `sid`         BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `from_state`  BIGINT NOT NULL DEFAULT 0,
+    `to_state`    BIGINT NOT NULL DEFAULT 0,
+    `operator_id` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `remark`      VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_order_events_deleted_at` (`deleted_at`),
+    INDEX `idx_t_order_events_mid` (`mid`),
+    INDEX `idx_t_order_events_sid` (`sid`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
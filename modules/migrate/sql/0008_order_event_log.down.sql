@@ -0,0 +1,5 @@
+ALTER TABLE `t_order_events`
+    DROP COLUMN `trade_id`,
+    DROP COLUMN `amount`,
+    DROP COLUMN `context`,
+    DROP COLUMN `kind`;
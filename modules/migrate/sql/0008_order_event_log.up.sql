@@ -0,0 +1,16 @@
+-- 订单事件记录增加事件类型、提交凭证快照及关联交易
+
+ALTER TABLE `t_order_events`
+    ADD COLUMN `kind`     INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `context`  TEXT NULL,
+    ADD COLUMN `amount`   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `trade_id` VARCHAR(64) NOT NULL DEFAULT '';
+
+-- 已有的状态流转记录按流转后状态补全事件类型
+UPDATE `t_order_events`
+SET `kind` = CASE `to_state` WHEN 3 THEN 2 WHEN 2 THEN 3 WHEN 6 THEN 4 WHEN 4 THEN 5 WHEN 5 THEN 6 ELSE 0 END
+WHERE `sid` = 0 AND `kind` = 0;
+
+UPDATE `t_order_events`
+SET `kind` = CASE `to_state` WHEN 2 THEN 9 WHEN 4 THEN 10 WHEN 5 THEN 11 WHEN 3 THEN 12 ELSE 0 END
+WHERE `sid` <> 0 AND `kind` = 0;
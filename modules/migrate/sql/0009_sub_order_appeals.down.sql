@@ -0,0 +1,5 @@
+ALTER TABLE `t_sub_orders`
+    DROP COLUMN `appeal_reason`,
+    DROP COLUMN `reject_comment`,
+    DROP COLUMN `reject_reason`,
+    DROP COLUMN `submits`;
@@ -0,0 +1,10 @@
+-- 子订单提交次数、驳回原因及申诉
+
+ALTER TABLE `t_sub_orders`
+    ADD COLUMN `submits`        BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `reject_reason`  INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `reject_comment` VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN `appeal_reason`  VARCHAR(512) NOT NULL DEFAULT '';
+
+-- 已提交过的子订单至少提交过一次
+UPDATE `t_sub_orders` SET `submits` = 1 WHERE `state` IN (2, 4, 5);
@@ -0,0 +1,9 @@
+ALTER TABLE `t_order_events`
+    DROP INDEX `idx_t_order_events_operator_id_created_at`;
+
+DROP TABLE IF EXISTS `t_review_skips`;
+
+ALTER TABLE `t_sub_orders`
+    DROP INDEX `idx_t_sub_orders_state`,
+    DROP COLUMN `lease_expire_at`,
+    DROP COLUMN `reviewer_id`;
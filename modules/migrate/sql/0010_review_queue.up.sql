@@ -0,0 +1,24 @@
+-- 审核队列：子订单审核租约及审核员跳过记录
+
+ALTER TABLE `t_sub_orders`
+    ADD COLUMN `reviewer_id`     BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `lease_expire_at` DATETIME(3) NULL,
+    ADD INDEX `idx_t_sub_orders_state` (`state`);
+
+CREATE TABLE IF NOT EXISTS `t_review_skips` (
+    `id`         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created_at` DATETIME(3) NULL,
+    `updated_at` DATETIME(3) NULL,
+    `deleted_at` DATETIME(3) NULL,
+    `sid`        BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `auditor_id` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    `submits`    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    INDEX `idx_t_review_skips_deleted_at` (`deleted_at`),
+    UNIQUE INDEX `uk_t_review_skips_sid_auditor_id_submits` (`sid`, `auditor_id`, `submits`),
+    INDEX `idx_t_review_skips_auditor_id_created_at` (`auditor_id`, `created_at`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- 按审核员统计审核量
+ALTER TABLE `t_order_events`
+    ADD INDEX `idx_t_order_events_operator_id_created_at` (`operator_id`, `created_at`);
@@ -0,0 +1,2 @@
+ALTER TABLE `t_master_orders`
+    DROP COLUMN `review_mode`;
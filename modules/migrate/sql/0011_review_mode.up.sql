@@ -0,0 +1,4 @@
+-- 订单审核方式，已有订单由平台审核员审核
+
+ALTER TABLE `t_master_orders`
+    ADD COLUMN `review_mode` INT UNSIGNED NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+-- 冲回托管期初余额，补开的订单托管账户保留为 0 余额
+
+UPDATE `t_ledger_accounts` AS a
+    JOIN `t_journal_lines` AS l ON l.`account_id` = a.`id`
+    JOIN `t_journal_entries` AS e ON e.`id` = l.`entry_id`
+SET a.`balance` = a.`balance` - l.`amount`, a.`updated_at` = NOW(3)
+WHERE e.`trade_id` = 'escrow-opening' AND e.`type` = 12;
+
+DELETE l
+FROM `t_journal_lines` AS l
+         JOIN `t_journal_entries` AS e ON e.`id` = l.`entry_id`
+WHERE e.`trade_id` = 'escrow-opening' AND e.`type` = 12;
+
+DELETE FROM `t_journal_entries` WHERE `trade_id` = 'escrow-opening' AND `type` = 12;
@@ -0,0 +1,61 @@
+-- 启用订单托管前已进行中的订单补记托管期初余额，原共用托管账户余额一并转出
+
+CREATE TEMPORARY TABLE `tmp_escrow_opening` (
+    `owner_id` BIGINT UNSIGNED NOT NULL,
+    `amount`   BIGINT NOT NULL,
+    PRIMARY KEY (`owner_id`)
+);
+
+-- 进行中订单托管 (总数量 - 已完成) 单，已结束及撤单中订单托管未结算子订单
+INSERT INTO `tmp_escrow_opening` (`owner_id`, `amount`)
+SELECT o.`id`,
+       CASE WHEN o.`state` = 3 THEN (o.`total` - o.`complete`) * o.`unit_price`
+            ELSE COALESCE(s.`active`, 0) * o.`unit_price` END
+FROM `t_master_orders` AS o
+         LEFT JOIN `t_ledger_accounts` AS a ON a.`type` = 2 AND a.`owner_id` = o.`id`
+         LEFT JOIN (SELECT `mid`, COUNT(*) AS `active`
+                    FROM `t_sub_orders`
+                    WHERE `state` IN (1, 2, 5, 6, 7) AND `deleted_at` IS NULL
+                    GROUP BY `mid`) AS s ON s.`mid` = o.`id`
+WHERE o.`state` IN (3, 4, 5, 6) AND o.`deleted_at` IS NULL AND a.`id` IS NULL;
+
+INSERT INTO `tmp_escrow_opening` (`owner_id`, `amount`)
+SELECT `owner_id`, -`balance`
+FROM `t_ledger_accounts`
+WHERE `type` = 2 AND `owner_id` = 0 AND `balance` <> 0;
+
+DELETE FROM `tmp_escrow_opening` WHERE `amount` = 0;
+
+INSERT INTO `t_ledger_accounts` (`created_at`, `updated_at`, `type`, `owner_id`, `balance`)
+SELECT NOW(3), NOW(3), 2, `owner_id`, 0
+FROM `tmp_escrow_opening`
+WHERE `owner_id` <> 0;
+
+INSERT IGNORE INTO `t_ledger_accounts` (`created_at`, `updated_at`, `type`, `owner_id`, `balance`)
+VALUES (NOW(3), NOW(3), 5, 0, 0);
+
+INSERT INTO `t_journal_entries` (`created_at`, `updated_at`, `trade_id`, `type`, `remark`)
+SELECT NOW(3), NOW(3), 'escrow-opening', 12, '启用订单托管期初余额'
+FROM DUAL
+WHERE EXISTS (SELECT 1 FROM `tmp_escrow_opening`);
+
+INSERT INTO `t_journal_lines` (`created_at`, `updated_at`, `entry_id`, `account_id`, `amount`, `balance`)
+SELECT NOW(3), NOW(3), e.`id`, a.`id`, t.`amount`, a.`balance` + t.`amount`
+FROM `tmp_escrow_opening` AS t
+         JOIN `t_ledger_accounts` AS a ON a.`type` = 2 AND a.`owner_id` = t.`owner_id`
+         JOIN `t_journal_entries` AS e ON e.`trade_id` = 'escrow-opening' AND e.`type` = 12;
+
+INSERT INTO `t_journal_lines` (`created_at`, `updated_at`, `entry_id`, `account_id`, `amount`, `balance`)
+SELECT NOW(3), NOW(3), e.`id`, a.`id`, -s.`amount`, a.`balance` - s.`amount`
+FROM (SELECT SUM(`amount`) AS `amount` FROM `tmp_escrow_opening`) AS s
+         JOIN `t_ledger_accounts` AS a ON a.`type` = 5 AND a.`owner_id` = 0
+         JOIN `t_journal_entries` AS e ON e.`trade_id` = 'escrow-opening' AND e.`type` = 12;
+
+-- 分录余额即过账后的账户余额
+UPDATE `t_ledger_accounts` AS a
+    JOIN `t_journal_lines` AS l ON l.`account_id` = a.`id`
+    JOIN `t_journal_entries` AS e ON e.`id` = l.`entry_id`
+SET a.`balance` = l.`balance`, a.`updated_at` = NOW(3)
+WHERE e.`trade_id` = 'escrow-opening' AND e.`type` = 12;
+
+DROP TEMPORARY TABLE `tmp_escrow_opening`;
@@ -0,0 +1,292 @@
+package order
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/user"
+)
+
+type (
+	ReqAppealSubOrder struct {
+		*model.ReqBase
+		Reason string `json:"reason" valid:"required"` // 申诉理由
+	}
+
+	RespAppealSubOrder struct {
+		*model.RespBase
+	}
+
+	ReqGetAppeals struct {
+		*model.ReqBase
+	}
+
+	RespGetAppeals struct {
+		*model.RespBase
+		SubOrders []*SubOrder     `json:"sub_orders"`
+		Page      *model.PageInfo `json:"page"`
+	}
+
+	ReqResolveAppeal struct {
+		*model.ReqBase
+		Approve bool   `json:"approve"` // 是否支持申诉，支持则通过子订单并结算佣金
+		Comment string `json:"comment"` // 终审说明
+	}
+
+	RespResolveAppeal struct {
+		*model.RespBase
+	}
+)
+
+// initAppealRouter 注册接单员申诉接口
+func (c *Ctl) initAppealRouter(g *gin.RouterGroup) {
+
+	// 申诉未通过的子订单
+	g.POST("/orders/:id/sub_orders/:sid/appeal", c.uc.Permit(user.ActionAppealSubOrder), c.OwnSubOrder(),
+		c.HandleAppealSubOrder)
+}
+
+// initAppealAdminRouter 注册申诉终审接口，路由组需已挂载管理员权限校验
+func (c *Ctl) initAppealAdminRouter(g *gin.RouterGroup) {
+
+	// 查询申诉中的子订单
+	g.GET("/appeals", c.HandleGetAppeals)
+
+	// 终审子订单申诉
+	g.PUT("/orders/:id/sub_orders/:sid/appeal", c.HandleResolveAppeal)
+}
+
+// AppealSubOrder 接单员对未通过的子订单申诉，每个子订单仅可申诉一次
+func (c *Ctl) AppealSubOrder(mid, sid uint, reason string) error {
+
+	masterOrder, err := c.GetOrder(mid)
+	if err != nil {
+		return err
+	}
+
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return err
+	}
+
+	return c.transit(&Transit{
+		Order:    masterOrder,
+		Sub:      subOrder,
+		To:       model.SOrderStateAppeal,
+		Operator: subOrder.UserID,
+		Remark:   reason,
+		Fields:   map[string]interface{}{"appeal_reason": reason},
+	})
+}
+
+// checkAppeal 仅可在申诉期内申诉一次
+func (c *Ctl) checkAppeal(t *Transit) error {
+
+	if len(t.Sub.AppealReason) != 0 {
+		return fmt.Errorf("子订单已申诉")
+	}
+
+	if time.Since(t.Sub.UpdatedAt) > time.Hour*time.Duration(c.cfg.AppealHours) {
+		return fmt.Errorf("已超过申诉期 %d 小时", c.cfg.AppealHours)
+	}
+
+	return nil
+}
+
+// ResolveAppeal 管理员终审申诉：支持申诉则通过子订单并补发佣金，否则维持未通过并随即超时取消。operator 为 0 时为系统自动终审
+func (c *Ctl) ResolveAppeal(mid, sid uint, approve bool, comment string, operator uint) error {
+
+	masterOrder, err := c.GetOrder(mid)
+	if err != nil {
+		return err
+	}
+
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return err
+	}
+
+	t := &Transit{
+		Order:    masterOrder,
+		Sub:      subOrder,
+		To:       model.SOrderStateFailed,
+		Kind:     model.OrderEventAppealDenied,
+		Operator: operator,
+		Remark:   comment,
+	}
+
+	if approve {
+		t.To = model.SOrderStateComplete
+		t.Kind = model.OrderEventAppealApproved
+		t.Amount = masterOrder.Reward
+		t.TradeID = subOrder.UUID
+	}
+
+	return c.transit(t)
+}
+
+// GetAppeals 分页查询申诉中的子订单
+func (c *Ctl) GetAppeals(page *model.Page) ([]*model.TSubOrder, *model.PageInfo, error) {
+
+	return c.GetSubOrdersPage(&repo.SubOrderFilter{States: []model.OrderState{model.SOrderStateAppeal}}, page)
+}
+
+// checkFailedOrder 申诉驳回或申诉期结束的未通过子订单超时取消，释放占用的托管资金
+func (c *Ctl) checkFailedOrder() {
+
+	subOrders, err := c.GetSubOrdersPlus(0, 0, []model.OrderState{model.SOrderStateFailed})
+	if err != nil {
+		return
+	}
+
+	for _, so := range subOrders {
+		// 每个子订单仅可申诉一次，申诉驳回后无需等待申诉期结束
+		if len(so.AppealReason) != 0 || time.Since(so.UpdatedAt) >= time.Hour*time.Duration(c.cfg.AppealHours) {
+			err = c.AutoFinishSubOrder(so)
+			if err != nil {
+				c.Errorf("auto finish failed sub order uuid=%s failed, err=%s", so.UUID, err.Error())
+				continue
+			}
+		}
+	}
+}
+
+// checkAppealSLA 申诉后超过终审时限仍未终审的子订单，由系统自动支持申诉
+func (c *Ctl) checkAppealSLA() {
+
+	subOrders, _, err := c.GetSubOrdersPage(&repo.SubOrderFilter{
+		States:        []model.OrderState{model.SOrderStateAppeal},
+		UpdatedBefore: time.Now().Add(-time.Hour * time.Duration(c.cfg.AppealSLAHours)),
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	for _, so := range subOrders {
+		err = c.ResolveAppeal(so.MID, so.ID, true, "超过终审时限自动支持", 0)
+		if err != nil {
+			c.Errorf("auto approve appeal uuid=%s failed, err=%s", so.UUID, err.Error())
+			continue
+		}
+	}
+}
+
+func (c *Ctl) HandleAppealSubOrder(ctx *gin.Context) {
+
+	req := &ReqAppealSubOrder{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	sid, err := strconv.Atoi(ctx.Param("sid"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	err = c.AppealSubOrder(uint(mid), uint(sid), req.Reason)
+	if err != nil {
+		c.Errorf("appeal sub order failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespAppealSubOrder{
+		RespBase: req.GenResponse(nil),
+	})
+}
+
+func (c *Ctl) HandleGetAppeals(ctx *gin.Context) {
+
+	req := &ReqGetAppeals{}
+
+	page, err := repo.ParsePage(ctx.Query, repo.SubOrderSorts...)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	subOrders, info, err := c.GetAppeals(page)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*SubOrder, 0)
+	for _, so := range subOrders {
+		data = append(data, &SubOrder{
+			TSubOrder: so,
+			StateCN:   model.SOrderStateCN[so.State],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetAppeals{
+		RespBase:  req.GenResponse(nil),
+		SubOrders: data,
+		Page:      info,
+	})
+}
+
+func (c *Ctl) HandleResolveAppeal(ctx *gin.Context) {
+
+	req := &ReqResolveAppeal{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	sid, err := strconv.Atoi(ctx.Param("sid"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user := c.uc.CurrentUser(ctx)
+	err = c.ResolveAppeal(uint(mid), uint(sid), req.Approve, req.Comment, user.ID)
+	if err != nil {
+		c.Errorf("resolve appeal failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespResolveAppeal{
+		RespBase: req.GenResponse(nil),
+	})
+}
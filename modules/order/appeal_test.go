@@ -0,0 +1,291 @@
+package order
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+func getSubOrder(t *testing.T, c *Ctl, so *model.TSubOrder) *model.TSubOrder {
+
+	t.Helper()
+
+	so, err := c.GetSubOrderInfo(so.MID, so.ID)
+	if err != nil {
+		t.Fatalf("get sub order failed, err=%s", err)
+	}
+
+	return so
+}
+
+// expire 将子订单最近变更时间前移，模拟已超过申诉期
+func expire(t *testing.T, c *Ctl, so *model.TSubOrder, d time.Duration) {
+
+	t.Helper()
+
+	err := c.store.DB().Model(model.TSubOrder{}).Where("id = ?", so.ID).
+		UpdateColumn("updated_at", time.Now().Add(-d)).Error
+	if err != nil {
+		t.Fatalf("update sub order failed, err=%s", err)
+	}
+}
+
+// TestRejectReason 驳回须给出有效原因，原因及说明记录在子订单上
+func TestRejectReason(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+
+	o := publish(t, c, publisher.ID, 1, model.ReviewModeAuditor)
+	so := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	submit(t, c, so)
+
+	_, err := c.ClaimSubOrder(o.ID, so.ID, auditor.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	for _, reason := range []model.RejectReason{0, model.RejectReasonOther + 1} {
+		err := c.ReviewSubOrder(o.ID, so.ID, model.SOrderStateReject, reason, "", auditor.ID)
+		if err == nil {
+			t.Fatalf("reject with reason %d succeeded", reason)
+		}
+	}
+
+	assertState(t, c, o.ID, so.ID, model.SOrderStateSubmit)
+
+	err = c.ReviewSubOrder(o.ID, so.ID, model.SOrderStateReject, model.RejectReasonUnclear, "看不清", auditor.ID)
+	if err != nil {
+		t.Fatalf("reject sub order failed, err=%s", err)
+	}
+
+	got := getSubOrder(t, c, so)
+	if got.State != model.SOrderStateReject || got.RejectReason != model.RejectReasonUnclear || got.RejectComment != "看不清" {
+		t.Errorf("sub order state=%s reason=%d comment=%q", model.SOrderStateCN[got.State], got.RejectReason, got.RejectComment)
+	}
+}
+
+// TestResubmitLimit 驳回后最多重新提交 MaxResubmits 次，超过后驳回即为未通过且不可再提交
+func TestResubmitLimit(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+
+	o := publish(t, c, publisher.ID, 1, model.ReviewModeAuditor)
+	so := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+
+	for i := 0; i <= int(c.cfg.MaxResubmits); i++ {
+		submit(t, c, so)
+		review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonIncomplete)
+	}
+
+	assertState(t, c, o.ID, so.ID, model.SOrderStateFailed)
+
+	err := c.SubmitSubOrder(o.ID, so.ID, &ReqSubmitSubOrders{Context: "screenshot"})
+	if err == nil {
+		t.Fatalf("submit failed sub order succeeded")
+	}
+
+	if got := getSubOrder(t, c, so).Submits; got != int(c.cfg.MaxResubmits)+1 {
+		t.Errorf("submits=%d, want %d", got, c.cfg.MaxResubmits+1)
+	}
+}
+
+// TestAppealOnce 未通过的子订单仅可在申诉期内申诉一次，申诉驳回后维持未通过
+func TestAppealOnce(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	admin := newTestUser(t, c, model.RoleAdministrator, 0)
+
+	o := publish(t, c, publisher.ID, 3, model.ReviewModeAuditor)
+	s1 := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	s2 := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+
+	for _, so := range []*model.TSubOrder{s1, s2} {
+		submit(t, c, so)
+		review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonFraud)
+		assertState(t, c, o.ID, so.ID, model.SOrderStateFailed)
+	}
+
+	// 驳回后仍可重新提交的子订单不可申诉
+	s3 := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	submit(t, c, s3)
+	review(t, c, s3, auditor.ID, model.SOrderStateReject, model.RejectReasonUnclear)
+
+	err := c.AppealSubOrder(o.ID, s3.ID, "已按要求完成")
+	if err == nil {
+		t.Fatalf("appeal rejected sub order succeeded")
+	}
+
+	err = c.AppealSubOrder(o.ID, s1.ID, "已按要求完成")
+	if err != nil {
+		t.Fatalf("appeal sub order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, s1.ID, model.SOrderStateAppeal)
+
+	err = c.ResolveAppeal(o.ID, s1.ID, false, "维持原判", admin.ID)
+	if err != nil {
+		t.Fatalf("deny appeal failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, s1.ID, model.SOrderStateFailed)
+
+	err = c.AppealSubOrder(o.ID, s1.ID, "再次申诉")
+	if err == nil {
+		t.Fatalf("appeal twice succeeded")
+	}
+
+	// 超过申诉期不可申诉
+	expire(t, c, s2, time.Hour*time.Duration(c.cfg.AppealHours+1))
+	err = c.AppealSubOrder(o.ID, s2.ID, "已按要求完成")
+	if err == nil {
+		t.Fatalf("appeal after appeal window succeeded")
+	}
+
+	assertState(t, c, o.ID, s2.ID, model.SOrderStateFailed)
+	assertLedger(t, c, o.ID, 3*o.UnitPrice)
+}
+
+// TestAppealApprovedAfterFinish 订单截止后支持申诉，子订单通过并从托管中补发佣金
+func TestAppealApprovedAfterFinish(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	admin := newTestUser(t, c, model.RoleAdministrator, 0)
+	worker := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 2, model.ReviewModeAuditor)
+	unit, reward := o.UnitPrice, o.Reward
+	so := accept(t, c, o.ID, worker.ID)
+	submit(t, c, so)
+	review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonFraud)
+
+	err := c.AppealSubOrder(o.ID, so.ID, "已按要求完成")
+	if err != nil {
+		t.Fatalf("appeal sub order failed, err=%s", err)
+	}
+
+	// 截止时仅退回未领取部分，申诉中的子订单仍占用托管
+	err = c.AutoFinishMOrder(reload(t, c, o.ID))
+	if err != nil {
+		t.Fatalf("finish order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, 0, model.MOrderStateFinish)
+	assertLedger(t, c, o.ID, unit)
+
+	err = c.ResolveAppeal(o.ID, so.ID, true, "凭证有效", admin.ID)
+	if err != nil {
+		t.Fatalf("approve appeal failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, so.ID, model.SOrderStateComplete)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - unit, worker.ID: reward})
+	assertLedger(t, c, o.ID, 0)
+
+	if got := reload(t, c, o.ID).Complete; got != 1 {
+		t.Errorf("order complete=%d, want 1", got)
+	}
+
+	if got := balance(t, c, model.AccountRevenue, 0); got != unit-reward {
+		t.Errorf("revenue=%d, want %d", got, unit-reward)
+	}
+}
+
+// TestAppealDeniedRefund 申诉驳回后无需等待申诉期结束，随即取消子订单并退回托管资金
+func TestAppealDeniedRefund(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	admin := newTestUser(t, c, model.RoleAdministrator, 0)
+
+	o := publish(t, c, publisher.ID, 2, model.ReviewModeAuditor)
+	unit := o.UnitPrice
+	s1 := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	s2 := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+
+	for _, so := range []*model.TSubOrder{s1, s2} {
+		submit(t, c, so)
+		review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonFraud)
+	}
+
+	err := c.AppealSubOrder(o.ID, s1.ID, "已按要求完成")
+	if err != nil {
+		t.Fatalf("appeal sub order failed, err=%s", err)
+	}
+
+	err = c.ResolveAppeal(o.ID, s1.ID, false, "维持原判", admin.ID)
+	if err != nil {
+		t.Fatalf("deny appeal failed, err=%s", err)
+	}
+
+	// 截止时未通过的子订单仍占用托管
+	err = c.AutoFinishMOrder(reload(t, c, o.ID))
+	if err != nil {
+		t.Fatalf("finish order failed, err=%s", err)
+	}
+
+	assertLedger(t, c, o.ID, 2*unit)
+
+	c.checkFailedOrder()
+
+	// 未申诉的子订单仍在申诉期内
+	assertState(t, c, o.ID, s1.ID, model.SOrderStateTimeout)
+	assertState(t, c, o.ID, s2.ID, model.SOrderStateFailed)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - unit})
+	assertLedger(t, c, o.ID, unit)
+}
+
+// TestAppealSLA 超过终审时限未终审的申诉由系统自动支持
+func TestAppealSLA(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	w1 := newTestUser(t, c, model.RoleWorker, 0)
+	w2 := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 2, model.ReviewModeAuditor)
+	unit, reward := o.UnitPrice, o.Reward
+	s1 := accept(t, c, o.ID, w1.ID)
+	s2 := accept(t, c, o.ID, w2.ID)
+
+	for _, so := range []*model.TSubOrder{s1, s2} {
+		submit(t, c, so)
+		review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonFraud)
+
+		err := c.AppealSubOrder(o.ID, so.ID, "已按要求完成")
+		if err != nil {
+			t.Fatalf("appeal sub order failed, err=%s", err)
+		}
+	}
+
+	sla := time.Hour * time.Duration(c.cfg.AppealSLAHours)
+	expire(t, c, s1, sla+time.Minute)
+	expire(t, c, s2, sla-time.Minute)
+
+	c.checkAppealSLA()
+
+	assertState(t, c, o.ID, s1.ID, model.SOrderStateComplete)
+	assertState(t, c, o.ID, s2.ID, model.SOrderStateAppeal)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - 2*unit, w1.ID: reward, w2.ID: 0})
+	assertLedger(t, c, o.ID, unit)
+
+	event := &model.TOrderEvent{}
+	err := c.store.DB().Where("sid = ? AND kind = ?", s1.ID, model.OrderEventAppealApproved).First(event).Error
+	if err != nil {
+		t.Fatalf("get appeal approved event failed, err=%s", err)
+	}
+
+	if event.OperatorID != 0 {
+		t.Errorf("appeal approved by %d, want system", event.OperatorID)
+	}
+}
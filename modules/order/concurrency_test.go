@@ -0,0 +1,188 @@
+package order
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+const (
+	raceRounds  = 5 // 并发场景重复轮数
+	raceWorkers = 6 // 每轮接单人数，半数提交后参与审核，其余参与超时
+)
+
+// TestOrderConcurrentSettle 同一订单并发审核通过、撤单、截止结束及子订单超时退费，
+// 结束后托管余额归零，发布人、接单员及平台收入金额与最终完成数量一致，且每个子订单至多结算一次。
+// 该测试校验状态条件更新下每次流转仅结算一次，不覆盖行锁：SQLite 忽略 FOR UPDATE 且写事务整体串行，
+// lockWallet 的行锁由 user 模块基于 MySQL 的钱包并发测试覆盖
+func TestOrderConcurrentSettle(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance*raceRounds)
+
+	for round := 0; round < raceRounds; round++ {
+		o := publish(t, c, publisher.ID, raceWorkers, model.ReviewModePublisher)
+
+		subs := make([]*model.TSubOrder, 0, raceWorkers)
+		for i := 0; i < raceWorkers; i++ {
+			subs = append(subs, accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID))
+		}
+
+		submitted, accepted := subs[:raceWorkers/2], subs[raceWorkers/2:]
+		for _, so := range submitted {
+			submit(t, c, so)
+		}
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs []error
+		)
+		run := func(f func() error) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := f()
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}()
+		}
+
+		// 每个操作并发执行两次，校验重复请求不会重复结算
+		for i := 0; i < 2; i++ {
+			for _, so := range submitted {
+				so := so
+				run(func() error { return c.ApproveSubOrder(o.ID, so.ID, publisher.ID) })
+			}
+
+			for _, so := range accepted {
+				so := so
+				run(func() error { return c.AutoFinishSubOrder(so) })
+			}
+
+			run(func() error { return c.CancelMasterOrder(o.ID, publisher.ID) })
+			run(func() error { return c.AutoFinishMOrder(o) })
+		}
+
+		wg.Wait()
+		assertConflicts(t, errs)
+		settle(t, c, o.ID)
+	}
+
+	assertSettled(t, c, publisher.ID)
+}
+
+// assertConflicts 并发操作仅可因状态已被其他操作变更而失败
+func assertConflicts(t *testing.T, errs []error) {
+
+	t.Helper()
+
+	for _, err := range errs {
+		if err == nil || errors.Is(err, ErrStateChanged) || errors.Is(err, ErrInvalidTransition) ||
+			errors.Is(err, ErrNotCancelable) {
+			continue
+		}
+
+		t.Errorf("unexpected concurrent settle err=%s", err)
+	}
+}
+
+// settle 将订单推进至结束：未结束的子订单超时，撤单中的订单随后结束
+func settle(t *testing.T, c *Ctl, mid uint) {
+
+	t.Helper()
+
+	o := reload(t, c, mid)
+	if o.State == model.MOrderStateDoing {
+		err := c.AutoFinishMOrder(o)
+		if err != nil {
+			t.Fatalf("finish order failed, err=%s", err)
+		}
+	}
+
+	subs, err := c.activeSubOrders(mid)
+	if err != nil {
+		t.Fatalf("get active sub orders failed, err=%s", err)
+	}
+
+	for _, so := range subs {
+		err = c.AutoFinishSubOrder(so)
+		if err != nil {
+			t.Fatalf("timeout sub order failed, err=%s", err)
+		}
+	}
+
+	if o = reload(t, c, mid); o.State == model.MOrderStateClosing {
+		err = c.FinishClosingOrder(o)
+		if err != nil {
+			t.Fatalf("finish closing order failed, err=%s", err)
+		}
+	}
+
+	state := reload(t, c, mid).State
+	if state != model.MOrderStateFinish && state != model.MOrderStateDone {
+		t.Fatalf("order state=%s, want finished", model.MOrderStateCN[state])
+	}
+
+	if got := balance(t, c, model.AccountEscrow, mid); got != 0 {
+		t.Errorf("order %d escrow=%d after settle, want 0", mid, got)
+	}
+}
+
+// assertSettled 按已完成的子订单核对发布人支出、接单员佣金及平台收入
+func assertSettled(t *testing.T, c *Ctl, publisher uint) {
+
+	t.Helper()
+
+	orders, err := c.GetMasterOrders()
+	if err != nil {
+		t.Fatalf("get orders failed, err=%s", err)
+	}
+
+	wallets := map[uint]int64{publisher: testBalance * raceRounds}
+	revenue := int64(0)
+	for _, o := range orders {
+		subs, err := c.GetAllSubOrders(o.ID)
+		if err != nil {
+			t.Fatalf("get sub orders failed, err=%s", err)
+		}
+
+		complete := int64(0)
+		for _, so := range subs {
+			wallets[so.UserID] = 0
+			if so.State != model.SOrderStateComplete {
+				continue
+			}
+
+			complete++
+			wallets[so.UserID] = o.Reward
+
+			// 每个子订单仅结算一次佣金
+			entries, _, err := c.tc.GetJournalEntries(so.UUID)
+			if err != nil {
+				t.Fatalf("get journal entries failed, err=%s", err)
+			}
+
+			if len(entries) != 1 {
+				t.Errorf("sub order %d settled %d times", so.ID, len(entries))
+			}
+		}
+
+		if o.Complete != complete {
+			t.Errorf("order %d complete=%d, completed sub orders=%d", o.ID, o.Complete, complete)
+		}
+
+		wallets[publisher] -= complete * o.UnitPrice
+		revenue += complete * (o.UnitPrice - o.Reward)
+		assertLedger(t, c, o.ID, 0)
+	}
+
+	assertWallets(t, c, wallets)
+
+	if got := balance(t, c, model.AccountRevenue, 0); got != revenue {
+		t.Errorf("revenue=%d, want %d", got, revenue)
+	}
+}
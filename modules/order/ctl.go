@@ -1,35 +1,105 @@
 package order
 
 import (
-	"strconv"
 	"time"
 
-	"gorm.io/gorm"
-
 	"git.ucloudadmin.com/unetworks/app/pkg/log"
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/price"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/trade"
 	"github.com/mojiQAQ/dispatch/modules/user"
 )
 
+const (
+	defaultMaxExtendHours  = 72
+	defaultMaxDurationDays = 30
+	defaultMaxResubmits    = 3
+	defaultAppealHours     = 72
+	defaultAppealSLA       = 72
+	defaultLeaseMinutes    = 15
+	defaultReviewSLA       = 24
+)
+
 type Ctl struct {
 	*log.Logger
-	db *gorm.DB
+	store repo.Store
+	cfg   model.OrderConf
+
+	orders    *Machine
+	subOrders *Machine
 
 	uc *user.Ctl
+	pc *price.Ctl
+	tc *trade.Ctl
 }
 
-func NewCtl(logger *log.Logger, db *gorm.DB, uc *user.Ctl) *Ctl {
-	return &Ctl{
+func NewCtl(logger *log.Logger, store repo.Store, uc *user.Ctl, pc *price.Ctl, tc *trade.Ctl, cfg model.OrderConf) *Ctl {
+
+	if cfg.MaxExtendHours == 0 {
+		cfg.MaxExtendHours = defaultMaxExtendHours
+	}
+
+	if cfg.MaxDurationDays == 0 {
+		cfg.MaxDurationDays = defaultMaxDurationDays
+	}
+
+	if cfg.MaxResubmits == 0 {
+		cfg.MaxResubmits = defaultMaxResubmits
+	}
+
+	if cfg.AppealHours == 0 {
+		cfg.AppealHours = defaultAppealHours
+	}
+
+	if cfg.AppealSLAHours == 0 {
+		cfg.AppealSLAHours = defaultAppealSLA
+	}
+
+	if cfg.ReviewLeaseMinutes == 0 {
+		cfg.ReviewLeaseMinutes = defaultLeaseMinutes
+	}
+
+	if cfg.ReviewSLAHours == 0 {
+		cfg.ReviewSLAHours = defaultReviewSLA
+	}
+
+	c := &Ctl{
 		Logger: logger,
-		db:     db,
+		store:  store,
+		cfg:    cfg,
 
 		uc: uc,
+		pc: pc,
+		tc: tc,
 	}
+
+	c.orders = NewMachine(false, model.MOrderStateCN, model.MOrderTransitions, model.MOrderStateEvents).
+		Hook(model.MOrderStateCreated, model.MOrderStateDoing, c.chargeOrder).
+		Hook(model.MOrderStateDoing, model.MOrderStateClosing, c.refundUnClaimed).
+		Hook(model.MOrderStateDoing, model.MOrderStateFinish, c.refundUnClaimed)
+
+	c.subOrders = NewMachine(true, model.SOrderStateCN, model.SOrderTransitions, model.SOrderStateEvents).
+		Guard(model.SOrderStateReject, model.SOrderStateSubmit, c.checkResubmit).
+		Guard(model.SOrderStateSubmit, model.SOrderStateComplete, c.checkReviewer).
+		Guard(model.SOrderStateSubmit, model.SOrderStateComplete, c.checkComplete).
+		Guard(model.SOrderStateSubmit, model.SOrderStateReject, c.checkReviewer).
+		Guard(model.SOrderStateSubmit, model.SOrderStateFailed, c.checkReviewer).
+		Hook(model.SOrderStateSubmit, model.SOrderStateComplete, c.rewardSubOrder).
+		Hook(model.SOrderStateAccept, model.SOrderStateTimeout, c.refundTimeout).
+		Hook(model.SOrderStateReject, model.SOrderStateTimeout, c.refundTimeout).
+		Guard(model.SOrderStateFailed, model.SOrderStateAppeal, c.checkAppeal).
+		Hook(model.SOrderStateFailed, model.SOrderStateTimeout, c.refundTimeout).
+		Guard(model.SOrderStateAppeal, model.SOrderStateComplete, c.checkComplete).
+		Hook(model.SOrderStateAppeal, model.SOrderStateComplete, c.rewardSubOrder)
+
+	return c
 }
 
 func (c *Ctl) Start() {
 
 	ticker := time.NewTicker(time.Second * 10)
+	escrowTicker := time.NewTicker(time.Hour)
 
 	go func() {
 		for {
@@ -40,6 +110,13 @@ func (c *Ctl) Start() {
 				go c.checkFinishOrder()
 				go c.checkUnPayOrder()
 				go c.checkAcceptOrder()
+				go c.checkClosingOrder()
+				go c.checkFailedOrder()
+				go c.checkAppealSLA()
+				go c.checkReviewSLA()
+			case <-escrowTicker.C:
+				// 核对订单托管余额
+				go c.checkEscrow()
 			}
 		}
 	}()
@@ -48,7 +125,7 @@ func (c *Ctl) Start() {
 // checkUnPayOrder 检查未支付订单
 func (c *Ctl) checkUnPayOrder() {
 
-	orders, err := c.GetMasterOrders("state = ?", model.MOrderStateCreated)
+	orders, err := c.GetMasterOrders(model.MOrderStateCreated)
 	if err != nil {
 		return
 	}
@@ -57,7 +134,7 @@ func (c *Ctl) checkUnPayOrder() {
 		// 如果订单处于待支付状态 10 分钟，则自动取消
 		if order.State == model.MOrderStateCreated {
 			if time.Now().Sub(order.UpdatedAt).Minutes() >= 10 {
-				err = c.changeOrderState(c.db, order.ID, model.MOrderStateCancel)
+				err = c.transit(&Transit{Order: order, To: model.MOrderStateCancel})
 				if err != nil {
 					c.Errorf("cancel timeout order failed, uuid=%s, err=%v", order.UUID, err)
 					continue
@@ -70,7 +147,7 @@ func (c *Ctl) checkUnPayOrder() {
 // checkFinishOrder 自动结束未完成订单
 func (c *Ctl) checkFinishOrder() {
 
-	orders, err := c.GetMasterOrders("state = ?", model.MOrderStateDoing)
+	orders, err := c.GetMasterOrders(model.MOrderStateDoing)
 	if err != nil {
 		return
 	}
@@ -91,10 +168,7 @@ func (c *Ctl) checkFinishOrder() {
 // checkAcceptOrder 自动结束未完成子订单
 func (c *Ctl) checkAcceptOrder() {
 
-	subOrders, err := c.GetSubOrdersPlus(0, 0, []string{
-		strconv.Itoa(int(model.SOrderStateAccept)),
-		strconv.Itoa(int(model.SOrderStateReject)),
-	})
+	subOrders, err := c.GetSubOrdersPlus(0, 0, []model.OrderState{model.SOrderStateAccept, model.SOrderStateReject})
 	if err != nil {
 		return
 	}
@@ -109,3 +183,40 @@ func (c *Ctl) checkAcceptOrder() {
 		}
 	}
 }
+
+// checkReviewSLA 提交后超过审核时限仍未审核的子订单，无论审核方式均由系统自动通过
+func (c *Ctl) checkReviewSLA() {
+
+	subOrders, _, err := c.GetSubOrdersPage(&repo.SubOrderFilter{
+		States:        []model.OrderState{model.SOrderStateSubmit},
+		UpdatedBefore: time.Now().Add(-time.Hour * time.Duration(c.cfg.ReviewSLAHours)),
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	for _, so := range subOrders {
+		err = c.ApproveSubOrder(so.MID, so.ID, 0)
+		if err != nil {
+			c.Errorf("auto approve sub order uuid=%s failed, err=%s", so.UUID, err.Error())
+			continue
+		}
+	}
+}
+
+// checkClosingOrder 结束子订单已全部完成或超时的撤单订单
+func (c *Ctl) checkClosingOrder() {
+
+	orders, err := c.GetMasterOrders(model.MOrderStateClosing)
+	if err != nil {
+		return
+	}
+
+	for _, order := range orders {
+		err = c.FinishClosingOrder(order)
+		if err != nil {
+			c.Errorf("finish closing order uuid=%s failed, err=%s", order.UUID, err.Error())
+			continue
+		}
+	}
+}
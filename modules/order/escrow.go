@@ -0,0 +1,94 @@
+package order
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	// EscrowMismatch 已结束或撤单中订单的托管余额与未结算子订单金额不一致
+	EscrowMismatch struct {
+		OrderID  uint             `json:"order_id"`
+		UUID     string           `json:"uuid"`
+		State    model.OrderState `json:"state"`
+		Active   int64            `json:"active"`
+		Expected int64            `json:"expected"`
+		Escrow   int64            `json:"escrow"`
+	}
+
+	ReqCheckEscrow struct {
+		*model.ReqBase
+	}
+
+	RespCheckEscrow struct {
+		*model.RespBase
+		Mismatches []*EscrowMismatch `json:"mismatches"`
+	}
+)
+
+// GetEscrow 查询订单托管余额
+func (c *Ctl) GetEscrow(mid uint) (int64, error) {
+
+	return c.tc.GetAccountBalance(model.AccountEscrow, mid)
+}
+
+// CheckEscrow 核对已结束及撤单中订单的托管余额：此时仅剩未结算的子订单占用托管资金，
+// 托管余额应等于 未结算子订单数 * 订单单价快照，子订单全部结束后应归零。
+// 以一次聚合查询筛出不一致的订单，未开立托管账户的订单托管余额按 0 计
+func (c *Ctl) CheckEscrow() ([]*EscrowMismatch, error) {
+
+	balances, err := c.store.MasterOrders().EscrowMismatches([]model.OrderState{
+		model.MOrderStateCancel, model.MOrderStateDone, model.MOrderStateFinish, model.MOrderStateClosing,
+	}, activeSubStates)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]*EscrowMismatch, 0, len(balances))
+	for _, b := range balances {
+		mismatches = append(mismatches, &EscrowMismatch{
+			OrderID:  b.MID,
+			UUID:     b.UUID,
+			State:    b.State,
+			Active:   b.Active,
+			Expected: b.Active * b.UnitPrice,
+			Escrow:   b.Escrow,
+		})
+	}
+
+	return mismatches, nil
+}
+
+// checkEscrow 定时核对订单托管余额，不一致时记录错误日志
+func (c *Ctl) checkEscrow() {
+
+	mismatches, err := c.CheckEscrow()
+	if err != nil {
+		c.Errorf("check escrow failed, err=%s", err.Error())
+		return
+	}
+
+	for _, m := range mismatches {
+		c.Errorf("escrow mismatch, uuid=%s, state=%d, expected=%d, escrow=%d",
+			m.UUID, m.State, m.Expected, m.Escrow)
+	}
+}
+
+func (c *Ctl) HandleCheckEscrow(ctx *gin.Context) {
+
+	req := &ReqCheckEscrow{}
+	mismatches, err := c.CheckEscrow()
+	if err != nil {
+		c.Errorf("check escrow failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespCheckEscrow{
+		RespBase:   req.GenResponse(nil),
+		Mismatches: mismatches,
+	})
+}
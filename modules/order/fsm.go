@@ -0,0 +1,194 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+)
+
+var (
+	// ErrStateChanged 流转时订单状态已被并发修改
+	ErrStateChanged = errors.New("订单状态已变更")
+	// ErrInvalidTransition 状态机未声明当前状态至目标状态的流转
+	ErrInvalidTransition = errors.New("不允许的状态流转")
+)
+
+type (
+	// Transit 一次状态流转，Sub 为空时流转订单状态，否则流转子订单状态
+	Transit struct {
+		Order    *model.TMasterOrder
+		Sub      *model.TSubOrder
+		To       model.OrderState
+		Kind     model.OrderEventKind   // 记录的事件类型，为空时取状态机声明的目标状态对应事件
+		Operator uint                   // 操作人 ID，系统自动流转为 0
+		Remark   string                 // 流转备注，记录在事件中
+		Snapshot string                 // 提交凭证快照，记录在事件中
+		Amount   int64                  // 流转涉及的金额，记录在事件中
+		TradeID  string                 // 流转涉及的交易 ID，记录在事件中
+		Fields   map[string]interface{} // 随状态一同更新的字段
+		Expect   map[string]interface{} // 除当前状态外，流转时须仍保持不变的字段
+	}
+
+	// Guard 流转前置条件，返回错误时拒绝流转
+	Guard func(t *Transit) error
+
+	// Hook 流转成功后在同一事务中执行的副作用，返回错误时回滚流转
+	Hook func(tx repo.Store, t *Transit) error
+
+	transition struct {
+		guards []Guard
+		hooks  []Hook
+	}
+
+	// Machine 订单状态机，声明允许的状态流转及各流转的前置条件和副作用；
+	// 所有状态变更均需经由 Fire，以当前状态为条件更新并记录事件
+	Machine struct {
+		sub         bool
+		states      map[model.OrderState]string
+		events      map[model.OrderState]model.OrderEventKind
+		transitions map[model.OrderState]map[model.OrderState]*transition
+	}
+)
+
+// NewMachine 按 transitions 声明的状态流转创建状态机，sub 表示是否为子订单状态机，
+// events 为流转至各状态时记录的事件类型
+func NewMachine(sub bool, states map[model.OrderState]string, transitions map[model.OrderState][]model.OrderState,
+	events map[model.OrderState]model.OrderEventKind) *Machine {
+
+	m := &Machine{
+		sub:         sub,
+		states:      states,
+		events:      events,
+		transitions: make(map[model.OrderState]map[model.OrderState]*transition),
+	}
+
+	for from, tos := range transitions {
+		m.transitions[from] = make(map[model.OrderState]*transition)
+		for _, to := range tos {
+			m.transitions[from][to] = &transition{}
+		}
+	}
+
+	return m
+}
+
+func (m *Machine) get(from, to model.OrderState) *transition {
+
+	t, ok := m.transitions[from][to]
+	if !ok {
+		panic(fmt.Sprintf("undeclared transition: %d -> %d", from, to))
+	}
+
+	return t
+}
+
+// Guard 为 from -> to 的流转添加前置条件，流转须已声明
+func (m *Machine) Guard(from, to model.OrderState, g Guard) *Machine {
+
+	t := m.get(from, to)
+	t.guards = append(t.guards, g)
+	return m
+}
+
+// Hook 为 from -> to 的流转添加副作用，流转须已声明
+func (m *Machine) Hook(from, to model.OrderState, h Hook) *Machine {
+
+	t := m.get(from, to)
+	t.hooks = append(t.hooks, h)
+	return m
+}
+
+// Can 校验状态流转是否合法
+func (m *Machine) Can(from, to model.OrderState) bool {
+
+	_, ok := m.transitions[from][to]
+	return ok
+}
+
+// Fire 在事务 tx 中执行状态流转：校验流转是否合法及前置条件，以当前状态为条件更新状态，
+// 记录事件后依次执行副作用。流转成功后 t 中订单的状态更新为目标状态
+func (m *Machine) Fire(tx repo.Store, t *Transit) error {
+
+	from := t.Order.State
+	if m.sub {
+		from = t.Sub.State
+	}
+
+	tr, ok := m.transitions[from][t.To]
+	if !ok {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, m.states[from], m.states[t.To])
+	}
+
+	for _, g := range tr.guards {
+		err := g(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	fields := map[string]interface{}{"state": t.To}
+	for k, v := range t.Fields {
+		fields[k] = v
+	}
+
+	if t.Kind == 0 {
+		t.Kind = m.events[t.To]
+	}
+
+	event := &model.TOrderEvent{
+		MID:        t.Order.ID,
+		Kind:       t.Kind,
+		From:       from,
+		To:         t.To,
+		OperatorID: t.Operator,
+		Context:    t.Snapshot,
+		Amount:     t.Amount,
+		TradeID:    t.TradeID,
+		Remark:     t.Remark,
+	}
+
+	expect := map[string]interface{}{"state": from}
+	for k, v := range t.Expect {
+		expect[k] = v
+	}
+
+	var (
+		updated bool
+		err     error
+	)
+	if m.sub {
+		event.SID = t.Sub.ID
+		updated, err = tx.SubOrders().UpdateIf(t.Sub.ID, expect, fields)
+	} else {
+		updated, err = tx.MasterOrders().UpdateIf(t.Order.ID, expect, fields)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return ErrStateChanged
+	}
+
+	err = tx.MasterOrders().AddEvent(event)
+	if err != nil {
+		return err
+	}
+
+	if m.sub {
+		t.Sub.State = t.To
+	} else {
+		t.Order.State = t.To
+	}
+
+	for _, h := range tr.hooks {
+		err = h(tx, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,454 @@
+package order
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/price"
+	"github.com/mojiQAQ/dispatch/modules/repo/repotest"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+	"github.com/mojiQAQ/dispatch/modules/user"
+)
+
+const testBalance = 10000 // 测试发布人初始余额，单位：分
+
+// newTestCtl 基于临时 SQLite 数据库及模拟支付创建订单模块，各模块与服务启动时的组装方式一致
+func newTestCtl(t *testing.T) *Ctl {
+
+	store, err := repotest.NewSQLite(filepath.Join(t.TempDir(), "dispatch.db"))
+	if err != nil {
+		t.Fatalf("open sqlite failed, err=%s", err)
+	}
+
+	logger := &log.Logger{}
+	pays := map[model.PayChannel]payment.Provider{
+		model.ChannelWechat: payment.NewFake(logger, "", "", model.FakePay{}),
+	}
+
+	tc := trade.NewCtl(logger, store)
+	uc := user.NewCtl(logger, store, tc, nil, pays, model.Session{}, model.WithdrawConf{})
+	pc := price.NewCtl(logger, store.DB())
+	return NewCtl(logger, store, uc, pc, tc, model.OrderConf{})
+}
+
+// newTestUser 创建用户，余额在首次记账时作为期初余额入账
+func newTestUser(t *testing.T, c *Ctl, role model.Role, balance int64) *model.TUser {
+
+	u := &model.TUser{User: &model.User{
+		Name:    fmt.Sprintf("%s-%d", model.RoleCN[role], time.Now().UnixNano()),
+		Role:    role,
+		Balance: balance,
+		OpenID:  fmt.Sprintf("openid-%d", time.Now().UnixNano()),
+	}}
+
+	err := c.store.Users().Create(u)
+	if err != nil {
+		t.Fatalf("create user failed, err=%s", err)
+	}
+
+	return u
+}
+
+func publish(t *testing.T, c *Ctl, publisher uint, total int64, mode model.ReviewMode) *model.TMasterOrder {
+
+	t.Helper()
+
+	o, err := c.PublishOrder(&model.MasterOrder{
+		Name:       "lifecycle",
+		Platform:   model.PlatformTB,
+		Total:      total,
+		FinishAt:   time.Now().Add(time.Hour),
+		ReviewMode: mode,
+	}, publisher)
+	if err != nil {
+		t.Fatalf("publish order failed, err=%s", err)
+	}
+
+	return reload(t, c, o.ID)
+}
+
+func reload(t *testing.T, c *Ctl, mid uint) *model.TMasterOrder {
+
+	t.Helper()
+
+	o, err := c.GetOrder(mid)
+	if err != nil {
+		t.Fatalf("get order failed, err=%s", err)
+	}
+
+	return o
+}
+
+func accept(t *testing.T, c *Ctl, mid, worker uint) *model.TSubOrder {
+
+	t.Helper()
+
+	so, err := c.CreateSubOrder(mid, worker)
+	if err != nil {
+		t.Fatalf("accept order failed, err=%s", err)
+	}
+
+	return so
+}
+
+func submit(t *testing.T, c *Ctl, so *model.TSubOrder) {
+
+	t.Helper()
+
+	err := c.SubmitSubOrder(so.MID, so.ID, &ReqSubmitSubOrders{Context: "screenshot"})
+	if err != nil {
+		t.Fatalf("submit sub order failed, err=%s", err)
+	}
+}
+
+// review 审核员领取租约后审核子订单
+func review(t *testing.T, c *Ctl, so *model.TSubOrder, auditor uint, state model.OrderState, reason model.RejectReason) {
+
+	t.Helper()
+
+	_, err := c.ClaimSubOrder(so.MID, so.ID, auditor)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	err = c.ReviewSubOrder(so.MID, so.ID, state, reason, "", auditor)
+	if err != nil {
+		t.Fatalf("review sub order failed, err=%s", err)
+	}
+}
+
+func assertState(t *testing.T, c *Ctl, mid, sid uint, want model.OrderState) {
+
+	t.Helper()
+
+	if sid == 0 {
+		if got := reload(t, c, mid).State; got != want {
+			t.Fatalf("order state=%s, want %s", model.MOrderStateCN[got], model.MOrderStateCN[want])
+		}
+		return
+	}
+
+	so, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		t.Fatalf("get sub order failed, err=%s", err)
+	}
+
+	if so.State != want {
+		t.Fatalf("sub order state=%s, want %s", model.SOrderStateCN[so.State], model.SOrderStateCN[want])
+	}
+}
+
+func balance(t *testing.T, c *Ctl, typ model.AccountType, owner uint) int64 {
+
+	t.Helper()
+
+	b, err := c.tc.GetAccountBalance(typ, owner)
+	if err != nil {
+		t.Fatalf("get %s balance failed, err=%s", model.AccountTypeCN[typ], err)
+	}
+
+	return b
+}
+
+// assertWallets 校验用户钱包余额，且缓存余额与账本一致
+func assertWallets(t *testing.T, c *Ctl, want map[uint]int64) {
+
+	t.Helper()
+
+	for id, amount := range want {
+		u, err := c.uc.GetUser(id)
+		if err != nil {
+			t.Fatalf("get user failed, err=%s", err)
+		}
+
+		if u.Balance != amount {
+			t.Errorf("user %d balance=%d, want %d", id, u.Balance, amount)
+		}
+	}
+
+	mismatches, err := c.tc.ReconcileWallets()
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("wallet mismatches=%v, err=%v", mismatches, err)
+	}
+}
+
+// assertLedger 校验账本借贷平衡，订单托管余额为 escrow，且托管核对无差异
+func assertLedger(t *testing.T, c *Ctl, mid uint, escrow int64) {
+
+	t.Helper()
+
+	if got := balance(t, c, model.AccountEscrow, mid); got != escrow {
+		t.Errorf("escrow=%d, want %d", got, escrow)
+	}
+
+	unbalanced, err := c.tc.CheckUnbalancedEntries()
+	if err != nil || len(unbalanced) != 0 {
+		t.Errorf("unbalanced entries=%v, err=%v", unbalanced, err)
+	}
+
+	mismatches, err := c.CheckEscrow()
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("escrow mismatches=%v, err=%v", mismatches, err)
+	}
+}
+
+// TestOrderLifecycleFinish 发布 -> 支付 -> 接单 -> 提交 -> 通过/驳回后重新提交 -> 截止结束，
+// 未领取部分退回发布人，佣金扣除平台收入后支付给接单员
+func TestOrderLifecycleFinish(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	w1 := newTestUser(t, c, model.RoleWorker, 0)
+	w2 := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 3, model.ReviewModeAuditor)
+	unit, reward := o.UnitPrice, o.Reward
+	assertState(t, c, o.ID, 0, model.MOrderStateDoing)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - 3*unit})
+	assertLedger(t, c, o.ID, 3*unit)
+
+	s1 := accept(t, c, o.ID, w1.ID)
+	s2 := accept(t, c, o.ID, w2.ID)
+
+	// 接单员 1 一次通过
+	submit(t, c, s1)
+	review(t, c, s1, auditor.ID, model.SOrderStateComplete, 0)
+	assertState(t, c, o.ID, s1.ID, model.SOrderStateComplete)
+
+	// 接单员 2 驳回后重新提交通过
+	submit(t, c, s2)
+	review(t, c, s2, auditor.ID, model.SOrderStateReject, model.RejectReasonUnclear)
+	assertState(t, c, o.ID, s2.ID, model.SOrderStateReject)
+	assertLedger(t, c, o.ID, 2*unit)
+
+	submit(t, c, s2)
+	review(t, c, s2, auditor.ID, model.SOrderStateComplete, 0)
+	assertState(t, c, o.ID, s2.ID, model.SOrderStateComplete)
+
+	assertWallets(t, c, map[uint]int64{w1.ID: reward, w2.ID: reward})
+	assertLedger(t, c, o.ID, unit)
+
+	if got := reload(t, c, o.ID).Complete; got != 2 {
+		t.Fatalf("order complete=%d, want 2", got)
+	}
+
+	// 截止结束，退回未领取的一单
+	err := c.AutoFinishMOrder(reload(t, c, o.ID))
+	if err != nil {
+		t.Fatalf("finish order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, 0, model.MOrderStateFinish)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - 2*unit, w1.ID: reward, w2.ID: reward})
+	assertLedger(t, c, o.ID, 0)
+
+	if got := balance(t, c, model.AccountRevenue, 0); got != 2*(unit-reward) {
+		t.Errorf("revenue=%d, want %d", got, 2*(unit-reward))
+	}
+
+	// 已结束的订单不可再接单
+	_, err = c.CreateSubOrder(o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	if err == nil {
+		t.Fatalf("accept finished order succeeded")
+	}
+}
+
+// TestOrderLifecycleCancel 撤单立即退回未领取部分，已接单的子订单判定作弊后申诉期结束超时退费，
+// 子订单全部结束后订单结束，发布人余额全额退回
+func TestOrderLifecycleCancel(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	worker := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 3, model.ReviewModeAuditor)
+	unit := o.UnitPrice
+	so := accept(t, c, o.ID, worker.ID)
+
+	err := c.CancelMasterOrder(o.ID, publisher.ID)
+	if err != nil {
+		t.Fatalf("cancel order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, 0, model.MOrderStateClosing)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance - unit})
+	assertLedger(t, c, o.ID, unit)
+
+	// 撤单中的订单不可再接单，已接单的子订单可继续提交
+	_, err = c.CreateSubOrder(o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	if err == nil {
+		t.Fatalf("accept closing order succeeded")
+	}
+
+	submit(t, c, so)
+	review(t, c, so, auditor.ID, model.SOrderStateReject, model.RejectReasonFraud)
+	assertState(t, c, o.ID, so.ID, model.SOrderStateFailed)
+	assertLedger(t, c, o.ID, unit)
+
+	// 申诉期结束后超时取消并退费
+	so, err = c.GetSubOrderInfo(o.ID, so.ID)
+	if err != nil {
+		t.Fatalf("get sub order failed, err=%s", err)
+	}
+
+	err = c.AutoFinishSubOrder(so)
+	if err != nil {
+		t.Fatalf("timeout sub order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, so.ID, model.SOrderStateTimeout)
+
+	err = c.FinishClosingOrder(reload(t, c, o.ID))
+	if err != nil {
+		t.Fatalf("finish closing order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, 0, model.MOrderStateFinish)
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance, worker.ID: 0})
+	assertLedger(t, c, o.ID, 0)
+}
+
+// TestOrderLifecycleUnpaidCancel 待支付订单撤单直接取消，不产生资金变动
+func TestOrderLifecycleUnpaidCancel(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+
+	o, err := c.CreateMasterOrder(&model.MasterOrder{
+		Name:     "unpaid",
+		Platform: model.PlatformTB,
+		Total:    2,
+		FinishAt: time.Now().Add(time.Hour),
+	}, publisher.ID)
+	if err != nil {
+		t.Fatalf("create order failed, err=%s", err)
+	}
+
+	err = c.CancelMasterOrder(o.ID, publisher.ID)
+	if err != nil {
+		t.Fatalf("cancel order failed, err=%s", err)
+	}
+
+	assertState(t, c, o.ID, 0, model.MOrderStateCancel)
+
+	// 已取消的订单不可支付
+	err = c.PayForMasterOrder(o.ID, publisher.ID)
+	if err == nil {
+		t.Fatalf("pay canceled order succeeded")
+	}
+
+	assertWallets(t, c, map[uint]int64{publisher.ID: testBalance})
+	assertLedger(t, c, o.ID, 0)
+}
+
+// TestOrderLifecycleReviewModes 发布人审核的订单仅可由发布人审核，自动通过的订单提交后即结算
+func TestOrderLifecycleReviewModes(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	other := newTestUser(t, c, model.RolePublisher, 0)
+	worker := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 1, model.ReviewModePublisher)
+	so := accept(t, c, o.ID, worker.ID)
+	submit(t, c, so)
+
+	// 非发布人不可审核
+	err := c.ApproveSubOrder(o.ID, so.ID, other.ID)
+	if err == nil {
+		t.Fatalf("approve by other publisher succeeded")
+	}
+
+	err = c.ApproveSubOrder(o.ID, so.ID, publisher.ID)
+	if err != nil {
+		t.Fatalf("approve by publisher failed, err=%s", err)
+	}
+
+	auto := publish(t, c, publisher.ID, 1, model.ReviewModeAuto)
+	aso := accept(t, c, auto.ID, worker.ID)
+	submit(t, c, aso)
+	assertState(t, c, auto.ID, aso.ID, model.SOrderStateComplete)
+
+	// 全部完成的订单截止时无需退费
+	for _, mid := range []uint{o.ID, auto.ID} {
+		err = c.AutoFinishMOrder(reload(t, c, mid))
+		if err != nil {
+			t.Fatalf("finish order failed, err=%s", err)
+		}
+
+		assertState(t, c, mid, 0, model.MOrderStateFinish)
+	}
+
+	assertWallets(t, c, map[uint]int64{
+		publisher.ID: testBalance - o.UnitPrice - auto.UnitPrice,
+		worker.ID:    o.Reward + auto.Reward,
+	})
+	assertLedger(t, c, o.ID, 0)
+	assertLedger(t, c, auto.ID, 0)
+}
+
+// TestCheckEscrowMissingAccount 已结束订单仍有未结算子订单但未开立托管账户时，托管核对应报告差异
+func TestCheckEscrowMissingAccount(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	w := newTestUser(t, c, model.RoleWorker, 0)
+
+	o := publish(t, c, publisher.ID, 2, model.ReviewModeAuditor)
+	accept(t, c, o.ID, w.ID)
+
+	err := c.AutoFinishMOrder(reload(t, c, o.ID))
+	if err != nil {
+		t.Fatalf("finish order failed, err=%s", err)
+	}
+
+	assertLedger(t, c, o.ID, o.UnitPrice)
+
+	// 模拟启用订单托管前已在进行中的订单
+	err = c.store.DB().Unscoped().Where("type = ? AND owner_id = ?", model.AccountEscrow, o.ID).
+		Delete(&model.TLedgerAccount{}).Error
+	if err != nil {
+		t.Fatalf("delete escrow account failed, err=%s", err)
+	}
+
+	mismatches, err := c.CheckEscrow()
+	if err != nil {
+		t.Fatalf("check escrow failed, err=%s", err)
+	}
+
+	if len(mismatches) != 1 {
+		t.Fatalf("escrow mismatches=%v, want 1", mismatches)
+	}
+
+	m := mismatches[0]
+	if m.OrderID != o.ID || m.Active != 1 || m.Expected != o.UnitPrice || m.Escrow != 0 {
+		t.Errorf("escrow mismatch=%+v, want order %d active 1 expected %d escrow 0", m, o.ID, o.UnitPrice)
+	}
+}
+
+// TestAmendOrderDefaultLimits 未配置延长限制时按默认值校验延长截止时间
+func TestAmendOrderDefaultLimits(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	o := publish(t, c, publisher.ID, 1, model.ReviewModeAuditor)
+
+	_, err := c.AmendMasterOrder(o.ID, publisher.ID, 0, o.FinishAt.Add(time.Hour*24))
+	if err != nil {
+		t.Fatalf("extend order failed, err=%s", err)
+	}
+
+	o = reload(t, c, o.ID)
+	_, err = c.AmendMasterOrder(o.ID, publisher.ID, 0, o.FinishAt.Add(time.Hour*(defaultMaxExtendHours+1)))
+	if err == nil {
+		t.Fatalf("extend order beyond %d hours succeeded", defaultMaxExtendHours)
+	}
+}
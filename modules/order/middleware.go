@@ -0,0 +1,99 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/user"
+)
+
+// lookupStatus 查询订单失败时的 HTTP 状态码：记录不存在返回 404，其余为服务端错误
+func lookupStatus(err error) int {
+
+	if errors.Is(err, repo.ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// OwnMasterOrder 订单归属校验：发布人仅可操作自己发布的订单，其他角色由权限矩阵控制
+func (c *Ctl) OwnMasterOrder() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		operator := c.uc.CurrentUser(ctx)
+		if operator.Role != model.RolePublisher {
+			ctx.Next()
+			return
+		}
+
+		mid, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			err := fmt.Errorf("invalid id: %s", ctx.Param("id"))
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		order, err := c.GetOrder(uint(mid))
+		if err != nil {
+			ctx.AbortWithStatusJSON(lookupStatus(err), &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		if order.UserID != operator.ID {
+			c.Errorf("user %d is not the owner of order %s", operator.ID, order.UUID)
+			user.Deny(ctx, user.ErrNotOwner)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// OwnSubOrder 子订单归属校验：接单员仅可操作自己接受的子订单，其他角色由权限矩阵控制
+func (c *Ctl) OwnSubOrder() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		operator := c.uc.CurrentUser(ctx)
+		if operator.Role != model.RoleWorker {
+			ctx.Next()
+			return
+		}
+
+		mid, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			err := fmt.Errorf("invalid id: %s", ctx.Param("id"))
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		sid, err := strconv.Atoi(ctx.Param("sid"))
+		if err != nil {
+			err := fmt.Errorf("invalid sid: %s", ctx.Param("sid"))
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		subOrder, err := c.GetSubOrderInfo(uint(mid), uint(sid))
+		if err != nil {
+			ctx.AbortWithStatusJSON(lookupStatus(err), &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		if subOrder.UserID != operator.ID {
+			c.Errorf("user %d is not the owner of sub order %s", operator.ID, subOrder.UUID)
+			user.Deny(ctx, user.ErrNotOwner)
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package order
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// TestLookupStatus 订单或子订单不存在返回 404，其余查询错误返回 500
+func TestLookupStatus(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	o := publish(t, c, publisher.ID, 1, model.ReviewModeAuditor)
+
+	_, err := c.GetOrder(o.ID + 1)
+	if got := lookupStatus(err); got != http.StatusNotFound {
+		t.Errorf("missing order status=%d, want %d, err=%v", got, http.StatusNotFound, err)
+	}
+
+	_, err = c.GetSubOrderInfo(o.ID, 1)
+	if got := lookupStatus(err); got != http.StatusNotFound {
+		t.Errorf("missing sub order status=%d, want %d, err=%v", got, http.StatusNotFound, err)
+	}
+
+	if got := lookupStatus(errors.New("db down")); got != http.StatusInternalServerError {
+		t.Errorf("db error status=%d, want %d", got, http.StatusInternalServerError)
+	}
+}
@@ -1,74 +1,75 @@
 package order
 
 import (
+	"errors"
 	"fmt"
-	"github.com/mojiQAQ/dispatch/modules/utils"
-	"gorm.io/gorm"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/utils"
 )
 
-const (
-	PublishOrderPrice  = 200 // 单位：分
-	CompleteOrderPrice = 100
-)
-
-func (c *Ctl) GetOrders(states []string, userID, platform uint) ([]*model.TMasterOrder, error) {
+// ErrNotCancelable 订单已撤单或已结束，不可撤单
+var ErrNotCancelable = errors.New("当前订单状态不可撤单")
 
-	expr := make([]string, 0)
-	if len(states) != 0 {
-		expr = append(expr, fmt.Sprintf("state in (%s)", strings.Join(states, ",")))
-	}
+// GetOrders 分页查询订单
+func (c *Ctl) GetOrders(filter *repo.MasterOrderFilter, page *model.Page) ([]*model.TMasterOrder, *model.PageInfo, error) {
 
-	if userID != 0 {
-		expr = append(expr, fmt.Sprintf("user_id = %d", userID))
-	}
+	return c.store.MasterOrders().List(filter, page)
+}
 
-	if platform != 0 {
-		expr = append(expr, fmt.Sprintf("platform = %d", platform))
-	}
+func (c *Ctl) GetOrder(id uint) (*model.TMasterOrder, error) {
 
-	return c.GetMasterOrders(strings.Join(expr, " AND "))
+	return c.store.MasterOrders().Get(id)
 }
 
-func (c *Ctl) GetOrder(id uint) (*model.TMasterOrder, error) {
+// GetMasterOrders 查询处于指定状态的订单
+func (c *Ctl) GetMasterOrders(states ...model.OrderState) ([]*model.TMasterOrder, error) {
 
-	order := &model.TMasterOrder{}
-	err := c.db.Model(model.TMasterOrder{}).Where("id = ?", id).First(order).Error
-	if err != nil {
-		return nil, err
-	}
-	return order, nil
+	orders, _, err := c.store.MasterOrders().List(&repo.MasterOrderFilter{States: states}, nil)
+	return orders, err
 }
 
-func (c *Ctl) GetMasterOrders(condition string, args ...interface{}) ([]*model.TMasterOrder, error) {
+// CreateMasterOrder 创建订单
+func (c *Ctl) CreateMasterOrder(order *model.MasterOrder, userID uint) (*model.TMasterOrder, error) {
 
-	orders := make([]*model.TMasterOrder, 0)
-	err := c.db.Model(model.TMasterOrder{}).Where(condition, args...).Order("id desc").Find(&orders).Error
+	// 按平台定价生成价格快照，后续结算均以快照为准
+	quote, err := c.pc.Quote(order.Platform, order.Reward)
 	if err != nil {
 		return nil, err
 	}
 
-	return orders, nil
-}
-
-// CreateMasterOrder 创建订单
-func (c *Ctl) CreateMasterOrder(order *model.MasterOrder, openID string) (*model.TMasterOrder, error) {
+	if order.ReviewMode == 0 {
+		order.ReviewMode = model.ReviewModeAuditor
+	}
 
-	user, err := c.uc.GetUserByOpenID(openID)
+	err = checkReviewMode(order.ReviewMode)
 	if err != nil {
 		return nil, err
 	}
 
+	order.Reward = quote.Reward
+	order.UnitPrice = quote.UnitPrice
 	order.State = model.MOrderStateCreated
 	order.UUID = utils.GenerateUUID()
-	order.UserID = user.ID
+	order.UserID = userID
 
 	tOrder := &model.TMasterOrder{MasterOrder: order}
-	err = c.db.Create(tOrder).Error
+	err = c.store.Transaction(func(tx repo.Store) error {
+
+		err := tx.MasterOrders().Create(tOrder)
+		if err != nil {
+			return err
+		}
+
+		return tx.MasterOrders().AddEvent(&model.TOrderEvent{
+			MID:        tOrder.ID,
+			Kind:       model.OrderEventCreated,
+			To:         model.MOrderStateCreated,
+			OperatorID: userID,
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -89,115 +90,267 @@ func (c *Ctl) ModifyMasterOrder(id uint, order *model.MasterOrder) (*model.TMast
 		return nil, fmt.Errorf("not allow modify order")
 	}
 
-	tOrder := &model.TMasterOrder{MasterOrder: order}
-	err = c.db.Model(model.TMasterOrder{}).Where("id = ?", id).Updates(tOrder).Error
+	if order.ReviewMode != 0 {
+		err = checkReviewMode(order.ReviewMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 修改平台或奖励后重新报价
+	platform, reward := oldOrder.Platform, oldOrder.Reward
+	if order.Platform != 0 {
+		platform = order.Platform
+	}
+
+	if order.Reward != 0 {
+		reward = order.Reward
+	}
+
+	quote, err := c.pc.Quote(platform, reward)
 	if err != nil {
 		return nil, err
 	}
 
-	return tOrder, nil
-}
+	order.Reward = quote.Reward
+	order.UnitPrice = quote.UnitPrice
 
-// changeOrderState 修改订单状态
-func (c *Ctl) changeOrderState(tx *gorm.DB, id uint, state model.OrderState) error {
+	err = c.store.MasterOrders().Modify(id, order)
+	if err != nil {
+		return nil, err
+	}
 
-	return tx.Model(model.TMasterOrder{}).Where("id = ?", id).Update("state", state).Error
+	return &model.TMasterOrder{MasterOrder: order}, nil
 }
 
-// PayForMasterOrder 支付订单
-func (c *Ctl) PayForMasterOrder(id uint) error {
+// AmendMasterOrder 追加进行中订单的数量或延长截止时间：追加数量需在同一事务内补缴费用，
+// 延长截止时间受配置限制，每次变更均记录订单变更历史
+func (c *Ctl) AmendMasterOrder(id, userID uint, total int64, finishAt time.Time) (*model.TMasterOrder, error) {
 
 	order, err := c.GetOrder(id)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// 仅进行中的订单可以追加
+	if order.State != model.MOrderStateDoing {
+		return nil, fmt.Errorf("only doing order can be amended")
+	}
+
+	if total == 0 {
+		total = order.Total
+	}
+
+	if total < order.Total {
+		return nil, fmt.Errorf("订单数量只能增加")
 	}
 
-	// 仅已创建状态的订单可以被支付
-	if order.State != model.MOrderStateCreated {
-		return fmt.Errorf("only pay for order state is created")
+	if finishAt.IsZero() {
+		finishAt = order.FinishAt
 	}
 
-	// 开启事务
-	tx := c.db.Begin()
-	defer func() {
+	if finishAt.Before(order.FinishAt) {
+		return nil, fmt.Errorf("截止时间只能延后")
+	}
+
+	if finishAt.Sub(order.FinishAt) > time.Hour*time.Duration(c.cfg.MaxExtendHours) {
+		return nil, fmt.Errorf("单次最多延长 %d 小时", c.cfg.MaxExtendHours)
+	}
+
+	if finishAt.Sub(order.CreatedAt) > time.Hour*24*time.Duration(c.cfg.MaxDurationDays) {
+		return nil, fmt.Errorf("订单最长持续 %d 天", c.cfg.MaxDurationDays)
+	}
+
+	if total == order.Total && finishAt.Equal(order.FinishAt) {
+		return order, nil
+	}
+
+	err = c.store.Transaction(func(tx repo.Store) error {
+
+		// 以订单当前状态及数量作为更新条件，避免并发变更
+		ok, err := tx.MasterOrders().UpdateIf(id,
+			map[string]interface{}{"state": model.MOrderStateDoing, "total": order.Total},
+			map[string]interface{}{"total": total, "finish_at": finishAt})
 		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
+			return err
+		}
+
+		if !ok {
+			return ErrStateChanged
+		}
+
+		// 补缴追加数量的费用
+		amount := (total - order.Total) * order.UnitPrice
+		if amount > 0 {
+			err = c.uc.PayForPublishOrder(tx.DB(), order, amount)
+			if err != nil {
+				return err
 			}
 		}
-	}()
 
-	// 支付订单
-	err = c.uc.PayForPublishOrder(tx, order.UserID, order.Total*PublishOrderPrice, order.UUID)
+		err = tx.MasterOrders().AddHistory(&model.TMasterOrderHistory{
+			MID:         order.ID,
+			UserID:      userID,
+			OldTotal:    order.Total,
+			NewTotal:    total,
+			OldFinishAt: order.FinishAt,
+			NewFinishAt: finishAt,
+			Amount:      amount,
+		})
+		if err != nil {
+			return err
+		}
+
+		event := &model.TOrderEvent{
+			MID:        order.ID,
+			Kind:       model.OrderEventAmended,
+			OperatorID: userID,
+			Amount:     amount,
+			Remark: fmt.Sprintf("总数量 %d -> %d，截止时间 %s -> %s", order.Total, total,
+				order.FinishAt.Format("2006-01-02 15:04:05"), finishAt.Format("2006-01-02 15:04:05")),
+		}
+		if amount > 0 {
+			event.TradeID = order.UUID
+		}
+
+		return tx.MasterOrders().AddEvent(event)
+	})
 	if err != nil {
-		return err
+		c.Errorf("amend order %d failed, err=%s", id, err.Error())
+		return nil, err
 	}
 
-	// 更新订单状态为进行中
-	err = c.changeOrderState(tx, id, model.MOrderStateDoing)
-	if err != nil {
-		return err
+	return c.GetOrder(id)
+}
+
+// GetMasterOrderHistories 查询订单变更历史
+func (c *Ctl) GetMasterOrderHistories(mid uint) ([]*model.TMasterOrderHistory, error) {
+
+	return c.store.MasterOrders().GetHistories(mid)
+}
+
+// transit 在事务中经由状态机流转订单或子订单状态，流转时 t.Order 更新为锁定后读取的订单
+func (c *Ctl) transit(t *Transit) error {
+
+	m := c.orders
+	if t.Sub != nil {
+		m = c.subOrders
 	}
 
-	// 提交事务
-	return tx.Commit().Error
+	return c.store.Transaction(func(tx repo.Store) error {
+
+		// 先锁定订单行，同一订单的状态流转、接单及退费串行执行，前置条件及退费金额均以锁定后的数据计算
+		order, err := tx.MasterOrders().Lock(t.Order.ID)
+		if err != nil {
+			return err
+		}
+
+		if t.Sub == nil && order.State != t.Order.State {
+			return ErrStateChanged
+		}
+
+		t.Order = order
+		return m.Fire(tx, t)
+	})
 }
 
-func (c *Ctl) PublishOrder(order *model.MasterOrder, openID string) (*model.TMasterOrder, error) {
-	o, err := c.CreateMasterOrder(order, openID)
+// PayForMasterOrder 支付订单，扣费由状态机流转至进行中时执行
+func (c *Ctl) PayForMasterOrder(id, operator uint) error {
+
+	order, err := c.GetOrder(id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = c.PayForMasterOrder(o.ID)
-	return o, err
+	return c.transit(&Transit{
+		Order:    order,
+		To:       model.MOrderStateDoing,
+		Operator: operator,
+		Amount:   order.Total * order.UnitPrice,
+		TradeID:  order.UUID,
+	})
 }
 
-func (c *Ctl) CreateSubOrder(mid uint, userID uint) (*model.TSubOrder, error) {
+// chargeOrder 订单开始进行时扣除发布人订单费用
+func (c *Ctl) chargeOrder(tx repo.Store, t *Transit) error {
+
+	return c.uc.PayForPublishOrder(tx.DB(), t.Order, t.Order.Total*t.Order.UnitPrice)
+}
+
+func (c *Ctl) PublishOrder(order *model.MasterOrder, userID uint) (*model.TMasterOrder, error) {
 
-	user, err := c.uc.GetUser(userID)
+	// 冻结用户不可发布订单
+	err := c.uc.CheckActive(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if user.Role != model.RoleWorker {
-		return nil, fmt.Errorf("该用户不准接单")
-	}
-
-	mOrder, err := c.GetOrder(mid)
+	o, err := c.CreateMasterOrder(order, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if mOrder.State != model.MOrderStateDoing {
-		return nil, fmt.Errorf("订单已失效")
-	}
+	err = c.PayForMasterOrder(o.ID, userID)
+	return o, err
+}
 
-	if time.Now().After(mOrder.FinishAt) {
-		return nil, fmt.Errorf("订单已截止")
-	}
+func (c *Ctl) CreateSubOrder(mid uint, userID uint) (*model.TSubOrder, error) {
 
-	subOrders, err := c.GetAllSubOrders(mid)
+	// 冻结用户不可接单
+	err := c.uc.CheckActive(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(subOrders) >= int(mOrder.Total) {
-		return nil, fmt.Errorf("订单已分配完成")
-	}
-
 	sOrder := &model.SubOrder{
 		UUID:   utils.GenerateUUID(),
-		MID:    mOrder.ID,
+		MID:    mid,
 		UserID: userID,
 		State:  model.SOrderStateAccept,
 	}
 
 	tSOrder := &model.TSubOrder{SubOrder: sOrder}
-	err = c.db.Model(model.TSubOrder{}).Create(tSOrder).Error
+	err = c.store.Transaction(func(tx repo.Store) error {
+
+		// 锁定订单后校验，避免与撤单、截止退费并发时按过期的子订单数量计算退费
+		mOrder, err := tx.MasterOrders().Lock(mid)
+		if err != nil {
+			return err
+		}
+
+		if mOrder.State != model.MOrderStateDoing {
+			return fmt.Errorf("订单已失效")
+		}
+
+		if time.Now().After(mOrder.FinishAt) {
+			return fmt.Errorf("订单已截止")
+		}
+
+		subOrders, err := tx.SubOrders().Lock(mid, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(subOrders) >= int(mOrder.Total) {
+			return fmt.Errorf("订单已分配完成")
+		}
+
+		err = tx.SubOrders().Create(tSOrder)
+		if err != nil {
+			return err
+		}
+
+		return tx.MasterOrders().AddEvent(&model.TOrderEvent{
+			MID:        mOrder.ID,
+			SID:        tSOrder.ID,
+			Kind:       model.OrderEventAccepted,
+			To:         model.SOrderStateAccept,
+			OperatorID: userID,
+		})
+	})
 	if err != nil {
-		if strings.Contains(err.Error(), "Error 1062: Duplicate entry") {
+		// 唯一索引 uk_t_sub_orders_mid_user_id 保证同一接单员不会重复接单
+		if errors.Is(err, repo.ErrDuplicate) {
 			return nil, fmt.Errorf("订单已接受")
 		}
 		return nil, err
@@ -206,93 +359,85 @@ func (c *Ctl) CreateSubOrder(mid uint, userID uint) (*model.TSubOrder, error) {
 	return tSOrder, nil
 }
 
-func (c *Ctl) GetSubOrdersPlus(mid, userID uint, states []string) ([]*model.TSubOrder, error) {
-	expr := make([]string, 0)
-	args := make([]interface{}, 0)
-	if userID != 0 {
-		expr = append(expr, "user_id = ?")
-		args = append(args, userID)
-	}
-
-	if mid != 0 {
-		expr = append(expr, "mid = ?")
-		args = append(args, mid)
-	}
-
-	if len(states) != 0 {
-		tmp := fmt.Sprintf("state in (%s)", strings.Join(states, ","))
-		expr = append(expr, tmp)
-	}
+func (c *Ctl) GetSubOrdersPlus(mid, userID uint, states []model.OrderState) ([]*model.TSubOrder, error) {
 
-	return c.getSubOrders(strings.Join(expr, " AND "), args...)
+	orders, _, err := c.store.SubOrders().List(&repo.SubOrderFilter{
+		MID:    mid,
+		UserID: userID,
+		States: states,
+	}, nil)
+	return orders, err
 }
 
-func (c *Ctl) getSubOrders(condition string, args ...interface{}) ([]*model.TSubOrder, error) {
-
-	sOrders := make([]*model.TSubOrder, 0)
-	err := c.db.Model(model.TSubOrder{}).Where(condition, args...).Find(&sOrders).Error
-	if err != nil {
-		return nil, err
-	}
+// GetSubOrdersPage 分页查询子订单
+func (c *Ctl) GetSubOrdersPage(filter *repo.SubOrderFilter, page *model.Page) ([]*model.TSubOrder, *model.PageInfo, error) {
 
-	return sOrders, nil
+	return c.store.SubOrders().List(filter, page)
 }
 
 func (c *Ctl) GetAllSubOrders(mid uint) ([]*model.TSubOrder, error) {
 
-	return c.getSubOrders("mid = ?", mid)
+	return c.GetSubOrdersPlus(mid, 0, nil)
 }
 
 func (c *Ctl) GetSubOrderInfo(mid, sid uint) (*model.TSubOrder, error) {
 
-	sOrder := &model.TSubOrder{}
-	err := c.db.Model(model.TSubOrder{}).Where("id = ? AND mid = ?", sid, mid).First(sOrder).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return sOrder, nil
+	return c.store.SubOrders().Get(mid, sid)
 }
 
 func (c *Ctl) SubmitSubOrder(mid, sid uint, req *ReqSubmitSubOrders) error {
 
+	mOrder, err := c.GetOrder(mid)
+	if err != nil {
+		return err
+	}
+
 	// 查询子订单是否存在
 	order, err := c.GetSubOrderInfo(mid, sid)
 	if err != nil {
 		return err
 	}
 
-	// 检查子订单是否允许提交
-	if order.State != model.SOrderStateAccept && order.State != model.SOrderStateReject && order.State != model.SOrderStateSubmit {
-		return fmt.Errorf("the sub order state only accept and reject can be submit")
+	// 提交订单
+	err = c.transit(&Transit{
+		Order:    mOrder,
+		Sub:      order,
+		To:       model.SOrderStateSubmit,
+		Operator: order.UserID,
+		Snapshot: req.Context,
+		Fields:   map[string]interface{}{"context": req.Context, "submits": order.Submits + 1},
+	})
+	if err != nil {
+		return err
 	}
 
-	// 如果订单为驳回，且任务已经结束，则不可以再提交该订单
-	if order.State == model.SOrderStateReject {
-		mOrder, err := c.GetOrder(mid)
+	// 自动通过的订单提交后即由系统审核，失败时留待审核时限到期后重试
+	if mOrder.ReviewMode == model.ReviewModeAuto {
+		err = c.ApproveSubOrder(mid, sid, 0)
 		if err != nil {
-			return err
-		}
-
-		if time.Now().After(mOrder.FinishAt) {
-			return fmt.Errorf("订单已结束")
+			c.Errorf("auto approve sub order uuid=%s failed, err=%s", order.UUID, err.Error())
 		}
 	}
 
-	// 提交订单
-	order.State = model.SOrderStateSubmit
-	order.Context = req.Context
-	return c.db.Model(model.TSubOrder{}).Where("id = ?", sid).Updates(order).Error
+	return nil
 }
 
-func (c *Ctl) changeSubOrderState(tx *gorm.DB, sid uint, state model.OrderState) error {
+// checkResubmit 驳回的子订单在任务结束后或超过最多重新提交次数后不可以再提交
+func (c *Ctl) checkResubmit(t *Transit) error {
+
+	if time.Now().After(t.Order.FinishAt) {
+		return fmt.Errorf("订单已结束")
+	}
+
+	if t.Sub.Submits > int(c.cfg.MaxResubmits) {
+		return fmt.Errorf("最多重新提交 %d 次", c.cfg.MaxResubmits)
+	}
 
-	return tx.Model(model.TSubOrder{}).Where("id = ?", sid).
-		Update("state", state).Error
+	return nil
 }
 
 // ApproveSubOrder 订单审核通过
-func (c *Ctl) ApproveSubOrder(mid, sid uint) error {
+func (c *Ctl) ApproveSubOrder(mid, sid, operator uint) error {
 
 	// 查询父订单
 	masterOrder, err := c.GetOrder(mid)
@@ -300,164 +445,237 @@ func (c *Ctl) ApproveSubOrder(mid, sid uint) error {
 		return err
 	}
 
-	if masterOrder.Complete == masterOrder.Total {
-		return fmt.Errorf("master is complete")
-	}
-
 	// 查询子订单是否存在
 	subOrder, err := c.GetSubOrderInfo(mid, sid)
 	if err != nil {
 		return err
 	}
 
-	// 开启事务
-	tx := c.db.Begin()
-	defer func() {
-		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
-			}
-		}
-	}()
+	return c.transit(&Transit{
+		Order:    masterOrder,
+		Sub:      subOrder,
+		To:       model.SOrderStateComplete,
+		Operator: operator,
+		Amount:   masterOrder.Reward,
+		TradeID:  subOrder.UUID,
+		Fields:   releaseLease(nil),
+		Expect:   map[string]interface{}{"reviewer_id": subOrder.ReviewerID},
+	})
+}
 
-	// 修改订单状态
-	err = c.changeSubOrderState(tx, sid, model.SOrderStateComplete)
-	if err != nil {
-	}
+// checkComplete 父订单已全部完成时不可再通过子订单
+func (c *Ctl) checkComplete(t *Transit) error {
 
-	err = tx.Model(model.TMasterOrder{}).Where("id = ?", mid).
-		Update("complete", masterOrder.Complete+1).Error
-	if err != nil {
-		return err
+	if t.Order.Complete >= t.Order.Total {
+		return fmt.Errorf("master is complete")
 	}
 
-	// 支付佣金
-	err = c.uc.RewardForOrder(tx, subOrder.UserID, CompleteOrderPrice, subOrder.UUID)
+	return nil
+}
+
+// rewardSubOrder 子订单完成后累计父订单完成数量并支付佣金
+func (c *Ctl) rewardSubOrder(tx repo.Store, t *Transit) error {
+
+	err := tx.MasterOrders().IncrComplete(t.Order.ID)
 	if err != nil {
 		return err
 	}
 
-	// 提交事务
-	return tx.Commit().Error
+	return c.uc.RewardForOrder(tx.DB(), t.Order, t.Sub)
 }
 
-func (c *Ctl) ReviewSubOrder(mid, sid uint, auditorID uint, state model.OrderState) error {
+// ReviewSubOrder 审核子订单，审核人须符合订单的审核方式，平台审核时须持有子订单的审核租约；驳回时需给出驳回原因
+func (c *Ctl) ReviewSubOrder(mid, sid uint, state model.OrderState, reason model.RejectReason, comment string,
+	operator uint) error {
 
-	// 查询审核人信息
-	auditor, err := c.uc.GetUser(auditorID)
-	if err != nil {
-		return err
+	switch state {
+	case model.SOrderStateComplete:
+		// 审核状态为完成，则通过子订单
+		return c.ApproveSubOrder(mid, sid, operator)
+	case model.SOrderStateReject:
+		return c.RejectSubOrder(mid, sid, reason, comment, operator)
+	default:
+		return fmt.Errorf("invalid review state: %d", state)
 	}
+}
+
+// RejectSubOrder 驳回子订单并记录驳回原因：已无法重新提交的子订单驳回后为未通过，接单员可在申诉期内申诉
+func (c *Ctl) RejectSubOrder(mid, sid uint, reason model.RejectReason, comment string, operator uint) error {
 
-	// 仅审核员可以审核子订单
-	if auditor.Role != model.RoleAuditor {
-		return fmt.Errorf("only auditor can review sub order")
+	if _, ok := model.RejectReasonCN[reason]; !ok {
+		return fmt.Errorf("invalid reject reason: %d", reason)
 	}
 
-	// 获取子订单信息
-	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	masterOrder, err := c.GetOrder(mid)
 	if err != nil {
 		return err
 	}
 
-	// 仅已提交的子订单可以被审核
-	if subOrder.State != model.SOrderStateSubmit {
-		return fmt.Errorf("only submit order can be review")
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return err
 	}
 
-	// 审核状态为完成，则通过子订单
-	if state == model.SOrderStateComplete {
-		return c.ApproveSubOrder(mid, sid)
+	to := model.SOrderStateReject
+	if c.isFinalReject(masterOrder, subOrder, reason) {
+		to = model.SOrderStateFailed
 	}
 
-	// 审核状态为驳回，则驳回子订单
-	if state == model.SOrderStateReject {
-		return c.changeSubOrderState(c.db, sid, model.SOrderStateReject)
+	remark := model.RejectReasonCN[reason]
+	if len(comment) != 0 {
+		remark = fmt.Sprintf("%s：%s", remark, comment)
 	}
 
-	return nil
+	return c.transit(&Transit{
+		Order:    masterOrder,
+		Sub:      subOrder,
+		To:       to,
+		Operator: operator,
+		Remark:   remark,
+		Fields:   releaseLease(map[string]interface{}{"reject_reason": reason, "reject_comment": comment}),
+		Expect:   map[string]interface{}{"reviewer_id": subOrder.ReviewerID},
+	})
 }
 
-// AutoFinishMOrder 自动结束未完成的订单并退回未接受的子订单金额
-func (c *Ctl) AutoFinishMOrder(order *model.TMasterOrder) error {
+// isFinalReject 任务已结束、已达最多重新提交次数或判定作弊时，驳回后不可再提交
+func (c *Ctl) isFinalReject(order *model.TMasterOrder, sub *model.TSubOrder, reason model.RejectReason) bool {
 
-	var err error
-	tx := c.db.Begin()
-	defer func() {
-		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
-			}
-		}
-	}()
+	return reason == model.RejectReasonFraud ||
+		time.Now().After(order.FinishAt) ||
+		sub.Submits > int(c.cfg.MaxResubmits)
+}
+
+// activeSubStates 仍在进行中的子订单状态，这部分子订单完成或超时后单独结算；
+// 未通过及申诉中的子订单在申诉结束前仍占用托管资金
+var activeSubStates = []model.OrderState{
+	model.SOrderStateAccept, model.SOrderStateSubmit, model.SOrderStateReject,
+	model.SOrderStateFailed, model.SOrderStateAppeal,
+}
+
+// activeSubOrders 查询仍在进行中的子订单
+func (c *Ctl) activeSubOrders(mid uint) ([]*model.TSubOrder, error) {
+
+	return c.GetSubOrdersPlus(mid, 0, activeSubStates)
+}
+
+// unClaimedAmount 在事务 tx 中锁定进行中的子订单，计算订单未被领取部分的退费金额
+func (c *Ctl) unClaimedAmount(tx repo.Store, order *model.TMasterOrder) (int64, error) {
 
-	// 修改未完成订单状态
-	err = c.changeOrderState(tx, order.ID, model.MOrderStateFinish)
+	subs, err := tx.SubOrders().Lock(order.ID, activeSubStates)
 	if err != nil {
-		c.Errorf("cancel timeout order failed, uuid=%s, err=%v", order.UUID, err)
-		return err
+		return 0, err
 	}
 
-	subs, err := c.GetSubOrdersPlus(order.ID, 0, []string{
-		strconv.Itoa(int(model.SOrderStateAccept)),
-		strconv.Itoa(int(model.SOrderStateSubmit)),
-	})
+	// 退回金额等于 (总单数-已完成-已接受-已提交-已驳回-未通过-申诉中) * 订单单价快照
+	// 已驳回的子订单仍可重新提交，超时后由子订单检查逻辑单独退费
+	return (order.Total - order.Complete - int64(len(subs))) * order.UnitPrice, nil
+}
+
+// CancelMasterOrder 发布人撤单：待支付订单直接取消；进行中订单停止接单并立即退回未领取部分金额，
+// 已接受的子订单可继续完成，超时后由子订单检查逻辑退费，全部结束后订单自动结束
+func (c *Ctl) CancelMasterOrder(id, operator uint) error {
+
+	order, err := c.GetOrder(id)
 	if err != nil {
-		c.Errorf("get sub order failed, uuid=%s, err=%v", order.UUID, err)
 		return err
 	}
 
-	// 退回金额等于 (总单数-已完成-已接受-已提交) * 单价（200分）
-	amount := (order.Total - order.Complete - int64(len(subs))) * PublishOrderPrice
+	to := model.MOrderStateCancel
+	switch order.State {
+	case model.MOrderStateCreated:
+	case model.MOrderStateDoing:
+		to = model.MOrderStateClosing
+	default:
+		return fmt.Errorf("%w: %s", ErrNotCancelable, model.MOrderStateCN[order.State])
+	}
+
+	// 以进行中状态为条件撤单，避免与订单截止结算重复退费
+	return c.transit(&Transit{Order: order, To: to, Operator: operator})
+}
+
+// refundUnClaimed 退回订单未被领取部分金额至商家账户
+func (c *Ctl) refundUnClaimed(tx repo.Store, t *Transit) error {
 
-	// 退回未完成订单金额至商家账户
-	err = c.uc.ReturnUnCompleteOrder(tx, order.UserID, amount, order.UUID)
+	amount, err := c.unClaimedAmount(tx, t.Order)
 	if err != nil {
+		c.Errorf("get sub order failed, uuid=%s, err=%v", t.Order.UUID, err)
 		return err
 	}
 
-	return tx.Commit().Error
+	return c.refund(tx, t, amount)
 }
 
-// AutoFinishSubOrder 自动终止已接受、驳回状态的子订单，若父订单已截止则退费子订单
-func (c *Ctl) AutoFinishSubOrder(subOrder *model.TSubOrder) error {
+// refund 退回订单金额至商家账户并记录退费事件
+func (c *Ctl) refund(tx repo.Store, t *Transit, amount int64) error {
 
-	var err error
-	tx := c.db.Begin()
-	defer func() {
-		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
-			}
-		}
-	}()
+	if amount == 0 {
+		return nil
+	}
 
-	err = c.changeSubOrderState(c.db, subOrder.ID, model.SOrderStateTimeout)
+	err := c.uc.ReturnUnCompleteOrder(tx.DB(), t.Order, amount)
 	if err != nil {
-		c.Errorf("set timeout sub order failed, uuid=%s, err=%v", subOrder.UUID, err)
 		return err
 	}
 
+	event := &model.TOrderEvent{
+		MID:     t.Order.ID,
+		Kind:    model.OrderEventRefunded,
+		Amount:  amount,
+		TradeID: t.Order.UUID,
+	}
+	if t.Sub != nil {
+		event.SID = t.Sub.ID
+	}
+
+	return tx.MasterOrders().AddEvent(event)
+}
+
+// FinishClosingOrder 撤单中的订单在所有子订单结束后自动结束
+func (c *Ctl) FinishClosingOrder(order *model.TMasterOrder) error {
+
+	subs, err := c.activeSubOrders(order.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) != 0 {
+		return nil
+	}
+
+	state := model.MOrderStateFinish
+	if order.Complete == order.Total {
+		state = model.MOrderStateDone
+	}
+
+	return c.transit(&Transit{Order: order, To: state})
+}
+
+// AutoFinishMOrder 自动结束未完成的订单，并由状态机退回未接受的子订单金额
+func (c *Ctl) AutoFinishMOrder(order *model.TMasterOrder) error {
+
+	return c.transit(&Transit{Order: order, To: model.MOrderStateFinish})
+}
+
+// AutoFinishSubOrder 自动终止已接受、驳回及申诉期结束的未通过子订单，若父订单已截止则由状态机退费子订单
+func (c *Ctl) AutoFinishSubOrder(subOrder *model.TSubOrder) error {
+
 	mOrder, err := c.GetOrder(subOrder.MID)
 	if err != nil {
 		c.Errorf("get master order failed, uuid=%s, err=%v", subOrder.UUID, err)
 		return err
 	}
 
-	// 如果父订单已结束，则退回该子订单金额
-	if mOrder.FinishAt.Before(time.Now()) {
-		// 退回金额等于 子订单单价（200分）
-		amount := PublishOrderPrice
+	return c.transit(&Transit{Order: mOrder, Sub: subOrder, To: model.SOrderStateTimeout})
+}
 
-		// 退回未完成订单金额至商家账户
-		err = c.uc.ReturnUnCompleteOrder(tx, mOrder.UserID, int64(amount), mOrder.UUID)
-		if err != nil {
-			return err
-		}
+// refundTimeout 如果父订单已结束或已撤单，则退回超时子订单金额；父订单尚未结束时该子订单的金额
+// 由父订单结束时 refundUnClaimed 一并退回，以流转时锁定的父订单状态为准，避免重复退费
+func (c *Ctl) refundTimeout(tx repo.Store, t *Transit) error {
+
+	if t.Order.State == model.MOrderStateFinish || t.Order.State == model.MOrderStateClosing {
+		// 退回金额等于 订单单价快照
+		return c.refund(tx, t, t.Order.UnitPrice)
 	}
 
-	return tx.Commit().Error
+	return nil
 }
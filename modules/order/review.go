@@ -0,0 +1,481 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/user"
+)
+
+const reviewQueueSize = 20
+
+var (
+	ErrLeaseTaken   = errors.New("子订单已由其他审核员领取或已审核")
+	ErrLeaseNotHeld = errors.New("未持有子订单的审核租约或租约已过期")
+	ErrQueueEmpty   = errors.New("暂无待审核的子订单")
+	ErrNotReviewer  = errors.New("订单的审核方式不允许该用户审核")
+)
+
+type (
+	// ReviewStat 审核员审核量，PerHour 为查询时间范围内平均每小时审核数量，未指定起始时间时为 0
+	ReviewStat struct {
+		*repo.ReviewStat
+		Name     string  `json:"name"`
+		Reviewed int64   `json:"reviewed"`
+		PerHour  float64 `json:"per_hour"`
+	}
+
+	ReqGetReviewQueue struct {
+		*model.ReqBase
+	}
+
+	RespGetReviewQueue struct {
+		*model.RespBase
+		SubOrders []*SubOrder `json:"sub_orders"`
+	}
+
+	ReqClaimReview struct {
+		*model.ReqBase
+	}
+
+	RespClaimReview struct {
+		*model.RespBase
+		SubOrder *SubOrder `json:"sub_order"`
+	}
+
+	ReqReleaseReview struct {
+		*model.ReqBase
+	}
+
+	RespReleaseReview struct {
+		*model.RespBase
+	}
+
+	ReqGetReviewStats struct {
+		*model.ReqBase
+	}
+
+	RespGetReviewStats struct {
+		*model.RespBase
+		Stats []*ReviewStat `json:"stats"`
+	}
+)
+
+// releaseLease 审核后释放子订单的审核租约
+func releaseLease(fields map[string]interface{}) map[string]interface{} {
+
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+
+	fields["reviewer_id"] = 0
+	fields["lease_expire_at"] = nil
+	return fields
+}
+
+func checkReviewMode(mode model.ReviewMode) error {
+
+	if _, ok := model.ReviewModeCN[mode]; !ok {
+		return fmt.Errorf("invalid review mode: %d", mode)
+	}
+
+	return nil
+}
+
+// checkReviewer 审核人须符合订单的审核方式：平台审核须持有子订单未过期的审核租约，发布人审核须为订单发布人，
+// 自动通过的订单不可人工审核；系统自动审核不校验
+func (c *Ctl) checkReviewer(t *Transit) error {
+
+	if t.Operator == 0 {
+		return nil
+	}
+
+	switch t.Order.ReviewMode {
+	case model.ReviewModePublisher:
+		if t.Operator != t.Order.UserID {
+			return ErrNotReviewer
+		}
+	case model.ReviewModeAuto:
+		return ErrNotReviewer
+	default:
+		if t.Sub.ReviewerID != t.Operator || t.Sub.LeaseExpireAt == nil || t.Sub.LeaseExpireAt.Before(time.Now()) {
+			return ErrLeaseNotHeld
+		}
+	}
+
+	return nil
+}
+
+// initReviewRouter 注册审核员审核队列接口
+func (c *Ctl) initReviewRouter(g *gin.RouterGroup) {
+
+	// 查询审核队列(审核员)
+	g.GET("/reviews", c.uc.Permit(user.ActionClaimReview), c.HandleGetReviewQueue)
+
+	// 领取下一个待审核子订单
+	g.POST("/reviews", c.uc.Permit(user.ActionClaimReview), c.HandleNextReview)
+
+	// 领取指定子订单的审核租约
+	g.POST("/orders/:id/sub_orders/:sid/lease", c.uc.Permit(user.ActionClaimReview), c.HandleClaimReview)
+
+	// 释放审核租约
+	g.DELETE("/orders/:id/sub_orders/:sid/lease", c.uc.Permit(user.ActionClaimReview), c.HandleReleaseReview)
+
+	// 跳过待审核子订单
+	g.POST("/orders/:id/sub_orders/:sid/skip", c.uc.Permit(user.ActionClaimReview), c.HandleSkipReview)
+}
+
+// initReviewAdminRouter 注册审核统计接口，路由组需已挂载管理员权限校验
+func (c *Ctl) initReviewAdminRouter(g *gin.RouterGroup) {
+
+	// 按审核员统计审核量
+	g.GET("/reviews/stats", c.HandleGetReviewStats)
+}
+
+// GetReviewQueue 查询审核员可领取的平台审核子订单，已持有租约的优先，其次按父订单截止时间先后
+func (c *Ctl) GetReviewQueue(auditor uint) ([]*model.TSubOrder, error) {
+
+	return c.store.Reviews().Queue(auditor, time.Now(), reviewQueueSize)
+}
+
+// ClaimSubOrder 领取子订单的审核租约，已持有时续期；仅平台审核的订单可领取
+func (c *Ctl) ClaimSubOrder(mid, sid, auditor uint) (*model.TSubOrder, error) {
+
+	masterOrder, err := c.GetOrder(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if masterOrder.ReviewMode != model.ReviewModeAuditor {
+		return nil, ErrNotReviewer
+	}
+
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ok, err := c.store.Reviews().Claim(subOrder.ID, auditor, now, now.Add(time.Minute*time.Duration(c.cfg.ReviewLeaseMinutes)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrLeaseTaken
+	}
+
+	return c.GetSubOrderInfo(mid, sid)
+}
+
+// NextReview 按优先级为审核员分配下一个待审核子订单，被其他审核员抢先领取时顺延
+func (c *Ctl) NextReview(auditor uint) (*model.TSubOrder, error) {
+
+	subOrders, err := c.GetReviewQueue(auditor)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, so := range subOrders {
+		subOrder, err := c.ClaimSubOrder(so.MID, so.ID, auditor)
+		if errors.Is(err, ErrLeaseTaken) {
+			continue
+		}
+
+		return subOrder, err
+	}
+
+	return nil, ErrQueueEmpty
+}
+
+// ReleaseSubOrder 释放审核员持有的审核租约，子订单回到队列中
+func (c *Ctl) ReleaseSubOrder(mid, sid, auditor uint) error {
+
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return err
+	}
+
+	ok, err := c.store.Reviews().Release(subOrder.ID, auditor)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrLeaseNotHeld
+	}
+
+	return nil
+}
+
+// SkipSubOrder 跳过待审核子订单的本次提交：须持有审核租约，跳过后释放租约，接单员重新提交前不再分配给该审核员
+func (c *Ctl) SkipSubOrder(mid, sid, auditor uint) error {
+
+	subOrder, err := c.GetSubOrderInfo(mid, sid)
+	if err != nil {
+		return err
+	}
+
+	if subOrder.State != model.SOrderStateSubmit {
+		return ErrLeaseTaken
+	}
+
+	return c.store.Transaction(func(tx repo.Store) error {
+
+		ok, err := tx.Reviews().Release(subOrder.ID, auditor)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrLeaseNotHeld
+		}
+
+		err = tx.Reviews().Skip(&model.TReviewSkip{SID: subOrder.ID, AuditorID: auditor, Submits: subOrder.Submits})
+		if err != nil && !errors.Is(err, repo.ErrDuplicate) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetReviewStats 按审核员统计时间范围内的审核量
+func (c *Ctl) GetReviewStats(tr repo.TimeRange) ([]*ReviewStat, error) {
+
+	stats, err := c.store.Reviews().Stats(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := 0.0
+	if !tr.From.IsZero() {
+		to := tr.To
+		if to.IsZero() {
+			to = time.Now()
+		}
+		hours = to.Sub(tr.From).Hours()
+	}
+
+	ids := make([]uint, 0, len(stats))
+	for _, s := range stats {
+		ids = append(ids, s.AuditorID)
+	}
+
+	users, err := c.uc.GetUsersByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]*ReviewStat, 0, len(stats))
+	for _, s := range stats {
+		// 仅统计审核员的审核量，发布人审核自己订单的数量及已删除的用户均不计入
+		u, ok := users[s.AuditorID]
+		if !ok {
+			c.Errorf("get auditor id=%d failed, err=user not found", s.AuditorID)
+			continue
+		}
+
+		if u.Role != model.RoleAuditor {
+			continue
+		}
+
+		stat := &ReviewStat{
+			ReviewStat: s,
+			Name:       u.Name,
+			Reviewed:   s.Approved + s.Rejected,
+		}
+
+		if hours > 0 {
+			stat.PerHour = float64(stat.Reviewed) / hours
+		}
+
+		data = append(data, stat)
+	}
+
+	return data, nil
+}
+
+// reviewStatus 审核错误对应的响应状态码：队列为空 404，租约冲突 409，审核方式不允许 403
+func reviewStatus(err error) int {
+
+	switch {
+	case errors.Is(err, ErrQueueEmpty):
+		return http.StatusNotFound
+	case errors.Is(err, ErrLeaseTaken), errors.Is(err, ErrLeaseNotHeld):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotReviewer):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (c *Ctl) HandleGetReviewQueue(ctx *gin.Context) {
+
+	req := &ReqGetReviewQueue{}
+	user := c.uc.CurrentUser(ctx)
+
+	subOrders, err := c.GetReviewQueue(user.ID)
+	if err != nil {
+		c.Errorf("get review queue failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*SubOrder, 0)
+	for _, so := range subOrders {
+		data = append(data, &SubOrder{
+			TSubOrder: so,
+			StateCN:   model.SOrderStateCN[so.State],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetReviewQueue{
+		RespBase:  req.GenResponse(nil),
+		SubOrders: data,
+	})
+}
+
+func (c *Ctl) HandleNextReview(ctx *gin.Context) {
+
+	req := &ReqClaimReview{}
+	user := c.uc.CurrentUser(ctx)
+
+	subOrder, err := c.NextReview(user.ID)
+	if err != nil {
+		c.Errorf("assign review failed, err=%s", err.Error())
+		ctx.JSON(reviewStatus(err), req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespClaimReview{
+		RespBase: req.GenResponse(nil),
+		SubOrder: &SubOrder{TSubOrder: subOrder, StateCN: model.SOrderStateCN[subOrder.State]},
+	})
+}
+
+func (c *Ctl) HandleClaimReview(ctx *gin.Context) {
+
+	req := &ReqClaimReview{}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	sid, err := strconv.Atoi(ctx.Param("sid"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user := c.uc.CurrentUser(ctx)
+	subOrder, err := c.ClaimSubOrder(uint(mid), uint(sid), user.ID)
+	if err != nil {
+		c.Errorf("claim review failed, err=%s", err.Error())
+		ctx.JSON(reviewStatus(err), req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespClaimReview{
+		RespBase: req.GenResponse(nil),
+		SubOrder: &SubOrder{TSubOrder: subOrder, StateCN: model.SOrderStateCN[subOrder.State]},
+	})
+}
+
+func (c *Ctl) HandleReleaseReview(ctx *gin.Context) {
+
+	req := &ReqReleaseReview{}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	sid, err := strconv.Atoi(ctx.Param("sid"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user := c.uc.CurrentUser(ctx)
+	err = c.ReleaseSubOrder(uint(mid), uint(sid), user.ID)
+	if err != nil {
+		c.Errorf("release review failed, err=%s", err.Error())
+		ctx.JSON(reviewStatus(err), req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReleaseReview{
+		RespBase: req.GenResponse(nil),
+	})
+}
+
+func (c *Ctl) HandleSkipReview(ctx *gin.Context) {
+
+	req := &ReqReleaseReview{}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	sid, err := strconv.Atoi(ctx.Param("sid"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user := c.uc.CurrentUser(ctx)
+	err = c.SkipSubOrder(uint(mid), uint(sid), user.ID)
+	if err != nil {
+		c.Errorf("skip review failed, err=%s", err.Error())
+		ctx.JSON(reviewStatus(err), req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReleaseReview{
+		RespBase: req.GenResponse(nil),
+	})
+}
+
+func (c *Ctl) HandleGetReviewStats(ctx *gin.Context) {
+
+	req := &ReqGetReviewStats{}
+
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	stats, err := c.GetReviewStats(timeRange)
+	if err != nil {
+		c.Errorf("get review stats failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetReviewStats{
+		RespBase: req.GenResponse(nil),
+		Stats:    stats,
+	})
+}
@@ -0,0 +1,400 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+)
+
+// submitted 发布订单并由 n 个接单员接单提交
+func submitted(t *testing.T, c *Ctl, publisher uint, n int) []*model.TSubOrder {
+
+	t.Helper()
+
+	o := publish(t, c, publisher, int64(n), model.ReviewModeAuditor)
+
+	subs := make([]*model.TSubOrder, 0, n)
+	for i := 0; i < n; i++ {
+		so := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+		submit(t, c, so)
+		subs = append(subs, so)
+	}
+
+	return subs
+}
+
+// skip 审核员领取租约后跳过子订单
+func skip(t *testing.T, c *Ctl, so *model.TSubOrder, auditor uint) {
+
+	t.Helper()
+
+	_, err := c.ClaimSubOrder(so.MID, so.ID, auditor)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	err = c.SkipSubOrder(so.MID, so.ID, auditor)
+	if err != nil {
+		t.Fatalf("skip sub order failed, err=%s", err)
+	}
+}
+
+func queueIDs(t *testing.T, c *Ctl, auditor uint) []uint {
+
+	t.Helper()
+
+	subs, err := c.GetReviewQueue(auditor)
+	if err != nil {
+		t.Fatalf("get review queue failed, err=%s", err)
+	}
+
+	ids := make([]uint, 0, len(subs))
+	for _, so := range subs {
+		ids = append(ids, so.ID)
+	}
+
+	return ids
+}
+
+func assertQueue(t *testing.T, c *Ctl, auditor uint, want ...uint) {
+
+	t.Helper()
+
+	got := queueIDs(t, c, auditor)
+	if len(got) != len(want) {
+		t.Fatalf("auditor %d queue=%v, want %v", auditor, got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("auditor %d queue=%v, want %v", auditor, got, want)
+		}
+	}
+}
+
+// TestReviewLease 租约由领取的审核员独占，释放后其他审核员可领取，未持有租约不可审核
+func TestReviewLease(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	a1 := newTestUser(t, c, model.RoleAuditor, 0)
+	a2 := newTestUser(t, c, model.RoleAuditor, 0)
+	so := submitted(t, c, publisher.ID, 1)[0]
+
+	got, err := c.ClaimSubOrder(so.MID, so.ID, a1.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	if got.ReviewerID != a1.ID || got.LeaseExpireAt == nil {
+		t.Fatalf("reviewer=%d lease=%v, want held by %d", got.ReviewerID, got.LeaseExpireAt, a1.ID)
+	}
+
+	// 已持有时续期
+	_, err = c.ClaimSubOrder(so.MID, so.ID, a1.ID)
+	if err != nil {
+		t.Fatalf("renew lease failed, err=%s", err)
+	}
+
+	_, err = c.ClaimSubOrder(so.MID, so.ID, a2.ID)
+	if !errors.Is(err, ErrLeaseTaken) {
+		t.Fatalf("claim leased sub order err=%v, want %s", err, ErrLeaseTaken)
+	}
+
+	err = c.ReviewSubOrder(so.MID, so.ID, model.SOrderStateComplete, 0, "", a2.ID)
+	if !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("review without lease err=%v, want %s", err, ErrLeaseNotHeld)
+	}
+
+	err = c.ReleaseSubOrder(so.MID, so.ID, a2.ID)
+	if !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("release lease of other auditor err=%v, want %s", err, ErrLeaseNotHeld)
+	}
+
+	err = c.ReleaseSubOrder(so.MID, so.ID, a1.ID)
+	if err != nil {
+		t.Fatalf("release lease failed, err=%s", err)
+	}
+
+	_, err = c.ClaimSubOrder(so.MID, so.ID, a2.ID)
+	if err != nil {
+		t.Fatalf("claim released sub order failed, err=%s", err)
+	}
+
+	err = c.ReviewSubOrder(so.MID, so.ID, model.SOrderStateComplete, 0, "", a2.ID)
+	if err != nil {
+		t.Fatalf("review sub order failed, err=%s", err)
+	}
+
+	// 审核后释放租约，已审核的子订单不可再领取
+	got = getSubOrder(t, c, so)
+	if got.State != model.SOrderStateComplete || got.ReviewerID != 0 || got.LeaseExpireAt != nil {
+		t.Errorf("sub order state=%s reviewer=%d lease=%v", model.SOrderStateCN[got.State], got.ReviewerID, got.LeaseExpireAt)
+	}
+
+	_, err = c.ClaimSubOrder(so.MID, so.ID, a1.ID)
+	if !errors.Is(err, ErrLeaseTaken) {
+		t.Fatalf("claim reviewed sub order err=%v, want %s", err, ErrLeaseTaken)
+	}
+}
+
+// TestReviewLeaseExpire 租约过期后其他审核员可接管，原审核员不可再审核
+func TestReviewLeaseExpire(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	a1 := newTestUser(t, c, model.RoleAuditor, 0)
+	a2 := newTestUser(t, c, model.RoleAuditor, 0)
+	so := submitted(t, c, publisher.ID, 1)[0]
+
+	_, err := c.ClaimSubOrder(so.MID, so.ID, a1.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	assertQueue(t, c, a2.ID)
+
+	err = c.store.DB().Model(model.TSubOrder{}).Where("id = ?", so.ID).
+		UpdateColumn("lease_expire_at", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatalf("expire lease failed, err=%s", err)
+	}
+
+	assertQueue(t, c, a2.ID, so.ID)
+
+	next, err := c.NextReview(a2.ID)
+	if err != nil {
+		t.Fatalf("next review failed, err=%s", err)
+	}
+
+	if next.ID != so.ID || next.ReviewerID != a2.ID {
+		t.Fatalf("next review=%d reviewer=%d, want %d held by %d", next.ID, next.ReviewerID, so.ID, a2.ID)
+	}
+
+	err = c.ReviewSubOrder(so.MID, so.ID, model.SOrderStateComplete, 0, "", a1.ID)
+	if !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("review with expired lease err=%v, want %s", err, ErrLeaseNotHeld)
+	}
+
+	err = c.ReviewSubOrder(so.MID, so.ID, model.SOrderStateComplete, 0, "", a2.ID)
+	if err != nil {
+		t.Fatalf("review sub order failed, err=%s", err)
+	}
+
+	_, err = c.NextReview(a1.ID)
+	if !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("next review err=%v, want %s", err, ErrQueueEmpty)
+	}
+}
+
+// TestReviewQueueSkip 跳过的提交不再分配给该审核员，其他审核员仍可领取，重新提交后恢复分配
+func TestReviewQueueSkip(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	a1 := newTestUser(t, c, model.RoleAuditor, 0)
+	a2 := newTestUser(t, c, model.RoleAuditor, 0)
+	subs := submitted(t, c, publisher.ID, 2)
+	s1, s2 := subs[0], subs[1]
+
+	assertQueue(t, c, a1.ID, s1.ID, s2.ID)
+
+	// 跳过时释放租约
+	skip(t, c, s1, a1.ID)
+	if got := getSubOrder(t, c, s1); got.ReviewerID != 0 || got.LeaseExpireAt != nil {
+		t.Fatalf("skipped sub order reviewer=%d lease=%v", got.ReviewerID, got.LeaseExpireAt)
+	}
+
+	assertQueue(t, c, a1.ID, s2.ID)
+	assertQueue(t, c, a2.ID, s1.ID, s2.ID)
+
+	// 持有租约的子订单排在队列最前，且不再分配给其他审核员
+	_, err := c.ClaimSubOrder(s2.MID, s2.ID, a2.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	assertQueue(t, c, a2.ID, s2.ID, s1.ID)
+	assertQueue(t, c, a1.ID)
+
+	// 驳回后重新提交，跳过记录失效
+	review(t, c, s1, a2.ID, model.SOrderStateReject, model.RejectReasonUnclear)
+	submit(t, c, s1)
+	assertQueue(t, c, a1.ID, s1.ID)
+}
+
+// TestReviewSkipLease 跳过须持有待审核子订单的租约，未持有时不记录跳过
+func TestReviewSkipLease(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	a1 := newTestUser(t, c, model.RoleAuditor, 0)
+	a2 := newTestUser(t, c, model.RoleAuditor, 0)
+	so := submitted(t, c, publisher.ID, 1)[0]
+
+	err := c.SkipSubOrder(so.MID, so.ID, a1.ID)
+	if !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("skip without lease err=%v, want %s", err, ErrLeaseNotHeld)
+	}
+
+	assertQueue(t, c, a1.ID, so.ID)
+
+	_, err = c.ClaimSubOrder(so.MID, so.ID, a1.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	// 其他审核员跳过不影响持有的租约
+	err = c.SkipSubOrder(so.MID, so.ID, a2.ID)
+	if !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("skip lease of other auditor err=%v, want %s", err, ErrLeaseNotHeld)
+	}
+
+	if got := getSubOrder(t, c, so); got.ReviewerID != a1.ID {
+		t.Fatalf("reviewer=%d, want %d", got.ReviewerID, a1.ID)
+	}
+
+	// 已审核的子订单不可跳过
+	review(t, c, so, a1.ID, model.SOrderStateComplete, 0)
+	err = c.SkipSubOrder(so.MID, so.ID, a1.ID)
+	if !errors.Is(err, ErrLeaseTaken) {
+		t.Fatalf("skip reviewed sub order err=%v, want %s", err, ErrLeaseTaken)
+	}
+
+	var skips int64
+	err = c.store.DB().Model(model.TReviewSkip{}).Count(&skips).Error
+	if err != nil || skips != 0 {
+		t.Errorf("review skips=%d, err=%v", skips, err)
+	}
+}
+
+// TestReviewSLA 超过审核时限未审核的子订单由系统自动通过，不受审核方式及其他审核员租约限制
+func TestReviewSLA(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	auditor := newTestUser(t, c, model.RoleAuditor, 0)
+	subs := submitted(t, c, publisher.ID, 3)
+	leased, stale, fresh := subs[0], subs[1], subs[2]
+
+	o := publish(t, c, publisher.ID, 1, model.ReviewModePublisher)
+	pso := accept(t, c, o.ID, newTestUser(t, c, model.RoleWorker, 0).ID)
+	submit(t, c, pso)
+
+	// 系统审核不要求持有租约
+	_, err := c.ClaimSubOrder(leased.MID, leased.ID, auditor.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	err = c.ApproveSubOrder(leased.MID, leased.ID, 0)
+	if err != nil {
+		t.Fatalf("auto approve leased sub order failed, err=%s", err)
+	}
+
+	if got := getSubOrder(t, c, leased); got.State != model.SOrderStateComplete || got.ReviewerID != 0 {
+		t.Fatalf("sub order state=%s reviewer=%d", model.SOrderStateCN[got.State], got.ReviewerID)
+	}
+
+	_, err = c.ClaimSubOrder(stale.MID, stale.ID, auditor.ID)
+	if err != nil {
+		t.Fatalf("claim sub order failed, err=%s", err)
+	}
+
+	sla := time.Hour * time.Duration(c.cfg.ReviewSLAHours)
+	expire(t, c, stale, sla+time.Minute)
+	expire(t, c, pso, sla+time.Minute)
+	expire(t, c, fresh, sla-time.Minute)
+
+	c.checkReviewSLA()
+
+	assertState(t, c, stale.MID, stale.ID, model.SOrderStateComplete)
+	assertState(t, c, o.ID, pso.ID, model.SOrderStateComplete)
+	assertState(t, c, fresh.MID, fresh.ID, model.SOrderStateSubmit)
+
+	var events []*model.TOrderEvent
+	err = c.store.DB().Where("kind = ?", model.OrderEventApproved).Find(&events).Error
+	if err != nil {
+		t.Fatalf("get order events failed, err=%s", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d approved events, want 3", len(events))
+	}
+
+	for _, e := range events {
+		if e.OperatorID != 0 {
+			t.Errorf("sub order %d approved by %d, want system", e.SID, e.OperatorID)
+		}
+	}
+
+	assertLedger(t, c, leased.MID, reload(t, c, leased.MID).UnitPrice)
+	assertLedger(t, c, o.ID, 0)
+}
+
+func TestReviewStatus(t *testing.T) {
+
+	cases := map[error]int{
+		ErrQueueEmpty:                           http.StatusNotFound,
+		ErrLeaseTaken:                           http.StatusConflict,
+		fmt.Errorf("wrap: %w", ErrLeaseNotHeld): http.StatusConflict,
+		ErrNotReviewer:                          http.StatusForbidden,
+		errors.New("db down"):                   http.StatusInternalServerError,
+	}
+
+	for err, want := range cases {
+		if got := reviewStatus(err); got != want {
+			t.Errorf("reviewStatus(%s)=%d, want %d", err, got, want)
+		}
+	}
+}
+
+// TestReviewStats 按审核员统计通过、驳回及跳过数量，非审核员的审核不计入
+func TestReviewStats(t *testing.T) {
+
+	c := newTestCtl(t)
+	publisher := newTestUser(t, c, model.RolePublisher, testBalance)
+	admin := newTestUser(t, c, model.RoleAdministrator, 0)
+	a1 := newTestUser(t, c, model.RoleAuditor, 0)
+	a2 := newTestUser(t, c, model.RoleAuditor, 0)
+	subs := submitted(t, c, publisher.ID, 5)
+
+	review(t, c, subs[0], a1.ID, model.SOrderStateComplete, 0)
+	review(t, c, subs[1], a1.ID, model.SOrderStateReject, model.RejectReasonUnclear)
+	review(t, c, subs[2], a2.ID, model.SOrderStateComplete, 0)
+	review(t, c, subs[3], admin.ID, model.SOrderStateComplete, 0)
+
+	skip(t, c, subs[4], a1.ID)
+
+	stats, err := c.GetReviewStats(repo.TimeRange{From: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("get review stats failed, err=%s", err)
+	}
+
+	want := map[uint][3]int64{a1.ID: {1, 1, 1}, a2.ID: {1, 0, 0}}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d stats, want %d", len(stats), len(want))
+	}
+
+	for _, s := range stats {
+		w, ok := want[s.AuditorID]
+		if !ok {
+			t.Errorf("unexpected stat for user %d", s.AuditorID)
+			continue
+		}
+
+		if s.Approved != w[0] || s.Rejected != w[1] || s.Skipped != w[2] || s.Reviewed != w[0]+w[1] {
+			t.Errorf("auditor %d approved=%d rejected=%d skipped=%d reviewed=%d, want %v",
+				s.AuditorID, s.Approved, s.Rejected, s.Skipped, s.Reviewed, w)
+		}
+
+		if s.Name == "" || s.PerHour <= 0 {
+			t.Errorf("auditor %d name=%q per_hour=%f", s.AuditorID, s.Name, s.PerHour)
+		}
+	}
+}
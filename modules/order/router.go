@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	valid "github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/user"
 )
 
 type (
@@ -20,7 +22,8 @@ type (
 
 	RespGetMasterOrders struct {
 		*model.RespBase
-		Orders []*Order `json:"orders"`
+		Orders []*Order        `json:"orders"`
+		Page   *model.PageInfo `json:"page"`
 	}
 
 	ReqGetAllMasterOrders struct {
@@ -29,16 +32,19 @@ type (
 
 	Order struct {
 		*model.TMasterOrder
-		PlatformCN string `json:"platform_cn"`
-		StateCN    string `json:"state_cn"`
-		IsAccepted bool   `json:"is_accepted"`
-		Accept     int    `json:"accept"`
-		Review     int    `json:"review"`
+		PlatformCN   string `json:"platform_cn"`
+		StateCN      string `json:"state_cn"`
+		ReviewModeCN string `json:"review_mode_cn"`
+		IsAccepted   bool   `json:"is_accepted"`
+		Accept       int    `json:"accept"`
+		Review       int    `json:"review"`
+		Escrow       int64  `json:"escrow"`
 	}
 
 	RespGetAllMasterOrders struct {
 		*model.RespBase
-		Orders []*Order `json:"orders"`
+		Orders []*Order        `json:"orders"`
+		Page   *model.PageInfo `json:"page"`
 	}
 
 	ReqCreateMasterOrder struct {
@@ -70,6 +76,21 @@ type (
 		Order *model.TMasterOrder `json:"order"`
 	}
 
+	ReqAmendMasterOrder struct {
+		*model.ReqBase
+		Total    int64     `json:"total"`     // 追加后的总数量，为空则不修改
+		FinishAt time.Time `json:"finish_at"` // 延长后的截止时间，为空则不修改
+	}
+
+	ReqGetOrderHistories struct {
+		*model.ReqBase
+	}
+
+	RespGetOrderHistories struct {
+		*model.RespBase
+		Histories []*model.TMasterOrderHistory `json:"histories"`
+	}
+
 	ReqOperateMasterOrder struct {
 		*model.ReqBase
 	}
@@ -94,7 +115,8 @@ type (
 
 	RespGetSubOrders struct {
 		*model.RespBase
-		SubOrders []*SubOrder `json:"sub_orders"`
+		SubOrders []*SubOrder     `json:"sub_orders"`
+		Page      *model.PageInfo `json:"page"`
 	}
 
 	ReqGetSubOrderInfo struct {
@@ -117,6 +139,8 @@ type (
 
 	ReqReviewSubOrders struct {
 		*model.ReqBase
+		Reason  model.RejectReason `json:"reason"`  // 驳回原因，驳回时必填
+		Comment string             `json:"comment"` // 审核说明
 	}
 
 	RespReviewSubOrders struct {
@@ -140,78 +164,106 @@ type (
 	RespGetUserSubOrders struct {
 		*model.RespBase
 		SubOrders []*SubOrderExtend `json:"sub_orders"`
+		Page      *model.PageInfo   `json:"page"`
 	}
 )
 
 func (c *Ctl) InitRouter(g *gin.RouterGroup) {
 
 	// 查询订单(商家用)
-	g.GET("/orders", c.HandleGetOrders)
+	g.GET("/orders", c.uc.Permit(user.ActionGetOrders), c.HandleGetOrders)
 
 	// 查询全部订单(用户用)
-	g.GET("/all_orders", c.HandleAllGetOrders)
+	g.GET("/all_orders", c.uc.Permit(user.ActionGetAllOrders), c.HandleAllGetOrders)
 
 	// 发布订单（创建并支付）
-	g.POST("/orders", c.HandlePublishMasterOrder)
+	g.POST("/orders", c.uc.Permit(user.ActionPublishOrder), c.HandlePublishMasterOrder)
 
 	// 查询订单详情
-	g.GET("/orders/:id", c.HandleGetOrderInfo)
+	g.GET("/orders/:id", c.uc.Permit(user.ActionGetOrder), c.OwnMasterOrder(), c.HandleGetOrderInfo)
 
 	// 修改订单
-	g.PUT("/orders/:id", c.HandleModifyOrder)
+	g.PUT("/orders/:id", c.uc.Permit(user.ActionModifyOrder), c.OwnMasterOrder(), c.HandleModifyOrder)
 
 	// 支付订单
-	g.POST("/orders/:id", c.HandlePayOrder)
+	g.POST("/orders/:id", c.uc.Permit(user.ActionPayOrder), c.OwnMasterOrder(), c.HandlePayOrder)
+
+	// 追加进行中订单
+	g.PATCH("/orders/:id", c.uc.Permit(user.ActionAmendOrder), c.OwnMasterOrder(), c.HandleAmendOrder)
+
+	// 查询订单变更历史
+	g.GET("/orders/:id/histories", c.uc.Permit(user.ActionGetOrder), c.OwnMasterOrder(), c.HandleGetOrderHistories)
+
+	// 查询订单时间线
+	g.GET("/orders/:id/timeline", c.uc.Permit(user.ActionGetOrderTimeline), c.OwnMasterOrder(), c.HandleGetOrderTimeline)
+
+	// 撤销订单
+	g.DELETE("/orders/:id", c.uc.Permit(user.ActionCancelOrder), c.OwnMasterOrder(), c.HandleCancelOrder)
 
 	// 创建子订单(接受订单)
-	g.POST("/orders/:id/sub_orders", c.HandleCreateSubOrder)
+	g.POST("/orders/:id/sub_orders", c.uc.Permit(user.ActionAcceptOrder), c.HandleCreateSubOrder)
 
 	// 获取用户所有子订单(接单员)
-	g.GET("/sub_orders", c.HandleGetUserSubOrders)
+	g.GET("/sub_orders", c.uc.Permit(user.ActionGetUserSubOrders), c.HandleGetUserSubOrders)
 
 	// 查询子订单列表
-	g.GET("/orders/:id/sub_orders", c.HandleGetSubOrders)
+	g.GET("/orders/:id/sub_orders", c.uc.Permit(user.ActionGetSubOrders), c.OwnMasterOrder(), c.HandleGetSubOrders)
 
 	// 查询子订单详情
-	g.GET("/orders/:id/sub_orders/:sid", c.HandleGetSubOrderInfo)
+	g.GET("/orders/:id/sub_orders/:sid", c.uc.Permit(user.ActionGetSubOrder), c.OwnMasterOrder(), c.OwnSubOrder(),
+		c.HandleGetSubOrderInfo)
 
 	// 提交子订单
-	g.POST("/orders/:id/sub_orders/:sid", c.HandleSubmitSubOrder)
+	g.POST("/orders/:id/sub_orders/:sid", c.uc.Permit(user.ActionSubmitSubOrder), c.OwnSubOrder(), c.HandleSubmitSubOrder)
 
 	// 审核子订单
-	g.PUT("/orders/:id/sub_orders/:sid", c.HandleReviewSubOrder)
+	g.PUT("/orders/:id/sub_orders/:sid", c.uc.Permit(user.ActionReviewSubOrder), c.OwnMasterOrder(),
+		c.HandleReviewSubOrder)
+
+	c.initReviewRouter(g)
+
+	c.initAppealRouter(g)
+}
+
+// InitAdminRouter 注册管理员接口，路由组需已挂载管理员权限校验
+func (c *Ctl) InitAdminRouter(g *gin.RouterGroup) {
+
+	// 核对订单托管余额
+	g.GET("/escrow/reconcile", c.HandleCheckEscrow)
+
+	c.initReviewAdminRouter(g)
+
+	c.initAppealAdminRouter(g)
 }
 
 func (c *Ctl) HandleGetOrders(ctx *gin.Context) {
 
 	req := &ReqGetMasterOrders{}
+	user := c.uc.CurrentUser(ctx)
 
-	openid := ctx.Query("openid")
-	if len(openid) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openid)
+	oStates, err := repo.ParseMasterOrderStates(ctx.Query("state"))
+	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	user, err := c.uc.GetUserByOpenID(openid)
+	platform, err := repo.ParsePlatform(ctx.Query("platform"))
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openid)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	states := make([]string, 0)
-	state := ctx.Query("state")
-	if len(state) != 0 {
-		states = strings.Split(state, ",")
+	page, err := repo.ParsePage(ctx.Query, repo.MasterOrderSorts...)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
 	}
 
-	platform := ctx.DefaultQuery("platform", "0")
-	pid, err := strconv.Atoi(platform)
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
 	if err != nil {
-		err := fmt.Errorf("invalid platform: %s", platform)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
@@ -222,14 +274,19 @@ func (c *Ctl) HandleGetOrders(ctx *gin.Context) {
 		userID = 0
 	}
 
-	orders, err := c.GetOrders(states, userID, uint(pid))
+	orders, info, err := c.GetOrders(&repo.MasterOrderFilter{
+		TimeRange: timeRange,
+		States:    oStates,
+		UserID:    userID,
+		Platform:  platform,
+	}, page)
 	if err != nil {
 		c.Errorf("get orders failed, err=%v", err)
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	sOrders, err := c.GetSubOrdersPlus(0, 0, []string{"1", "2"})
+	sOrders, err := c.GetSubOrdersPlus(0, 0, []model.OrderState{model.SOrderStateAccept, model.SOrderStateSubmit})
 	if err != nil {
 		c.Errorf("get sub orders failed, err=%v", err)
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -262,6 +319,7 @@ func (c *Ctl) HandleGetOrders(ctx *gin.Context) {
 			TMasterOrder: o,
 			PlatformCN:   model.PlatformCN[o.Platform],
 			StateCN:      model.MOrderStateCN[o.State],
+			ReviewModeCN: model.ReviewModeCN[o.ReviewMode],
 			Accept:       id2accept[o.ID],
 			Review:       id2review[o.ID],
 		})
@@ -270,52 +328,55 @@ func (c *Ctl) HandleGetOrders(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &RespGetMasterOrders{
 		RespBase: req.GenResponse(err),
 		Orders:   data,
+		Page:     info,
 	})
 }
 
 func (c *Ctl) HandleAllGetOrders(ctx *gin.Context) {
 
 	req := &ReqGetAllMasterOrders{}
+	user := c.uc.CurrentUser(ctx)
 
-	openid := ctx.Query("openid")
-	if len(openid) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openid)
+	oStates, err := repo.ParseMasterOrderStates(ctx.Query("state"))
+	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	user, err := c.uc.GetUserByOpenID(openid)
+	platform, err := repo.ParsePlatform(ctx.Query("platform"))
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openid)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	states := make([]string, 0)
-	state := ctx.Query("state")
-	if len(state) != 0 {
-		states = strings.Split(state, ",")
+	page, err := repo.ParsePage(ctx.Query, repo.MasterOrderSorts...)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
 	}
 
-	platform := ctx.DefaultQuery("platform", "0")
-	pid, err := strconv.Atoi(platform)
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
 	if err != nil {
-		err := fmt.Errorf("invalid platform: %s", openid)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	orders, err := c.GetOrders(states, 0, uint(pid))
+	orders, info, err := c.GetOrders(&repo.MasterOrderFilter{
+		TimeRange: timeRange,
+		States:    oStates,
+		Platform:  platform,
+	}, page)
 	if err != nil {
 		c.Errorf("get orders failed, err=%v", err)
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	subOrders, err := c.GetSubOrdersPlus(0, user.ID, []string{})
+	subOrders, err := c.GetSubOrdersPlus(0, user.ID, nil)
 	if err != nil {
 		c.Errorf("get sub_orders failed, err=%v", err)
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -344,20 +405,14 @@ func (c *Ctl) HandleAllGetOrders(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &RespGetAllMasterOrders{
 		RespBase: req.GenResponse(err),
 		Orders:   allOrders,
+		Page:     info,
 	})
 }
 
 func (c *Ctl) HandlePublishMasterOrder(ctx *gin.Context) {
 
 	req := &ReqCreateMasterOrder{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
+	user := c.uc.CurrentUser(ctx)
 	err := ctx.ShouldBindBodyWith(req, binding.JSON)
 	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
@@ -372,7 +427,7 @@ func (c *Ctl) HandlePublishMasterOrder(ctx *gin.Context) {
 		return
 	}
 
-	order, err := c.PublishOrder(req.MasterOrder, openID)
+	order, err := c.PublishOrder(req.MasterOrder, user.ID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, &RespCreateMasterOrder{
 			RespBase: req.GenResponse(err),
@@ -390,21 +445,7 @@ func (c *Ctl) HandlePublishMasterOrder(ctx *gin.Context) {
 func (c *Ctl) HandleGetOrderInfo(ctx *gin.Context) {
 
 	req := &ReqGetMasterOrder{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
-	user, err := c.uc.GetUserByOpenID(openID)
-	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
+	user := c.uc.CurrentUser(ctx)
 
 	sid := ctx.Param("id")
 	id, err := strconv.Atoi(sid)
@@ -420,7 +461,7 @@ func (c *Ctl) HandleGetOrderInfo(ctx *gin.Context) {
 		return
 	}
 
-	sOrders, err := c.GetSubOrdersPlus(order.ID, 0, []string{})
+	sOrders, err := c.GetSubOrdersPlus(order.ID, 0, nil)
 	if err != nil {
 		c.Errorf("get sub_orders failed, err=%v", err)
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -443,13 +484,22 @@ func (c *Ctl) HandleGetOrderInfo(ctx *gin.Context) {
 		}
 	}
 
+	escrow, err := c.GetEscrow(order.ID)
+	if err != nil {
+		c.Errorf("get escrow failed, err=%v", err)
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
 	info := &Order{
 		TMasterOrder: order,
 		PlatformCN:   model.PlatformCN[order.Platform],
 		StateCN:      model.MOrderStateCN[order.State],
+		ReviewModeCN: model.ReviewModeCN[order.ReviewMode],
 		IsAccepted:   isAccept,
 		Accept:       accepted,
 		Review:       review,
+		Escrow:       escrow,
 	}
 
 	ctx.JSON(http.StatusOK, &RespGetMasterOrder{
@@ -461,23 +511,8 @@ func (c *Ctl) HandleGetOrderInfo(ctx *gin.Context) {
 func (c *Ctl) HandleModifyOrder(ctx *gin.Context) {
 
 	req := &ReqModifyMasterOrder{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
 
-	_, err := c.uc.GetUserByOpenID(openID)
-	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
-	err = ctx.ShouldBindBodyWith(req, binding.JSON)
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
 	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
@@ -514,16 +549,33 @@ func (c *Ctl) HandleModifyOrder(ctx *gin.Context) {
 func (c *Ctl) HandlePayOrder(ctx *gin.Context) {
 
 	req := &ReqOperateMasterOrder{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
+
+	sid := ctx.Param("id")
+	id, err := strconv.Atoi(sid)
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
-	_, err := c.uc.GetUserByOpenID(openID)
+
+	user := c.uc.CurrentUser(ctx)
+	err = c.PayForMasterOrder(uint(id), user.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespOperateMasterOrder{
+		RespBase: req.GenResponse(err),
+	})
+}
+
+func (c *Ctl) HandleAmendOrder(ctx *gin.Context) {
+
+	req := &ReqAmendMasterOrder{}
+	user := c.uc.CurrentUser(ctx)
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
@@ -537,36 +589,73 @@ func (c *Ctl) HandlePayOrder(ctx *gin.Context) {
 		return
 	}
 
-	err = c.PayForMasterOrder(uint(id))
+	order, err := c.AmendMasterOrder(uint(id), user.ID, req.Total, req.FinishAt)
 	if err != nil {
+		c.Errorf("amend order failed, err=%s", err.Error())
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &RespOperateMasterOrder{
+	ctx.JSON(http.StatusOK, &RespModifyMasterOrder{
 		RespBase: req.GenResponse(err),
+		Order:    order,
 	})
 }
 
-func (c *Ctl) HandleCreateSubOrder(ctx *gin.Context) {
+func (c *Ctl) HandleGetOrderHistories(ctx *gin.Context) {
 
-	req := &ReqCreateSubOrder{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
+	req := &ReqGetOrderHistories{}
+
+	sid := ctx.Param("id")
+	id, err := strconv.Atoi(sid)
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
-	user, err := c.uc.GetUserByOpenID(openID)
+
+	histories, err := c.GetMasterOrderHistories(uint(id))
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetOrderHistories{
+		RespBase:  req.GenResponse(err),
+		Histories: histories,
+	})
+}
+
+func (c *Ctl) HandleCancelOrder(ctx *gin.Context) {
+
+	req := &ReqOperateMasterOrder{}
+
+	sid := ctx.Param("id")
+	id, err := strconv.Atoi(sid)
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	err = ctx.ShouldBindBodyWith(req, binding.JSON)
+	user := c.uc.CurrentUser(ctx)
+	err = c.CancelMasterOrder(uint(id), user.ID)
+	if err != nil {
+		c.Errorf("cancel order failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespOperateMasterOrder{
+		RespBase: req.GenResponse(err),
+	})
+}
+
+func (c *Ctl) HandleCreateSubOrder(ctx *gin.Context) {
+
+	req := &ReqCreateSubOrder{}
+	user := c.uc.CurrentUser(ctx)
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
 	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
@@ -603,30 +692,41 @@ func (c *Ctl) HandleCreateSubOrder(ctx *gin.Context) {
 func (c *Ctl) HandleGetSubOrders(ctx *gin.Context) {
 
 	req := &ReqGetSubOrders{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
+
+	sid := ctx.Param("id")
+	mid, err := strconv.Atoi(sid)
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	states, err := repo.ParseSubOrderStates(ctx.Query("state"))
+	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
-	_, err := c.uc.GetUserByOpenID(openID)
+
+	page, err := repo.ParsePage(ctx.Query, repo.SubOrderSorts...)
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	sid := ctx.Param("id")
-	mid, err := strconv.Atoi(sid)
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
 	if err != nil {
-		c.Errorf("request params invalid, err=%s", err.Error())
+		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	sOrders, err := c.GetAllSubOrders(uint(mid))
+	sOrders, info, err := c.GetSubOrdersPage(&repo.SubOrderFilter{
+		TimeRange: timeRange,
+		MID:       uint(mid),
+		States:    states,
+	}, page)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
@@ -643,26 +743,13 @@ func (c *Ctl) HandleGetSubOrders(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &RespGetSubOrders{
 		RespBase:  req.GenResponse(err),
 		SubOrders: data,
+		Page:      info,
 	})
 }
 
 func (c *Ctl) HandleGetSubOrderInfo(ctx *gin.Context) {
 
 	req := &ReqGetSubOrderInfo{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-	_, err := c.uc.GetUserByOpenID(openID)
-	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
 
 	mid, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
@@ -707,21 +794,6 @@ func (c *Ctl) HandleSubmitSubOrder(ctx *gin.Context) {
 		return
 	}
 
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-	_, err = c.uc.GetUserByOpenID(openID)
-	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
 	mid, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		c.Errorf("request params invalid, err=%s", err.Error())
@@ -751,21 +823,6 @@ func (c *Ctl) HandleReviewSubOrder(ctx *gin.Context) {
 
 	req := &ReqReviewSubOrders{}
 
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-	user, err := c.uc.GetUserByOpenID(openID)
-	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
 	state := ctx.Query("state")
 	if len(state) == 0 {
 		err := fmt.Errorf("invalid state: %s", state)
@@ -775,7 +832,7 @@ func (c *Ctl) HandleReviewSubOrder(ctx *gin.Context) {
 	}
 
 	iState, err := strconv.Atoi(state)
-	if err != nil {
+	if err != nil || (model.OrderState(iState) != model.SOrderStateComplete && model.OrderState(iState) != model.SOrderStateReject) {
 		err := fmt.Errorf("invalid state: %s", state)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
@@ -796,9 +853,20 @@ func (c *Ctl) HandleReviewSubOrder(ctx *gin.Context) {
 		return
 	}
 
-	err = c.ReviewSubOrder(uint(mid), uint(sid), user.ID, model.OrderState(iState))
+	// 驳回时需在请求体中给出驳回原因
+	if model.OrderState(iState) == model.SOrderStateReject {
+		err = ctx.ShouldBindBodyWith(req, binding.JSON)
+		if err != nil {
+			c.Errorf("parsing request failed, err=%s", err.Error())
+			ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+			return
+		}
+	}
+
+	user := c.uc.CurrentUser(ctx)
+	err = c.ReviewSubOrder(uint(mid), uint(sid), model.OrderState(iState), req.Reason, req.Comment, user.ID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		ctx.JSON(reviewStatus(err), req.GenResponse(err))
 		return
 	}
 
@@ -810,28 +878,34 @@ func (c *Ctl) HandleReviewSubOrder(ctx *gin.Context) {
 func (c *Ctl) HandleGetUserSubOrders(ctx *gin.Context) {
 
 	req := &ReqGetUserSubOrders{}
-	openID := ctx.Query("openid")
-	if len(openID) == 0 {
-		err := fmt.Errorf("invalid openid: %s", openID)
+	user := c.uc.CurrentUser(ctx)
+
+	oStates, err := repo.ParseSubOrderStates(ctx.Query("state"))
+	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
-	user, err := c.uc.GetUserByOpenID(openID)
+
+	page, err := repo.ParsePage(ctx.Query, repo.SubOrderSorts...)
 	if err != nil {
-		err := fmt.Errorf("invalid openid: %s", openID)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	states := make([]string, 0)
-	state := ctx.Query("state")
-	if len(state) != 0 {
-		states = strings.Split(state, ",")
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
 	}
 
-	subOrders, err := c.GetSubOrdersPlus(0, user.ID, states)
+	subOrders, info, err := c.GetSubOrdersPage(&repo.SubOrderFilter{
+		TimeRange: timeRange,
+		UserID:    user.ID,
+		States:    oStates,
+	}, page)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
@@ -857,5 +931,6 @@ func (c *Ctl) HandleGetUserSubOrders(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &RespGetUserSubOrders{
 		RespBase:  req.GenResponse(err),
 		SubOrders: sOrders,
+		Page:      info,
 	})
 }
@@ -0,0 +1,143 @@
+package order
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+type (
+	// OrderEvent 订单事件及其中文描述
+	OrderEvent struct {
+		*model.TOrderEvent
+		KindCN string `json:"kind_cn"`
+		FromCN string `json:"from_cn,omitempty"`
+		ToCN   string `json:"to_cn,omitempty"`
+	}
+
+	// TimelineItem 订单时间线条目，Event、Trade 二者其一有效
+	TimelineItem struct {
+		Time  time.Time    `json:"time"`
+		Event *OrderEvent  `json:"event,omitempty"`
+		Trade *trade.Trade `json:"trade,omitempty"`
+	}
+
+	ReqGetOrderTimeline struct {
+		*model.ReqBase
+	}
+
+	RespGetOrderTimeline struct {
+		*model.RespBase
+		Timeline []*TimelineItem `json:"timeline"`
+	}
+)
+
+func newOrderEvent(e *model.TOrderEvent) *OrderEvent {
+
+	states := model.MOrderStateCN
+	if e.SID != 0 {
+		states = model.SOrderStateCN
+	}
+
+	return &OrderEvent{
+		TOrderEvent: e,
+		KindCN:      model.OrderEventKindCN[e.Kind],
+		FromCN:      states[e.From],
+		ToCN:        states[e.To],
+	}
+}
+
+// GetTimeline 查询订单时间线：订单及其子订单的事件，与订单支付、退费及子订单佣金结算的交易记录按时间合并；
+// viewer 不为 0 时仅合并该用户的交易记录，避免泄露接单员余额
+func (c *Ctl) GetTimeline(mid, viewer uint) ([]*TimelineItem, error) {
+
+	order, err := c.GetOrder(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := c.store.MasterOrders().GetEvents(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	subOrders, err := c.GetAllSubOrders(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	// 订单支付、追加、退费以订单 UUID 为交易 ID，子订单佣金以子订单 UUID 为交易 ID
+	tradeIDs := []string{order.UUID}
+	for _, so := range subOrders {
+		tradeIDs = append(tradeIDs, so.UUID)
+	}
+
+	trades, err := c.tc.GetTradesByIDs(tradeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*TimelineItem, 0, len(events)+len(trades))
+	for _, e := range events {
+		items = append(items, &TimelineItem{Time: e.CreatedAt, Event: newOrderEvent(e)})
+	}
+
+	for _, t := range trades {
+		if viewer != 0 && t.UserID != viewer {
+			continue
+		}
+
+		items = append(items, &TimelineItem{
+			Time: t.CreatedAt,
+			Trade: &trade.Trade{
+				TTradeRecord: t,
+				TypeCN:       model.TradeTypeCN[t.Type],
+				ChannelCN:    model.PayChannelCN[t.Channel],
+			},
+		})
+	}
+
+	// 同一时间的事件排在其引起的交易记录之前
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Time.Before(items[j].Time)
+	})
+
+	return items, nil
+}
+
+func (c *Ctl) HandleGetOrderTimeline(ctx *gin.Context) {
+
+	req := &ReqGetOrderTimeline{}
+
+	mid, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	// 发布人仅可查看自己的交易记录
+	viewer := uint(0)
+	user := c.uc.CurrentUser(ctx)
+	if user.Role == model.RolePublisher {
+		viewer = user.ID
+	}
+
+	timeline, err := c.GetTimeline(uint(mid), viewer)
+	if err != nil {
+		c.Errorf("get order timeline failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetOrderTimeline{
+		RespBase: req.GenResponse(nil),
+		Timeline: timeline,
+	})
+}
@@ -0,0 +1,42 @@
+package payment
+
+type (
+	// Biller 支持下载对账单的支付渠道，日期格式为 2006-01-02
+	Biller interface {
+		// TradeBill 下载交易账单，包含支付成功及退款记录
+		TradeBill(date string) ([]*TradeBillRow, error)
+		// FundFlowBill 下载基本账户资金账单，包含转账等资金变动记录
+		FundFlowBill(date string) ([]*FundFlowRow, error)
+	}
+
+	// TradeBillRow 交易账单明细，金额单位分
+	TradeBillRow struct {
+		TransactionID string `json:"transaction_id"` // 渠道订单号
+		OutTradeNo    string `json:"out_trade_no"`   // 商户订单号，即交易 ID
+		TradeState    string `json:"trade_state"`    // 交易状态：SUCCESS 或 REFUND
+		Amount        int64  `json:"amount"`         // 订单金额
+		RefundID      string `json:"refund_id"`      // 渠道退款单号
+		OutRefundNo   string `json:"out_refund_no"`  // 商户退款单号，即退款单号
+		RefundAmount  int64  `json:"refund_amount"`  // 退款金额
+		RefundState   string `json:"refund_state"`   // 退款状态
+	}
+
+	// FundFlowRow 资金账单明细，金额单位分
+	FundFlowRow struct {
+		BizNo     string `json:"biz_no"`     // 渠道业务单号
+		FlowID    string `json:"flow_id"`    // 资金流水单号
+		BizName   string `json:"biz_name"`   // 业务名称
+		BizType   string `json:"biz_type"`   // 业务类型
+		Direction string `json:"direction"`  // 收支类型：收入或支出
+		Amount    int64  `json:"amount"`     // 收支金额
+		VoucherNo string `json:"voucher_no"` // 业务凭证号，转账为商户批次单号
+		Remark    string `json:"remark"`     // 备注
+	}
+)
+
+const (
+	TradeStateRefund = "REFUND" // 交易账单中的退款记录
+
+	FundFlowIncome  = "收入"
+	FundFlowExpense = "支出"
+)
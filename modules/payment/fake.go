@@ -0,0 +1,340 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// FakeTokenHeader 模拟支付通知携带的校验头，避免外部伪造通知
+const FakeTokenHeader = "X-Fake-Pay-Token"
+
+type (
+	// Fake 本地模拟支付：预支付后异步回调支付通知，转账结果按配置模拟成功、失败或一直处理中，
+	// 配置退款通知地址时退款结果异步通知，否则同步返回；成功的交易按日期记入模拟账单
+	Fake struct {
+		*log.Logger
+		cfg       model.FakePay
+		notifyURL string
+		refundURL string
+		token     string
+		client    *http.Client
+
+		lock       sync.Mutex
+		transfers  map[string]*fakeTransfer
+		tradeBills map[string][]*TradeBillRow
+		fundBills  map[string][]*FundFlowRow
+	}
+
+	fakeTransfer struct {
+		amount    int64
+		state     string
+		createdAt time.Time
+		billed    bool
+	}
+)
+
+func NewFake(logger *log.Logger, notifyURL, refundURL string, cfg model.FakePay) *Fake {
+
+	if len(cfg.PayState) == 0 {
+		cfg.PayState = TradeStateSuccess
+	}
+
+	if len(cfg.TransferState) == 0 {
+		cfg.TransferState = DetailStateSuccess
+	}
+
+	if len(cfg.RefundState) == 0 {
+		cfg.RefundState = RefundStateSuccess
+	}
+
+	return &Fake{
+		Logger:     logger,
+		cfg:        cfg,
+		notifyURL:  notifyURL,
+		refundURL:  refundURL,
+		token:      randomHex(16),
+		client:     &http.Client{Timeout: 10 * time.Second},
+		transfers:  make(map[string]*fakeTransfer),
+		tradeBills: make(map[string][]*TradeBillRow),
+		fundBills:  make(map[string][]*FundFlowRow),
+	}
+}
+
+func randomHex(n int) string {
+
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (f *Fake) Name() string {
+	return ProviderFake
+}
+
+func (f *Fake) delay() time.Duration {
+	return time.Second * time.Duration(f.cfg.Delay)
+}
+
+// Prepay 模拟预支付，延迟后异步发送支付通知
+func (f *Fake) Prepay(req *PrepayRequest) (*PrepayResult, error) {
+
+	prepayID := "fake-prepay-" + req.TradeID
+	go f.notify(req)
+
+	return &PrepayResult{
+		PrepayID:  prepayID,
+		NonceStr:  randomHex(8),
+		SignType:  "RSA",
+		PaySign:   "fake",
+		Package:   "prepay_id=" + prepayID,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}, nil
+}
+
+// notify 模拟微信异步支付通知
+func (f *Fake) notify(req *PrepayRequest) {
+
+	time.Sleep(f.delay())
+
+	n := &Notification{
+		NotifyID:      randomHex(16),
+		EventType:     "TRANSACTION." + f.cfg.PayState,
+		OutTradeNo:    req.TradeID,
+		TransactionID: "fake-" + req.TradeID,
+		TradeState:    f.cfg.PayState,
+		PayerOpenID:   req.OpenID,
+		Amount:        req.Amount,
+	}
+
+	if f.cfg.PayState == TradeStateSuccess {
+		f.addTradeBill(&TradeBillRow{
+			TransactionID: n.TransactionID,
+			OutTradeNo:    req.TradeID,
+			TradeState:    TradeStateSuccess,
+			Amount:        req.Amount,
+		})
+	}
+
+	f.send(f.notifyURL, n)
+}
+
+// send 发送模拟通知：回调失败时按间隔重试，最多 3 次
+func (f *Fake) send(url string, n interface{}) {
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		f.Errorf("marshal fake notification failed, err=%s", err.Error())
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		err = f.post(url, body)
+		if err == nil {
+			return
+		}
+
+		f.Errorf("send fake notification failed, url=%s, err=%s", url, err.Error())
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+}
+
+func (f *Fake) post(url string, body []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FakeTokenHeader, f.token)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// parse 校验并解析模拟通知，返回原始通知内容
+func (f *Fake) parse(req *http.Request, n interface{}) (string, error) {
+
+	if req.Header.Get(FakeTokenHeader) != f.token {
+		return "", fmt.Errorf("invalid fake notification token")
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), json.Unmarshal(body, n)
+}
+
+// ParseNotify 解析模拟支付通知
+func (f *Fake) ParseNotify(req *http.Request) (*Notification, error) {
+
+	n := &Notification{}
+	payload, err := f.parse(req, n)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Payload = payload
+	return n, nil
+}
+
+// Transfer 模拟转账，转账结果在延迟后按配置生效
+func (f *Fake) Transfer(req *TransferRequest) (*TransferResult, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.transfers[req.TradeID] = &fakeTransfer{
+		amount:    req.Amount,
+		state:     f.cfg.TransferState,
+		createdAt: time.Now(),
+	}
+
+	return &TransferResult{BatchID: "fake-batch-" + req.TradeID}, nil
+}
+
+// QueryTransfer 查询模拟转账结果，配置为 PROCESSING 时转账一直处于处理中
+func (f *Fake) QueryTransfer(tradeID string) (*TransferStatus, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	t, ok := f.transfers[tradeID]
+	if !ok {
+		return nil, ErrTransferNotFound
+	}
+
+	status := &TransferStatus{
+		BatchStatus:  TransferStateProcessing,
+		DetailStatus: DetailStateProcessing,
+		Amount:       t.amount,
+	}
+
+	if time.Since(t.createdAt) < f.delay() || t.state == DetailStateProcessing {
+		return status, nil
+	}
+
+	status.BatchStatus = TransferStateFinished
+	status.DetailStatus = t.state
+	if t.state == DetailStateFail {
+		status.FailReason = "模拟转账失败"
+	}
+	if t.state == DetailStateSuccess && !t.billed {
+		t.billed = true
+		date := time.Now().Format(billDateLayout)
+		f.fundBills[date] = append(f.fundBills[date], &FundFlowRow{
+			BizNo:     "fake-batch-" + tradeID,
+			FlowID:    randomHex(16),
+			BizName:   "商家转账到零钱",
+			BizType:   "转账",
+			Direction: FundFlowExpense,
+			Amount:    t.amount,
+			VoucherNo: tradeID,
+		})
+	}
+
+	return status, nil
+}
+
+// Refund 模拟退款：未配置退款通知地址时同步返回退款结果，否则延迟后异步通知
+func (f *Fake) Refund(req *RefundRequest) (*RefundResult, error) {
+
+	refundID := "fake-refund-" + req.RefundID
+	if f.cfg.RefundState == RefundStateSuccess {
+		f.addTradeBill(&TradeBillRow{
+			TransactionID: "fake-" + req.TradeID,
+			OutTradeNo:    req.TradeID,
+			TradeState:    TradeStateRefund,
+			Amount:        req.Total,
+			RefundID:      refundID,
+			OutRefundNo:   req.RefundID,
+			RefundAmount:  req.Amount,
+			RefundState:   RefundStateSuccess,
+		})
+	}
+
+	if len(f.refundURL) == 0 {
+		return &RefundResult{RefundID: refundID, Status: f.cfg.RefundState}, nil
+	}
+
+	go func() {
+		time.Sleep(f.delay())
+		f.send(f.refundURL, &RefundNotification{
+			RefundID:         req.RefundID,
+			ProviderRefundID: refundID,
+			TradeID:          req.TradeID,
+			Status:           f.cfg.RefundState,
+			Amount:           req.Amount,
+		})
+	}()
+
+	return &RefundResult{RefundID: refundID, Status: RefundStateProcessing}, nil
+}
+
+// ParseRefundNotify 解析模拟退款通知
+func (f *Fake) ParseRefundNotify(req *http.Request) (*RefundNotification, error) {
+
+	n := &RefundNotification{}
+	payload, err := f.parse(req, n)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Payload = payload
+	return n, nil
+}
+
+const billDateLayout = "2006-01-02"
+
+func (f *Fake) addTradeBill(row *TradeBillRow) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	date := time.Now().Format(billDateLayout)
+	f.tradeBills[date] = append(f.tradeBills[date], row)
+}
+
+// TradeBill 查询模拟交易账单，仅包含本进程内产生的交易
+func (f *Fake) TradeBill(date string) ([]*TradeBillRow, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]*TradeBillRow{}, f.tradeBills[date]...), nil
+}
+
+// FundFlowBill 查询模拟资金账单，仅包含本进程内成功的转账
+func (f *Fake) FundFlowBill(date string) ([]*FundFlowRow, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]*FundFlowRow{}, f.fundBills[date]...), nil
+}
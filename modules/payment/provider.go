@@ -0,0 +1,124 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrTransferNotFound 渠道侧不存在该转账，可安全地重新发起转账
+var ErrTransferNotFound = errors.New("transfer not found")
+
+const (
+	ProviderWechat = "wechat" // 微信支付 v3
+	ProviderFake   = "fake"   // 本地模拟支付，仅用于开发及测试环境
+)
+
+const (
+	TradeStateSuccess = "SUCCESS" // 支付成功
+
+	TransferStateProcessing = "PROCESSING" // 转账中
+	TransferStateFinished   = "FINISHED"   // 转账批次已完成
+
+	DetailStateProcessing = "PROCESSING" // 转账明细处理中
+	DetailStateSuccess    = "SUCCESS"    // 转账成功
+	DetailStateFail       = "FAIL"       // 转账失败
+
+	RefundStateProcessing = "PROCESSING" // 退款处理中
+	RefundStateSuccess    = "SUCCESS"    // 退款成功
+	RefundStateClosed     = "CLOSED"     // 退款关闭
+	RefundStateAbnormal   = "ABNORMAL"   // 退款异常
+)
+
+type (
+	// Provider 支付渠道（PaymentProvider）：充值预支付、支付通知解析、提现转账、转账查询及退款，
+	// 资金流程仅依赖该接口，便于切换支付渠道或在本地使用模拟实现
+	Provider interface {
+		// Name 支付渠道名称
+		Name() string
+		// Prepay 创建预支付订单
+		Prepay(req *PrepayRequest) (*PrepayResult, error)
+		// ParseNotify 校验并解析支付通知
+		ParseNotify(req *http.Request) (*Notification, error)
+		// Transfer 发起转账至用户零钱
+		Transfer(req *TransferRequest) (*TransferResult, error)
+		// QueryTransfer 查询转账结果
+		QueryTransfer(tradeID string) (*TransferStatus, error)
+		// Refund 原路退款
+		Refund(req *RefundRequest) (*RefundResult, error)
+		// ParseRefundNotify 校验并解析退款结果通知
+		ParseRefundNotify(req *http.Request) (*RefundNotification, error)
+	}
+
+	PrepayRequest struct {
+		OpenID  string
+		TradeID string
+		Desc    string
+		Amount  int64
+	}
+
+	// PrepayResult 预支付结果，客户端据此调起支付
+	PrepayResult struct {
+		PrepayID  string `json:"prepay_id"`
+		NonceStr  string `json:"nonce_str"`
+		SignType  string `json:"sign_type"`
+		PaySign   string `json:"pay_sign"`
+		Package   string `json:"package"`
+		Timestamp string `json:"timestamp"`
+		PayURL    string `json:"pay_url,omitempty"` // 跳转支付链接，支付宝有效
+	}
+
+	// Notification 解析后的支付通知
+	Notification struct {
+		NotifyID      string `json:"notify_id"`
+		EventType     string `json:"event_type"`
+		OutTradeNo    string `json:"out_trade_no"`
+		TransactionID string `json:"transaction_id"`
+		TradeState    string `json:"trade_state"`
+		PayerOpenID   string `json:"payer_openid"`
+		Amount        int64  `json:"amount"`
+		Payload       string `json:"-"` // 原始通知内容，用于存档
+	}
+
+	TransferRequest struct {
+		Payee     string // 收款方：微信为 openid，支付宝为登录账号
+		PayeeName string // 收款人姓名，支付宝必填
+		TradeID   string
+		Desc      string
+		Amount    int64
+	}
+
+	TransferResult struct {
+		BatchID string
+	}
+
+	// TransferStatus 转账结果：批次状态及明细状态
+	TransferStatus struct {
+		BatchStatus  string
+		DetailStatus string // 转账明细状态，批次尚未生成明细时为空
+		Amount       int64
+		FailReason   string // 转账失败原因
+	}
+
+	RefundRequest struct {
+		TradeID  string // 原支付交易 ID
+		RefundID string // 退款单号
+		Reason   string
+		Amount   int64 // 退款金额
+		Total    int64 // 原支付金额
+	}
+
+	RefundResult struct {
+		RefundID string // 渠道退款单号
+		Status   string
+	}
+
+	// RefundNotification 解析后的退款结果通知
+	RefundNotification struct {
+		RefundID         string `json:"refund_id"`          // 退款单号
+		ProviderRefundID string `json:"provider_refund_id"` // 渠道退款单号
+		TradeID          string `json:"trade_id"`           // 原支付交易 ID
+		Status           string `json:"status"`
+		Amount           int64  `json:"amount"`
+		Payload          string `json:"-"`
+	}
+)
@@ -0,0 +1,140 @@
+package price
+
+import (
+	"errors"
+	"fmt"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type Ctl struct {
+	*log.Logger
+	db *gorm.DB
+}
+
+// Quote 订单报价
+type Quote struct {
+	Reward     int64 `json:"reward"`     // 单个任务奖励，单位：分
+	Commission int64 `json:"commission"` // 单个任务平台佣金，单位：分
+	UnitPrice  int64 `json:"unit_price"` // 单个任务价格，单位：分
+}
+
+func NewCtl(logger *log.Logger, db *gorm.DB) *Ctl {
+
+	return &Ctl{logger, db}
+}
+
+// defaultPrice 平台未配置定价时的默认价格，与历史固定单价（发布 200 分、奖励 100 分）保持一致
+func defaultPrice(platform model.Platform) *model.PlatformPrice {
+
+	return &model.PlatformPrice{
+		Platform:       platform,
+		BaseReward:     model.DefaultBaseReward,
+		MinReward:      model.DefaultBaseReward,
+		CommissionRate: model.DefaultCommissionRate,
+	}
+}
+
+// GetPrice 查询平台定价
+func (c *Ctl) GetPrice(platform model.Platform) (*model.PlatformPrice, error) {
+
+	if _, ok := model.PlatformCN[platform]; !ok {
+		return nil, fmt.Errorf("invalid platform: %d", platform)
+	}
+
+	price := &model.TPlatformPrice{}
+	err := c.db.Model(model.TPlatformPrice{}).Where("platform = ?", platform).First(price).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return defaultPrice(platform), nil
+		}
+		return nil, err
+	}
+
+	return price.PlatformPrice, nil
+}
+
+// GetPrices 查询所有平台定价
+func (c *Ctl) GetPrices() ([]*model.PlatformPrice, error) {
+
+	prices := make([]*model.PlatformPrice, 0)
+	for platform := model.PlatformTB; platform <= model.PlatformKS; platform++ {
+		price, err := c.GetPrice(platform)
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, price)
+	}
+
+	return prices, nil
+}
+
+// SetPrice 修改平台定价，已发布订单使用发布时的价格快照，不受影响
+func (c *Ctl) SetPrice(price *model.PlatformPrice) (*model.PlatformPrice, error) {
+
+	if _, ok := model.PlatformCN[price.Platform]; !ok {
+		return nil, fmt.Errorf("invalid platform: %d", price.Platform)
+	}
+
+	if price.MinReward <= 0 || price.BaseReward < price.MinReward {
+		return nil, fmt.Errorf("默认奖励不能低于最低奖励")
+	}
+
+	if price.CommissionRate < 0 {
+		return nil, fmt.Errorf("invalid commission rate: %d", price.CommissionRate)
+	}
+
+	old := &model.TPlatformPrice{}
+	err := c.db.Model(model.TPlatformPrice{}).Where("platform = ?", price.Platform).First(old).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		err = c.db.Create(&model.TPlatformPrice{PlatformPrice: price}).Error
+		if err != nil {
+			return nil, err
+		}
+
+		return price, nil
+	}
+
+	err = c.db.Model(model.TPlatformPrice{}).Where("id = ?", old.ID).Updates(map[string]interface{}{
+		"base_reward":     price.BaseReward,
+		"min_reward":      price.MinReward,
+		"commission_rate": price.CommissionRate,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return price, nil
+}
+
+// Quote 计算订单单价：单价 = 奖励 + 奖励 * 佣金比例（向上取整），奖励为 0 时使用平台默认奖励
+func (c *Ctl) Quote(platform model.Platform, reward int64) (*Quote, error) {
+
+	price, err := c.GetPrice(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	if reward == 0 {
+		reward = price.BaseReward
+	}
+
+	if reward < price.MinReward {
+		return nil, fmt.Errorf("单个任务奖励不能低于 %d 分", price.MinReward)
+	}
+
+	commission := (reward*price.CommissionRate + 999) / 1000
+	return &Quote{
+		Reward:     reward,
+		Commission: commission,
+		UnitPrice:  reward + commission,
+	}, nil
+}
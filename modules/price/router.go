@@ -0,0 +1,174 @@
+package price
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	ReqGetPrices struct {
+		*model.ReqBase
+	}
+
+	Price struct {
+		*model.PlatformPrice
+		PlatformCN string `json:"platform_cn"`
+	}
+
+	RespGetPrices struct {
+		*model.RespBase
+		Prices []*Price `json:"prices"`
+	}
+
+	ReqSetPrice struct {
+		*model.ReqBase
+		BaseReward     int64 `json:"base_reward"`
+		MinReward      int64 `json:"min_reward"`
+		CommissionRate int64 `json:"commission_rate"`
+	}
+
+	RespSetPrice struct {
+		*model.RespBase
+		Price *Price `json:"price"`
+	}
+
+	ReqQuote struct {
+		*model.ReqBase
+	}
+
+	RespQuote struct {
+		*model.RespBase
+		Quote *Quote `json:"quote"`
+	}
+)
+
+// InitRouter 注册定价查询接口
+func (c *Ctl) InitRouter(g *gin.RouterGroup) {
+
+	// 查询平台定价
+	g.GET("/prices", c.HandleGetPrices)
+
+	// 订单报价
+	g.GET("/prices/:platform/quote", c.HandleQuote)
+}
+
+// InitAdminRouter 注册定价管理接口，路由组需已挂载管理员权限校验
+func (c *Ctl) InitAdminRouter(g *gin.RouterGroup) {
+
+	// 查询平台定价
+	g.GET("/prices", c.HandleGetPrices)
+
+	// 修改平台定价
+	g.PUT("/prices/:platform", c.HandleSetPrice)
+}
+
+func parsePlatform(ctx *gin.Context) (model.Platform, error) {
+
+	pid, err := strconv.Atoi(ctx.Param("platform"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid platform: %s", ctx.Param("platform"))
+	}
+
+	if _, ok := model.PlatformCN[model.Platform(pid)]; !ok {
+		return 0, fmt.Errorf("invalid platform: %s", ctx.Param("platform"))
+	}
+
+	return model.Platform(pid), nil
+}
+
+func (c *Ctl) HandleGetPrices(ctx *gin.Context) {
+
+	req := &ReqGetPrices{}
+	prices, err := c.GetPrices()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*Price, 0)
+	for _, p := range prices {
+		data = append(data, &Price{
+			PlatformPrice: p,
+			PlatformCN:    model.PlatformCN[p.Platform],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetPrices{
+		RespBase: req.GenResponse(nil),
+		Prices:   data,
+	})
+}
+
+func (c *Ctl) HandleQuote(ctx *gin.Context) {
+
+	req := &ReqQuote{}
+	platform, err := parsePlatform(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	reward, err := strconv.ParseInt(ctx.DefaultQuery("reward", "0"), 10, 64)
+	if err != nil {
+		err := fmt.Errorf("invalid reward: %s", ctx.Query("reward"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	quote, err := c.Quote(platform, reward)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespQuote{
+		RespBase: req.GenResponse(nil),
+		Quote:    quote,
+	})
+}
+
+func (c *Ctl) HandleSetPrice(ctx *gin.Context) {
+
+	req := &ReqSetPrice{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	platform, err := parsePlatform(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	price, err := c.SetPrice(&model.PlatformPrice{
+		Platform:       platform,
+		BaseReward:     req.BaseReward,
+		MinReward:      req.MinReward,
+		CommissionRate: req.CommissionRate,
+	})
+	if err != nil {
+		c.Errorf("set price failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespSetPrice{
+		RespBase: req.GenResponse(nil),
+		Price: &Price{
+			PlatformPrice: price,
+			PlatformCN:    model.PlatformCN[price.Platform],
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package recon
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/user"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	ErrBillUnsupported = errors.New("支付渠道不支持下载对账单")
+	ErrReconRunning    = errors.New("对账任务进行中")
+)
+
+// Ctl 微信支付对账：下载交易账单及资金账单，与本地充值、退款、提现记录逐笔核对
+type Ctl struct {
+	*log.Logger
+	db   *gorm.DB
+	conf model.ReconConf
+
+	uc     *user.Ctl
+	biller payment.Biller
+	lock   sync.Mutex
+}
+
+func NewCtl(logger *log.Logger, db *gorm.DB, uc *user.Ctl, pays map[model.PayChannel]payment.Provider, conf model.ReconConf) *Ctl {
+
+	c := &Ctl{
+		Logger: logger,
+		db:     db,
+		conf:   conf,
+		uc:     uc,
+	}
+
+	if biller, ok := pays[model.ChannelWechat].(payment.Biller); ok {
+		c.biller = biller
+	}
+
+	return c
+}
+
+// Start 每日在配置的时间对前一日账单对账
+func (c *Ctl) Start() {
+
+	if !c.conf.Enable || c.biller == nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute * 10)
+
+	go func() {
+		for range ticker.C {
+			if uint(time.Now().Hour()) != c.conf.Hour {
+				continue
+			}
+
+			go c.checkDailyRecon()
+		}
+	}()
+}
+
+// checkDailyRecon 前一日尚未对账完成时执行对账
+func (c *Ctl) checkDailyRecon() {
+
+	date := time.Now().AddDate(0, 0, -1).Format(dateLayout)
+	run, err := c.GetRun(date)
+	if err == nil && run.State == model.ReconStateDone {
+		return
+	}
+
+	_, err = c.Reconcile(date)
+	if err != nil {
+		c.Errorf("reconcile bill failed, date=%s, err=%s", date, err.Error())
+	}
+}
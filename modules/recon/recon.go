@@ -0,0 +1,503 @@
+package recon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+)
+
+// result 单次对账结果
+type result struct {
+	matched       int
+	discrepancies []*model.TReconDiscrepancy
+}
+
+func (r *result) add(d *model.TReconDiscrepancy) {
+	r.discrepancies = append(r.discrepancies, d)
+}
+
+// Reconcile 对指定日期的微信支付账单对账，重新对账时替换未处理的差异，已处理的差异保留
+func (c *Ctl) Reconcile(date string) (*model.TReconRun, error) {
+
+	if c.biller == nil {
+		return nil, ErrBillUnsupported
+	}
+
+	day, err := time.ParseInLocation(dateLayout, date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date: [%s]", date)
+	}
+
+	now := time.Now()
+	if !day.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)) {
+		return nil, fmt.Errorf("仅可对账今日之前的账单")
+	}
+
+	if !c.lock.TryLock() {
+		return nil, ErrReconRunning
+	}
+	defer c.lock.Unlock()
+
+	run, err := c.startRun(date)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.reconcile(day)
+	if err != nil {
+		run.State, run.Error = model.ReconStateFailed, err.Error()
+		uErr := c.db.Model(model.TReconRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+			"state": run.State,
+			"error": run.Error,
+		}).Error
+		if uErr != nil {
+			c.Errorf("update recon run failed, err=%s", uErr.Error())
+		}
+		return nil, err
+	}
+
+	err = c.saveResult(run, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// startRun 创建或重置账单日的对账任务
+func (c *Ctl) startRun(date string) (*model.TReconRun, error) {
+
+	run, err := c.GetRun(date)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		run = &model.TReconRun{Date: date, Channel: model.ChannelWechat, State: model.ReconStateRunning}
+		return run, c.db.Model(model.TReconRun{}).Create(run).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run.State, run.Matched, run.Discrepancies, run.Error = model.ReconStateRunning, 0, 0, ""
+	err = c.db.Model(model.TReconRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"state":         run.State,
+		"matched":       0,
+		"discrepancies": 0,
+		"error":         "",
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+func (c *Ctl) reconcile(day time.Time) (*result, error) {
+
+	date := day.Format(dateLayout)
+	trades, err := c.biller.TradeBill(date)
+	if err != nil {
+		return nil, fmt.Errorf("download trade bill failed, %s", err.Error())
+	}
+
+	funds, err := c.biller.FundFlowBill(date)
+	if err != nil {
+		return nil, fmt.Errorf("download fund flow bill failed, %s", err.Error())
+	}
+
+	pays, refunds := make([]*payment.TradeBillRow, 0), make([]*payment.TradeBillRow, 0)
+	for _, row := range trades {
+		if row.TradeState == payment.TradeStateRefund {
+			refunds = append(refunds, row)
+			continue
+		}
+		pays = append(pays, row)
+	}
+
+	res := &result{discrepancies: make([]*model.TReconDiscrepancy, 0)}
+	start, end := day, day.AddDate(0, 0, 1)
+
+	err = c.reconcilePays(res, pays, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.reconcileRefunds(res, refunds, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.reconcileTransfers(res, funds, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// reconcilePays 核对充值：账单中的成功交易与本地预支付记录按交易 ID 匹配，
+// 本地当日支付成功但账单中缺失的记为渠道缺失
+func (c *Ctl) reconcilePays(res *result, rows []*payment.TradeBillRow, start, end time.Time) error {
+
+	ids := make([]string, 0)
+	for _, row := range rows {
+		ids = append(ids, row.OutTradeNo)
+	}
+
+	records := make([]*model.TWxPayRecord, 0)
+	err := c.db.Model(model.TWxPayRecord{}).Where("trade_id IN ?", ids).
+		Or("state = ? AND updated_at >= ? AND updated_at < ?", model.WxPayStateSUCCESS, start, end).
+		Find(&records).Error
+	if err != nil {
+		return err
+	}
+
+	locals := make(map[string]*model.TWxPayRecord)
+	for _, r := range records {
+		locals[r.TradeID] = r
+	}
+
+	for _, row := range rows {
+		d := &model.TReconDiscrepancy{
+			Category:     model.ReconCategoryPay,
+			TradeID:      row.OutTradeNo,
+			RemoteID:     row.TransactionID,
+			RemoteAmount: row.Amount,
+			RemoteState:  row.TradeState,
+		}
+
+		local, ok := locals[row.OutTradeNo]
+		if !ok {
+			d.Kind = model.DiscrepancyMissingLocal
+			res.add(d)
+			continue
+		}
+		delete(locals, row.OutTradeNo)
+
+		d.LocalAmount, d.LocalState = local.Amount, string(local.State)
+		switch {
+		case local.Amount != row.Amount:
+			d.Kind = model.DiscrepancyAmountMismatch
+		case string(local.State) != row.TradeState:
+			d.Kind = model.DiscrepancyStateMismatch
+		default:
+			res.matched++
+			continue
+		}
+		res.add(d)
+	}
+
+	for _, local := range locals {
+		res.add(&model.TReconDiscrepancy{
+			Category:    model.ReconCategoryPay,
+			Kind:        model.DiscrepancyMissingRemote,
+			TradeID:     local.TradeID,
+			RemoteID:    local.WxTransactionID,
+			LocalAmount: local.Amount,
+			LocalState:  string(local.State),
+		})
+	}
+
+	return nil
+}
+
+// reconcileRefunds 核对退款：账单中的退款记录与本地退款记录按退款单号匹配
+func (c *Ctl) reconcileRefunds(res *result, rows []*payment.TradeBillRow, start, end time.Time) error {
+
+	ids := make([]string, 0)
+	for _, row := range rows {
+		ids = append(ids, row.OutRefundNo)
+	}
+
+	records := make([]*model.TRefundRecord, 0)
+	err := c.db.Model(model.TRefundRecord{}).Where("channel = ?", model.ChannelWechat).
+		Where(c.db.Where("refund_id IN ?", ids).
+			Or("state = ? AND updated_at >= ? AND updated_at < ?", payment.RefundStateSuccess, start, end)).
+		Find(&records).Error
+	if err != nil {
+		return err
+	}
+
+	locals := make(map[string]*model.TRefundRecord)
+	for _, r := range records {
+		locals[r.RefundID] = r
+	}
+
+	for _, row := range rows {
+		d := &model.TReconDiscrepancy{
+			Category:     model.ReconCategoryRefund,
+			TradeID:      row.OutRefundNo,
+			RemoteID:     row.RefundID,
+			RemoteAmount: row.RefundAmount,
+			RemoteState:  row.RefundState,
+		}
+
+		local, ok := locals[row.OutRefundNo]
+		if !ok {
+			d.Kind = model.DiscrepancyMissingLocal
+			res.add(d)
+			continue
+		}
+		delete(locals, row.OutRefundNo)
+
+		d.LocalAmount, d.LocalState = local.Amount, local.State
+		switch {
+		case local.Amount != row.RefundAmount:
+			d.Kind = model.DiscrepancyAmountMismatch
+		case local.State != row.RefundState:
+			d.Kind = model.DiscrepancyStateMismatch
+		default:
+			res.matched++
+			continue
+		}
+		res.add(d)
+	}
+
+	for _, local := range locals {
+		res.add(&model.TReconDiscrepancy{
+			Category:    model.ReconCategoryRefund,
+			Kind:        model.DiscrepancyMissingRemote,
+			TradeID:     local.RefundID,
+			RemoteID:    local.ProviderRefundID,
+			LocalAmount: local.Amount,
+			LocalState:  local.State,
+		})
+	}
+
+	return nil
+}
+
+// reconcileTransfers 核对提现：资金账单中的支出记录与本地转账记录按交易 ID 或转账批次号匹配
+func (c *Ctl) reconcileTransfers(res *result, rows []*payment.FundFlowRow, start, end time.Time) error {
+
+	expenses := make([]*payment.FundFlowRow, 0)
+	keys := make([]string, 0)
+	for _, row := range rows {
+		if row.Direction != payment.FundFlowExpense {
+			continue
+		}
+		expenses = append(expenses, row)
+		keys = append(keys, row.VoucherNo, row.BizNo)
+	}
+
+	records := make([]*model.TWxTransferRecord, 0)
+	err := c.db.Model(model.TWxTransferRecord{}).Where("trade_id IN ? OR batch_id IN ?", keys, keys).
+		Or("state = ? AND updated_at >= ? AND updated_at < ?", model.TransferStateSUCCESS, start, end).
+		Find(&records).Error
+	if err != nil {
+		return err
+	}
+
+	locals := make(map[string]*model.TWxTransferRecord)
+	for _, r := range records {
+		locals[r.TradeID] = r
+		if len(r.BatchID) != 0 {
+			locals[r.BatchID] = r
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, row := range expenses {
+		local, ok := locals[row.VoucherNo]
+		if !ok {
+			local, ok = locals[row.BizNo]
+		}
+
+		if !ok {
+			// 非转账类支出（如退款）由交易账单核对
+			if !strings.Contains(row.BizName, "转账") && !strings.Contains(row.BizType, "转账") {
+				continue
+			}
+
+			res.add(&model.TReconDiscrepancy{
+				Category:     model.ReconCategoryTransfer,
+				Kind:         model.DiscrepancyMissingLocal,
+				TradeID:      row.VoucherNo,
+				RemoteID:     row.BizNo,
+				RemoteAmount: row.Amount,
+				RemoteState:  payment.DetailStateSuccess,
+			})
+			continue
+		}
+		seen[local.TradeID] = true
+
+		d := &model.TReconDiscrepancy{
+			Category:     model.ReconCategoryTransfer,
+			TradeID:      local.TradeID,
+			RemoteID:     row.BizNo,
+			LocalAmount:  local.Amount,
+			LocalState:   string(local.State),
+			RemoteAmount: row.Amount,
+			RemoteState:  payment.DetailStateSuccess,
+		}
+
+		switch {
+		case local.Amount != row.Amount:
+			d.Kind = model.DiscrepancyAmountMismatch
+		case d.LocalState != model.TransferStateSUCCESS:
+			d.Kind = model.DiscrepancyStateMismatch
+		default:
+			res.matched++
+			continue
+		}
+		res.add(d)
+	}
+
+	for _, local := range records {
+		if seen[local.TradeID] || local.State != model.TransferStateSUCCESS {
+			continue
+		}
+
+		res.add(&model.TReconDiscrepancy{
+			Category:    model.ReconCategoryTransfer,
+			Kind:        model.DiscrepancyMissingRemote,
+			TradeID:     local.TradeID,
+			RemoteID:    local.BatchID,
+			LocalAmount: local.Amount,
+			LocalState:  string(local.State),
+		})
+	}
+
+	return nil
+}
+
+// saveResult 保存对账结果：删除上次未处理的差异，与已处理差异相同的不再重复记录
+func (c *Ctl) saveResult(run *model.TReconRun, res *result) error {
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	err = tx.Where("date = ? AND channel = ? AND resolved = ?", run.Date, run.Channel, false).
+		Delete(&model.TReconDiscrepancy{}).Error
+	if err != nil {
+		return err
+	}
+
+	resolved := make([]*model.TReconDiscrepancy, 0)
+	err = tx.Model(model.TReconDiscrepancy{}).Where("date = ? AND channel = ? AND resolved = ?", run.Date, run.Channel, true).
+		Find(&resolved).Error
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool)
+	for _, d := range resolved {
+		exists[discrepancyKey(d)] = true
+	}
+
+	count := 0
+	for _, d := range res.discrepancies {
+		d.RunID, d.Date, d.Channel = run.ID, run.Date, run.Channel
+		if exists[discrepancyKey(d)] {
+			continue
+		}
+
+		err = tx.Model(model.TReconDiscrepancy{}).Create(d).Error
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	run.State, run.Matched, run.Discrepancies = model.ReconStateDone, res.matched, count
+	err = tx.Model(model.TReconRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"state":         run.State,
+		"matched":       run.Matched,
+		"discrepancies": run.Discrepancies,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func discrepancyKey(d *model.TReconDiscrepancy) string {
+	return fmt.Sprintf("%s/%d/%s", d.Category, d.Kind, d.TradeID)
+}
+
+// GetRun 查询账单日的对账任务
+func (c *Ctl) GetRun(date string) (*model.TReconRun, error) {
+
+	run := &model.TReconRun{}
+	err := c.db.Model(model.TReconRun{}).Where("date = ? AND channel = ?", date, model.ChannelWechat).
+		First(run).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// GetRuns 查询最近的对账任务
+func (c *Ctl) GetRuns(limit int) ([]*model.TReconRun, error) {
+
+	runs := make([]*model.TReconRun, 0)
+	err := c.db.Model(model.TReconRun{}).Order("date desc").Limit(limit).Find(&runs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// GetDiscrepancies 查询对账差异，date 为空时查询全部日期，resolved 为空时不区分处理状态
+func (c *Ctl) GetDiscrepancies(date string, resolved *bool) ([]*model.TReconDiscrepancy, error) {
+
+	db := c.db.Model(model.TReconDiscrepancy{})
+	if len(date) != 0 {
+		db = db.Where("date = ?", date)
+	}
+
+	if resolved != nil {
+		db = db.Where("resolved = ?", *resolved)
+	}
+
+	ds := make([]*model.TReconDiscrepancy, 0)
+	err := db.Order("id desc").Find(&ds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// ResolveDiscrepancy 标记差异已处理，资金差异需先通过调账、重放通知等方式修正
+func (c *Ctl) ResolveDiscrepancy(operatorID, id uint, resolution string) (*model.TReconDiscrepancy, error) {
+
+	now := time.Now()
+	ret := c.db.Model(model.TReconDiscrepancy{}).Where("id = ? AND resolved = ?", id, false).
+		Updates(map[string]interface{}{
+			"resolved":    true,
+			"resolution":  resolution,
+			"resolved_by": operatorID,
+			"resolved_at": &now,
+		})
+	if ret.Error != nil {
+		return nil, ret.Error
+	}
+
+	if ret.RowsAffected == 0 {
+		return nil, fmt.Errorf("差异记录不存在或已处理")
+	}
+
+	d := &model.TReconDiscrepancy{}
+	err := c.db.Model(model.TReconDiscrepancy{}).Where("id = ?", id).First(d).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
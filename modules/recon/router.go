@@ -0,0 +1,221 @@
+package recon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	ReqGetRuns struct {
+		*model.ReqBase
+	}
+
+	Run struct {
+		*model.TReconRun
+		StateCN string `json:"state_cn"`
+	}
+
+	RespGetRuns struct {
+		*model.RespBase
+		Runs []*Run `json:"runs"`
+	}
+
+	ReqReconcile struct {
+		*model.ReqBase
+		Date string `json:"date" valid:"required"`
+	}
+
+	RespReconcile struct {
+		*model.RespBase
+		Run *Run `json:"run"`
+	}
+
+	ReqGetDiscrepancies struct {
+		*model.ReqBase
+	}
+
+	Discrepancy struct {
+		*model.TReconDiscrepancy
+		CategoryCN string `json:"category_cn"`
+		KindCN     string `json:"kind_cn"`
+	}
+
+	RespGetDiscrepancies struct {
+		*model.RespBase
+		Discrepancies []*Discrepancy `json:"discrepancies"`
+	}
+
+	ReqResolveDiscrepancy struct {
+		*model.ReqBase
+		Resolution string `json:"resolution" valid:"required"`
+	}
+
+	RespResolveDiscrepancy struct {
+		*model.RespBase
+		Discrepancy *Discrepancy `json:"discrepancy"`
+	}
+)
+
+// InitAdminRouter 注册对账管理接口，路由组需已挂载管理员权限校验
+func (c *Ctl) InitAdminRouter(g *gin.RouterGroup) {
+
+	// 查询对账任务
+	g.GET("/recon/runs", c.HandleGetRuns)
+
+	// 手动对账，重新对账将替换未处理的差异
+	g.POST("/recon/runs", c.HandleReconcile)
+
+	// 查询对账差异
+	g.GET("/recon/discrepancies", c.HandleGetDiscrepancies)
+
+	// 处理对账差异
+	g.POST("/recon/discrepancies/:id/resolve", c.HandleResolveDiscrepancy)
+}
+
+func newRun(r *model.TReconRun) *Run {
+	return &Run{TReconRun: r, StateCN: model.ReconStateCN[r.State]}
+}
+
+func newDiscrepancy(d *model.TReconDiscrepancy) *Discrepancy {
+	return &Discrepancy{
+		TReconDiscrepancy: d,
+		CategoryCN:        model.ReconCategoryCN[d.Category],
+		KindCN:            model.DiscrepancyKindCN[d.Kind],
+	}
+}
+
+func (c *Ctl) HandleGetRuns(ctx *gin.Context) {
+
+	req := &ReqGetRuns{}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 {
+		err = fmt.Errorf("invalid limit: [%v]", ctx.Query("limit"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	runs, err := c.GetRuns(limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*Run, 0)
+	for _, r := range runs {
+		data = append(data, newRun(r))
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetRuns{
+		RespBase: req.GenResponse(nil),
+		Runs:     data,
+	})
+}
+
+func (c *Ctl) HandleReconcile(ctx *gin.Context) {
+
+	req := &ReqReconcile{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	run, err := c.Reconcile(req.Date)
+	if err != nil {
+		c.Errorf("reconcile bill failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReconcile{
+		RespBase: req.GenResponse(nil),
+		Run:      newRun(run),
+	})
+}
+
+func (c *Ctl) HandleGetDiscrepancies(ctx *gin.Context) {
+
+	req := &ReqGetDiscrepancies{}
+
+	var resolved *bool
+	if r := ctx.Query("resolved"); len(r) != 0 {
+		b, err := strconv.ParseBool(r)
+		if err != nil {
+			err = fmt.Errorf("invalid resolved: [%v]", r)
+			c.Errorf("parsing request failed, err=%s", err.Error())
+			ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+			return
+		}
+		resolved = &b
+	}
+
+	ds, err := c.GetDiscrepancies(ctx.Query("date"), resolved)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*Discrepancy, 0)
+	for _, d := range ds {
+		data = append(data, newDiscrepancy(d))
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetDiscrepancies{
+		RespBase:      req.GenResponse(nil),
+		Discrepancies: data,
+	})
+}
+
+func (c *Ctl) HandleResolveDiscrepancy(ctx *gin.Context) {
+
+	req := &ReqResolveDiscrepancy{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		err = fmt.Errorf("invalid id: %s", ctx.Param("id"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	d, err := c.ResolveDiscrepancy(c.uc.CurrentUser(ctx).ID, uint(id), req.Resolution)
+	if err != nil {
+		c.Errorf("resolve discrepancy failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespResolveDiscrepancy{
+		RespBase:    req.GenResponse(nil),
+		Discrepancy: newDiscrepancy(d),
+	})
+}
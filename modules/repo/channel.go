@@ -0,0 +1,263 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	payRecordRepo struct {
+		*store
+	}
+
+	transferRecordRepo struct {
+		*store
+	}
+)
+
+func unsupportedChannel(channel model.PayChannel) error {
+	return fmt.Errorf("unsupported pay channel: %s", channel)
+}
+
+// payModel 渠道对应的预支付记录表
+func payModel(channel model.PayChannel) (interface{}, error) {
+
+	switch channel {
+	case model.ChannelWechat:
+		return model.TWxPayRecord{}, nil
+	case model.ChannelAlipay:
+		return model.TAliPayRecord{}, nil
+	default:
+		return nil, unsupportedChannel(channel)
+	}
+}
+
+// transferModel 渠道对应的转账记录表
+func transferModel(channel model.PayChannel) (interface{}, error) {
+
+	switch channel {
+	case model.ChannelWechat:
+		return model.TWxTransferRecord{}, nil
+	case model.ChannelAlipay:
+		return model.TAliTransferRecord{}, nil
+	default:
+		return nil, unsupportedChannel(channel)
+	}
+}
+
+func (r *payRecordRepo) Add(channel model.PayChannel, record *PayRecord) error {
+
+	switch channel {
+	case model.ChannelWechat:
+		return r.create(&model.TWxPayRecord{
+			PrepayID: record.PrepayID,
+			TradeID:  record.TradeID,
+			OpenID:   record.OpenID,
+			Amount:   record.Amount,
+			State:    model.WxPayState(record.State),
+		})
+	case model.ChannelAlipay:
+		return r.create(&model.TAliPayRecord{
+			TradeID: record.TradeID,
+			UserID:  record.UserID,
+			Amount:  record.Amount,
+			State:   record.State,
+		})
+	default:
+		return unsupportedChannel(channel)
+	}
+}
+
+func (r *payRecordRepo) Lock(channel model.PayChannel, tradeID string) (*PayRecord, error) {
+
+	db := r.locking()
+	switch channel {
+	case model.ChannelWechat:
+		w := &model.TWxPayRecord{}
+		err := db.Model(model.TWxPayRecord{}).Where("trade_id = ?", tradeID).First(w).Error
+		if err != nil {
+			return nil, err
+		}
+
+		return &PayRecord{TradeID: w.TradeID, OpenID: w.OpenID, PrepayID: w.PrepayID, Amount: w.Amount,
+			State: string(w.State), TransactionID: w.WxTransactionID}, nil
+	case model.ChannelAlipay:
+		a := &model.TAliPayRecord{}
+		err := db.Model(model.TAliPayRecord{}).Where("trade_id = ?", tradeID).First(a).Error
+		if err != nil {
+			return nil, err
+		}
+
+		return &PayRecord{TradeID: a.TradeID, UserID: a.UserID, Amount: a.Amount, State: a.State,
+			TransactionID: a.AliTradeNo}, nil
+	default:
+		return nil, unsupportedChannel(channel)
+	}
+}
+
+func (r *payRecordRepo) UpdateState(channel model.PayChannel, tradeID, state string) error {
+
+	m, err := payModel(channel)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Model(m).Where("trade_id = ?", tradeID).Update("state", state).Error
+}
+
+func (r *payRecordRepo) Pay(channel model.PayChannel, tradeID, transactionID string) error {
+
+	column := "wx_transaction_id"
+	if channel == model.ChannelAlipay {
+		column = "ali_trade_no"
+	}
+
+	m, err := payModel(channel)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Model(m).Where("trade_id = ?", tradeID).Updates(map[string]interface{}{
+		"state": model.WxPayStateSUCCESS,
+		column:  transactionID,
+	}).Error
+}
+
+func wxTransferRecord(r *model.TWxTransferRecord) *TransferRecord {
+	return &TransferRecord{Channel: model.ChannelWechat, TradeID: r.TradeID, BatchID: r.BatchID, OpenID: r.OpenID,
+		Amount: r.Amount, State: string(r.State), FailReason: r.FailReason, Attempts: r.Attempts}
+}
+
+func aliTransferRecord(r *model.TAliTransferRecord) *TransferRecord {
+	return &TransferRecord{Channel: model.ChannelAlipay, TradeID: r.TradeID, BatchID: r.OrderID, UserID: r.UserID,
+		Account: r.Account, Name: r.Name, Amount: r.Amount, State: r.State, FailReason: r.FailReason,
+		Attempts: r.Attempts}
+}
+
+func (r *transferRecordRepo) Add(record *TransferRecord) error {
+
+	switch record.Channel {
+	case model.ChannelWechat:
+		return r.create(&model.TWxTransferRecord{
+			TradeID: record.TradeID,
+			BatchID: record.BatchID,
+			OpenID:  record.OpenID,
+			Amount:  record.Amount,
+			State:   model.WxPayState(record.State),
+		})
+	case model.ChannelAlipay:
+		return r.create(&model.TAliTransferRecord{
+			TradeID: record.TradeID,
+			OrderID: record.BatchID,
+			UserID:  record.UserID,
+			Account: record.Account,
+			Name:    record.Name,
+			Amount:  record.Amount,
+			State:   record.State,
+		})
+	default:
+		return unsupportedChannel(record.Channel)
+	}
+}
+
+func (r *transferRecordRepo) Get(channel model.PayChannel, tradeID string) (*TransferRecord, error) {
+	return r.get(r.db, channel, tradeID)
+}
+
+func (r *transferRecordRepo) Lock(channel model.PayChannel, tradeID string) (*TransferRecord, error) {
+	return r.get(r.locking(), channel, tradeID)
+}
+
+func (r *transferRecordRepo) get(db *gorm.DB, channel model.PayChannel, tradeID string) (*TransferRecord, error) {
+
+	switch channel {
+	case model.ChannelWechat:
+		w := &model.TWxTransferRecord{}
+		err := db.Model(model.TWxTransferRecord{}).Where("trade_id = ?", tradeID).First(w).Error
+		if err != nil {
+			return nil, err
+		}
+		return wxTransferRecord(w), nil
+	case model.ChannelAlipay:
+		a := &model.TAliTransferRecord{}
+		err := db.Model(model.TAliTransferRecord{}).Where("trade_id = ?", tradeID).First(a).Error
+		if err != nil {
+			return nil, err
+		}
+		return aliTransferRecord(a), nil
+	default:
+		return nil, unsupportedChannel(channel)
+	}
+}
+
+func (r *transferRecordRepo) Due(states []string, now time.Time) ([]*TransferRecord, error) {
+
+	due := "state IN ? AND (next_query_at IS NULL OR next_query_at <= ?)"
+
+	wxs := make([]*model.TWxTransferRecord, 0)
+	err := r.db.Model(model.TWxTransferRecord{}).Where(due, states, now).Find(&wxs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	alis := make([]*model.TAliTransferRecord, 0)
+	err = r.db.Model(model.TAliTransferRecord{}).Where(due, states, now).Find(&alis).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]*TransferRecord, 0)
+	for _, w := range wxs {
+		rs = append(rs, wxTransferRecord(w))
+	}
+
+	for _, a := range alis {
+		rs = append(rs, aliTransferRecord(a))
+	}
+
+	return rs, nil
+}
+
+func (r *transferRecordRepo) Transit(channel model.PayChannel, tradeID, from, to, batchID,
+	failReason string) (bool, error) {
+
+	m, err := transferModel(channel)
+	if err != nil {
+		return false, err
+	}
+
+	updates := map[string]interface{}{"state": to, "attempts": 0, "next_query_at": nil}
+	if len(failReason) != 0 {
+		updates["fail_reason"] = failReason
+	}
+
+	if len(batchID) != 0 {
+		column := "batch_id"
+		if channel == model.ChannelAlipay {
+			column = "order_id"
+		}
+		updates[column] = batchID
+	}
+
+	res := r.db.Model(m).Where("trade_id = ? AND state = ?", tradeID, from).Updates(updates)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
+
+func (r *transferRecordRepo) Schedule(channel model.PayChannel, tradeID string, attempts int, next time.Time) error {
+
+	m, err := transferModel(channel)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Model(m).Where("trade_id = ?", tradeID).
+		Updates(map[string]interface{}{"attempts": attempts, "next_query_at": &next}).Error
+}
@@ -0,0 +1,207 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type enum interface {
+	~int64 | ~uint32
+}
+
+// parseEnums 解析逗号分隔的枚举值列表，每一项必须是 valid 中定义的十进制枚举值，重复项去重；
+// 为空时返回空列表，表示不作为查询条件
+func parseEnums[T enum](name, raw string, valid map[T]string) ([]T, error) {
+
+	ret := make([]T, 0)
+	if len(raw) == 0 {
+		return ret, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) > len(valid) {
+		return nil, fmt.Errorf("too many %s values: %d", name, len(parts))
+	}
+
+	seen := make(map[T]struct{})
+	for _, p := range parts {
+		v, err := parseEnum(name, strings.TrimSpace(p), valid)
+		if err != nil {
+			return nil, err
+		}
+
+		if v == 0 {
+			return nil, fmt.Errorf("invalid %s: %q", name, p)
+		}
+
+		if _, ok := seen[v]; ok {
+			continue
+		}
+
+		seen[v] = struct{}{}
+		ret = append(ret, v)
+	}
+
+	return ret, nil
+}
+
+// parseEnum 解析单个枚举值，"0" 及空串表示不作为查询条件，返回零值
+func parseEnum[T enum](name, raw string, valid map[T]string) (T, error) {
+
+	if len(raw) == 0 || raw == "0" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+
+	v := T(n)
+	if _, ok := valid[v]; !ok {
+		return 0, fmt.Errorf("unknown %s: %q", name, raw)
+	}
+
+	return v, nil
+}
+
+func validEnums[T enum](name string, values []T, valid map[T]string) error {
+
+	for _, v := range values {
+		if _, ok := valid[v]; !ok {
+			return fmt.Errorf("unknown %s: %d", name, v)
+		}
+	}
+
+	return nil
+}
+
+// ParseMasterOrderStates 解析查询参数中逗号分隔的订单状态
+func ParseMasterOrderStates(raw string) ([]model.OrderState, error) {
+	return parseEnums("state", raw, model.MOrderStateCN)
+}
+
+// ParseSubOrderStates 解析查询参数中逗号分隔的子订单状态
+func ParseSubOrderStates(raw string) ([]model.OrderState, error) {
+	return parseEnums("state", raw, model.SOrderStateCN)
+}
+
+// ParsePlatform 解析查询参数中的平台
+func ParsePlatform(raw string) (model.Platform, error) {
+	return parseEnum("platform", raw, model.PlatformCN)
+}
+
+// ParseTradeType 解析查询参数中的交易类型
+func ParseTradeType(raw string) (model.TradeType, error) {
+	return parseEnum("type", raw, model.TradeTypeCN)
+}
+
+func (r TimeRange) scope(db *gorm.DB) *gorm.DB {
+
+	if !r.From.IsZero() {
+		db = db.Where("created_at >= ?", r.From)
+	}
+
+	if !r.To.IsZero() {
+		db = db.Where("created_at < ?", r.To)
+	}
+
+	return db
+}
+
+// Validate 校验订单状态及平台均为已定义的枚举值
+func (f *MasterOrderFilter) Validate() error {
+
+	err := validEnums("state", f.States, model.MOrderStateCN)
+	if err != nil {
+		return err
+	}
+
+	if f.Platform != 0 {
+		return validEnums("platform", []model.Platform{f.Platform}, model.PlatformCN)
+	}
+
+	return nil
+}
+
+func (f *MasterOrderFilter) scope(db *gorm.DB) *gorm.DB {
+
+	db = f.TimeRange.scope(db)
+	if len(f.States) != 0 {
+		db = db.Where("state IN ?", f.States)
+	}
+
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+
+	if f.Platform != 0 {
+		db = db.Where("platform = ?", f.Platform)
+	}
+
+	return db
+}
+
+// Validate 校验子订单状态均为已定义的枚举值
+func (f *SubOrderFilter) Validate() error {
+	return validEnums("state", f.States, model.SOrderStateCN)
+}
+
+func (f *SubOrderFilter) scope(db *gorm.DB) *gorm.DB {
+
+	db = f.TimeRange.scope(db)
+	if f.MID != 0 {
+		db = db.Where("mid = ?", f.MID)
+	}
+
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+
+	if len(f.States) != 0 {
+		db = db.Where("state IN ?", f.States)
+	}
+
+	if !f.UpdatedBefore.IsZero() {
+		db = db.Where("updated_at < ?", f.UpdatedBefore)
+	}
+
+	return db
+}
+
+// Validate 校验交易类型为已定义的枚举值
+func (f *TradeFilter) Validate() error {
+
+	if f.Type != 0 {
+		return validEnums("type", []model.TradeType{f.Type}, model.TradeTypeCN)
+	}
+
+	return nil
+}
+
+func (f *TradeFilter) scope(db *gorm.DB) *gorm.DB {
+
+	db = f.TimeRange.scope(db)
+	if len(f.TradeID) != 0 {
+		db = db.Where("trade_id = ?", f.TradeID)
+	}
+
+	if len(f.TradeIDs) != 0 {
+		db = db.Where("trade_id IN ?", f.TradeIDs)
+	}
+
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+
+	if f.Type != 0 {
+		db = db.Where("type = ?", f.Type)
+	}
+
+	return db
+}
@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+var enumSeeds = []string{
+	"", "0", "1", "1,2", "2, 3", "1,1,1", "1,,2", ",", " ", "-1", "+1", "01",
+	"4294967295", "4294967296", "18446744073709551616", "1e3", "0x1", "abc", "１", "1,2,3,4,5,6,7,8,9,10,11,12",
+	// 注入及越界输入
+	"1;DROP", "1;DROP TABLE t_users", "'", "1' OR '1'='1", "1 OR 1=1", "1)--", "1,-1", "-9223372036854775808",
+	"9223372036854775807", "9223372036854775808", "99999999999999999999999999",
+}
+
+// checkEnums 解析成功时结果必须均为已定义的非零枚举值且不重复
+func checkEnums[T enum](t *testing.T, raw string, values []T, err error, valid map[T]string) {
+
+	if err != nil {
+		if values != nil {
+			t.Fatalf("raw=%q: values=%v returned with err=%s", raw, values, err)
+		}
+		return
+	}
+
+	if len(values) > len(valid) {
+		t.Fatalf("raw=%q: %d values exceeds %d declared", raw, len(values), len(valid))
+	}
+
+	seen := make(map[T]struct{}, len(values))
+	for _, v := range values {
+		if v == 0 {
+			t.Fatalf("raw=%q: zero value accepted", raw)
+		}
+
+		if _, ok := valid[v]; !ok {
+			t.Fatalf("raw=%q: undeclared value %d accepted", raw, v)
+		}
+
+		if _, ok := seen[v]; ok {
+			t.Fatalf("raw=%q: duplicate value %d", raw, v)
+		}
+		seen[v] = struct{}{}
+	}
+}
+
+func checkEnum[T enum](t *testing.T, raw string, v T, err error, valid map[T]string) {
+
+	if err != nil {
+		if v != 0 {
+			t.Fatalf("raw=%q: value=%d returned with err=%s", raw, v, err)
+		}
+		return
+	}
+
+	if v == 0 {
+		return
+	}
+
+	if _, ok := valid[v]; !ok {
+		t.Fatalf("raw=%q: undeclared value %d accepted", raw, v)
+	}
+}
+
+func FuzzParseMasterOrderStates(f *testing.F) {
+
+	for _, s := range enumSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		values, err := ParseMasterOrderStates(raw)
+		checkEnums(t, raw, values, err, model.MOrderStateCN)
+	})
+}
+
+func FuzzParseSubOrderStates(f *testing.F) {
+
+	for _, s := range enumSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		values, err := ParseSubOrderStates(raw)
+		checkEnums(t, raw, values, err, model.SOrderStateCN)
+	})
+}
+
+func FuzzParsePlatform(f *testing.F) {
+
+	for _, s := range enumSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		v, err := ParsePlatform(raw)
+		checkEnum(t, raw, v, err, model.PlatformCN)
+	})
+}
+
+func FuzzParseTradeType(f *testing.F) {
+
+	for _, s := range enumSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		v, err := ParseTradeType(raw)
+		checkEnum(t, raw, v, err, model.TradeTypeCN)
+	})
+}
+
+func TestParseMasterOrderStates(t *testing.T) {
+
+	cases := []struct {
+		raw  string
+		want []model.OrderState
+		err  bool
+	}{
+		{raw: "", want: []model.OrderState{}},
+		{raw: "1", want: []model.OrderState{1}},
+		{raw: "1, 2,1", want: []model.OrderState{1, 2}},
+		{raw: "0", err: true},
+		{raw: "1,,2", err: true},
+		{raw: "-1", err: true},
+		{raw: "abc", err: true},
+		{raw: "4294967296", err: true},
+		{raw: "9223372036854775807", err: true},
+		{raw: "99999999999999999999999999", err: true},
+		{raw: "999", err: true},
+		{raw: "1;DROP", err: true},
+		{raw: "'", err: true},
+		{raw: "1' OR '1'='1", err: true},
+		{raw: "1,-1", err: true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseMasterOrderStates(c.raw)
+		if c.err {
+			if err == nil {
+				t.Errorf("raw=%q: expect error, got %v", c.raw, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("raw=%q: unexpected err=%s", c.raw, err)
+			continue
+		}
+
+		if len(got) != len(c.want) {
+			t.Errorf("raw=%q: got %v, want %v", c.raw, got, c.want)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("raw=%q: got %v, want %v", c.raw, got, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package repo
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	masterOrderRepo struct {
+		*store
+	}
+
+	subOrderRepo struct {
+		*store
+	}
+)
+
+func (r *masterOrderRepo) Create(order *model.TMasterOrder) error {
+	return r.create(order)
+}
+
+func (r *masterOrderRepo) Get(id uint) (*model.TMasterOrder, error) {
+
+	order := &model.TMasterOrder{}
+	err := r.db.Model(model.TMasterOrder{}).Where("id = ?", id).First(order).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *masterOrderRepo) Lock(id uint) (*model.TMasterOrder, error) {
+
+	order := &model.TMasterOrder{}
+	err := r.locking().Model(model.TMasterOrder{}).Where("id = ?", id).First(order).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *masterOrderRepo) List(filter *MasterOrderFilter, page *model.Page) ([]*model.TMasterOrder, *model.PageInfo, error) {
+
+	err := filter.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paginate(filter.scope(r.db.Model(model.TMasterOrder{})), page, MasterOrderSorts, masterOrderKey)
+}
+
+func (r *masterOrderRepo) Modify(id uint, order *model.MasterOrder) error {
+	return r.db.Model(model.TMasterOrder{}).Where("id = ?", id).Updates(&model.TMasterOrder{MasterOrder: order}).Error
+}
+
+func (r *masterOrderRepo) UpdateIf(id uint, expect, fields map[string]interface{}) (bool, error) {
+	return updateIf(r.db.Model(model.TMasterOrder{}).Where("id = ?", id), expect, fields)
+}
+
+func (r *masterOrderRepo) IncrComplete(id uint) error {
+	return r.db.Model(model.TMasterOrder{}).Where("id = ?", id).Update("complete", gorm.Expr("complete + 1")).Error
+}
+
+func (r *masterOrderRepo) AddHistory(history *model.TMasterOrderHistory) error {
+	return r.create(history)
+}
+
+func (r *masterOrderRepo) GetHistories(mid uint) ([]*model.TMasterOrderHistory, error) {
+
+	histories := make([]*model.TMasterOrderHistory, 0)
+	err := r.db.Model(model.TMasterOrderHistory{}).Where("mid = ?", mid).Order("id desc").Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+func (r *masterOrderRepo) EscrowMismatches(states, active []model.OrderState) ([]*EscrowBalance, error) {
+
+	balances := make([]*EscrowBalance, 0)
+	err := r.db.Table("t_master_orders AS o").
+		Select("o.id AS mid, o.uuid, o.state, o.unit_price, COALESCE(a.balance, 0) AS escrow, COALESCE(s.active, 0) AS active").
+		Joins("LEFT JOIN t_ledger_accounts AS a ON a.owner_id = o.id AND a.type = ? AND a.deleted_at IS NULL",
+			model.AccountEscrow).
+		Joins("LEFT JOIN (SELECT mid, COUNT(*) AS active FROM t_sub_orders "+
+			"WHERE state IN ? AND deleted_at IS NULL GROUP BY mid) AS s ON s.mid = o.id", active).
+		Where("o.state IN ? AND o.deleted_at IS NULL", states).
+		Where("COALESCE(a.balance, 0) <> COALESCE(s.active, 0) * o.unit_price").
+		Scan(&balances).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (r *masterOrderRepo) AddEvent(event *model.TOrderEvent) error {
+	return r.create(event)
+}
+
+func (r *masterOrderRepo) GetEvents(mid uint) ([]*model.TOrderEvent, error) {
+
+	events := make([]*model.TOrderEvent, 0)
+	err := r.db.Model(model.TOrderEvent{}).Where("mid = ?", mid).Order("id").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *subOrderRepo) Create(order *model.TSubOrder) error {
+	return r.create(order)
+}
+
+func (r *subOrderRepo) Get(mid, sid uint) (*model.TSubOrder, error) {
+
+	order := &model.TSubOrder{}
+	err := r.db.Model(model.TSubOrder{}).Where("id = ? AND mid = ?", sid, mid).First(order).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *subOrderRepo) Lock(mid uint, states []model.OrderState) ([]*model.TSubOrder, error) {
+
+	db := r.locking().Model(model.TSubOrder{}).Where("mid = ?", mid)
+	if len(states) != 0 {
+		db = db.Where("state IN ?", states)
+	}
+
+	orders := make([]*model.TSubOrder, 0)
+	err := db.Order("id").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (r *subOrderRepo) List(filter *SubOrderFilter, page *model.Page) ([]*model.TSubOrder, *model.PageInfo, error) {
+
+	err := filter.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paginate(filter.scope(r.db.Model(model.TSubOrder{})), page, SubOrderSorts, subOrderKey)
+}
+
+func (r *subOrderRepo) UpdateIf(sid uint, expect, fields map[string]interface{}) (bool, error) {
+	return updateIf(r.db.Model(model.TSubOrder{}).Where("id = ?", sid), expect, fields)
+}
+
+// updateIf 仅当记录各字段仍等于 expect 时更新，返回是否更新成功
+func updateIf(db *gorm.DB, expect, fields map[string]interface{}) (bool, error) {
+
+	if len(expect) != 0 {
+		db = db.Where(expect)
+	}
+
+	res := db.Updates(fields)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
@@ -0,0 +1,295 @@
+package repo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+const (
+	SortCreatedAt = "created_at" // 按创建时间排序
+	SortFinishAt  = "finish_at"  // 按截止时间排序，仅订单有效
+	SortReward    = "reward"     // 按单个任务奖励排序，仅订单有效
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var (
+	// MasterOrderSorts 订单列表支持的排序字段
+	MasterOrderSorts = []string{SortCreatedAt, SortFinishAt, SortReward}
+	// SubOrderSorts 子订单列表支持的排序字段
+	SubOrderSorts = []string{SortCreatedAt}
+	// UserSorts 用户列表支持的排序字段
+	UserSorts = []string{SortCreatedAt}
+	// TradeSorts 交易记录列表支持的排序字段
+	TradeSorts = []string{SortCreatedAt}
+)
+
+// cursor 游标记录上一页最后一条记录的排序字段值及 ID，排序字段或方向变化时游标失效
+type cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"i"`
+}
+
+// ParsePage 解析查询参数中的分页参数 cursor、limit、sort、order，sorts 为接口支持的排序字段，第一个为默认排序字段；
+// 未指定 cursor 时兼容旧版客户端的 offset 参数
+func ParsePage(query func(string) string, sorts ...string) (*model.Page, error) {
+
+	page := &model.Page{
+		Cursor: query("cursor"),
+		Limit:  defaultPageLimit,
+		Sort:   query("sort"),
+		Order:  query("order"),
+	}
+
+	if raw := query("limit"); len(raw) != 0 {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return nil, fmt.Errorf("invalid limit: %q", raw)
+		}
+		page.Limit = limit
+	}
+
+	if raw := query("offset"); len(raw) != 0 && len(page.Cursor) == 0 {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset: %q", raw)
+		}
+		page.Offset = offset
+	}
+
+	if len(page.Sort) == 0 {
+		page.Sort = sorts[0]
+	}
+
+	if !contains(sorts, page.Sort) {
+		return nil, fmt.Errorf("invalid sort: %q", page.Sort)
+	}
+
+	if len(page.Order) == 0 {
+		page.Order = OrderDesc
+	}
+
+	if page.Order != OrderAsc && page.Order != OrderDesc {
+		return nil, fmt.Errorf("invalid order: %q", page.Order)
+	}
+
+	_, err := decodeCursor(page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// ParseTimeRange 解析查询参数中的创建时间范围 from、to，格式为 RFC3339
+func ParseTimeRange(query func(string) string) (TimeRange, error) {
+
+	from, err := parseTime("from", query("from"))
+	if err != nil {
+		return TimeRange{}, err
+	}
+
+	to, err := parseTime("to", query("to"))
+	if err != nil {
+		return TimeRange{}, err
+	}
+
+	r := TimeRange{From: from, To: to}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return TimeRange{}, fmt.Errorf("invalid time range: from %s, to %s", query("from"), query("to"))
+	}
+
+	return r, nil
+}
+
+func parseTime(name, raw string) (time.Time, error) {
+
+	if len(raw) == 0 {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+
+	return t, nil
+}
+
+func contains(values []string, v string) bool {
+
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeCursor(page *model.Page) (*cursor, error) {
+
+	if len(page.Cursor) == 0 {
+		return nil, nil
+	}
+
+	invalid := fmt.Errorf("invalid cursor: %q", page.Cursor)
+
+	data, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return nil, invalid
+	}
+
+	cur := &cursor{}
+	err = json.Unmarshal(data, cur)
+	if err != nil {
+		return nil, invalid
+	}
+
+	if cur.Sort != page.Sort || cur.Order != page.Order {
+		return nil, invalid
+	}
+
+	_, err = cursorValue(cur)
+	if err != nil {
+		return nil, invalid
+	}
+
+	return cur, nil
+}
+
+func encodeCursor(page *model.Page, value interface{}, id uint) string {
+
+	cur := &cursor{Sort: page.Sort, Order: page.Order, ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		cur.Value = v.Format(time.RFC3339Nano)
+	case int64:
+		cur.Value = strconv.FormatInt(v, 10)
+	}
+
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursorValue 按排序字段类型还原游标中的排序字段值
+func cursorValue(cur *cursor) (interface{}, error) {
+
+	switch cur.Sort {
+	case SortCreatedAt, SortFinishAt:
+		return time.Parse(time.RFC3339Nano, cur.Value)
+	case SortReward:
+		return strconv.ParseInt(cur.Value, 10, 64)
+	default:
+		return nil, fmt.Errorf("invalid sort: %q", cur.Sort)
+	}
+}
+
+// paginate 按排序字段及 ID 进行游标分页查询，无游标时按 Offset 跳过记录，同时返回符合条件的记录总数；
+// page 为空时按 ID 倒序查询全部记录。key 返回记录的排序字段值及 ID
+func paginate[T any](db *gorm.DB, page *model.Page, sorts []string,
+	key func(row *T, sort string) (interface{}, uint)) ([]*T, *model.PageInfo, error) {
+
+	rows := make([]*T, 0)
+	if page == nil {
+		err := db.Order("id desc").Find(&rows).Error
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return rows, nil, nil
+	}
+
+	if !contains(sorts, page.Sort) {
+		return nil, nil, fmt.Errorf("invalid sort: %q", page.Sort)
+	}
+
+	cur, err := decodeCursor(page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base := db.Session(&gorm.Session{})
+
+	var total int64
+	err = base.Count(&total).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desc := page.Order == OrderDesc
+	query := base
+	if cur != nil {
+		value, _ := cursorValue(cur)
+		query = query.Where(clause.Or(
+			after(page.Sort, value, desc),
+			clause.And(clause.Eq{Column: clause.Column{Name: page.Sort}, Value: value}, after("id", cur.ID, desc)),
+		))
+	} else if page.Offset > 0 {
+		query = query.Offset(page.Offset)
+	}
+
+	err = query.Order(clause.OrderByColumn{Column: clause.Column{Name: page.Sort}, Desc: desc}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc}).
+		Limit(page.Limit + 1).Find(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &model.PageInfo{Page: page, Total: total}
+	if len(rows) > page.Limit {
+		rows = rows[:page.Limit]
+		value, id := key(rows[len(rows)-1], page.Sort)
+		info.NextCursor = encodeCursor(page, value, id)
+	}
+
+	return rows, info, nil
+}
+
+// after 按排序方向返回位于游标之后的条件
+func after(column string, value interface{}, desc bool) clause.Expression {
+
+	if desc {
+		return clause.Lt{Column: clause.Column{Name: column}, Value: value}
+	}
+
+	return clause.Gt{Column: clause.Column{Name: column}, Value: value}
+}
+
+func masterOrderKey(o *model.TMasterOrder, sort string) (interface{}, uint) {
+
+	switch sort {
+	case SortFinishAt:
+		return o.FinishAt, o.ID
+	case SortReward:
+		return o.Reward, o.ID
+	default:
+		return o.CreatedAt, o.ID
+	}
+}
+
+func subOrderKey(o *model.TSubOrder, _ string) (interface{}, uint) {
+	return o.Model.CreatedAt, o.Model.ID
+}
+
+func userKey(u *model.TUser, _ string) (interface{}, uint) {
+	return u.CreatedAt, u.ID
+}
+
+func tradeKey(t *model.TTradeRecord, _ string) (interface{}, uint) {
+	return t.CreatedAt, t.ID
+}
@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// TestParsePageOffset 未指定 cursor 时解析 offset，指定 cursor 时忽略 offset
+func TestParsePageOffset(t *testing.T) {
+
+	cursor := encodeCursor(&model.Page{Sort: SortCreatedAt, Order: OrderDesc}, time.Now(), 1)
+	cases := []struct {
+		raw    string
+		offset int
+		err    bool
+	}{
+		{raw: "limit=10", offset: 0},
+		{raw: "offset=20&limit=10", offset: 20},
+		{raw: "offset=0", offset: 0},
+		{raw: "offset=-1", err: true},
+		{raw: "offset=abc", err: true},
+		{raw: "offset=abc&cursor=" + cursor, offset: 0},
+		{raw: "offset=20&cursor=" + cursor, offset: 0},
+	}
+
+	for _, c := range cases {
+		values, _ := url.ParseQuery(c.raw)
+		page, err := ParsePage(values.Get, TradeSorts...)
+		if c.err {
+			if err == nil {
+				t.Errorf("raw=%q: expect error, got %+v", c.raw, page)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("raw=%q: unexpected err=%s", c.raw, err)
+			continue
+		}
+
+		if page.Offset != c.offset {
+			t.Errorf("raw=%q: offset=%d, want %d", c.raw, page.Offset, c.offset)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+var (
+	// ErrNotFound 记录不存在，与 gorm.ErrRecordNotFound 相同，兼容已有的判断
+	ErrNotFound = gorm.ErrRecordNotFound
+	// ErrDuplicate 违反唯一约束
+	ErrDuplicate = errors.New("duplicate record")
+)
+
+type (
+	// Store 数据访问入口，按聚合提供仓储；通过 Transaction 取得的 Store 上的所有操作共享同一事务
+	Store interface {
+		Users() UserRepo
+		MasterOrders() MasterOrderRepo
+		SubOrders() SubOrderRepo
+		TradeRecords() TradeRecordRepo
+		PayRecords() PayRecordRepo
+		TransferRecords() TransferRecordRepo
+		Reviews() ReviewRepo
+
+		// Transaction 在事务中执行 fn，fn 返回错误时回滚，否则提交
+		Transaction(fn func(tx Store) error) error
+		// WithDB 返回绑定指定连接或事务的 Store，供仍以 *gorm.DB 传递事务的账本等模块使用
+		WithDB(db *gorm.DB) Store
+		// DB 返回当前连接或事务
+		DB() *gorm.DB
+	}
+
+	UserRepo interface {
+		Create(user *model.TUser) error
+		Get(id uint) (*model.TUser, error)
+		GetByOpenID(openid string) (*model.TUser, error)
+		// GetByIDs 批量查询用户，不存在的用户不返回
+		GetByIDs(ids []uint) ([]*model.TUser, error)
+		// Lock 在事务中锁定用户行并读取最新数据
+		Lock(id uint) (*model.TUser, error)
+		// List 分页查询用户，page 为空时查询全部
+		List(filter *UserFilter, page *model.Page) ([]*model.TUser, *model.PageInfo, error)
+		Update(id uint, fields map[string]interface{}) error
+		// AddBalance 以增量方式更新余额缓存
+		AddBalance(id uint, delta int64) error
+	}
+
+	MasterOrderRepo interface {
+		Create(order *model.TMasterOrder) error
+		Get(id uint) (*model.TMasterOrder, error)
+		// Lock 在事务中锁定订单行并读取最新数据
+		Lock(id uint) (*model.TMasterOrder, error)
+		// List 分页查询订单，page 为空时查询全部
+		List(filter *MasterOrderFilter, page *model.Page) ([]*model.TMasterOrder, *model.PageInfo, error)
+		// Modify 更新订单内容，仅更新非零值字段
+		Modify(id uint, order *model.MasterOrder) error
+		// UpdateIf 仅当订单各字段仍等于 expect 时更新，返回是否更新成功
+		UpdateIf(id uint, expect, fields map[string]interface{}) (bool, error)
+		// IncrComplete 已完成数量加一
+		IncrComplete(id uint) error
+
+		AddHistory(history *model.TMasterOrderHistory) error
+		GetHistories(mid uint) ([]*model.TMasterOrderHistory, error)
+
+		// EscrowMismatches 查询处于 states 且托管余额不等于 active 状态子订单数 * 订单单价快照的订单，
+		// 未开立托管账户的订单托管余额按 0 计
+		EscrowMismatches(states, active []model.OrderState) ([]*EscrowBalance, error)
+
+		// AddEvent 追加订单事件，事件记录不可修改
+		AddEvent(event *model.TOrderEvent) error
+		// GetEvents 按发生顺序查询订单及其子订单的事件
+		GetEvents(mid uint) ([]*model.TOrderEvent, error)
+	}
+
+	SubOrderRepo interface {
+		// Create 创建子订单，同一接单员重复接单时返回 ErrDuplicate
+		Create(order *model.TSubOrder) error
+		Get(mid, sid uint) (*model.TSubOrder, error)
+		// Lock 在事务中锁定订单下处于 states 的子订单并读取最新数据，states 为空时锁定全部子订单
+		Lock(mid uint, states []model.OrderState) ([]*model.TSubOrder, error)
+		// List 分页查询子订单，page 为空时查询全部
+		List(filter *SubOrderFilter, page *model.Page) ([]*model.TSubOrder, *model.PageInfo, error)
+		// UpdateIf 仅当子订单各字段仍等于 expect 时更新，返回是否更新成功
+		UpdateIf(sid uint, expect, fields map[string]interface{}) (bool, error)
+	}
+
+	// ReviewRepo 子订单审核队列：审核员领取待审核子订单的租约，租约到期前其他审核员不可领取
+	ReviewRepo interface {
+		// Queue 查询 auditor 可领取的平台审核子订单：租约空闲、已过期或由其持有，且本次提交未被其跳过；
+		// 已持有租约的优先，其次按父订单截止时间先后
+		Queue(auditor uint, now time.Time, limit int) ([]*model.TSubOrder, error)
+		// Claim 仅当子订单待审核且 auditor 可领取时领取租约至 until，返回是否领取成功
+		Claim(sid, auditor uint, now, until time.Time) (bool, error)
+		// Release 释放 auditor 持有的租约，返回是否释放成功
+		Release(sid, auditor uint) (bool, error)
+		// Skip 记录审核员跳过子订单的本次提交，重复跳过时返回 ErrDuplicate
+		Skip(skip *model.TReviewSkip) error
+		// Stats 按审核员统计时间范围内的审核及跳过数量
+		Stats(tr TimeRange) ([]*ReviewStat, error)
+	}
+
+	TradeRecordRepo interface {
+		Create(record *model.TTradeRecord) error
+		// Get 查询用户指定类型的交易记录
+		Get(userID uint, tradeID string, typ model.TradeType) (*model.TTradeRecord, error)
+		// List 分页查询交易记录，page 为空时查询全部
+		List(filter *TradeFilter, page *model.Page) ([]*model.TTradeRecord, *model.PageInfo, error)
+		UpdateType(tradeID string, typ model.TradeType) error
+		// UpdatePending 更新同一交易尚未入账（entry_id 为 0）的记录，返回是否存在该记录
+		UpdatePending(tradeID string, userID uint, fields map[string]interface{}) (bool, error)
+	}
+
+	// PayRecordRepo 各支付渠道的预支付记录，按渠道读写对应的表
+	PayRecordRepo interface {
+		Add(channel model.PayChannel, record *PayRecord) error
+		// Lock 在事务中锁定预支付记录
+		Lock(channel model.PayChannel, tradeID string) (*PayRecord, error)
+		UpdateState(channel model.PayChannel, tradeID, state string) error
+		// Pay 支付成功，记录渠道交易号
+		Pay(channel model.PayChannel, tradeID, transactionID string) error
+	}
+
+	// TransferRecordRepo 各支付渠道的提现转账记录，按渠道读写对应的表
+	TransferRecordRepo interface {
+		Add(record *TransferRecord) error
+		Get(channel model.PayChannel, tradeID string) (*TransferRecord, error)
+		// Lock 在事务中锁定转账记录
+		Lock(channel model.PayChannel, tradeID string) (*TransferRecord, error)
+		// Due 查询各渠道处于 states 且已到查询时间的转账记录
+		Due(states []string, now time.Time) ([]*TransferRecord, error)
+		// Transit 仅当记录仍处于 from 状态时更新为 to，并清空查询次数，返回是否更新成功；
+		// batchID、failReason 为空时不更新
+		Transit(channel model.PayChannel, tradeID, from, to, batchID, failReason string) (bool, error)
+		// Schedule 记录查询次数及下次查询时间
+		Schedule(channel model.PayChannel, tradeID string, attempts int, next time.Time) error
+	}
+
+	// TimeRange 创建时间范围 [From, To)，零值不作为条件
+	TimeRange struct {
+		From time.Time
+		To   time.Time
+	}
+
+	// UserFilter 用户查询条件，零值字段不作为条件
+	UserFilter struct {
+		TimeRange
+	}
+
+	// MasterOrderFilter 订单查询条件，零值字段不作为条件
+	MasterOrderFilter struct {
+		TimeRange
+		States   []model.OrderState
+		UserID   uint
+		Platform model.Platform
+	}
+
+	// SubOrderFilter 子订单查询条件，零值字段不作为条件
+	SubOrderFilter struct {
+		TimeRange
+		MID           uint
+		UserID        uint
+		States        []model.OrderState
+		UpdatedBefore time.Time // 最近变更时间早于该时间
+	}
+
+	// TradeFilter 交易记录查询条件，零值字段不作为条件
+	TradeFilter struct {
+		TimeRange
+		TradeID  string
+		TradeIDs []string
+		UserID   uint
+		Type     model.TradeType
+	}
+
+	// EscrowBalance 订单托管余额及占用托管资金的子订单数量
+	EscrowBalance struct {
+		MID       uint             `gorm:"column:mid"`
+		UUID      string           `gorm:"column:uuid"`
+		State     model.OrderState `gorm:"column:state"`
+		UnitPrice int64            `gorm:"column:unit_price"`
+		Escrow    int64            `gorm:"column:escrow"` // 托管余额，单位：分
+		Active    int64            `gorm:"column:active"` // 占用托管资金的子订单数量
+	}
+
+	// ReviewStat 审核员审核量
+	ReviewStat struct {
+		AuditorID uint  `json:"auditor_id"`
+		Approved  int64 `json:"approved"` // 通过数量
+		Rejected  int64 `json:"rejected"` // 驳回数量，含驳回后未通过
+		Skipped   int64 `json:"skipped"`  // 跳过数量
+	}
+
+	// PayRecord 各支付渠道预支付记录的统一视图
+	PayRecord struct {
+		TradeID       string
+		UserID        uint   // 支付宝记录有效
+		OpenID        string // 微信记录有效
+		PrepayID      string // 微信记录有效
+		Amount        int64
+		State         string
+		TransactionID string
+	}
+
+	// TransferRecord 各支付渠道转账记录的统一视图
+	TransferRecord struct {
+		Channel    model.PayChannel
+		TradeID    string
+		BatchID    string // 渠道转账单号，微信为转账批次号，支付宝为转账订单号
+		UserID     uint   // 支付宝记录有效
+		OpenID     string // 微信记录有效
+		Account    string // 支付宝记录有效
+		Name       string // 支付宝记录有效
+		Amount     int64
+		State      string
+		FailReason string
+		Attempts   int
+	}
+)
@@ -0,0 +1,72 @@
+// Package repotest 提供基于 SQLite 的 Store，仅供测试使用，生产代码不应引用，避免引入 cgo 驱动
+package repotest
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+)
+
+// sqliteIndexes 与 MySQL 迁移脚本一致的唯一约束，业务依赖这些约束防止重复数据
+var sqliteIndexes = []string{
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_master_orders_uuid` ON `t_master_orders` (`uuid`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_sub_orders_uuid` ON `t_sub_orders` (`uuid`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_sub_orders_mid_user_id` ON `t_sub_orders` (`mid`, `user_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_wx_pay_records_trade_id` ON `t_wx_pay_records` (`trade_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_wx_transfer_records_trade_id` ON `t_wx_transfer_records` (`trade_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_ali_pay_records_trade_id` ON `t_ali_pay_records` (`trade_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_ali_transfer_records_trade_id` ON `t_ali_transfer_records` (`trade_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_platform_prices_platform` ON `t_platform_prices` (`platform`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_ledger_accounts_type_owner_id` ON `t_ledger_accounts` (`type`, `owner_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_refund_records_refund_id` ON `t_refund_records` (`refund_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_withdraw_requests_trade_id` ON `t_withdraw_requests` (`trade_id`)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS `uk_t_review_skips_sid_auditor_id_submits` ON `t_review_skips` (`sid`, `auditor_id`, `submits`)",
+}
+
+// Models 所有数据表对应的模型
+func Models() []interface{} {
+
+	return []interface{}{
+		&model.TUser{}, &model.TMasterOrder{}, &model.TSubOrder{}, &model.TMasterOrderHistory{},
+		&model.TTradeRecord{}, &model.TWxPayRecord{}, &model.TWxTransferRecord{},
+		&model.TAliPayRecord{}, &model.TAliTransferRecord{}, &model.TRefundRecord{},
+		&model.TPayNotification{}, &model.TLedgerAccount{}, &model.TJournalEntry{}, &model.TJournalLine{},
+		&model.TPlatformPrice{}, &model.TWithdrawRequest{}, &model.TReconRun{}, &model.TReconDiscrepancy{},
+		&model.TOrderEvent{}, &model.TReviewSkip{},
+	}
+}
+
+// NewSQLite 打开 SQLite 数据库文件并按模型初始化表结构，用于进程内运行的测试，
+// 测试中可使用临时目录下的文件。SQLite 不支持行锁，事务开始时即获取写锁，并发事务由数据库锁串行执行，
+// 避免读锁升级为写锁时直接返回 database is locked
+func NewSQLite(path string) (repo.Store, error) {
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", path)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AutoMigrate(Models()...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range sqliteIndexes {
+		err = db.Exec(stmt).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return repo.New(db, isSQLiteDuplicate), nil
+}
+
+func isSQLiteDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
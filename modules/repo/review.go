@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type reviewRepo struct {
+	*store
+}
+
+// leaseFree 子订单审核租约空闲、已过期或已由 auditor 持有
+const leaseFree = "(t_sub_orders.reviewer_id IN (0, ?) OR t_sub_orders.lease_expire_at IS NULL OR t_sub_orders.lease_expire_at < ?)"
+
+func (r *reviewRepo) Queue(auditor uint, now time.Time, limit int) ([]*model.TSubOrder, error) {
+
+	subOrders := make([]*model.TSubOrder, 0)
+	err := r.db.Model(model.TSubOrder{}).
+		Select("t_sub_orders.*").
+		Joins("JOIN t_master_orders ON t_master_orders.id = t_sub_orders.mid AND t_master_orders.deleted_at IS NULL").
+		Where("t_master_orders.review_mode = ? AND t_sub_orders.state = ?", model.ReviewModeAuditor, model.SOrderStateSubmit).
+		Where(leaseFree, auditor, now).
+		Where("NOT EXISTS (SELECT 1 FROM t_review_skips WHERE t_review_skips.sid = t_sub_orders.id "+
+			"AND t_review_skips.auditor_id = ? AND t_review_skips.submits = t_sub_orders.submits "+
+			"AND t_review_skips.deleted_at IS NULL)", auditor).
+		// 已持有的租约优先，其次按父订单截止时间先后
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "t_sub_orders.reviewer_id = ? DESC, t_master_orders.finish_at, t_sub_orders.id",
+			Vars:               []interface{}{auditor},
+			WithoutParentheses: true,
+		}}).
+		Limit(limit).
+		Find(&subOrders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return subOrders, nil
+}
+
+func (r *reviewRepo) Claim(sid, auditor uint, now, until time.Time) (bool, error) {
+
+	// 领取及释放租约不更新 updated_at，避免影响依赖子订单更新时间的超时判断
+	res := r.db.Model(model.TSubOrder{}).
+		Where("id = ? AND state = ?", sid, model.SOrderStateSubmit).
+		Where(leaseFree, auditor, now).
+		UpdateColumns(map[string]interface{}{"reviewer_id": auditor, "lease_expire_at": until})
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
+
+func (r *reviewRepo) Release(sid, auditor uint) (bool, error) {
+
+	res := r.db.Model(model.TSubOrder{}).
+		Where("id = ? AND reviewer_id = ?", sid, auditor).
+		UpdateColumns(map[string]interface{}{"reviewer_id": 0, "lease_expire_at": nil})
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
+
+func (r *reviewRepo) Skip(skip *model.TReviewSkip) error {
+	return r.create(skip)
+}
+
+func (r *reviewRepo) Stats(tr TimeRange) ([]*ReviewStat, error) {
+
+	stats := make([]*ReviewStat, 0)
+	err := tr.scope(r.db.Model(model.TOrderEvent{})).
+		Select("operator_id AS auditor_id, "+
+			"SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS approved, "+
+			"SUM(CASE WHEN kind = ? THEN 1 ELSE 0 END) AS rejected",
+			model.OrderEventApproved, model.OrderEventRejected).
+		Where("sid <> 0 AND operator_id <> 0 AND kind IN ?",
+			[]model.OrderEventKind{model.OrderEventApproved, model.OrderEventRejected}).
+		Group("operator_id").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	skips := make([]*ReviewStat, 0)
+	err = tr.scope(r.db.Model(model.TReviewSkip{})).
+		Select("auditor_id, COUNT(*) AS skipped").
+		Group("auditor_id").
+		Scan(&skips).Error
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint]*ReviewStat, len(stats))
+	for _, s := range stats {
+		index[s.AuditorID] = s
+	}
+
+	for _, s := range skips {
+		if st, ok := index[s.AuditorID]; ok {
+			st.Skipped = s.Skipped
+			continue
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
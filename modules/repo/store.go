@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type store struct {
+	db *gorm.DB
+
+	// duplicate 判断错误是否为违反唯一约束，各数据库的错误不同
+	duplicate func(err error) bool
+}
+
+// New 基于已连接的数据库创建 Store，duplicate 判断错误是否为违反唯一约束
+func New(db *gorm.DB, duplicate func(err error) bool) Store {
+	return &store{db: db, duplicate: duplicate}
+}
+
+// NewMySQL 基于已连接的 MySQL 数据库创建 Store，表结构由 migrate 子命令维护
+func NewMySQL(db *gorm.DB) Store {
+	return New(db, isMySQLDuplicate)
+}
+
+// isMySQLDuplicate 兼容不同驱动版本的错误格式："Error 1062: ..." 及 "Error 1062 (23000): ..."
+func isMySQLDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "Error 1062")
+}
+
+func (s *store) Users() UserRepo {
+	return &userRepo{s}
+}
+
+func (s *store) MasterOrders() MasterOrderRepo {
+	return &masterOrderRepo{s}
+}
+
+func (s *store) SubOrders() SubOrderRepo {
+	return &subOrderRepo{s}
+}
+
+func (s *store) TradeRecords() TradeRecordRepo {
+	return &tradeRecordRepo{s}
+}
+
+func (s *store) PayRecords() PayRecordRepo {
+	return &payRecordRepo{s}
+}
+
+func (s *store) TransferRecords() TransferRecordRepo {
+	return &transferRecordRepo{s}
+}
+
+func (s *store) Reviews() ReviewRepo {
+	return &reviewRepo{s}
+}
+
+func (s *store) Transaction(fn func(tx Store) error) error {
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(s.WithDB(tx))
+	})
+}
+
+func (s *store) WithDB(db *gorm.DB) Store {
+	return &store{db: db, duplicate: s.duplicate}
+}
+
+func (s *store) DB() *gorm.DB {
+	return s.db
+}
+
+// create 创建记录，违反唯一约束时返回 ErrDuplicate
+func (s *store) create(value interface{}) error {
+
+	err := s.db.Create(value).Error
+	if err != nil && s.duplicate(err) {
+		return fmt.Errorf("%w: %s", ErrDuplicate, err.Error())
+	}
+
+	return err
+}
+
+// locking 加行锁查询，SQLite 不支持行锁，由其驱动忽略该子句
+func (s *store) locking() *gorm.DB {
+	return s.db.Clauses(clause.Locking{Strength: "UPDATE"})
+}
@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type tradeRecordRepo struct {
+	*store
+}
+
+func (r *tradeRecordRepo) Create(record *model.TTradeRecord) error {
+	return r.create(record)
+}
+
+func (r *tradeRecordRepo) Get(userID uint, tradeID string, typ model.TradeType) (*model.TTradeRecord, error) {
+
+	record := &model.TTradeRecord{}
+	err := r.db.Model(model.TTradeRecord{}).
+		Where("trade_id = ? AND user_id = ? AND type = ?", tradeID, userID, typ).First(record).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (r *tradeRecordRepo) List(filter *TradeFilter, page *model.Page) ([]*model.TTradeRecord, *model.PageInfo, error) {
+
+	err := filter.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paginate(filter.scope(r.db.Model(model.TTradeRecord{})), page, TradeSorts, tradeKey)
+}
+
+func (r *tradeRecordRepo) UpdateType(tradeID string, typ model.TradeType) error {
+	return r.db.Model(model.TTradeRecord{}).Where("trade_id = ?", tradeID).Update("type", typ).Error
+}
+
+func (r *tradeRecordRepo) UpdatePending(tradeID string, userID uint, fields map[string]interface{}) (bool, error) {
+
+	res := r.db.Model(model.TTradeRecord{}).
+		Where("trade_id = ? AND user_id = ? AND entry_id = 0", tradeID, userID).Updates(fields)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type userRepo struct {
+	*store
+}
+
+func (r *userRepo) Create(user *model.TUser) error {
+	return r.create(user)
+}
+
+func (r *userRepo) Get(id uint) (*model.TUser, error) {
+
+	user := &model.TUser{}
+	err := r.db.Model(model.TUser{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *userRepo) GetByOpenID(openid string) (*model.TUser, error) {
+
+	user := &model.TUser{}
+	err := r.db.Model(model.TUser{}).Where("openid = ?", openid).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *userRepo) GetByIDs(ids []uint) ([]*model.TUser, error) {
+
+	users := make([]*model.TUser, 0, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	err := r.db.Model(model.TUser{}).Where("id IN ?", ids).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *userRepo) Lock(id uint) (*model.TUser, error) {
+
+	user := &model.TUser{}
+	err := r.locking().Model(model.TUser{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *userRepo) List(filter *UserFilter, page *model.Page) ([]*model.TUser, *model.PageInfo, error) {
+	return paginate(filter.TimeRange.scope(r.db.Model(model.TUser{})), page, UserSorts, userKey)
+}
+
+func (r *userRepo) Update(id uint, fields map[string]interface{}) error {
+	return r.db.Model(model.TUser{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func (r *userRepo) AddBalance(id uint, delta int64) error {
+	return r.db.Model(model.TUser{}).Where("id = ?", id).Update("balance", gorm.Expr("balance + ?", delta)).Error
+}
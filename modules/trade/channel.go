@@ -0,0 +1,97 @@
+package trade
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+)
+
+type (
+	// PayRecord 各支付渠道预支付记录的统一视图
+	PayRecord = repo.PayRecord
+
+	// TransferRecord 各支付渠道转账记录的统一视图
+	TransferRecord = repo.TransferRecord
+)
+
+// AddPayRecord 创建预支付记录
+func (c *Ctl) AddPayRecord(db *gorm.DB, channel model.PayChannel, user *model.TUser, amount int64, tradeID, prepayID string) error {
+
+	return c.store.WithDB(db).PayRecords().Add(channel, &PayRecord{
+		TradeID:  tradeID,
+		UserID:   user.ID,
+		OpenID:   user.OpenID,
+		PrepayID: prepayID,
+		Amount:   amount,
+		State:    model.WxPayStatePrepay,
+	})
+}
+
+// LockPayRecord 在事务中锁定预支付记录，同一交易的支付通知将串行处理
+func (c *Ctl) LockPayRecord(tx *gorm.DB, channel model.PayChannel, tradeID string) (*PayRecord, error) {
+	return c.store.WithDB(tx).PayRecords().Lock(channel, tradeID)
+}
+
+// UpdatePayRecordState 更新预支付记录状态
+func (c *Ctl) UpdatePayRecordState(tx *gorm.DB, channel model.PayChannel, tradeID, state string) error {
+	return c.store.WithDB(tx).PayRecords().UpdateState(channel, tradeID, state)
+}
+
+// PayPayRecord 预支付记录支付成功，记录渠道交易号
+func (c *Ctl) PayPayRecord(tx *gorm.DB, channel model.PayChannel, tradeID, transactionID string) error {
+	return c.store.WithDB(tx).PayRecords().Pay(channel, tradeID, transactionID)
+}
+
+// AddTransferRecord 创建 INIT 状态的转账记录，提交渠道成功后更新为 ACCEPTED，支付宝转账需记录收款账号及姓名
+func (c *Ctl) AddTransferRecord(db *gorm.DB, channel model.PayChannel, user *model.TUser, account, name string,
+	amount int64, tradeID string) error {
+
+	return c.store.WithDB(db).TransferRecords().Add(&TransferRecord{
+		Channel: channel,
+		TradeID: tradeID,
+		UserID:  user.ID,
+		OpenID:  user.OpenID,
+		Account: account,
+		Name:    name,
+		Amount:  amount,
+		State:   model.TransferStateINIT,
+	})
+}
+
+// GetDueTransferRecords 查询各渠道未结束且已到查询时间的转账记录
+func (c *Ctl) GetDueTransferRecords(now time.Time) ([]*TransferRecord, error) {
+
+	states := []string{model.TransferStateINIT, model.TransferStateACCEPTED, model.TransferStatePROCESSING}
+	return c.store.TransferRecords().Due(states, now)
+}
+
+// LockTransferRecord 在事务中锁定转账记录，同一转账的状态更新串行处理
+func (c *Ctl) LockTransferRecord(tx *gorm.DB, channel model.PayChannel, tradeID string) (*TransferRecord, error) {
+	return c.store.WithDB(tx).TransferRecords().Lock(channel, tradeID)
+}
+
+// GetTransferRecord 查询转账记录
+func (c *Ctl) GetTransferRecord(channel model.PayChannel, tradeID string) (*TransferRecord, error) {
+	return c.store.TransferRecords().Get(channel, tradeID)
+}
+
+// TransitTransferRecord 按状态机更新转账记录状态，仅当记录仍处于 from 状态时更新，返回是否更新成功。
+// batchID、failReason 为空时不更新
+func (c *Ctl) TransitTransferRecord(db *gorm.DB, channel model.PayChannel, tradeID, from, to, batchID,
+	failReason string) (bool, error) {
+
+	if !model.CanTransitTransfer(from, to) {
+		return false, fmt.Errorf("invalid transfer state transition: %s -> %s", from, to)
+	}
+
+	return c.store.WithDB(db).TransferRecords().Transit(channel, tradeID, from, to, batchID, failReason)
+}
+
+// ScheduleTransferQuery 记录查询次数及下次查询转账结果的时间
+func (c *Ctl) ScheduleTransferQuery(db *gorm.DB, channel model.PayChannel, tradeID string, attempts int, next time.Time) error {
+	return c.store.WithDB(db).TransferRecords().Schedule(channel, tradeID, attempts, next)
+}
@@ -2,70 +2,26 @@ package trade
 
 import (
 	"gorm.io/gorm"
-	"strings"
 
 	"git.ucloudadmin.com/unetworks/app/pkg/log"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 )
 
 type Ctl struct {
 	*log.Logger
-	db *gorm.DB
+	db    *gorm.DB
+	store repo.Store
 }
 
-func NewCtl(logger *log.Logger, db *gorm.DB) *Ctl {
+func NewCtl(logger *log.Logger, store repo.Store) *Ctl {
 
-	return &Ctl{logger, db}
+	return &Ctl{logger, store.DB(), store}
 }
 
-func (c *Ctl) UpdateWxPayRecordState(db *gorm.DB, tradeID string, state string) error {
-	return db.Model(model.TWxPayRecord{}).Where("trade_id = ?", tradeID).Update("state", state).Error
-}
-
-func (c *Ctl) AddWxPayRecord(db *gorm.DB, openid string, amount int64, tradeID, prepayID string) error {
-
-	record := &model.TWxPayRecord{
-		PrepayID: prepayID,
-		TradeID:  tradeID,
-		OpenID:   openid,
-		Amount:   amount,
-		State:    model.WxPayStatePrepay,
-	}
-
-	return db.Model(model.TWxPayRecord{}).Create(record).Error
-}
-
-func (c *Ctl) AddWxTransferRecord(db *gorm.DB, openid string, amount int64, tradeID, batchID string) error {
-
-	record := &model.TWxTransferRecord{
-		TradeID: tradeID,
-		BatchID: batchID,
-		OpenID:  openid,
-		Amount:  amount,
-		State:   model.WxTransferStateACCEPTED,
-	}
-
-	return db.Model(model.TWxPayRecord{}).Create(record).Error
-}
-
-func (c *Ctl) GetWxTransferRecord(states ...string) ([]*model.TWxTransferRecord, error) {
-
-	rs := make([]*model.TWxTransferRecord, 0)
-	err := c.db.Model(model.TWxTransferRecord{}).
-		Where("state in ('')", strings.Join(states, "','")).Find(&rs).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return rs, nil
-}
-
-func (c *Ctl) UpdateWxTransferRecordState(db *gorm.DB, tradeID string, state string) error {
-	return db.Model(model.TWxPayRecord{}).Where("trade_id = ?", tradeID).Update("state", state).Error
-}
-
-func (c *Ctl) AddTradeRecord(db *gorm.DB, userID uint, Type model.TradeType, amount, balance int64, TradeID string) error {
+func (c *Ctl) AddTradeRecord(db *gorm.DB, userID uint, Type model.TradeType, amount, balance int64, TradeID, remark string,
+	channel model.PayChannel) error {
 
 	record := &model.TTradeRecord{
 		TradeID: TradeID,
@@ -73,75 +29,27 @@ func (c *Ctl) AddTradeRecord(db *gorm.DB, userID uint, Type model.TradeType, amo
 		Type:    Type,
 		Amount:  amount,
 		Balance: balance,
+		Remark:  remark,
+		Channel: channel,
 	}
 
-	return db.Model(model.TTradeRecord{}).Create(record).Error
+	return c.store.WithDB(db).TradeRecords().Create(record)
 }
 
 func (c *Ctl) UpdateTradeRecordState(tx *gorm.DB, tradeID string, Type model.TradeType) error {
 
-	return tx.Model(model.TTradeRecord{}).Where("trade_id = ?", tradeID).Update("type", Type).Error
-}
-
-func (c *Ctl) UpdateTradeRecordBalance(tx *gorm.DB, tradeID string, balance int64) error {
-
-	return tx.Model(model.TTradeRecord{}).Where("trade_id = ?", tradeID).Update("balance", balance).Error
+	return c.store.WithDB(tx).TradeRecords().UpdateType(tradeID, Type)
 }
 
-func (c *Ctl) getTrades(condition string, args ...interface{}) ([]*model.TTradeRecord, error) {
-
-	trades := make([]*model.TTradeRecord, 0)
-	err := c.db.Model(model.TTradeRecord{}).Where(condition, args...).Order("id desc").Find(&trades).Error
-	if err != nil {
-		return nil, err
-	}
+// GetTrades 分页查询交易记录
+func (c *Ctl) GetTrades(filter *repo.TradeFilter, page *model.Page) ([]*model.TTradeRecord, *model.PageInfo, error) {
 
-	return trades, nil
+	return c.store.TradeRecords().List(filter, page)
 }
 
-func (c *Ctl) GetTrades(uuid string, userID int, tradeType model.TradeType) ([]*model.TTradeRecord, error) {
-
-	expr := make([]string, 0)
-	args := make([]interface{}, 0)
-	if len(uuid) != 0 {
-		expr = append(expr, "trade_id = ?")
-		args = append(args, uuid)
-	}
-
-	if userID != 0 {
-		expr = append(expr, "user_id = ?")
-		args = append(args, userID)
-	}
-
-	if tradeType != 0 {
-		expr = append(expr, "type = ?")
-		args = append(args, tradeType)
-	}
-
-	return c.getTrades(strings.Join(expr, " AND "), args...)
-}
-
-func (c *Ctl) getTradesPage(offset, limit int, condition string, args ...interface{}) ([]*model.TTradeRecord, error) {
-
-	trades := make([]*model.TTradeRecord, 0)
-	err := c.db.Model(model.TTradeRecord{}).Where(condition, args...).
-		Offset(offset).Limit(limit).Order("id desc").Find(&trades).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return trades, nil
-}
-
-func (c *Ctl) GetTradesPage(userID uint, offset, limit int) ([]*model.TTradeRecord, error) {
-
-	expr := make([]string, 0)
-	args := make([]interface{}, 0)
-
-	if userID != 0 {
-		expr = append(expr, "user_id = ?")
-		args = append(args, userID)
-	}
+// GetTradesByIDs 查询交易 ID 关联的全部交易记录
+func (c *Ctl) GetTradesByIDs(tradeIDs []string) ([]*model.TTradeRecord, error) {
 
-	return c.getTradesPage(offset, limit, strings.Join(expr, " AND "), args...)
+	trades, _, err := c.store.TradeRecords().List(&repo.TradeFilter{TradeIDs: tradeIDs}, nil)
+	return trades, err
 }
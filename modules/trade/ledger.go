@@ -0,0 +1,332 @@
+package trade
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	// Line 待记账分录
+	Line struct {
+		Type    model.AccountType
+		OwnerID uint
+		Amount  int64
+	}
+
+	// Entry 待记账凭证
+	Entry struct {
+		TradeID string
+		Type    model.TradeType
+		Remark  string
+		Channel model.PayChannel // 支付渠道，写入用户交易记录
+		Lines   []Line
+	}
+
+	// WalletMismatch 用户缓存余额与账本钱包余额不一致
+	WalletMismatch struct {
+		UserID        uint  `json:"user_id"`
+		UserBalance   int64 `json:"user_balance"`
+		LedgerBalance int64 `json:"ledger_balance"`
+	}
+
+	// UnbalancedEntry 借贷不平的记账凭证
+	UnbalancedEntry struct {
+		EntryID uint  `json:"entry_id"`
+		Sum     int64 `json:"sum"`
+	}
+)
+
+var ErrUnbalancedEntry = errors.New("journal entry is unbalanced")
+
+func Wallet(userID uint, amount int64) Line {
+	return Line{Type: model.AccountUserWallet, OwnerID: userID, Amount: amount}
+}
+
+// Escrow 订单托管账户，每个订单独立记账
+func Escrow(mid uint, amount int64) Line {
+	return Line{Type: model.AccountEscrow, OwnerID: mid, Amount: amount}
+}
+
+func Revenue(amount int64) Line {
+	return Line{Type: model.AccountRevenue, Amount: amount}
+}
+
+func WechatClearing(amount int64) Line {
+	return Line{Type: model.AccountWechatClearing, Amount: amount}
+}
+
+func AlipayClearing(amount int64) Line {
+	return Line{Type: model.AccountAlipayClearing, Amount: amount}
+}
+
+// Clearing 按支付渠道选择清算账户
+func Clearing(channel model.PayChannel, amount int64) Line {
+	if channel == model.ChannelAlipay {
+		return AlipayClearing(amount)
+	}
+	return WechatClearing(amount)
+}
+
+// lockAccount 查询并锁定账户，账户不存在时创建
+func (c *Ctl) lockAccount(tx *gorm.DB, typ model.AccountType, ownerID uint) (*model.TLedgerAccount, error) {
+
+	account := &model.TLedgerAccount{}
+	err := tx.Model(model.TLedgerAccount{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("type = ? AND owner_id = ?", typ, ownerID).First(account).Error
+	if err == nil {
+		return account, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	account = &model.TLedgerAccount{Type: typ, OwnerID: ownerID}
+	err = tx.Model(model.TLedgerAccount{}).Create(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// openWallet 首次为用户记账时创建钱包账户，并以用户当前余额生成期初凭证
+func (c *Ctl) openWallet(tx *gorm.DB, userID uint) error {
+
+	var count int64
+	err := tx.Model(model.TLedgerAccount{}).
+		Where("type = ? AND owner_id = ?", model.AccountUserWallet, userID).Count(&count).Error
+	if err != nil || count != 0 {
+		return err
+	}
+
+	user, err := c.store.WithDB(tx).Users().Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Balance == 0 {
+		_, err = c.lockAccount(tx, model.AccountUserWallet, userID)
+		return err
+	}
+
+	_, err = c.post(tx, &Entry{
+		TradeID: fmt.Sprintf("opening-%d", userID),
+		Type:    model.TypeOpening,
+		Remark:  "启用账本期初余额",
+		Lines: []Line{
+			{Type: model.AccountOpening, Amount: -user.Balance},
+			Wallet(userID, user.Balance),
+		},
+	})
+
+	return err
+}
+
+// Post 记账：校验凭证借贷平衡后逐条过账，用户钱包分录同步生成用户交易记录
+func (c *Ctl) Post(tx *gorm.DB, entry *Entry) (*model.TJournalEntry, error) {
+
+	for _, l := range entry.Lines {
+		if l.Type != model.AccountUserWallet {
+			continue
+		}
+
+		err := c.openWallet(tx, l.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.post(tx, entry)
+}
+
+func (c *Ctl) post(tx *gorm.DB, entry *Entry) (*model.TJournalEntry, error) {
+
+	if len(entry.Lines) == 0 {
+		return nil, ErrUnbalancedEntry
+	}
+
+	var sum int64
+	for _, l := range entry.Lines {
+		sum += l.Amount
+	}
+
+	if sum != 0 {
+		return nil, ErrUnbalancedEntry
+	}
+
+	je := &model.TJournalEntry{
+		TradeID: entry.TradeID,
+		Type:    entry.Type,
+		Remark:  entry.Remark,
+	}
+	err := tx.Model(model.TJournalEntry{}).Create(je).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range entry.Lines {
+		if l.Amount == 0 {
+			continue
+		}
+
+		account, err := c.lockAccount(tx, l.Type, l.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		balance := account.Balance + l.Amount
+		err = tx.Model(model.TLedgerAccount{}).Where("id = ?", account.ID).
+			Update("balance", gorm.Expr("balance + ?", l.Amount)).Error
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Model(model.TJournalLine{}).Create(&model.TJournalLine{
+			EntryID:   je.ID,
+			AccountID: account.ID,
+			Amount:    l.Amount,
+			Balance:   balance,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if l.Type == model.AccountUserWallet {
+			err = c.projectTradeRecord(tx, je, entry.Channel, l, balance)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return je, nil
+}
+
+// projectTradeRecord 根据用户钱包分录生成用户交易记录，若存在同一交易的未入账记录（如充值中）则直接更新该记录
+func (c *Ctl) projectTradeRecord(tx *gorm.DB, je *model.TJournalEntry, channel model.PayChannel, l Line, balance int64) error {
+
+	amount := l.Amount
+	if amount < 0 && je.Type != model.TypeAdjust {
+		amount = -amount
+	}
+
+	records := c.store.WithDB(tx).TradeRecords()
+	updated, err := records.UpdatePending(je.TradeID, l.OwnerID, map[string]interface{}{
+		"type":     je.Type,
+		"amount":   amount,
+		"balance":  balance,
+		"entry_id": je.ID,
+	})
+	if err != nil || updated {
+		return err
+	}
+
+	return records.Create(&model.TTradeRecord{
+		TradeID: je.TradeID,
+		UserID:  l.OwnerID,
+		Type:    je.Type,
+		Amount:  amount,
+		Balance: balance,
+		Remark:  je.Remark,
+		EntryID: je.ID,
+		Channel: channel,
+	})
+}
+
+// GetAccountBalance 查询账户余额，账户不存在时余额为 0
+func (c *Ctl) GetAccountBalance(typ model.AccountType, ownerID uint) (int64, error) {
+
+	accounts := make([]*model.TLedgerAccount, 0)
+	err := c.db.Model(model.TLedgerAccount{}).Where("type = ? AND owner_id = ?", typ, ownerID).
+		Find(&accounts).Error
+	if err != nil || len(accounts) == 0 {
+		return 0, err
+	}
+
+	return accounts[0].Balance, nil
+}
+
+// GetAccounts 查询记账账户
+func (c *Ctl) GetAccounts(typ model.AccountType) ([]*model.TLedgerAccount, error) {
+
+	accounts := make([]*model.TLedgerAccount, 0)
+	db := c.db.Model(model.TLedgerAccount{})
+	if typ != 0 {
+		db = db.Where("type = ?", typ)
+	}
+
+	err := db.Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// GetJournalEntries 查询交易对应的记账凭证及分录
+func (c *Ctl) GetJournalEntries(tradeID string) ([]*model.TJournalEntry, map[uint][]*model.TJournalLine, error) {
+
+	entries := make([]*model.TJournalEntry, 0)
+	err := c.db.Model(model.TJournalEntry{}).Where("trade_id = ?", tradeID).Order("id").Find(&entries).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]uint, 0)
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	lines := make([]*model.TJournalLine, 0)
+	if len(ids) != 0 {
+		err = c.db.Model(model.TJournalLine{}).Where("entry_id IN ?", ids).Order("id").Find(&lines).Error
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	id2lines := make(map[uint][]*model.TJournalLine)
+	for _, l := range lines {
+		id2lines[l.EntryID] = append(id2lines[l.EntryID], l)
+	}
+
+	return entries, id2lines, nil
+}
+
+// ReconcileWallets 核对用户缓存余额与账本钱包余额
+func (c *Ctl) ReconcileWallets() ([]*WalletMismatch, error) {
+
+	mismatches := make([]*WalletMismatch, 0)
+	err := c.db.Table("t_users AS u").
+		Select("u.id AS user_id, u.balance AS user_balance, COALESCE(a.balance, 0) AS ledger_balance").
+		Joins("LEFT JOIN t_ledger_accounts AS a ON a.owner_id = u.id AND a.type = ? AND a.deleted_at IS NULL",
+			model.AccountUserWallet).
+		Where("u.deleted_at IS NULL AND a.id IS NOT NULL AND u.balance <> a.balance").
+		Scan(&mismatches).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return mismatches, nil
+}
+
+// CheckUnbalancedEntries 检查借贷不平的记账凭证
+func (c *Ctl) CheckUnbalancedEntries() ([]*UnbalancedEntry, error) {
+
+	entries := make([]*UnbalancedEntry, 0)
+	err := c.db.Model(model.TJournalLine{}).
+		Select("entry_id, SUM(amount) AS sum").
+		Group("entry_id").Having("SUM(amount) <> 0").
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
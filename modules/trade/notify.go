@@ -0,0 +1,54 @@
+package trade
+
+import (
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// AddPayNotification 支付通知入收件箱，独立于入账事务，入账失败时通知仍保留
+func (c *Ctl) AddPayNotification(n *model.TPayNotification) error {
+
+	n.State = model.NotifyStateReceived
+	return c.db.Model(model.TPayNotification{}).Create(n).Error
+}
+
+// UpdatePayNotification 更新支付通知处理状态
+func (c *Ctl) UpdatePayNotification(id uint, state model.NotifyState, result string) error {
+
+	return c.db.Model(model.TPayNotification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"state":  state,
+		"result": result,
+	}).Error
+}
+
+// GetPayNotification 查询支付通知
+func (c *Ctl) GetPayNotification(id uint) (*model.TPayNotification, error) {
+
+	n := &model.TPayNotification{}
+	err := c.db.Model(model.TPayNotification{}).Where("id = ?", id).First(n).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// GetPayNotifications 查询支付通知列表，可按商户交易 ID 及处理状态过滤
+func (c *Ctl) GetPayNotifications(outTradeNo string, state model.NotifyState) ([]*model.TPayNotification, error) {
+
+	ns := make([]*model.TPayNotification, 0)
+	db := c.db.Model(model.TPayNotification{})
+	if len(outTradeNo) != 0 {
+		db = db.Where("out_trade_no = ?", outTradeNo)
+	}
+
+	if state != 0 {
+		db = db.Where("state = ?", state)
+	}
+
+	err := db.Order("id desc").Find(&ns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ns, nil
+}
@@ -0,0 +1,78 @@
+package trade
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// GetRechargeRecord 查询用户充值成功的交易记录
+func (c *Ctl) GetRechargeRecord(userID uint, tradeID string) (*model.TTradeRecord, error) {
+
+	return c.store.TradeRecords().Get(userID, tradeID, model.TypeRecharge)
+}
+
+// GetRefundedAmount 查询充值已退款及退款中的金额，退款关闭的不计入
+func (c *Ctl) GetRefundedAmount(tx *gorm.DB, tradeID string) (int64, error) {
+
+	var amount int64
+	err := tx.Model(model.TRefundRecord{}).Select("COALESCE(SUM(amount), 0)").
+		Where("trade_id = ? AND state <> ?", tradeID, "CLOSED").Scan(&amount).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+func (c *Ctl) AddRefundRecord(tx *gorm.DB, record *model.TRefundRecord) error {
+	return tx.Model(model.TRefundRecord{}).Create(record).Error
+}
+
+// LockRefundRecord 在事务中锁定退款记录，同一退款的通知将串行处理
+func (c *Ctl) LockRefundRecord(tx *gorm.DB, refundID string) (*model.TRefundRecord, error) {
+
+	record := &model.TRefundRecord{}
+	err := tx.Model(model.TRefundRecord{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("refund_id = ?", refundID).First(record).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (c *Ctl) UpdateRefundRecord(tx *gorm.DB, refundID, state, providerRefundID string) error {
+	return tx.Model(model.TRefundRecord{}).Where("refund_id = ?", refundID).Updates(map[string]interface{}{
+		"state":              state,
+		"provider_refund_id": providerRefundID,
+	}).Error
+}
+
+// GetProcessingRefundRecords 查询 before 前未再更新的处理中退款
+func (c *Ctl) GetProcessingRefundRecords(before time.Time) ([]*model.TRefundRecord, error) {
+
+	records := make([]*model.TRefundRecord, 0)
+	err := c.db.Model(model.TRefundRecord{}).Where("state = ? AND updated_at < ?", "PROCESSING", before).
+		Order("id").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetRefundRecords 查询用户退款记录
+func (c *Ctl) GetRefundRecords(userID uint) ([]*model.TRefundRecord, error) {
+
+	records := make([]*model.TRefundRecord, 0)
+	err := c.db.Model(model.TRefundRecord{}).Where("user_id = ?", userID).Order("id desc").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
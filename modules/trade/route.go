@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 	"net/http"
 	"strconv"
 )
@@ -15,12 +16,45 @@ type (
 
 	Trade struct {
 		*model.TTradeRecord
-		TypeCN string `json:"type_cn"`
+		TypeCN    string `json:"type_cn"`
+		ChannelCN string `json:"channel_cn,omitempty"`
 	}
 
 	RespGetTrades struct {
 		*model.RespBase
-		Trades []*Trade `json:"trades"`
+		Trades []*Trade        `json:"trades"`
+		Page   *model.PageInfo `json:"page"`
+	}
+
+	ReqGetLedger struct {
+		*model.ReqBase
+	}
+
+	Account struct {
+		*model.TLedgerAccount
+		TypeCN string `json:"type_cn"`
+	}
+
+	RespGetAccounts struct {
+		*model.RespBase
+		Accounts []*Account `json:"accounts"`
+	}
+
+	JournalEntry struct {
+		*model.TJournalEntry
+		TypeCN string                `json:"type_cn"`
+		Lines  []*model.TJournalLine `json:"lines"`
+	}
+
+	RespGetJournalEntries struct {
+		*model.RespBase
+		Entries []*JournalEntry `json:"entries"`
+	}
+
+	RespReconcileLedger struct {
+		*model.RespBase
+		Wallets    []*WalletMismatch  `json:"wallets"`
+		Unbalanced []*UnbalancedEntry `json:"unbalanced"`
 	}
 )
 
@@ -29,6 +63,105 @@ func (c *Ctl) InitRouter(g *gin.RouterGroup) {
 	g.GET("/trades", c.HandleGetTrades)
 }
 
+// InitAdminRouter 注册账本管理接口，路由组需已挂载管理员权限校验
+func (c *Ctl) InitAdminRouter(g *gin.RouterGroup) {
+
+	// 查询记账账户
+	g.GET("/ledger/accounts", c.HandleGetAccounts)
+
+	// 查询交易记账凭证
+	g.GET("/ledger/entries", c.HandleGetJournalEntries)
+
+	// 核对账本
+	g.GET("/ledger/reconcile", c.HandleReconcileLedger)
+}
+
+func (c *Ctl) HandleGetAccounts(ctx *gin.Context) {
+
+	req := &ReqGetLedger{}
+	t := ctx.DefaultQuery("type", "0")
+	iType, err := strconv.Atoi(t)
+	if err != nil {
+		err := fmt.Errorf("invalid type: [%v]", t)
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	accounts, err := c.GetAccounts(model.AccountType(iType))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*Account, 0)
+	for _, a := range accounts {
+		data = append(data, &Account{
+			TLedgerAccount: a,
+			TypeCN:         model.AccountTypeCN[a.Type],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetAccounts{
+		RespBase: req.GenResponse(nil),
+		Accounts: data,
+	})
+}
+
+func (c *Ctl) HandleGetJournalEntries(ctx *gin.Context) {
+
+	req := &ReqGetLedger{}
+	tradeID := ctx.Query("trade_id")
+	if len(tradeID) == 0 {
+		err := fmt.Errorf("invalid trade_id: [%v]", tradeID)
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	entries, lines, err := c.GetJournalEntries(tradeID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*JournalEntry, 0)
+	for _, e := range entries {
+		data = append(data, &JournalEntry{
+			TJournalEntry: e,
+			TypeCN:        model.TradeTypeCN[e.Type],
+			Lines:         lines[e.ID],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetJournalEntries{
+		RespBase: req.GenResponse(nil),
+		Entries:  data,
+	})
+}
+
+func (c *Ctl) HandleReconcileLedger(ctx *gin.Context) {
+
+	req := &ReqGetLedger{}
+	wallets, err := c.ReconcileWallets()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	unbalanced, err := c.CheckUnbalancedEntries()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReconcileLedger{
+		RespBase:   req.GenResponse(nil),
+		Wallets:    wallets,
+		Unbalanced: unbalanced,
+	})
+}
+
 func (c *Ctl) HandleGetTrades(ctx *gin.Context) {
 
 	req := &ReqGetTrades{}
@@ -38,7 +171,7 @@ func (c *Ctl) HandleGetTrades(ctx *gin.Context) {
 		userID = "0"
 	}
 
-	uid, err := strconv.Atoi(userID)
+	uid, err := strconv.ParseUint(userID, 10, 32)
 	if err != nil {
 		err := fmt.Errorf("invalid user_id: [%v]", userID)
 		c.Errorf("parsing request failed, err=%s", err.Error())
@@ -46,20 +179,33 @@ func (c *Ctl) HandleGetTrades(ctx *gin.Context) {
 		return
 	}
 
-	t := ctx.Query("type")
-	if len(t) == 0 {
-		t = "0"
+	tradeType, err := repo.ParseTradeType(ctx.Query("type"))
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
 	}
 
-	iType, err := strconv.Atoi(t)
+	page, err := repo.ParsePage(ctx.Query, repo.TradeSorts...)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
 	if err != nil {
-		err := fmt.Errorf("invalid type: [%v]", t)
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	trades, err := c.GetTrades(aUUID, uid, model.TradeType(iType))
+	trades, info, err := c.GetTrades(&repo.TradeFilter{
+		TimeRange: timeRange,
+		TradeID:   aUUID,
+		UserID:    uint(uid),
+		Type:      tradeType,
+	}, page)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
@@ -70,11 +216,13 @@ func (c *Ctl) HandleGetTrades(ctx *gin.Context) {
 		data = append(data, &Trade{
 			TTradeRecord: t,
 			TypeCN:       model.TradeTypeCN[t.Type],
+			ChannelCN:    model.PayChannelCN[t.Channel],
 		})
 	}
 
 	ctx.JSON(http.StatusOK, &RespGetTrades{
 		RespBase: req.GenResponse(err),
 		Trades:   data,
+		Page:     info,
 	})
 }
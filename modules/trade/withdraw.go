@@ -0,0 +1,89 @@
+package trade
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+// WithdrawHold 提现冻结账户
+func WithdrawHold(amount int64) Line {
+	return Line{Type: model.AccountWithdrawHold, Amount: amount}
+}
+
+func (c *Ctl) AddWithdrawRequest(tx *gorm.DB, r *model.TWithdrawRequest) error {
+	return tx.Model(model.TWithdrawRequest{}).Create(r).Error
+}
+
+// LockWithdrawRequest 在事务中锁定提现申请，避免重复审批
+func (c *Ctl) LockWithdrawRequest(tx *gorm.DB, id uint) (*model.TWithdrawRequest, error) {
+
+	r := &model.TWithdrawRequest{}
+	err := tx.Model(model.TWithdrawRequest{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).First(r).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ReviewWithdrawRequest 记录提现申请审批结果
+func (c *Ctl) ReviewWithdrawRequest(tx *gorm.DB, r *model.TWithdrawRequest, state model.WithdrawState,
+	reviewerID uint, remark string) error {
+
+	now := time.Now()
+	err := tx.Model(model.TWithdrawRequest{}).Where("id = ?", r.ID).Updates(map[string]interface{}{
+		"state":         state,
+		"reviewer_id":   reviewerID,
+		"review_remark": remark,
+		"reviewed_at":   &now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	r.State, r.ReviewerID, r.ReviewRemark, r.ReviewedAt = state, reviewerID, remark, &now
+	return nil
+}
+
+// SumWithdrawRequests 统计用户自 since 起未被驳回的提现申请金额及次数
+func (c *Ctl) SumWithdrawRequests(tx *gorm.DB, userID uint, since time.Time) (int64, int64, error) {
+
+	var ret struct {
+		Amount int64
+		Count  int64
+	}
+	err := tx.Model(model.TWithdrawRequest{}).Select("COALESCE(SUM(amount), 0) AS amount, COUNT(*) AS count").
+		Where("user_id = ? AND state <> ? AND created_at >= ?", userID, model.WithdrawStateRejected, since).
+		Scan(&ret).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ret.Amount, ret.Count, nil
+}
+
+// GetWithdrawRequests 查询提现申请，userID 为 0 时查询所有用户，state 为 0 时查询所有状态
+func (c *Ctl) GetWithdrawRequests(userID uint, state model.WithdrawState) ([]*model.TWithdrawRequest, error) {
+
+	db := c.db.Model(model.TWithdrawRequest{})
+	if userID != 0 {
+		db = db.Where("user_id = ?", userID)
+	}
+
+	if state != 0 {
+		db = db.Where("state = ?", state)
+	}
+
+	rs := make([]*model.TWithdrawRequest, 0)
+	err := db.Order("id desc").Find(&rs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
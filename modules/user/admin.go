@@ -0,0 +1,127 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+	"github.com/mojiQAQ/dispatch/modules/utils"
+)
+
+var (
+	ErrUserFrozen     = errors.New("账户已冻结")
+	ErrRoleNotAllowed = errors.New("该角色不可自助注册")
+)
+
+// CheckActive 校验用户是否已被冻结
+func (c *Ctl) CheckActive(userID uint) error {
+
+	user, err := c.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Frozen {
+		return ErrUserFrozen
+	}
+
+	return nil
+}
+
+// ChangeUserRole 管理员修改用户角色
+func (c *Ctl) ChangeUserRole(adminID, userID uint, role model.Role) (*model.TUser, error) {
+
+	if _, ok := model.RoleCN[role]; !ok {
+		return nil, fmt.Errorf("invalid role: %d", role)
+	}
+
+	// 管理员不可修改自身角色，避免系统失去管理员
+	if adminID == userID {
+		return nil, fmt.Errorf("不可修改自身角色")
+	}
+
+	return c.updateUser(userID, map[string]interface{}{"role": role})
+}
+
+// FreezeUser 管理员冻结/解冻用户
+func (c *Ctl) FreezeUser(adminID, userID uint, frozen bool) (*model.TUser, error) {
+
+	if adminID == userID {
+		return nil, fmt.Errorf("不可冻结自身账户")
+	}
+
+	return c.updateUser(userID, map[string]interface{}{"frozen": frozen})
+}
+
+// ChangeUserCredit 管理员调整用户信誉分
+func (c *Ctl) ChangeUserCredit(userID uint, credit int) (*model.TUser, error) {
+
+	if credit < 0 {
+		return nil, fmt.Errorf("invalid credit: %d", credit)
+	}
+
+	return c.updateUser(userID, map[string]interface{}{"credit": credit})
+}
+
+func (c *Ctl) updateUser(userID uint, data map[string]interface{}) (*model.TUser, error) {
+
+	_, err := c.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.store.Users().Update(userID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetUser(userID)
+}
+
+// AdjustBalance 管理员手工调账：amount 为正数时增加余额，为负数时扣减余额，调账原因必填
+func (c *Ctl) AdjustBalance(adminID, userID uint, amount int64, reason string) (*model.TUser, error) {
+
+	if amount == 0 {
+		return nil, fmt.Errorf("invalid amount: %d", amount)
+	}
+
+	if len(reason) == 0 {
+		return nil, fmt.Errorf("调账原因不能为空")
+	}
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	user, err := c.lockWallet(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记账：调账损益由平台收入承担，同时生成调账交易记录，扣减超出账户余额时失败
+	err = c.changeBalance(tx, user, amount, &trade.Entry{
+		TradeID: utils.GenerateUUID(),
+		Type:    model.TypeAdjust,
+		Remark:  fmt.Sprintf("管理员(%d)调账：%s", adminID, reason),
+		Lines:   []trade.Line{trade.Wallet(userID, amount), trade.Revenue(-amount)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	c.Infof("admin %d adjust balance of user %d by %d, reason=%s", adminID, userID, amount, reason)
+	return c.GetUser(userID)
+}
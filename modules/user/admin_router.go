@@ -0,0 +1,400 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	ReqChangeUserRole struct {
+		*model.ReqBase
+		Role model.Role `json:"role" valid:"required"`
+	}
+
+	ReqChangeUserCredit struct {
+		*model.ReqBase
+		Credit int `json:"credit"`
+	}
+
+	ReqAdjustBalance struct {
+		*model.ReqBase
+		Amount int64  `json:"amount" valid:"required"`
+		Reason string `json:"reason" valid:"required"`
+	}
+
+	ReqAdminOperateUser struct {
+		*model.ReqBase
+	}
+
+	RespAdminOperateUser struct {
+		*model.RespBase
+		Info *User `json:"info"`
+	}
+
+	ReqGetPayNotifications struct {
+		*model.ReqBase
+	}
+
+	PayNotification struct {
+		*model.TPayNotification
+		StateCN string `json:"state_cn"`
+	}
+
+	RespGetPayNotifications struct {
+		*model.RespBase
+		Notifications []*PayNotification `json:"notifications"`
+	}
+
+	ReqReviewWithdraw struct {
+		*model.ReqBase
+		Remark string `json:"remark"`
+	}
+
+	ReqRejectWithdraw struct {
+		*model.ReqBase
+		Reason string `json:"reason" valid:"required"`
+	}
+
+	RespReviewWithdraw struct {
+		*model.RespBase
+		Withdraw *WithdrawRequest `json:"withdraw"`
+	}
+
+	RespReplayPayNotification struct {
+		*model.RespBase
+		Notification *PayNotification `json:"notification"`
+	}
+)
+
+// InitAdminRouter 注册管理员接口，路由组需已挂载管理员权限校验
+func (c *Ctl) InitAdminRouter(g *gin.RouterGroup) {
+
+	// 修改用户角色
+	g.PUT("/users/:id/role", c.HandleChangeUserRole)
+
+	// 冻结用户
+	g.POST("/users/:id/freeze", c.HandleFreezeUser)
+
+	// 解冻用户
+	g.POST("/users/:id/unfreeze", c.HandleUnfreezeUser)
+
+	// 调整信誉分
+	g.PUT("/users/:id/credit", c.HandleChangeUserCredit)
+
+	// 手工调账
+	g.POST("/users/:id/balance", c.HandleAdjustBalance)
+
+	// 查询支付通知收件箱
+	g.GET("/pay_notifications", c.HandleGetPayNotifications)
+
+	// 重放支付通知
+	g.POST("/pay_notifications/:id/replay", c.HandleReplayPayNotification)
+
+	// 查询提现申请
+	g.GET("/withdraws", c.HandleGetAllWithdraws)
+
+	// 审批通过提现申请
+	g.POST("/withdraws/:id/approve", c.HandleApproveWithdraw)
+
+	// 驳回提现申请
+	g.POST("/withdraws/:id/reject", c.HandleRejectWithdraw)
+}
+
+func parseUserID(ctx *gin.Context) (uint, error) {
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid user id: %s", ctx.Param("id"))
+	}
+
+	return uint(id), nil
+}
+
+func (c *Ctl) HandleChangeUserRole(ctx *gin.Context) {
+
+	req := &ReqChangeUserRole{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	uid, err := parseUserID(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user, err := c.ChangeUserRole(c.CurrentUser(ctx).ID, uid, req.Role)
+	if err != nil {
+		c.Errorf("change user role failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespAdminOperateUser{
+		RespBase: req.GenResponse(nil),
+		Info:     &User{TUser: user, RoleCN: model.RoleCN[user.Role]},
+	})
+}
+
+func (c *Ctl) HandleFreezeUser(ctx *gin.Context) {
+
+	c.handleFreezeUser(ctx, true)
+}
+
+func (c *Ctl) HandleUnfreezeUser(ctx *gin.Context) {
+
+	c.handleFreezeUser(ctx, false)
+}
+
+func (c *Ctl) handleFreezeUser(ctx *gin.Context, frozen bool) {
+
+	req := &ReqAdminOperateUser{}
+	uid, err := parseUserID(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user, err := c.FreezeUser(c.CurrentUser(ctx).ID, uid, frozen)
+	if err != nil {
+		c.Errorf("freeze user failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespAdminOperateUser{
+		RespBase: req.GenResponse(nil),
+		Info:     &User{TUser: user, RoleCN: model.RoleCN[user.Role]},
+	})
+}
+
+func (c *Ctl) HandleChangeUserCredit(ctx *gin.Context) {
+
+	req := &ReqChangeUserCredit{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	uid, err := parseUserID(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user, err := c.ChangeUserCredit(uid, req.Credit)
+	if err != nil {
+		c.Errorf("change user credit failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespAdminOperateUser{
+		RespBase: req.GenResponse(nil),
+		Info:     &User{TUser: user, RoleCN: model.RoleCN[user.Role]},
+	})
+}
+
+func (c *Ctl) HandleAdjustBalance(ctx *gin.Context) {
+
+	req := &ReqAdjustBalance{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	uid, err := parseUserID(ctx)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	user, err := c.AdjustBalance(c.CurrentUser(ctx).ID, uid, req.Amount, req.Reason)
+	if err != nil {
+		c.Errorf("adjust balance failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespAdminOperateUser{
+		RespBase: req.GenResponse(nil),
+		Info:     &User{TUser: user, RoleCN: model.RoleCN[user.Role]},
+	})
+}
+
+func (c *Ctl) HandleGetPayNotifications(ctx *gin.Context) {
+
+	req := &ReqGetPayNotifications{}
+	state, _ := strconv.Atoi(ctx.Query("state"))
+	ns, err := c.trade.GetPayNotifications(ctx.Query("out_trade_no"), model.NotifyState(state))
+	if err != nil {
+		c.Errorf("get pay notifications failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	notifications := make([]*PayNotification, 0)
+	for _, n := range ns {
+		notifications = append(notifications, &PayNotification{
+			TPayNotification: n,
+			StateCN:          model.NotifyStateCN[n.State],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetPayNotifications{
+		RespBase:      req.GenResponse(nil),
+		Notifications: notifications,
+	})
+}
+
+func (c *Ctl) HandleReplayPayNotification(ctx *gin.Context) {
+
+	req := &ReqGetPayNotifications{}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		err = fmt.Errorf("invalid notification id: %s", ctx.Param("id"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	n, err := c.ReplayPayNotification(uint(id))
+	if err != nil {
+		c.Errorf("replay pay notification failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReplayPayNotification{
+		RespBase:     req.GenResponse(nil),
+		Notification: &PayNotification{TPayNotification: n, StateCN: model.NotifyStateCN[n.State]},
+	})
+}
+
+func (c *Ctl) HandleGetAllWithdraws(ctx *gin.Context) {
+
+	req := &ReqGetWithdraws{}
+	uid, _ := strconv.Atoi(ctx.Query("user_id"))
+	state, _ := strconv.Atoi(ctx.Query("state"))
+
+	rs, err := c.trade.GetWithdrawRequests(uint(uid), model.WithdrawState(state))
+	if err != nil {
+		c.Errorf("get withdraw requests failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*WithdrawRequest, 0)
+	for _, r := range rs {
+		data = append(data, newWithdrawRequest(r))
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetWithdraws{
+		RespBase:  req.GenResponse(nil),
+		Withdraws: data,
+	})
+}
+
+func (c *Ctl) HandleApproveWithdraw(ctx *gin.Context) {
+
+	// 审批意见可选，允许空请求体
+	req := &ReqReviewWithdraw{}
+	if ctx.Request.ContentLength != 0 {
+		err := ctx.ShouldBindBodyWith(req, binding.JSON)
+		if err != nil {
+			c.Errorf("parsing request failed, err=%s", err.Error())
+			ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+			return
+		}
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		err = fmt.Errorf("invalid withdraw id: %s", ctx.Param("id"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	r, err := c.ApproveWithdraw(c.CurrentUser(ctx).ID, uint(id), req.Remark)
+	if err != nil {
+		c.Errorf("approve withdraw failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReviewWithdraw{
+		RespBase: req.GenResponse(nil),
+		Withdraw: newWithdrawRequest(r),
+	})
+}
+
+func (c *Ctl) HandleRejectWithdraw(ctx *gin.Context) {
+
+	req := &ReqRejectWithdraw{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		err = fmt.Errorf("invalid withdraw id: %s", ctx.Param("id"))
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	r, err := c.RejectWithdraw(c.CurrentUser(ctx).ID, uint(id), req.Reason)
+	if err != nil {
+		c.Errorf("reject withdraw failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespReviewWithdraw{
+		RespBase: req.GenResponse(nil),
+		Withdraw: newWithdrawRequest(r),
+	})
+}
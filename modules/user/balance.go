@@ -2,18 +2,27 @@ package user
 
 import (
 	"fmt"
-	"net/http"
 
 	"gorm.io/gorm"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/trade"
 	"github.com/mojiQAQ/dispatch/modules/utils"
 )
 
-func (c *Ctl) DoManageBalance(userID uint, tradeType model.TradeType, amount int64) (*PrePayInfo, error) {
+// DoManageBalance 余额充值及提现，channel 为支付渠道，account、name 为支付宝提现的收款账号及姓名
+func (c *Ctl) DoManageBalance(userID uint, tradeType model.TradeType, channel model.PayChannel, amount int64,
+	account, name string) (*PrePayInfo, error) {
+
+	// 未指定渠道时默认微信支付
+	if len(channel) == 0 {
+		channel = model.ChannelWechat
+	}
 
 	var err error
 	var prepayInfo *PrePayInfo
+	var withdraw *model.TWithdrawRequest
 	tx := c.db.Begin()
 	defer func() {
 		if err != nil {
@@ -27,9 +36,9 @@ func (c *Ctl) DoManageBalance(userID uint, tradeType model.TradeType, amount int
 	tradeID := utils.GenerateUUID()
 	switch tradeType {
 	case model.TypeRecharge:
-		prepayInfo, err = c.RechargeBalance(tx, userID, amount, tradeID)
+		prepayInfo, err = c.RechargeBalance(tx, userID, channel, amount, tradeID)
 	case model.TypeWithdraw:
-		err = c.WithdrawBalance(tx, userID, amount, tradeID)
+		withdraw, err = c.WithdrawBalance(tx, userID, channel, amount, tradeID, account, name)
 	default:
 		err = fmt.Errorf("unsupport trade type: %v", model.TradeTypeCN[tradeType])
 	}
@@ -43,253 +52,210 @@ func (c *Ctl) DoManageBalance(userID uint, tradeType model.TradeType, amount int
 		return nil, err
 	}
 
+	if withdraw != nil {
+		c.submitWithdraw(withdraw)
+	}
+
 	return prepayInfo, nil
 }
 
-// RechargeBalance 余额充值：用户发起充值后并不会立即增加余额，当微信预支付回调触发后，再增加用户余额。
-func (c *Ctl) RechargeBalance(tx *gorm.DB, userID uint, amount int64, tradeID string) (*PrePayInfo, error) {
+// RechargeBalance 余额充值：用户发起充值后并不会立即增加余额，当支付渠道回调触发后，再增加用户余额。
+func (c *Ctl) RechargeBalance(tx *gorm.DB, userID uint, channel model.PayChannel, amount int64, tradeID string) (*PrePayInfo, error) {
+
+	pay, err := c.provider(channel)
+	if err != nil {
+		return nil, err
+	}
 
 	user, err := c.GetUser(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.wx.CreateWechatPrePayOrder(user.OpenID, tradeID, fmt.Sprintf("余额充值-%d元", amount/100), amount)
+	resp, err := pay.Prepay(&payment.PrepayRequest{
+		OpenID:  user.OpenID,
+		TradeID: tradeID,
+		Desc:    fmt.Sprintf("余额充值-%d元", amount/100),
+		Amount:  amount,
+	})
 	if err != nil {
 		c.Errorf("create prepay order failed, err=%s", err.Error())
 		return nil, err
 	}
 
-	// 创建微信支付记录
-	err = c.trade.AddWxPayRecord(tx, user.OpenID, amount, tradeID, *resp.PrepayId)
+	// 创建预支付记录
+	err = c.trade.AddPayRecord(tx, channel, user, amount, tradeID, resp.PrepayID)
 	if err != nil {
 		return nil, err
 	}
 
 	// 添加交易记录及余额状态
 	balance := user.Balance + amount
-	err = c.trade.AddTradeRecord(tx, userID, model.TypeRecharging, amount, balance, tradeID)
+	err = c.trade.AddTradeRecord(tx, userID, model.TypeRecharging, amount, balance, tradeID, "", channel)
 	if err != nil {
 		return nil, err
 	}
 
 	return &PrePayInfo{
-		PrepayID:  *resp.PrepayId,
-		NonceStr:  *resp.NonceStr,
-		Package:   *resp.Package,
-		SignType:  *resp.SignType,
-		PaySign:   *resp.PaySign,
-		Timestamp: *resp.TimeStamp,
+		PrepayID:  resp.PrepayID,
+		NonceStr:  resp.NonceStr,
+		Package:   resp.Package,
+		SignType:  resp.SignType,
+		PaySign:   resp.PaySign,
+		Timestamp: resp.Timestamp,
+		PayURL:    resp.PayURL,
 	}, nil
 }
 
-// WithdrawBalance 余额提现：发起提现后用户余额立即减少，并交由微信支付转账至用户零钱，若转账失败，则退回该部分余额
-func (c *Ctl) WithdrawBalance(tx *gorm.DB, userID uint, amount int64, tradeID string) error {
+// WithdrawBalance 余额提现申请：申请后用户余额立即转入提现冻结，符合自动审批条件的直接发起转账，
+// 否则等待管理员审批；审批驳回或转账失败时退回该部分余额
+func (c *Ctl) WithdrawBalance(tx *gorm.DB, userID uint, channel model.PayChannel, amount int64, tradeID, account,
+	name string) (*model.TWithdrawRequest, error) {
 
-	user, err := c.GetUser(userID)
+	_, err := c.provider(channel)
 	if err != nil {
-		return err
-	}
-
-	if amount > 20000 {
-		return fmt.Errorf("最大提现 200")
-	}
-
-	// 提现金额需要大于账户余额
-	if amount > user.Balance {
-		return fmt.Errorf("超出账户余额")
+		return nil, err
 	}
 
-	// 如果是接单员，有最小余额限制
-	if user.Role == model.RoleWorker {
-		if (user.Balance - amount) < c.minBalance {
-			return fmt.Errorf("需保持余额")
-		}
+	// 支付宝提现需指定收款账号及姓名
+	if channel == model.ChannelAlipay && (len(account) == 0 || len(name) == 0) {
+		return nil, fmt.Errorf("收款账号及姓名不能为空")
 	}
 
-	resp, err := c.wx.TransferToWorker(user.OpenID, tradeID, fmt.Sprintf("余额提现-%d", amount), amount)
+	user, err := c.lockWallet(tx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 添加微信预转账交易记录
-	err = c.trade.AddWxTransferRecord(tx, user.OpenID, amount, tradeID, *resp.BatchId)
-	if err != nil {
-		return err
+	if user.Frozen {
+		return nil, ErrUserFrozen
 	}
 
-	// 更新账户余额
-	balance := user.Balance - amount
-
-	// 添加交易记录
-	err = c.trade.AddTradeRecord(tx, userID, model.TypeWithdrawing, amount, balance, tradeID)
-	if err != nil {
-		return err
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount: %d", amount)
 	}
 
-	return tx.Model(model.TUser{}).Where("id = ?", userID).Update("balance", balance).Error
-}
-
-// PayForPublishOrder 订单支付
-func (c *Ctl) PayForPublishOrder(tx *gorm.DB, userID uint, amount int64, orderID string) error {
-
-	user, err := c.GetUser(userID)
-	if err != nil {
-		return err
+	if amount > c.withdraw.MaxAmount {
+		return nil, fmt.Errorf("最大提现 %d", c.withdraw.MaxAmount/100)
 	}
 
-	// 支付金额需要小于账户余额
+	// 提现金额需要大于账户余额
 	if amount > user.Balance {
-		return fmt.Errorf("账户余额不足")
+		return nil, fmt.Errorf("超出账户余额")
 	}
 
-	// 更新账户余额
-	balance := user.Balance - amount
-
-	// 添加交易记录
-	err = c.trade.AddTradeRecord(tx, userID, model.TypePublishOrder, amount, balance, orderID)
-	if err != nil {
-		return err
+	// 如果是接单员，有最小余额限制
+	if user.Role == model.RoleWorker {
+		if (user.Balance - amount) < c.withdraw.WorkerMinBalance {
+			return nil, fmt.Errorf("需保持余额")
+		}
 	}
 
-	return tx.Model(model.TUser{}).Where("id = ?", userID).Update("balance", balance).Error
-}
-
-// ReturnUnCompleteOrder 退费未完成子订单：若订单截止时，子订单有未接受的，则完成该部分订单退费。
-// 若子订单已接受未完成，超时候则由子订单检查逻辑完成退费。
-func (c *Ctl) ReturnUnCompleteOrder(tx *gorm.DB, userID uint, amount int64, orderID string) error {
-
-	user, err := c.GetUser(userID)
+	reason, err := c.checkWithdrawLimits(tx, user, amount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 更新账户余额
-	balance := user.Balance + amount
-
-	// 添加退款交易记录
-	err = c.trade.AddTradeRecord(tx, userID, model.TypeReturnOrder, amount, balance, orderID)
+	r := &model.TWithdrawRequest{
+		TradeID:      tradeID,
+		UserID:       userID,
+		Channel:      channel,
+		Amount:       amount,
+		Account:      account,
+		Name:         name,
+		State:        model.WithdrawStatePending,
+		ReviewReason: reason,
+	}
+	err = c.trade.AddWithdrawRequest(tx, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return tx.Model(model.TUser{}).Where("id = ?", userID).Update("balance", balance).Error
-}
-
-// RewardForOrder 订单完成奖励
-func (c *Ctl) RewardForOrder(tx *gorm.DB, userID uint, amount int64, orderID string) error {
-
-	user, err := c.GetUser(userID)
+	// 记账：用户钱包 -> 提现冻结，同时生成提现中交易记录
+	err = c.changeBalance(tx, user, -amount, &trade.Entry{
+		TradeID: tradeID,
+		Type:    model.TypeWithdrawing,
+		Channel: channel,
+		Lines:   []trade.Line{trade.Wallet(userID, -amount), trade.WithdrawHold(amount)},
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 更新账户余额
-	balance := user.Balance + amount
+	// 需人工审批
+	if len(reason) != 0 {
+		return r, nil
+	}
 
-	// 添加交易记录
-	err = c.trade.AddTradeRecord(tx, userID, model.TypeCompleteOrder, amount, balance, orderID)
+	err = c.transferWithdraw(tx, user, r, 0, "自动审批")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return tx.Model(model.TUser{}).Where("id = ?", userID).Update("balance", balance).Error
+	return r, nil
 }
 
-// PrepayCallback 预充值回调：调用微信 预支付后，交易成功则微信会回调改接口，并完成用于余额增加
-func (c *Ctl) PrepayCallback(req *http.Request) error {
+// PayForPublishOrder 订单支付：支付金额转入该订单的托管账户
+func (c *Ctl) PayForPublishOrder(tx *gorm.DB, order *model.TMasterOrder, amount int64) error {
 
-	var err error
-	tx := c.db.Begin()
-	defer func() {
-		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
-			}
-		}
-	}()
-
-	transaction, err := c.wx.PrepayCallback(req)
+	userID := order.UserID
+	user, err := c.lockWallet(tx, userID)
 	if err != nil {
 		return err
 	}
 
-	user, err := c.GetUserByOpenID(*transaction.Payer.Openid)
-	if err != nil {
-		return err
-	}
+	// 记账：用户钱包 -> 订单托管，支付金额超出账户余额时失败
+	return c.changeBalance(tx, user, -amount, &trade.Entry{
+		TradeID: order.UUID,
+		Type:    model.TypePublishOrder,
+		Lines:   []trade.Line{trade.Wallet(userID, -amount), trade.Escrow(order.ID, amount)},
+	})
+}
 
-	err = c.trade.UpdateWxPayRecordState(tx, *transaction.OutTradeNo, *transaction.TradeState)
-	if err != nil {
-		return err
-	}
+// ReturnUnCompleteOrder 退费未完成子订单：若订单截止时，子订单有未接受的，则完成该部分订单退费。
+// 若子订单已接受未完成，超时候则由子订单检查逻辑完成退费。退费金额从该订单的托管账户释放。
+func (c *Ctl) ReturnUnCompleteOrder(tx *gorm.DB, order *model.TMasterOrder, amount int64) error {
 
-	// 更新交易记录状态
-	err = c.trade.UpdateTradeRecordState(tx, *transaction.OutTradeNo, model.TypeRecharge)
-	if err != nil {
-		return err
+	if amount == 0 {
+		return nil
 	}
 
-	// 更新账户余额
-	balance := user.Balance + *transaction.Amount.Total
-	err = tx.Model(model.TUser{}).Where("id = ?", user.ID).Update("balance", balance).Error
+	userID := order.UserID
+	user, err := c.lockWallet(tx, userID)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit().Error
+	// 记账：订单托管 -> 用户钱包
+	return c.changeBalance(tx, user, amount, &trade.Entry{
+		TradeID: order.UUID,
+		Type:    model.TypeReturnOrder,
+		Lines:   []trade.Line{trade.Escrow(order.ID, -amount), trade.Wallet(userID, amount)},
+	})
 }
 
-// UpdateWithdrawState 更新提现记录，定时查询提现任务的状态，成功则更新转账记录状态
-func (c *Ctl) UpdateWithdrawState(tradeID, batchStatus, detailStatus string, user *model.TUser, amount int64) error {
+// RewardForOrder 订单完成奖励：从订单托管中结算一个任务单价，奖励部分支付给接单员，其余部分计入平台收入
+func (c *Ctl) RewardForOrder(tx *gorm.DB, order *model.TMasterOrder, subOrder *model.TSubOrder) error {
 
-	var err error
-	tx := c.db.Begin()
-	defer func() {
-		if err != nil {
-			rErr := tx.Rollback().Error
-			if rErr != nil {
-				c.Errorf("tx rollback failed, err=%v", rErr)
-			}
-		}
-	}()
+	amount, unitPrice := order.Reward, order.UnitPrice
+	if amount > unitPrice {
+		return fmt.Errorf("reward %d exceeds unit price %d", amount, unitPrice)
+	}
 
-	// 更新批量转账记录状态
-	err = c.trade.UpdateWxTransferRecordState(c.db, tradeID, batchStatus)
+	userID := subOrder.UserID
+	user, err := c.lockWallet(tx, userID)
 	if err != nil {
 		return err
 	}
 
-	// 成功的话，添加转账记录
-	if detailStatus == "SUCCESS" {
-		err = c.trade.UpdateTradeRecordState(tx, tradeID, model.TypeWithdraw)
-		if err != nil {
-			return err
-		}
-	}
-
-	// 转账失败，则退回用户余额
-	if detailStatus == "FAIL" {
-		// 更新交易记录状态
-		err = c.trade.UpdateTradeRecordState(tx, tradeID, model.TypeWithdrawFail)
-		if err != nil {
-			return err
-		}
-
-		// 更新交易记录余额
-		err = c.trade.UpdateTradeRecordBalance(tx, tradeID, user.Balance+amount)
-		if err != nil {
-			return err
-		}
-
-		// 更新账户余额
-		err = tx.Model(model.TUser{}).Where("id = ?", user.ID).
-			Update("balance = ?", user.Balance+amount).Error
-		if err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit().Error
+	// 记账：订单托管 -> 用户钱包 + 平台收入
+	return c.changeBalance(tx, user, amount, &trade.Entry{
+		TradeID: subOrder.UUID,
+		Type:    model.TypeCompleteOrder,
+		Lines: []trade.Line{
+			trade.Escrow(order.ID, -unitPrice),
+			trade.Wallet(userID, amount),
+			trade.Revenue(unitPrice - amount),
+		},
+	})
 }
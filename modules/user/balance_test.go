@@ -0,0 +1,105 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+)
+
+// TestManageBalanceDefaultChannel 未指定渠道时充值及提现均按微信支付处理
+func TestManageBalanceDefaultChannel(t *testing.T) {
+
+	c := newTestCtl(t, newFake(payment.DetailStateSuccess))
+	u := newTestUser(t, c)
+
+	info, err := c.DoManageBalance(u.ID, model.TypeRecharge, "", testRecharge, "", "")
+	if err != nil {
+		t.Fatalf("recharge failed, err=%s", err)
+	}
+
+	if info == nil || len(info.PrepayID) == 0 {
+		t.Fatalf("recharge prepay info=%v", info)
+	}
+
+	var count int64
+	err = c.db.Model(model.TWxPayRecord{}).Where("prepay_id = ?", info.PrepayID).Count(&count).Error
+	if err != nil || count != 1 {
+		t.Fatalf("wechat pay records=%d, err=%v", count, err)
+	}
+
+	recharging := &model.TTradeRecord{}
+	err = c.db.Where("user_id = ? AND type = ?", u.ID, model.TypeRecharging).First(recharging).Error
+	if err != nil {
+		t.Fatalf("get recharging record failed, err=%s", err)
+	}
+
+	if recharging.Channel != model.ChannelWechat {
+		t.Errorf("recharging channel=%q, want %s", recharging.Channel, model.ChannelWechat)
+	}
+
+	_, err = c.DoManageBalance(u.ID, model.TypeWithdraw, "", testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw by manage balance failed, err=%s", err)
+	}
+
+	r, err := c.Withdraw(u.ID, "", testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	if r.Channel != model.ChannelWechat {
+		t.Errorf("withdraw channel=%q, want %s", r.Channel, model.ChannelWechat)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateACCEPTED {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateACCEPTED)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-2*testWithdraw, 0, -testRecharge+2*testWithdraw)
+}
+
+// TestGetTradesOffset 未指定游标时按 offset 分页，返回的游标可继续查询下一页
+func TestGetTradesOffset(t *testing.T) {
+
+	c := newTestCtl(t, newFake(payment.DetailStateSuccess))
+	u := newTestUser(t, c)
+	for i := 0; i < 2; i++ {
+		recharge(t, c, u, testRecharge)
+	}
+
+	filter := &repo.TradeFilter{UserID: u.ID}
+	all, _, err := c.trade.GetTrades(filter, &model.Page{Limit: 100, Sort: repo.SortCreatedAt, Order: repo.OrderDesc})
+	if err != nil {
+		t.Fatalf("get trades failed, err=%s", err)
+	}
+
+	if len(all) < 3 {
+		t.Fatalf("trades=%d, want at least 3", len(all))
+	}
+
+	page := &model.Page{Offset: 1, Limit: 1, Sort: repo.SortCreatedAt, Order: repo.OrderDesc}
+	trades, info, err := c.trade.GetTrades(filter, page)
+	if err != nil {
+		t.Fatalf("get trades by offset failed, err=%s", err)
+	}
+
+	if len(trades) != 1 || trades[0].ID != all[1].ID {
+		t.Fatalf("offset trades=%v, want %d", trades, all[1].ID)
+	}
+
+	if info.Total != int64(len(all)) || len(info.NextCursor) == 0 {
+		t.Fatalf("offset page info=%+v, want total %d with next cursor", info, len(all))
+	}
+
+	next := &model.Page{Cursor: info.NextCursor, Offset: 1, Limit: 1, Sort: repo.SortCreatedAt, Order: repo.OrderDesc}
+	trades, _, err = c.trade.GetTrades(filter, next)
+	if err != nil {
+		t.Fatalf("get trades by cursor failed, err=%s", err)
+	}
+
+	if len(trades) != 1 || trades[0].ID != all[2].ID {
+		t.Fatalf("cursor trades=%v, want %d", trades, all[2].ID)
+	}
+}
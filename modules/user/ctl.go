@@ -1,8 +1,11 @@
 package user
 
 import (
+	"fmt"
 	"git.ucloudadmin.com/unetworks/app/pkg/log"
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 	"github.com/mojiQAQ/dispatch/modules/trade"
 	"github.com/mojiQAQ/dispatch/modules/wechat"
 	"gorm.io/gorm"
@@ -12,81 +15,88 @@ import (
 type (
 	Ctl struct {
 		*log.Logger
-		db *gorm.DB
+		db    *gorm.DB
+		store repo.Store
 
-		minBalance int64
+		withdraw model.WithdrawConf
+
+		secret        []byte
+		tokenExpire   time.Duration
+		refreshExpire time.Duration
 
 		wx    *wechat.Ctl
+		pays  map[model.PayChannel]payment.Provider
 		trade *trade.Ctl
 	}
 )
 
-func NewCtl(logger *log.Logger, db *gorm.DB, t *trade.Ctl, w *wechat.Ctl) *Ctl {
+func NewCtl(logger *log.Logger, store repo.Store, t *trade.Ctl, w *wechat.Ctl, pays map[model.PayChannel]payment.Provider,
+	cfg model.Session, withdraw model.WithdrawConf) *Ctl {
+
+	expire, refreshExpire := cfg.Expire, cfg.RefreshExpire
+	if expire == 0 {
+		expire = defaultTokenExpire
+	}
+
+	if refreshExpire == 0 {
+		refreshExpire = defaultRefreshExpire
+	}
+
+	if withdraw.MaxAmount == 0 {
+		withdraw.MaxAmount = defaultMaxWithdraw
+	}
+
+	if withdraw.WorkerMinBalance == 0 {
+		withdraw.WorkerMinBalance = defaultWorkerMinBalance
+	}
+
 	return &Ctl{
 		Logger: logger,
-		db:     db,
+		db:     store.DB(),
+		store:  store,
 
-		minBalance: 2000,
-		wx:         w,
-		trade:      t,
+		withdraw: withdraw,
+
+		secret:        []byte(cfg.Secret),
+		tokenExpire:   time.Minute * time.Duration(expire),
+		refreshExpire: time.Hour * time.Duration(refreshExpire),
+
+		wx:    w,
+		pays:  pays,
+		trade: t,
 	}
 }
 
+// Start 定时查询未完成的提现转账，重新发起超时未结束的退款
 func (c *Ctl) Start() {
 
-	ticker := time.NewTicker(time.Minute * 5)
+	ticker := time.NewTicker(transferPollInterval)
 
 	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.Debugf("time to check order")
-				// 检查超时未完成订单
-				go c.autoCheckTransferBatchResult()
-			}
+		for range ticker.C {
+			c.checkTransfers()
+			c.checkRefunds()
 		}
 	}()
 }
 
-func (c *Ctl) autoCheckTransferBatchResult() {
-
-	err := c.checkTransferBatchResult()
-	if err != nil {
-		c.Errorf("check wechat uncomplete transfer order failed, err=%s", err.Error())
-		return
-	}
-}
+// provider 查询支付渠道
+func (c *Ctl) provider(channel model.PayChannel) (payment.Provider, error) {
 
-// checkTransferBatchResult 检查转账记录
-func (c *Ctl) checkTransferBatchResult() error {
-	records, err := c.trade.GetWxTransferRecord(model.WxTransferStateACCEPTED, model.WxTransferStatePROCESSING)
-	if err != nil {
-		return err
+	pay, ok := c.pays[channel]
+	if !ok {
+		return nil, fmt.Errorf("unsupported pay channel: %s", channel)
 	}
 
-	for _, r := range records {
-		// 查询提现未完成的订单
-		info, err := c.wx.CheckUnCompleteTransferOrder(r.TradeID)
-		if err != nil {
-			c.Errorf("check uncomplete transfer record failed, err=%s", err.Error())
-			continue
-		}
+	return pay, nil
+}
 
-		// 获取用户信息
-		user, err := c.GetUserByOpenID(r.OpenID)
-		if err != nil {
-			c.Errorf("get user failed, err=%s", err.Error())
-			continue
-		}
+// getTransferUser 查询转账记录对应的用户，微信记录以 openid 关联，支付宝记录以用户 ID 关联
+func (c *Ctl) getTransferUser(r *trade.TransferRecord) (*model.TUser, error) {
 
-		// 更新提现记录
-		err = c.UpdateWithdrawState(r.TradeID, *info.TransferBatch.BatchStatus,
-			*info.TransferDetailList[0].DetailStatus, user, *info.TransferBatch.TotalAmount)
-		if err != nil {
-			c.Errorf("update withdraw trade record failed, err=%s", err.Error())
-			continue
-		}
+	if r.Channel == model.ChannelWechat {
+		return c.GetUserByOpenID(r.OpenID)
 	}
 
-	return err
+	return c.GetUser(r.UserID)
 }
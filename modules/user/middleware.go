@@ -0,0 +1,61 @@
+package user
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+const ctxUserKey = "dispatch.user"
+
+// Authenticate 会话鉴权中间件：校验请求头 Authorization 中的访问令牌，并将当前用户写入请求上下文
+func (c *Ctl) Authenticate() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		token := strings.TrimSpace(strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer"))
+		if len(token) == 0 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, &model.RespBase{Message: ErrInvalidToken.Error()})
+			return
+		}
+
+		claims, err := c.parseToken(token, TokenTypeAccess)
+		if err != nil {
+			c.Errorf("parsing token failed, err=%s", err.Error())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, &model.RespBase{Message: err.Error()})
+			return
+		}
+
+		user, err := c.GetUser(claims.UserID)
+		if err != nil {
+			c.Errorf("get token user failed, err=%s", err.Error())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, &model.RespBase{Message: ErrInvalidToken.Error()})
+			return
+		}
+
+		// 冻结前签发的令牌同样不可再使用
+		if user.Frozen {
+			c.Errorf("user %d is frozen", user.ID)
+			Deny(ctx, ErrUserFrozen)
+			return
+		}
+
+		ctx.Set(ctxUserKey, user)
+		ctx.Next()
+	}
+}
+
+// CurrentUser 获取鉴权中间件写入的当前用户
+func (c *Ctl) CurrentUser(ctx *gin.Context) *model.TUser {
+
+	v, exist := ctx.Get(ctxUserKey)
+	if !exist {
+		return nil
+	}
+
+	user, _ := v.(*model.TUser)
+	return user
+}
@@ -0,0 +1,195 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+// PrepayCallback 预充值回调：调用预支付后，交易状态变化时支付渠道会回调该接口。
+// 原始通知先入收件箱存档，再按商户交易 ID 幂等入账，重复通知不会重复增加余额
+func (c *Ctl) PrepayCallback(channel model.PayChannel, req *http.Request) error {
+
+	pay, err := c.provider(channel)
+	if err != nil {
+		return err
+	}
+
+	pn, err := pay.ParseNotify(req)
+	if err != nil {
+		return err
+	}
+
+	n := &model.TPayNotification{
+		NotifyID:      pn.NotifyID,
+		EventType:     pn.EventType,
+		OutTradeNo:    pn.OutTradeNo,
+		TransactionID: pn.TransactionID,
+		TradeState:    pn.TradeState,
+		Payer:         pn.PayerOpenID,
+		Amount:        pn.Amount,
+		Payload:       pn.Payload,
+		Channel:       channel,
+	}
+
+	err = c.trade.AddPayNotification(n)
+	if err != nil {
+		return err
+	}
+
+	return c.handlePayNotification(n, pn)
+}
+
+// ReplayPayNotification 重放收件箱中的支付通知，用于处理失败后的人工补单
+func (c *Ctl) ReplayPayNotification(id uint) (*model.TPayNotification, error) {
+
+	n, err := c.trade.GetPayNotification(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 已入账的通知无需重放
+	if n.State == model.NotifyStateProcessed {
+		return n, nil
+	}
+
+	err = c.handlePayNotification(n, &payment.Notification{
+		NotifyID:      n.NotifyID,
+		EventType:     n.EventType,
+		OutTradeNo:    n.OutTradeNo,
+		TransactionID: n.TransactionID,
+		TradeState:    n.TradeState,
+		PayerOpenID:   n.Payer,
+		Amount:        n.Amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.trade.GetPayNotification(id)
+}
+
+// handlePayNotification 处理支付通知并记录处理结果
+func (c *Ctl) handlePayNotification(n *model.TPayNotification, pn *payment.Notification) error {
+
+	channel := n.Channel
+	if len(channel) == 0 {
+		channel = model.ChannelWechat
+	}
+
+	state, result, err := c.settlePayment(channel, pn)
+	if err != nil {
+		state, result = model.NotifyStateFailed, err.Error()
+	}
+
+	uErr := c.trade.UpdatePayNotification(n.ID, state, result)
+	if uErr != nil {
+		c.Errorf("update pay notification %d failed, err=%s", n.ID, uErr.Error())
+	}
+
+	return err
+}
+
+// settlePayment 支付通知入账：仅成功状态入账，以预支付记录校验金额及付款人，已成功的交易不再重复入账
+func (c *Ctl) settlePayment(channel model.PayChannel, pn *payment.Notification) (model.NotifyState, string, error) {
+
+	tradeID, transactionID, tradeState, amount := pn.OutTradeNo, pn.TransactionID, pn.TradeState, pn.Amount
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	record, err := c.trade.LockPayRecord(tx, channel, tradeID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// 已入账的交易：同一微信交易的重复通知直接忽略
+	if record.State == model.WxPayStateSUCCESS {
+		if record.TransactionID != transactionID {
+			err = fmt.Errorf("trade %s already paid by transaction %s", tradeID, record.TransactionID)
+			return 0, "", err
+		}
+
+		err = tx.Commit().Error
+		return model.NotifyStateIgnored, "重复通知", err
+	}
+
+	// 非成功状态仅更新预支付记录状态，支付失败的同时更新充值交易记录
+	if tradeState != model.WxPayStateSUCCESS {
+		err = c.trade.UpdatePayRecordState(tx, channel, tradeID, tradeState)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if tradeState == model.WxPayStateCLOSED || tradeState == model.WxPayStatePAYERROR ||
+			tradeState == model.WxPayStateREVOKED {
+			err = c.trade.UpdateTradeRecordState(tx, tradeID, model.TypeRechargeFail)
+			if err != nil {
+				return 0, "", err
+			}
+		}
+
+		err = tx.Commit().Error
+		return model.NotifyStateIgnored, fmt.Sprintf("交易状态 %s", tradeState), err
+	}
+
+	if amount != record.Amount {
+		err = fmt.Errorf("trade %s amount mismatch, prepay=%d, notify=%d", tradeID, record.Amount, amount)
+		return 0, "", err
+	}
+
+	if len(pn.PayerOpenID) != 0 && len(record.OpenID) != 0 && pn.PayerOpenID != record.OpenID {
+		err = fmt.Errorf("trade %s payer mismatch", tradeID)
+		return 0, "", err
+	}
+
+	// 微信记录以 openid 关联用户，支付宝记录以用户 ID 关联
+	userID := record.UserID
+	if userID == 0 {
+		var payer *model.TUser
+		payer, err = c.GetUserByOpenID(record.OpenID)
+		if err != nil {
+			return 0, "", err
+		}
+		userID = payer.ID
+	}
+
+	user, err := c.lockWallet(tx, userID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = c.trade.PayPayRecord(tx, channel, tradeID, transactionID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// 记账：渠道清算 -> 用户钱包，同时将充值中交易记录更新为充值成功
+	err = c.changeBalance(tx, user, amount, &trade.Entry{
+		TradeID: tradeID,
+		Type:    model.TypeRecharge,
+		Channel: channel,
+		Lines:   []trade.Line{trade.Clearing(channel, -amount), trade.Wallet(user.ID, amount)},
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return 0, "", err
+	}
+
+	return model.NotifyStateProcessed, "", nil
+}
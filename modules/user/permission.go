@@ -0,0 +1,124 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type Action string
+
+const (
+	ActionGetUsers        Action = "users:list"
+	ActionCreateUser      Action = "users:create"
+	ActionGetUser         Action = "users:get"
+	ActionUpdateUser      Action = "users:update"
+	ActionGetTransactions Action = "users:transactions"
+	ActionRecharge        Action = "balance:recharge"
+	ActionWithdraw        Action = "balance:withdraw"
+	ActionRefund          Action = "balance:refund"
+	ActionGetTmpSecret    Action = "cos:secret"
+
+	ActionGetTrades Action = "trades:list"
+	ActionGetPrices Action = "prices:list"
+
+	ActionGetOrders        Action = "orders:list"
+	ActionGetAllOrders     Action = "orders:list_all"
+	ActionPublishOrder     Action = "orders:publish"
+	ActionGetOrder         Action = "orders:get"
+	ActionModifyOrder      Action = "orders:modify"
+	ActionPayOrder         Action = "orders:pay"
+	ActionCancelOrder      Action = "orders:cancel"
+	ActionAmendOrder       Action = "orders:amend"
+	ActionGetOrderTimeline Action = "orders:timeline"
+	ActionAcceptOrder      Action = "sub_orders:accept"
+	ActionGetUserSubOrders Action = "sub_orders:mine"
+	ActionGetSubOrders     Action = "sub_orders:list"
+	ActionGetSubOrder      Action = "sub_orders:get"
+	ActionSubmitSubOrder   Action = "sub_orders:submit"
+	ActionReviewSubOrder   Action = "sub_orders:review"
+	ActionClaimReview      Action = "sub_orders:claim"
+	ActionAppealSubOrder   Action = "sub_orders:appeal"
+
+	ActionAdmin Action = "admin"
+)
+
+var (
+	ErrForbidden = errors.New("permission denied")
+	ErrNotOwner  = errors.New("permission denied: not the owner")
+)
+
+var allRoles = []model.Role{model.RolePublisher, model.RoleWorker, model.RoleAuditor, model.RoleAdministrator}
+
+// permissions 接口权限矩阵：操作 -> 允许的用户角色，未声明的操作一律拒绝
+var permissions = map[Action][]model.Role{
+	ActionGetUsers:        {model.RoleAdministrator},
+	ActionCreateUser:      {model.RoleAdministrator},
+	ActionGetUser:         allRoles,
+	ActionUpdateUser:      allRoles,
+	ActionGetTransactions: allRoles,
+	ActionRecharge:        {model.RolePublisher},
+	ActionWithdraw:        {model.RolePublisher, model.RoleWorker},
+	ActionRefund:          {model.RolePublisher},
+	ActionGetTmpSecret:    allRoles,
+
+	ActionGetTrades: {model.RoleAdministrator},
+	ActionGetPrices: allRoles,
+
+	ActionGetOrders:        {model.RolePublisher, model.RoleAuditor, model.RoleAdministrator},
+	ActionGetAllOrders:     {model.RoleWorker, model.RoleAdministrator},
+	ActionPublishOrder:     {model.RolePublisher},
+	ActionGetOrder:         allRoles,
+	ActionModifyOrder:      {model.RolePublisher},
+	ActionPayOrder:         {model.RolePublisher},
+	ActionCancelOrder:      {model.RolePublisher},
+	ActionAmendOrder:       {model.RolePublisher},
+	ActionGetOrderTimeline: {model.RolePublisher, model.RoleAuditor, model.RoleAdministrator},
+	ActionAcceptOrder:      {model.RoleWorker},
+	ActionGetUserSubOrders: {model.RoleWorker},
+	ActionGetSubOrders:     {model.RolePublisher, model.RoleAuditor, model.RoleAdministrator},
+	ActionGetSubOrder:      allRoles,
+	ActionSubmitSubOrder:   {model.RoleWorker},
+	ActionReviewSubOrder:   {model.RolePublisher, model.RoleAuditor},
+	ActionClaimReview:      {model.RoleAuditor},
+	ActionAppealSubOrder:   {model.RoleWorker},
+
+	ActionAdmin: {model.RoleAdministrator},
+}
+
+// Allowed 判断角色是否有权执行该操作
+func Allowed(role model.Role, action Action) bool {
+
+	for _, r := range permissions[action] {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Deny 统一的 403 拒绝响应
+func Deny(ctx *gin.Context, err error) {
+
+	ctx.AbortWithStatusJSON(http.StatusForbidden, &model.RespBase{Message: err.Error()})
+}
+
+// Permit 权限中间件：当前用户角色不在权限矩阵中时拒绝请求，需挂载在 Authenticate 之后
+func (c *Ctl) Permit(action Action) gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		user := c.CurrentUser(ctx)
+		if user == nil || !Allowed(user.Role, action) {
+			c.Errorf("permission denied, action=%s", action)
+			Deny(ctx, ErrForbidden)
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,264 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+	"github.com/mojiQAQ/dispatch/modules/utils"
+)
+
+// refundRetryInterval 退款处理中超过该时长未收到结果时重新发起
+const refundRetryInterval = time.Minute * 10
+
+// RefundRecharge 充值原路退款：发起退款后用户余额立即减少，退款关闭时退回该部分余额。
+// amount 为 0 时退还该笔充值的全部可退金额。退款记录及扣款提交后再调用渠道，
+// 渠道调用失败时退款保持处理中，由退款通知或定时重试推进
+func (c *Ctl) RefundRecharge(userID uint, tradeID string, amount int64, reason string) (*model.TRefundRecord, error) {
+
+	recharge, err := c.trade.GetRechargeRecord(userID, tradeID)
+	if err != nil {
+		return nil, fmt.Errorf("充值记录不存在")
+	}
+
+	channel := recharge.Channel
+	if len(channel) == 0 {
+		channel = model.ChannelWechat
+	}
+
+	_, err = c.provider(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	// 锁定预支付记录，同一笔充值的退款串行处理
+	payRecord, err := c.trade.LockPayRecord(tx, channel, tradeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if payRecord.State != model.WxPayStateSUCCESS {
+		err = fmt.Errorf("充值未成功，不可退款")
+		return nil, err
+	}
+
+	refunded, err := c.trade.GetRefundedAmount(tx, tradeID)
+	if err != nil {
+		return nil, err
+	}
+
+	refundable := payRecord.Amount - refunded
+	if amount == 0 {
+		amount = refundable
+	}
+
+	if amount <= 0 || amount > refundable {
+		err = fmt.Errorf("超出可退款金额，可退 %d", refundable)
+		return nil, err
+	}
+
+	user, err := c.lockWallet(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Frozen {
+		err = ErrUserFrozen
+		return nil, err
+	}
+
+	record := &model.TRefundRecord{
+		RefundID: utils.GenerateUUID(),
+		TradeID:  tradeID,
+		UserID:   userID,
+		Channel:  channel,
+		Amount:   amount,
+		Total:    payRecord.Amount,
+		Reason:   reason,
+		State:    payment.RefundStateProcessing,
+	}
+	err = c.trade.AddRefundRecord(tx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记账：用户钱包 -> 渠道清算，同时生成退款中交易记录，超出账户余额时失败
+	err = c.changeBalance(tx, user, -amount, &trade.Entry{
+		TradeID: record.RefundID,
+		Type:    model.TypeRefunding,
+		Remark:  reason,
+		Channel: channel,
+		Lines:   []trade.Line{trade.Wallet(userID, -amount), trade.Clearing(channel, amount)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	sErr := c.submitRefund(record)
+	if sErr != nil {
+		c.Errorf("submit refund %s failed, err=%s", record.RefundID, sErr.Error())
+	}
+
+	return record, nil
+}
+
+// checkRefunds 重新发起超时未结束的退款。渠道按退款单号幂等，重复发起返回已受理退款的状态
+func (c *Ctl) checkRefunds() {
+
+	records, err := c.trade.GetProcessingRefundRecords(time.Now().Add(-refundRetryInterval))
+	if err != nil {
+		c.Errorf("get processing refund records failed, err=%s", err.Error())
+		return
+	}
+
+	for _, r := range records {
+		err = c.submitRefund(r)
+		if err != nil {
+			c.Errorf("submit refund %s failed, err=%s", r.RefundID, err.Error())
+		}
+	}
+}
+
+// submitRefund 以退款单号向渠道发起退款，渠道同步返回结束状态时更新退款结果。
+// 调用方不可持有事务，渠道调用失败时退款保持处理中
+func (c *Ctl) submitRefund(record *model.TRefundRecord) error {
+
+	pay, err := c.provider(record.Channel)
+	if err != nil {
+		return err
+	}
+
+	result, err := pay.Refund(&payment.RefundRequest{
+		TradeID:  record.TradeID,
+		RefundID: record.RefundID,
+		Reason:   record.Reason,
+		Amount:   record.Amount,
+		Total:    record.Total,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 支付宝等渠道同步返回退款结果
+	return c.settleRefund(record, result.Status, result.RefundID, 0)
+}
+
+// RefundCallback 退款结果通知：按退款单号幂等处理，已结束的退款不再重复处理
+func (c *Ctl) RefundCallback(channel model.PayChannel, req *http.Request) error {
+
+	pay, err := c.provider(channel)
+	if err != nil {
+		return err
+	}
+
+	n, err := pay.ParseRefundNotify(req)
+	if err != nil {
+		return err
+	}
+
+	return c.settleRefund(&model.TRefundRecord{RefundID: n.RefundID}, n.Status, n.ProviderRefundID, n.Amount)
+}
+
+// settleRefund 锁定退款记录并更新退款结果，已结束的退款不再重复处理，amount 非 0 时校验退款金额。
+// 更新后的状态同步回 record
+func (c *Ctl) settleRefund(record *model.TRefundRecord, state, providerRefundID string, amount int64) error {
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	locked, err := c.trade.LockRefundRecord(tx, record.RefundID)
+	if err != nil {
+		return err
+	}
+
+	if locked.State == payment.RefundStateSuccess || locked.State == payment.RefundStateClosed {
+		c.Infof("refund %s already finished, state=%s", locked.RefundID, locked.State)
+		*record = *locked
+		return tx.Commit().Error
+	}
+
+	if amount != 0 && amount != locked.Amount {
+		err = fmt.Errorf("refund %s amount mismatch, record=%d, notify=%d", locked.RefundID, locked.Amount, amount)
+		return err
+	}
+
+	err = c.finishRefund(tx, locked, state, providerRefundID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	*record = *locked
+	return nil
+}
+
+// finishRefund 更新退款结果：退款成功更新交易记录为已退款；退款关闭则退回用户余额；
+// 退款异常需在商户平台人工处理，仅记录状态
+func (c *Ctl) finishRefund(tx *gorm.DB, record *model.TRefundRecord, state, providerRefundID string) error {
+
+	err := c.trade.UpdateRefundRecord(tx, record.RefundID, state, providerRefundID)
+	if err != nil {
+		return err
+	}
+
+	record.State, record.ProviderRefundID = state, providerRefundID
+	switch state {
+	case payment.RefundStateSuccess:
+		return c.trade.UpdateTradeRecordState(tx, record.RefundID, model.TypeRefund)
+	case payment.RefundStateClosed:
+		err = c.trade.UpdateTradeRecordState(tx, record.RefundID, model.TypeRefundFail)
+		if err != nil {
+			return err
+		}
+
+		user, err := c.lockWallet(tx, record.UserID)
+		if err != nil {
+			return err
+		}
+
+		// 记账：渠道清算 -> 用户钱包，生成退款失败退回交易记录
+		return c.changeBalance(tx, user, record.Amount, &trade.Entry{
+			TradeID: record.RefundID,
+			Type:    model.TypeRefundFail,
+			Remark:  "退款失败退回",
+			Channel: record.Channel,
+			Lines:   []trade.Line{trade.Clearing(record.Channel, -record.Amount), trade.Wallet(record.UserID, record.Amount)},
+		})
+	case payment.RefundStateAbnormal:
+		c.Errorf("refund %s abnormal, need manual handling", record.RefundID)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+)
+
+// refundProvider 模拟渠道：前 failures 次发起退款失败，其余行为同 payment.Fake
+type refundProvider struct {
+	*payment.Fake
+	failures int
+	refunds  int
+}
+
+func (p *refundProvider) Refund(req *payment.RefundRequest) (*payment.RefundResult, error) {
+
+	p.refunds++
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("channel unavailable")
+	}
+
+	return p.Fake.Refund(req)
+}
+
+// recharge 通过微信充值 amount 并模拟支付成功通知入账，返回充值交易 ID
+func recharge(t *testing.T, c *Ctl, u *model.TUser, amount int64) string {
+
+	t.Helper()
+
+	info, err := c.DoManageBalance(u.ID, model.TypeRecharge, model.ChannelWechat, amount, "", "")
+	if err != nil {
+		t.Fatalf("recharge failed, err=%s", err)
+	}
+
+	tradeID := strings.TrimPrefix(info.PrepayID, "fake-prepay-")
+	_, _, err = c.settlePayment(model.ChannelWechat, &payment.Notification{
+		OutTradeNo:    tradeID,
+		TransactionID: "fake-" + tradeID,
+		TradeState:    model.WxPayStateSUCCESS,
+		PayerOpenID:   u.OpenID,
+		Amount:        amount,
+	})
+	if err != nil {
+		t.Fatalf("settle payment failed, err=%s", err)
+	}
+
+	return tradeID
+}
+
+func getRefund(t *testing.T, c *Ctl, refundID string) *model.TRefundRecord {
+
+	t.Helper()
+
+	r, err := c.trade.LockRefundRecord(c.db, refundID)
+	if err != nil {
+		t.Fatalf("get refund record failed, err=%s", err)
+	}
+
+	return r
+}
+
+// TestRefundRechargeRetry 渠道调用失败时退款保持处理中且已扣款，超时后以相同退款单号重新发起并结束
+func TestRefundRechargeRetry(t *testing.T) {
+
+	pay := &refundProvider{Fake: newFake(payment.DetailStateSuccess), failures: 1}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+	tradeID := recharge(t, c, u, testRecharge)
+
+	r, err := c.RefundRecharge(u.ID, tradeID, testWithdraw, "不想充了")
+	if err != nil {
+		t.Fatalf("refund recharge failed, err=%s", err)
+	}
+
+	if r.State != payment.RefundStateProcessing {
+		t.Fatalf("refund state=%s, want %s", r.State, payment.RefundStateProcessing)
+	}
+
+	// 扣款已提交，资金位于清算账户
+	assertBalances(t, c, u.ID, 2*testRecharge-testWithdraw, 0, -2*testRecharge+testWithdraw)
+	assertTradeTypes(t, c, r.RefundID, model.TypeRefunding)
+
+	// 未到重试时间不重新发起
+	c.checkRefunds()
+	if pay.refunds != 1 {
+		t.Fatalf("refunds=%d, want 1", pay.refunds)
+	}
+
+	err = c.db.Model(model.TRefundRecord{}).Where("refund_id = ?", r.RefundID).
+		UpdateColumn("updated_at", time.Now().Add(-refundRetryInterval-time.Second)).Error
+	if err != nil {
+		t.Fatalf("update refund record failed, err=%s", err)
+	}
+
+	c.checkRefunds()
+	if pay.refunds != 2 {
+		t.Fatalf("refunds=%d, want 2", pay.refunds)
+	}
+
+	if s := getRefund(t, c, r.RefundID).State; s != payment.RefundStateSuccess {
+		t.Fatalf("refund state=%s, want %s", s, payment.RefundStateSuccess)
+	}
+
+	assertBalances(t, c, u.ID, 2*testRecharge-testWithdraw, 0, -2*testRecharge+testWithdraw)
+	assertTradeTypes(t, c, r.RefundID, model.TypeRefund)
+
+	// 已结束的退款不再重新发起
+	records, err := c.trade.GetProcessingRefundRecords(time.Now().Add(time.Hour))
+	if err != nil || len(records) != 0 {
+		t.Errorf("processing refunds=%v, err=%v", records, err)
+	}
+}
+
+// TestRefundRechargeClosed 渠道同步返回退款关闭时退回用户余额
+func TestRefundRechargeClosed(t *testing.T) {
+
+	c := newTestCtl(t, payment.NewFake(&log.Logger{}, "", "", model.FakePay{RefundState: payment.RefundStateClosed}))
+	u := newTestUser(t, c)
+	tradeID := recharge(t, c, u, testRecharge)
+
+	r, err := c.RefundRecharge(u.ID, tradeID, 0, "不想充了")
+	if err != nil {
+		t.Fatalf("refund recharge failed, err=%s", err)
+	}
+
+	if r.State != payment.RefundStateClosed || r.Amount != testRecharge {
+		t.Fatalf("refund state=%s amount=%d, want %s %d", r.State, r.Amount, payment.RefundStateClosed, testRecharge)
+	}
+
+	assertBalances(t, c, u.ID, 2*testRecharge, 0, -2*testRecharge)
+	assertTradeTypes(t, c, r.RefundID, model.TypeRefundFail)
+}
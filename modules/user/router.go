@@ -1,6 +1,7 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -10,6 +11,7 @@ import (
 	"github.com/gin-gonic/gin/binding"
 
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 )
 
 type (
@@ -30,7 +32,10 @@ type (
 
 	ReqHandleBalance struct {
 		*model.ReqBase
-		Amount int64 `json:"amount"`
+		Amount  int64            `json:"amount"`
+		Channel model.PayChannel `json:"channel"` // 支付渠道，默认微信支付
+		Account string           `json:"account"` // 支付宝提现收款账号
+		Name    string           `json:"name"`    // 支付宝提现收款人姓名
 	}
 
 	PrePayInfo struct {
@@ -40,6 +45,7 @@ type (
 		PaySign   string `json:"pay_sign"`
 		Package   string `json:"package"`
 		Timestamp string `json:"timestamp"`
+		PayURL    string `json:"pay_url,omitempty"`
 	}
 
 	RespHandleBalanceRecharge struct {
@@ -49,6 +55,22 @@ type (
 
 	RespHandleBalanceWithdraw struct {
 		*model.RespBase
+		Withdraw *WithdrawRequest `json:"withdraw"`
+	}
+
+	ReqGetWithdraws struct {
+		*model.ReqBase
+	}
+
+	WithdrawRequest struct {
+		*model.TWithdrawRequest
+		StateCN   string `json:"state_cn"`
+		ChannelCN string `json:"channel_cn"`
+	}
+
+	RespGetWithdraws struct {
+		*model.RespBase
+		Withdraws []*WithdrawRequest `json:"withdraws"`
 	}
 
 	ReqGetUserInfo struct {
@@ -78,7 +100,8 @@ type (
 
 	RespGetUsers struct {
 		*model.RespBase
-		Users []*User `json:"users"`
+		Users []*User         `json:"users"`
+		Page  *model.PageInfo `json:"page"`
 	}
 
 	ReqLogin struct {
@@ -88,10 +111,22 @@ type (
 	RespLogin struct {
 		*model.RespBase
 		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpireAt     int64  `json:"expire_at"`
 		OpenID       string `json:"openid"`
 		IsRegistered bool   `json:"is_registered"`
 	}
 
+	ReqRefreshToken struct {
+		*model.ReqBase
+		RefreshToken string `json:"refresh_token" valid:"required"`
+	}
+
+	RespRefreshToken struct {
+		*model.RespBase
+		*Session
+	}
+
 	ReqRegister struct {
 		*model.ReqBase
 		PhoneCode string     `json:"phone_code" valid:"required"`
@@ -104,6 +139,7 @@ type (
 	RespRegister struct {
 		*model.RespBase
 		*model.User
+		*Session
 	}
 
 	Resource struct {
@@ -148,46 +184,96 @@ type (
 
 	Trade struct {
 		*model.TTradeRecord
-		TypeCN string `json:"type_cn"`
+		TypeCN    string `json:"type_cn"`
+		ChannelCN string `json:"channel_cn,omitempty"`
 	}
 
 	RespGetTrades struct {
 		*model.RespBase
-		Trades []*Trade `json:"trades"`
+		Trades []*Trade        `json:"trades"`
+		Page   *model.PageInfo `json:"page"`
+	}
+
+	ReqRefundRecharge struct {
+		*model.ReqBase
+		TradeID string `json:"trade_id" valid:"required"`
+		Amount  int64  `json:"amount"`
+		Reason  string `json:"reason"`
+	}
+
+	RespRefundRecharge struct {
+		*model.RespBase
+		Refund *model.TRefundRecord `json:"refund"`
+	}
+
+	ReqGetRefunds struct {
+		*model.ReqBase
+	}
+
+	Refund struct {
+		*model.TRefundRecord
+		ChannelCN string `json:"channel_cn"`
+	}
+
+	RespGetRefunds struct {
+		*model.RespBase
+		Refunds []*Refund `json:"refunds"`
 	}
 )
 
-func (c *Ctl) InitRouter(g *gin.RouterGroup) {
+// InitPublicRouter 注册无需登录的接口
+func (c *Ctl) InitPublicRouter(g *gin.RouterGroup) {
 
 	g.POST("/register", c.HandleRegister)
 
 	g.GET("/login", c.HandleLogin)
 
+	// 刷新会话令牌
+	g.POST("/refresh", c.HandleRefreshToken)
+
+	// 微信支付确认回调
+	g.POST("/wechat_prepay_callback", c.HandlePrepayCallback)
+
+	// 支付宝异步通知
+	g.POST("/alipay_notify", c.HandleAlipayNotify)
+
+	// 微信退款结果回调
+	g.POST("/wechat_refund_callback", c.HandleRefundCallback)
+}
+
+func (c *Ctl) InitRouter(g *gin.RouterGroup) {
+
 	// 查询所有用户
-	g.GET("/users", c.HandleGetUsers)
+	g.GET("/users", c.Permit(ActionGetUsers), c.HandleGetUsers)
 
 	// 注册用户
-	g.POST("/users", c.HandleRegisterUser)
+	g.POST("/users", c.Permit(ActionCreateUser), c.HandleRegisterUser)
 
 	// 用户详情
-	g.GET("/users/:openid", c.HandleGetUserInfo)
+	g.GET("/users/:openid", c.Permit(ActionGetUser), c.HandleGetUserInfo)
 
 	// 用户详情
-	g.PUT("/users/:openid", c.HandleUpdateUser)
+	g.PUT("/users/:openid", c.Permit(ActionUpdateUser), c.HandleUpdateUser)
 
 	// 交易记录
-	g.GET("/users/transactions", c.HandleGetTransactions)
+	g.GET("/users/transactions", c.Permit(ActionGetTransactions), c.HandleGetTransactions)
 
 	// 充值
-	g.POST("/users/balance/recharge", c.HandleBalanceRecharge)
+	g.POST("/users/balance/recharge", c.Permit(ActionRecharge), c.HandleBalanceRecharge)
 
 	// 提现
-	g.POST("/users/balance/withdraw", c.HandleBalanceWithdraw)
+	g.POST("/users/balance/withdraw", c.Permit(ActionWithdraw), c.HandleBalanceWithdraw)
 
-	// 微信支付确认回调
-	g.POST("/wechat_prepay_callback", c.HandlePrepayCallback)
+	// 提现申请记录
+	g.GET("/users/balance/withdraws", c.Permit(ActionWithdraw), c.HandleGetWithdraws)
+
+	// 充值退款
+	g.POST("/users/balance/refund", c.Permit(ActionRefund), c.HandleRefundRecharge)
+
+	// 退款记录
+	g.GET("/users/balance/refunds", c.Permit(ActionRefund), c.HandleGetRefunds)
 
-	g.GET("/tmpSecret", c.HandleGetTmpSecret)
+	g.GET("/tmpSecret", c.Permit(ActionGetTmpSecret), c.HandleGetTmpSecret)
 }
 
 func (c *Ctl) HandleRegister(ctx *gin.Context) {
@@ -207,7 +293,13 @@ func (c *Ctl) HandleRegister(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.Register(req.Name, req.Avatar, req.PhoneCode, req.UserCode, req.Role)
+	user, session, err := c.Register(req.Name, req.Avatar, req.PhoneCode, req.UserCode, req.Role)
+	if errors.Is(err, ErrRoleNotAllowed) || errors.Is(err, ErrUserFrozen) {
+		c.Errorf("register denied, err=%s", err.Error())
+		ctx.JSON(http.StatusForbidden, req.GenResponse(err))
+		return
+	}
+
 	if err != nil {
 		c.Errorf("register failed, err=%s", err.Error())
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -216,7 +308,8 @@ func (c *Ctl) HandleRegister(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, &RespRegister{
 		RespBase: req.GenResponse(err),
-		User:     user,
+		User:     user.User,
+		Session:  session,
 	})
 }
 
@@ -247,7 +340,13 @@ func (c *Ctl) HandleLogin(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.Login(code, model.Role(rd))
+	user, session, err := c.Login(code, model.Role(rd))
+	if errors.Is(err, ErrUserFrozen) {
+		c.Errorf("login denied, err=%s", err.Error())
+		ctx.JSON(http.StatusForbidden, req.GenResponse(err))
+		return
+	}
+
 	if err != nil {
 		c.Errorf("login failed, err=%s", err.Error())
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -256,11 +355,50 @@ func (c *Ctl) HandleLogin(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, &RespLogin{
 		RespBase:     req.GenResponse(err),
+		Token:        session.Token,
+		RefreshToken: session.RefreshToken,
+		ExpireAt:     session.ExpireAt,
 		OpenID:       user.OpenID,
 		IsRegistered: user.Phone == "",
 	})
 }
 
+func (c *Ctl) HandleRefreshToken(ctx *gin.Context) {
+
+	req := &ReqRefreshToken{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	session, err := c.RefreshSession(req.RefreshToken)
+	if errors.Is(err, ErrUserFrozen) {
+		c.Errorf("refresh session denied, err=%s", err.Error())
+		ctx.JSON(http.StatusForbidden, req.GenResponse(err))
+		return
+	}
+
+	if err != nil {
+		c.Errorf("refresh session failed, err=%s", err.Error())
+		ctx.JSON(http.StatusUnauthorized, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespRefreshToken{
+		RespBase: req.GenResponse(nil),
+		Session:  session,
+	})
+}
+
 func (c *Ctl) HandleRegisterUser(ctx *gin.Context) {
 
 	req := &ReqRegisterUser{}
@@ -287,7 +425,7 @@ func (c *Ctl) HandleRegisterUser(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, &RespRegisterUser{
 		RespBase: req.GenResponse(err),
-		Info:     user,
+		Info:     user.User,
 	})
 }
 
@@ -308,35 +446,85 @@ func (c *Ctl) HandleBalanceRecharge(ctx *gin.Context) {
 		return
 	}
 
-	openid := ctx.Query("openid")
-	if len(openid) == 0 {
+	user := c.CurrentUser(ctx)
+
+	prepayInfo, err := c.DoManageBalance(user.ID, model.TypeRecharge, req.Channel, req.Amount, "", "")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespHandleBalanceRecharge{
+		RespBase:   req.GenResponse(nil),
+		PrepayInfo: prepayInfo,
+	})
+}
+
+func (c *Ctl) HandleBalanceWithdraw(ctx *gin.Context) {
+
+	req := &ReqHandleBalance{}
+	err := ctx.ShouldBindBodyWith(req, binding.JSON)
+	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	user, err := c.GetUserByOpenID(openid)
-	if err != nil {
-		c.Errorf("check user failed: %s", err.Error())
+	ok, err := valid.ValidateStruct(req)
+	if err != nil || !ok {
+		c.Errorf("request params invalid, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	prepayInfo, err := c.DoManageBalance(user.ID, model.TypeRecharge, req.Amount)
+	user := c.CurrentUser(ctx)
+
+	r, err := c.Withdraw(user.ID, req.Channel, req.Amount, req.Account, req.Name)
 	if err != nil {
+		c.Errorf("withdraw failed, err=%s", err.Error())
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &RespHandleBalanceRecharge{
-		RespBase:   req.GenResponse(nil),
-		PrepayInfo: prepayInfo,
+	ctx.JSON(http.StatusOK, &RespHandleBalanceWithdraw{
+		RespBase: req.GenResponse(nil),
+		Withdraw: newWithdrawRequest(r),
 	})
 }
 
-func (c *Ctl) HandleBalanceWithdraw(ctx *gin.Context) {
+func newWithdrawRequest(r *model.TWithdrawRequest) *WithdrawRequest {
+	return &WithdrawRequest{
+		TWithdrawRequest: r,
+		StateCN:          model.WithdrawStateCN[r.State],
+		ChannelCN:        model.PayChannelCN[r.Channel],
+	}
+}
 
-	req := &ReqHandleBalance{}
+func (c *Ctl) HandleGetWithdraws(ctx *gin.Context) {
+
+	req := &ReqGetWithdraws{}
+	user := c.CurrentUser(ctx)
+
+	rs, err := c.trade.GetWithdrawRequests(user.ID, 0)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
+		return
+	}
+
+	data := make([]*WithdrawRequest, 0)
+	for _, r := range rs {
+		data = append(data, newWithdrawRequest(r))
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetWithdraws{
+		RespBase:  req.GenResponse(nil),
+		Withdraws: data,
+	})
+}
+
+func (c *Ctl) HandleRefundRecharge(ctx *gin.Context) {
+
+	req := &ReqRefundRecharge{}
 	err := ctx.ShouldBindBodyWith(req, binding.JSON)
 	if err != nil {
 		c.Errorf("parsing request failed, err=%s", err.Error())
@@ -351,28 +539,50 @@ func (c *Ctl) HandleBalanceWithdraw(ctx *gin.Context) {
 		return
 	}
 
-	openid := ctx.Query("openid")
-	if len(openid) == 0 {
-		c.Errorf("parsing request failed, err=%s", err.Error())
+	if req.Amount < 0 {
+		err = fmt.Errorf("invalid amount: [%v]", req.Amount)
+		c.Errorf("request params invalid, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	user, err := c.GetUserByOpenID(openid)
+	user := c.CurrentUser(ctx)
+
+	refund, err := c.RefundRecharge(user.ID, req.TradeID, req.Amount, req.Reason)
 	if err != nil {
-		c.Errorf("check user failed: %s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		c.Errorf("refund recharge failed, err=%s", err.Error())
+		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	_, err = c.DoManageBalance(user.ID, model.TypeWithdraw, req.Amount)
+	ctx.JSON(http.StatusOK, &RespRefundRecharge{
+		RespBase: req.GenResponse(nil),
+		Refund:   refund,
+	})
+}
+
+func (c *Ctl) HandleGetRefunds(ctx *gin.Context) {
+
+	req := &ReqGetRefunds{}
+	user := c.CurrentUser(ctx)
+
+	records, err := c.trade.GetRefundRecords(user.ID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &RespHandleBalanceWithdraw{
+	data := make([]*Refund, 0)
+	for _, r := range records {
+		data = append(data, &Refund{
+			TRefundRecord: r,
+			ChannelCN:     model.PayChannelCN[r.Channel],
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &RespGetRefunds{
 		RespBase: req.GenResponse(nil),
+		Refunds:  data,
 	})
 }
 
@@ -387,6 +597,13 @@ func (c *Ctl) HandleGetUserInfo(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 	}
 
+	// 仅允许查询本人信息，审核员及管理员除外
+	operator := c.CurrentUser(ctx)
+	if operator.OpenID != openID && operator.Role != model.RoleAuditor && operator.Role != model.RoleAdministrator {
+		Deny(ctx, ErrNotOwner)
+		return
+	}
+
 	user, err := c.GetUserByOpenID(openID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
@@ -403,7 +620,21 @@ func (c *Ctl) HandleGetUsers(ctx *gin.Context) {
 
 	req := &ReqGetUsers{}
 
-	users, err := c.GetUsers()
+	page, err := repo.ParsePage(ctx.Query, repo.UserSorts...)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
+	if err != nil {
+		c.Errorf("parsing request failed, err=%s", err.Error())
+		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
+		return
+	}
+
+	users, info, err := c.GetUsers(&repo.UserFilter{TimeRange: timeRange}, page)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
@@ -420,13 +651,45 @@ func (c *Ctl) HandleGetUsers(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &RespGetUsers{
 		RespBase: req.GenResponse(err),
 		Users:    data,
+		Page:     info,
 	})
 }
 
 func (c *Ctl) HandlePrepayCallback(ctx *gin.Context) {
 
-	err := c.PrepayCallback(ctx.Request)
+	err := c.PrepayCallback(model.ChannelWechat, ctx.Request)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, &RespPrepayCallback{
+			Code:    "FAIL",
+			Message: "失败",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &RespPrepayCallback{
+		Code:    "SUCCESS",
+		Message: "成功",
+	})
+}
+
+// HandleAlipayNotify 支付宝异步通知，处理成功需返回纯文本 success，否则支付宝将重试
+func (c *Ctl) HandleAlipayNotify(ctx *gin.Context) {
+
+	err := c.PrepayCallback(model.ChannelAlipay, ctx.Request)
+	if err != nil {
+		c.Errorf("handle alipay notify failed, err=%s", err.Error())
+		ctx.String(http.StatusOK, "fail")
+		return
+	}
+
+	ctx.String(http.StatusOK, "success")
+}
+
+func (c *Ctl) HandleRefundCallback(ctx *gin.Context) {
+
+	err := c.RefundCallback(model.ChannelWechat, ctx.Request)
 	if err != nil {
+		c.Errorf("handle refund callback failed, err=%s", err.Error())
 		ctx.JSON(http.StatusInternalServerError, &RespPrepayCallback{
 			Code:    "FAIL",
 			Message: "失败",
@@ -473,38 +736,22 @@ func (c *Ctl) HandleGetTransactions(ctx *gin.Context) {
 		return
 	}
 
-	openid := ctx.Query("openid")
-	if len(openid) == 0 {
-		c.Errorf("parsing request failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
-	offset, limit := 0, 0
-	offsetStr := ctx.Query("offset")
-	offset, err = strconv.Atoi(offsetStr)
-	if err != nil {
-		c.Errorf("parsing offset failed, err=%s", err.Error())
-		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
-		return
-	}
-
-	limitStr := ctx.Query("limit")
-	limit, err = strconv.Atoi(limitStr)
+	page, err := repo.ParsePage(ctx.Query, repo.TradeSorts...)
 	if err != nil {
-		c.Errorf("parsing limit failed, err=%s", err.Error())
+		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	user, err := c.GetUserByOpenID(openid)
+	timeRange, err := repo.ParseTimeRange(ctx.Query)
 	if err != nil {
-		c.Errorf("check user failed: %s", err.Error())
+		c.Errorf("parsing request failed, err=%s", err.Error())
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 		return
 	}
 
-	trades, err := c.trade.GetTradesPage(user.ID, offset, limit)
+	user := c.CurrentUser(ctx)
+	trades, info, err := c.trade.GetTrades(&repo.TradeFilter{TimeRange: timeRange, UserID: user.ID}, page)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, req.GenResponse(err))
 		return
@@ -515,12 +762,14 @@ func (c *Ctl) HandleGetTransactions(ctx *gin.Context) {
 		data = append(data, &Trade{
 			TTradeRecord: t,
 			TypeCN:       model.TradeTypeCN[t.Type],
+			ChannelCN:    model.PayChannelCN[t.Channel],
 		})
 	}
 
 	ctx.JSON(http.StatusOK, &RespGetTrades{
 		RespBase: req.GenResponse(nil),
 		Trades:   data,
+		Page:     info,
 	})
 }
 
@@ -548,6 +797,12 @@ func (c *Ctl) HandleUpdateUser(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, req.GenResponse(err))
 	}
 
+	// 仅允许修改本人信息
+	if c.CurrentUser(ctx).OpenID != openID {
+		Deny(ctx, ErrNotOwner)
+		return
+	}
+
 	user, err := c.UpdateUserInfo(openID, req.Name, req.Avatar)
 	if err != nil {
 		c.Errorf("update user info failed, err=%s", err.Error())
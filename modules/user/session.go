@@ -0,0 +1,177 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mojiQAQ/dispatch/model"
+)
+
+type (
+	TokenType string
+
+	// Claims 会话令牌载荷
+	Claims struct {
+		UserID   uint       `json:"uid"`
+		Role     model.Role `json:"role"`
+		Type     TokenType  `json:"typ"`
+		IssuedAt int64      `json:"iat"`
+		ExpireAt int64      `json:"exp"`
+	}
+
+	// Session 登录会话，access token 用于接口鉴权，refresh token 用于续期
+	Session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpireAt     int64  `json:"expire_at"`
+	}
+)
+
+const (
+	TokenTypeAccess  TokenType = "access"
+	TokenTypeRefresh TokenType = "refresh"
+
+	defaultTokenExpire   = 120 // 单位：分钟
+	defaultRefreshExpire = 720 // 单位：小时
+
+	// SessionSecretEnv 会话令牌签名密钥的环境变量
+	SessionSecretEnv = "DISPATCH_SESSION_SECRET"
+	minSessionSecret = 32
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// LoadSessionSecret 读取会话令牌签名密钥：优先取环境变量 DISPATCH_SESSION_SECRET，其次取未纳入版本库的配置，
+// 未配置或长度不足时返回错误，服务应拒绝启动
+func LoadSessionSecret(cfg *model.Session) error {
+
+	if secret := os.Getenv(SessionSecretEnv); len(secret) != 0 {
+		cfg.Secret = secret
+	}
+
+	if len(cfg.Secret) < minSessionSecret {
+		return fmt.Errorf("session secret must be at least %d bytes, set env %s", minSessionSecret, SessionSecretEnv)
+	}
+
+	return nil
+}
+
+func (c *Ctl) sign(payload string) string {
+
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signToken 签发令牌，格式为 base64(claims).base64(hmac-sha256)
+func (c *Ctl) signToken(claims *Claims) (string, error) {
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + c.sign(payload), nil
+}
+
+// parseToken 校验令牌签名、类型及有效期
+func (c *Ctl) parseToken(token string, typ TokenType) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(c.sign(parts[0]))) {
+		return nil, ErrInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	err = json.Unmarshal(data, claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != typ {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpireAt {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// IssueSession 为用户签发访问令牌及刷新令牌
+func (c *Ctl) IssueSession(user *model.TUser) (*Session, error) {
+
+	now := time.Now()
+	access := &Claims{
+		UserID:   user.ID,
+		Role:     user.Role,
+		Type:     TokenTypeAccess,
+		IssuedAt: now.Unix(),
+		ExpireAt: now.Add(c.tokenExpire).Unix(),
+	}
+
+	token, err := c.signToken(access)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := &Claims{
+		UserID:   user.ID,
+		Role:     user.Role,
+		Type:     TokenTypeRefresh,
+		IssuedAt: now.Unix(),
+		ExpireAt: now.Add(c.refreshExpire).Unix(),
+	}
+
+	refreshToken, err := c.signToken(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpireAt:     access.ExpireAt,
+	}, nil
+}
+
+// RefreshSession 使用刷新令牌重新签发会话，用户角色以数据库中最新数据为准
+func (c *Ctl) RefreshSession(refreshToken string) (*Session, error) {
+
+	claims, err := c.parseToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.GetUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Frozen {
+		return nil, ErrUserFrozen
+	}
+
+	return c.IssueSession(user)
+}
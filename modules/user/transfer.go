@@ -0,0 +1,257 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+const (
+	transferPollInterval   = time.Second * 30 // 转账查询任务执行间隔
+	transferBackoffBase    = time.Minute      // 转账结果未变化时的首次查询退避间隔
+	transferBackoffMax     = time.Hour        // 查询退避间隔上限
+	maxTransferSubmissions = 10               // 渠道未受理的转账最多提交次数，超过后转账失败并退回余额
+)
+
+// checkTransfers 查询到期的未完成转账，推进提现转账状态机
+func (c *Ctl) checkTransfers() {
+
+	records, err := c.trade.GetDueTransferRecords(time.Now())
+	if err != nil {
+		c.Errorf("get uncomplete transfer records failed, err=%s", err.Error())
+		return
+	}
+
+	for _, r := range records {
+		err = c.pollTransfer(r)
+		if err != nil {
+			c.Errorf("poll transfer failed, trade_id=%s, err=%s", r.TradeID, err.Error())
+		}
+	}
+}
+
+// pollTransfer 推进单笔转账：INIT 状态提交渠道转账，重试前先查询渠道是否已受理，避免重复转账；
+// 其余状态查询渠道转账明细并更新状态
+func (c *Ctl) pollTransfer(r *trade.TransferRecord) error {
+
+	pay, err := c.provider(r.Channel)
+	if err != nil {
+		return err
+	}
+
+	if r.State == model.TransferStateINIT && r.Attempts == 0 {
+		return c.submitTransfer(pay, r)
+	}
+
+	status, err := pay.QueryTransfer(r.TradeID)
+	if errors.Is(err, payment.ErrTransferNotFound) && r.State == model.TransferStateINIT {
+		// 已达最大提交次数且渠道确认未受理，转账失败
+		if r.Attempts >= maxTransferSubmissions {
+			return c.UpdateWithdrawState(r, &payment.TransferStatus{
+				DetailStatus: payment.DetailStateFail,
+				FailReason:   "渠道未受理转账",
+			})
+		}
+		return c.submitTransfer(pay, r)
+	}
+	if err != nil {
+		return c.retryTransfer(r, err)
+	}
+
+	return c.UpdateWithdrawState(r, status)
+}
+
+// submitTransfer 提交渠道转账，成功后转账记录进入 ACCEPTED 状态
+func (c *Ctl) submitTransfer(pay payment.Provider, r *trade.TransferRecord) error {
+
+	// 微信提现至用户零钱
+	payee := r.Account
+	if r.Channel == model.ChannelWechat {
+		payee = r.OpenID
+	}
+
+	resp, err := pay.Transfer(&payment.TransferRequest{
+		Payee:     payee,
+		PayeeName: r.Name,
+		TradeID:   r.TradeID,
+		Desc:      fmt.Sprintf("余额提现-%d", r.Amount),
+		Amount:    r.Amount,
+	})
+	if err != nil {
+		if r.Attempts+1 < maxTransferSubmissions {
+			return c.retryTransfer(r, err)
+		}
+
+		// 最后一次提交失败时渠道可能已受理，查询确认未受理后才失败并退回余额，查询失败时稍后再查
+		status, qErr := pay.QueryTransfer(r.TradeID)
+		switch {
+		case errors.Is(qErr, payment.ErrTransferNotFound):
+			return c.UpdateWithdrawState(r, &payment.TransferStatus{
+				DetailStatus: payment.DetailStateFail,
+				FailReason:   err.Error(),
+			})
+		case qErr != nil:
+			return c.retryTransfer(r, qErr)
+		default:
+			return c.UpdateWithdrawState(r, status)
+		}
+	}
+
+	_, err = c.trade.TransitTransferRecord(c.db, r.Channel, r.TradeID, model.TransferStateINIT,
+		model.TransferStateACCEPTED, resp.BatchID, "")
+	return err
+}
+
+// retryTransfer 记录失败原因，按退避间隔稍后重试
+func (c *Ctl) retryTransfer(r *trade.TransferRecord, cause error) error {
+
+	attempts := r.Attempts + 1
+	err := c.trade.ScheduleTransferQuery(c.db, r.Channel, r.TradeID, attempts, time.Now().Add(transferBackoff(attempts)))
+	if err != nil {
+		return err
+	}
+
+	return cause
+}
+
+// transferBackoff 查询退避间隔：自 transferBackoffBase 起按次数翻倍，不超过 transferBackoffMax
+func transferBackoff(attempts int) time.Duration {
+
+	d := transferBackoffBase
+	for i := 1; i < attempts && d < transferBackoffMax; i++ {
+		d *= 2
+	}
+
+	if d > transferBackoffMax {
+		d = transferBackoffMax
+	}
+
+	return d
+}
+
+// transferState 将渠道转账查询结果转换为提现转账记录状态
+func transferState(status *payment.TransferStatus) string {
+
+	switch {
+	case status.DetailStatus == payment.DetailStateSuccess:
+		return model.TransferStateSUCCESS
+	case status.DetailStatus == payment.DetailStateFail:
+		return model.TransferStateFAIL
+	case status.BatchStatus == model.WxTransferStateCLOSED:
+		return model.TransferStateFAIL
+	case status.BatchStatus == model.WxTransferStateACCEPTED && len(status.DetailStatus) == 0:
+		return model.TransferStateACCEPTED
+	default:
+		return model.TransferStatePROCESSING
+	}
+}
+
+// UpdateWithdrawState 按转账查询结果推进提现转账状态：成功则更新提现交易记录，失败则退回用户余额，
+// 状态未变化时按退避间隔继续查询
+func (c *Ctl) UpdateWithdrawState(r *trade.TransferRecord, status *payment.TransferStatus) error {
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	record, err := c.trade.LockTransferRecord(tx, r.Channel, r.TradeID)
+	if err != nil {
+		return err
+	}
+
+	// 渠道金额与转账记录不一致时不推进状态，按退避间隔继续查询并报错，等待人工处理
+	if status.Amount != 0 && status.Amount != record.Amount {
+		attempts := record.Attempts + 1
+		err = c.trade.ScheduleTransferQuery(tx, record.Channel, record.TradeID, attempts,
+			time.Now().Add(transferBackoff(attempts)))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit().Error
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("transfer %s amount mismatch, record=%d, channel=%d, need manual handling",
+			record.TradeID, record.Amount, status.Amount)
+	}
+
+	to := transferState(status)
+	if !model.CanTransitTransfer(record.State, to) {
+		// 已结束的转账不再查询
+		if len(model.TransferTransitions[record.State]) == 0 {
+			return tx.Commit().Error
+		}
+
+		attempts := record.Attempts + 1
+		err = c.trade.ScheduleTransferQuery(tx, record.Channel, record.TradeID, attempts,
+			time.Now().Add(transferBackoff(attempts)))
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit().Error
+	}
+
+	ok, err := c.trade.TransitTransferRecord(tx, record.Channel, record.TradeID, record.State, to, "", status.FailReason)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		err = fmt.Errorf("transfer %s state changed concurrently", record.TradeID)
+		return err
+	}
+
+	switch to {
+	case model.TransferStateSUCCESS:
+		err = c.trade.UpdateTradeRecordState(tx, record.TradeID, model.TypeWithdraw)
+		if err != nil {
+			return err
+		}
+	case model.TransferStateFAIL:
+		// 更新交易记录状态
+		err = c.trade.UpdateTradeRecordState(tx, record.TradeID, model.TypeWithdrawFail)
+		if err != nil {
+			return err
+		}
+
+		var user *model.TUser
+		user, err = c.getTransferUser(record)
+		if err != nil {
+			return err
+		}
+
+		var wallet *model.TUser
+		wallet, err = c.lockWallet(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// 记账：渠道清算 -> 用户钱包，生成提现退回交易记录
+		err = c.changeBalance(tx, wallet, record.Amount, &trade.Entry{
+			TradeID: record.TradeID,
+			Type:    model.TypeWithdrawRefund,
+			Remark:  status.FailReason,
+			Channel: record.Channel,
+			Lines:   []trade.Line{trade.Clearing(record.Channel, -record.Amount), trade.Wallet(user.ID, record.Amount)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
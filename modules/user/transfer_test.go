@@ -0,0 +1,507 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
+	"github.com/mojiQAQ/dispatch/modules/repo/repotest"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+const (
+	testRecharge = 5000 // 测试用户充值金额，单位：分
+	testWithdraw = 1000 // 测试提现金额，单位：分
+)
+
+// flakyProvider 模拟渠道：前 failures 次提交转账失败，其余行为同 payment.Fake
+type flakyProvider struct {
+	*payment.Fake
+	failures   int
+	submits    int
+	accepted   bool  // 提交失败时渠道仍已受理，模拟响应丢失
+	queryErr   error // 查询转账返回的错误
+	amountDiff int64 // 查询结果金额与转账金额的差额
+}
+
+func (p *flakyProvider) Transfer(req *payment.TransferRequest) (*payment.TransferResult, error) {
+
+	p.submits++
+	if p.failures > 0 {
+		p.failures--
+		if p.accepted {
+			_, _ = p.Fake.Transfer(req)
+		}
+		return nil, errors.New("channel unavailable")
+	}
+
+	return p.Fake.Transfer(req)
+}
+
+func (p *flakyProvider) QueryTransfer(tradeID string) (*payment.TransferStatus, error) {
+
+	if p.queryErr != nil {
+		return nil, p.queryErr
+	}
+
+	status, err := p.Fake.QueryTransfer(tradeID)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Amount += p.amountDiff
+	return status, nil
+}
+
+func newTestCtl(t *testing.T, pay payment.Provider) *Ctl {
+
+	store, err := repotest.NewSQLite(filepath.Join(t.TempDir(), "dispatch.db"))
+	if err != nil {
+		t.Fatalf("open sqlite failed, err=%s", err)
+	}
+
+	logger := &log.Logger{}
+	pays := map[model.PayChannel]payment.Provider{model.ChannelWechat: pay}
+	return NewCtl(logger, store, trade.NewCtl(logger, store), nil, pays, model.Session{},
+		model.WithdrawConf{AutoApproveAmount: 10000})
+}
+
+func newFake(state string) *payment.Fake {
+	return payment.NewFake(&log.Logger{}, "", "", model.FakePay{TransferState: state})
+}
+
+// newTestUser 创建发布人并通过微信充值入账 testRecharge
+func newTestUser(t *testing.T, c *Ctl) *model.TUser {
+
+	u := &model.TUser{User: &model.User{Name: "tester", Role: model.RolePublisher, OpenID: "openid-tester"}}
+	err := c.store.Users().Create(u)
+	if err != nil {
+		t.Fatalf("create user failed, err=%s", err)
+	}
+
+	tx := c.db.Begin()
+	wallet, err := c.lockWallet(tx, u.ID)
+	if err == nil {
+		err = c.changeBalance(tx, wallet, testRecharge, &trade.Entry{
+			TradeID: fmt.Sprintf("recharge-%d", u.ID),
+			Type:    model.TypeRecharge,
+			Channel: model.ChannelWechat,
+			Lines:   []trade.Line{trade.Clearing(model.ChannelWechat, -testRecharge), trade.Wallet(u.ID, testRecharge)},
+		})
+	}
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("recharge failed, err=%s", err)
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		t.Fatalf("commit recharge failed, err=%s", err)
+	}
+
+	return u
+}
+
+func getTransfer(t *testing.T, c *Ctl, tradeID string) *trade.TransferRecord {
+
+	r, err := c.trade.GetTransferRecord(model.ChannelWechat, tradeID)
+	if err != nil {
+		t.Fatalf("get transfer record failed, err=%s", err)
+	}
+
+	return r
+}
+
+func poll(t *testing.T, c *Ctl, tradeID string) error {
+	return c.pollTransfer(getTransfer(t, c, tradeID))
+}
+
+// assertBalances 校验用户钱包、提现冻结及微信清算账户余额，且账本借贷平衡、钱包缓存与账本一致
+func assertBalances(t *testing.T, c *Ctl, userID uint, wallet, hold, clearing int64) {
+
+	t.Helper()
+
+	want := map[model.AccountType]int64{
+		model.AccountUserWallet:     wallet,
+		model.AccountWithdrawHold:   hold,
+		model.AccountWechatClearing: clearing,
+	}
+
+	for typ, amount := range want {
+		owner := uint(0)
+		if typ == model.AccountUserWallet {
+			owner = userID
+		}
+
+		got, err := c.trade.GetAccountBalance(typ, owner)
+		if err != nil {
+			t.Fatalf("get %s balance failed, err=%s", model.AccountTypeCN[typ], err)
+		}
+
+		if got != amount {
+			t.Errorf("%s balance=%d, want %d", model.AccountTypeCN[typ], got, amount)
+		}
+	}
+
+	u, err := c.GetUser(userID)
+	if err != nil {
+		t.Fatalf("get user failed, err=%s", err)
+	}
+
+	if u.Balance != wallet {
+		t.Errorf("user balance=%d, want %d", u.Balance, wallet)
+	}
+
+	mismatches, err := c.trade.ReconcileWallets()
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("wallet mismatches=%v, err=%v", mismatches, err)
+	}
+
+	unbalanced, err := c.trade.CheckUnbalancedEntries()
+	if err != nil || len(unbalanced) != 0 {
+		t.Errorf("unbalanced entries=%v, err=%v", unbalanced, err)
+	}
+}
+
+func assertTradeTypes(t *testing.T, c *Ctl, tradeID string, types ...model.TradeType) {
+
+	t.Helper()
+
+	records, err := c.trade.GetTradesByIDs([]string{tradeID})
+	if err != nil {
+		t.Fatalf("get trades failed, err=%s", err)
+	}
+
+	got := make(map[model.TradeType]bool)
+	for _, r := range records {
+		got[r.Type] = true
+	}
+
+	for _, typ := range types {
+		if !got[typ] {
+			t.Errorf("trade %s has no %s record, got %v", tradeID, model.TradeTypeCN[typ], records)
+		}
+	}
+}
+
+func TestWithdrawTransferSuccess(t *testing.T) {
+
+	c := newTestCtl(t, newFake(payment.DetailStateSuccess))
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	if r.State != model.WithdrawStateTransferred {
+		t.Fatalf("withdraw state=%v, want transferred", r.State)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateACCEPTED {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateACCEPTED)
+	}
+
+	// 已提交渠道，资金位于清算账户
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll transfer failed, err=%s", err)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateSUCCESS {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateSUCCESS)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+	assertTradeTypes(t, c, r.TradeID, model.TypeWithdraw)
+
+	// 已结束的转账不再查询
+	records, err := c.trade.GetDueTransferRecords(time.Now().Add(transferBackoffMax))
+	if err != nil || len(records) != 0 {
+		t.Errorf("due transfers=%v, err=%v", records, err)
+	}
+}
+
+func TestWithdrawTransferFail(t *testing.T) {
+
+	c := newTestCtl(t, newFake(payment.DetailStateFail))
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll transfer failed, err=%s", err)
+	}
+
+	record := getTransfer(t, c, r.TradeID)
+	if record.State != model.TransferStateFAIL || len(record.FailReason) == 0 {
+		t.Fatalf("transfer state=%s, reason=%q, want %s with reason", record.State, record.FailReason,
+			model.TransferStateFAIL)
+	}
+
+	// 转账失败，资金由清算账户退回用户钱包
+	assertBalances(t, c, u.ID, testRecharge, 0, -testRecharge)
+	assertTradeTypes(t, c, r.TradeID, model.TypeWithdrawFail, model.TypeWithdrawRefund)
+
+	// 重复查询不会重复退回
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll finished transfer failed, err=%s", err)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge, 0, -testRecharge)
+}
+
+func TestWithdrawTransferRetry(t *testing.T) {
+
+	pay := &flakyProvider{Fake: newFake(payment.DetailStateSuccess), failures: 1}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	// 首次提交失败，保持 INIT 并按退避间隔稍后重试
+	record := getTransfer(t, c, r.TradeID)
+	if record.State != model.TransferStateINIT || record.Attempts != 1 {
+		t.Fatalf("transfer state=%s, attempts=%d, want %s and 1", record.State, record.Attempts,
+			model.TransferStateINIT)
+	}
+
+	due, err := c.trade.GetDueTransferRecords(time.Now())
+	if err != nil || len(due) != 0 {
+		t.Fatalf("transfer due before backoff, due=%v, err=%v", due, err)
+	}
+
+	due, err = c.trade.GetDueTransferRecords(time.Now().Add(transferBackoff(1) + time.Second))
+	if err != nil || len(due) != 1 || due[0].TradeID != r.TradeID {
+		t.Fatalf("transfer not due after backoff, due=%v, err=%v", due, err)
+	}
+
+	// 重试前查询渠道未受理，重新提交
+	err = c.pollTransfer(due[0])
+	if err != nil {
+		t.Fatalf("retry transfer failed, err=%s", err)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateACCEPTED {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateACCEPTED)
+	}
+
+	// 渠道已受理后重试仅查询，不重复提交
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll transfer failed, err=%s", err)
+	}
+
+	if pay.submits != 2 {
+		t.Errorf("transfer submitted %d times, want 2", pay.submits)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateSUCCESS {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateSUCCESS)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+}
+
+func TestWithdrawTransferProcessing(t *testing.T) {
+
+	c := newTestCtl(t, newFake(payment.DetailStateProcessing))
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = poll(t, c, r.TradeID)
+		if err != nil {
+			t.Fatalf("poll transfer failed, err=%s", err)
+		}
+	}
+
+	// 首次查询进入处理中，之后状态未变化时按次数退避
+	record := getTransfer(t, c, r.TradeID)
+	if record.State != model.TransferStatePROCESSING || record.Attempts != 2 {
+		t.Fatalf("transfer state=%s, attempts=%d, want %s and 2", record.State, record.Attempts,
+			model.TransferStatePROCESSING)
+	}
+
+	due, err := c.trade.GetDueTransferRecords(time.Now().Add(transferBackoff(2) - time.Second))
+	if err != nil || len(due) != 0 {
+		t.Fatalf("transfer due before backoff, due=%v, err=%v", due, err)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+}
+
+func TestWithdrawTransferGiveUp(t *testing.T) {
+
+	pay := &flakyProvider{Fake: newFake(payment.DetailStateSuccess), failures: maxTransferSubmissions}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	for i := 1; i < maxTransferSubmissions; i++ {
+		_ = poll(t, c, r.TradeID)
+	}
+
+	// 超过最多提交次数后转账失败并退回余额
+	if pay.submits != maxTransferSubmissions {
+		t.Errorf("transfer submitted %d times, want %d", pay.submits, maxTransferSubmissions)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateFAIL {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateFAIL)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge, 0, -testRecharge)
+}
+
+// TestWithdrawTransferAcceptedOnGiveUp 最后一次提交响应丢失但渠道已受理时，按查询结果推进而不退回余额
+func TestWithdrawTransferAcceptedOnGiveUp(t *testing.T) {
+
+	pay := &flakyProvider{Fake: newFake(payment.DetailStateSuccess), failures: maxTransferSubmissions}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	for i := 1; i < maxTransferSubmissions-1; i++ {
+		_ = poll(t, c, r.TradeID)
+	}
+
+	pay.accepted = true
+	_ = poll(t, c, r.TradeID)
+
+	if s := getTransfer(t, c, r.TradeID).State; s == model.TransferStateFAIL || s == model.TransferStateINIT {
+		t.Fatalf("transfer state=%s, want accepted by channel", s)
+	}
+
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll transfer failed, err=%s", err)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateSUCCESS {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateSUCCESS)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+}
+
+// TestWithdrawTransferGiveUpQueryFailed 最后一次提交失败且查询失败时，保持 INIT 稍后再查，确认未受理后才退回
+func TestWithdrawTransferGiveUpQueryFailed(t *testing.T) {
+
+	pay := &flakyProvider{Fake: newFake(payment.DetailStateSuccess), failures: maxTransferSubmissions}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	for i := 1; i < maxTransferSubmissions-1; i++ {
+		_ = poll(t, c, r.TradeID)
+	}
+
+	pay.queryErr = errors.New("query unavailable")
+	record := getTransfer(t, c, r.TradeID)
+	err = c.submitTransfer(pay, record)
+	if err == nil {
+		t.Fatalf("submit transfer succeeded")
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateINIT {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateINIT)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+
+	// 查询恢复后渠道确认未受理，不再提交，转账失败并退回余额
+	pay.queryErr = nil
+	err = poll(t, c, r.TradeID)
+	if err != nil {
+		t.Fatalf("poll transfer failed, err=%s", err)
+	}
+
+	if pay.submits != maxTransferSubmissions {
+		t.Errorf("transfer submitted %d times, want %d", pay.submits, maxTransferSubmissions)
+	}
+
+	if s := getTransfer(t, c, r.TradeID).State; s != model.TransferStateFAIL {
+		t.Fatalf("transfer state=%s, want %s", s, model.TransferStateFAIL)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge, 0, -testRecharge)
+}
+
+// TestWithdrawTransferAmountMismatch 渠道金额与转账记录不一致时报错，转账保持原状态等待人工处理
+func TestWithdrawTransferAmountMismatch(t *testing.T) {
+
+	pay := &flakyProvider{Fake: newFake(payment.DetailStateSuccess), amountDiff: 1}
+	c := newTestCtl(t, pay)
+	u := newTestUser(t, c)
+
+	r, err := c.Withdraw(u.ID, model.ChannelWechat, testWithdraw, "", "")
+	if err != nil {
+		t.Fatalf("withdraw failed, err=%s", err)
+	}
+
+	err = poll(t, c, r.TradeID)
+	if err == nil {
+		t.Fatalf("poll transfer with amount mismatch succeeded")
+	}
+
+	record := getTransfer(t, c, r.TradeID)
+	if record.State != model.TransferStateACCEPTED || record.Attempts != 1 {
+		t.Fatalf("transfer state=%s, attempts=%d, want %s and 1", record.State, record.Attempts,
+			model.TransferStateACCEPTED)
+	}
+
+	assertBalances(t, c, u.ID, testRecharge-testWithdraw, 0, -testRecharge+testWithdraw)
+	assertTradeTypes(t, c, r.TradeID, model.TypeWithdrawing)
+}
+
+func TestTransferBackoff(t *testing.T) {
+
+	cases := map[int]time.Duration{
+		0:   transferBackoffBase,
+		1:   transferBackoffBase,
+		2:   transferBackoffBase * 2,
+		3:   transferBackoffBase * 4,
+		6:   transferBackoffBase * 32,
+		7:   transferBackoffMax,
+		100: transferBackoffMax,
+	}
+
+	for attempts, want := range cases {
+		if got := transferBackoff(attempts); got != want {
+			t.Errorf("transferBackoff(%d)=%s, want %s", attempts, got, want)
+		}
+	}
+}
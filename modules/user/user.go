@@ -3,52 +3,57 @@ package user
 import (
 	"errors"
 	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
 	"gorm.io/gorm"
 )
 
-func (c *Ctl) CreateUser(user *model.User) error {
+func (c *Ctl) CreateUser(user *model.User) (*model.TUser, error) {
 
 	data := &model.TUser{
 		User: user,
 	}
 
-	return c.db.Create(data).Error
+	err := c.store.Users().Create(data)
+	if err != nil {
+		return nil, err
+	}
 
+	return data, nil
 }
 
-func (c *Ctl) GetUsers() ([]*model.TUser, error) {
+// GetUsers 分页查询用户
+func (c *Ctl) GetUsers(filter *repo.UserFilter, page *model.Page) ([]*model.TUser, *model.PageInfo, error) {
 
-	users := make([]*model.TUser, 0)
-	err := c.db.Model(model.TUser{}).Find(&users).Error
-	if err != nil {
-		return nil, err
-	}
-	return users, nil
+	return c.store.Users().List(filter, page)
 }
 
-func (c *Ctl) GetUser(id uint) (*model.User, error) {
+func (c *Ctl) GetUser(id uint) (*model.TUser, error) {
+
+	return c.store.Users().Get(id)
+}
 
-	user := &model.TUser{}
-	err := c.db.Model(model.TUser{}).Where("id = ?", id).First(user).Error
+// GetUsersByIDs 批量查询用户，按用户 ID 索引
+func (c *Ctl) GetUsersByIDs(ids []uint) (map[uint]*model.TUser, error) {
+
+	users, err := c.store.Users().GetByIDs(ids)
 	if err != nil {
 		return nil, err
 	}
 
-	return user.User, nil
+	data := make(map[uint]*model.TUser, len(users))
+	for _, u := range users {
+		data[u.ID] = u
+	}
+
+	return data, nil
 }
 
 func (c *Ctl) GetUserByOpenID(id string) (*model.TUser, error) {
 
-	user := &model.TUser{}
-	err := c.db.Model(model.TUser{}).Where("openid = ?", id).First(user).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
+	return c.store.Users().GetByOpenID(id)
 }
 
-func (c *Ctl) RegisterUser(openID, pn, name, avatar string, role model.Role) (*model.User, error) {
+func (c *Ctl) RegisterUser(openID, pn, name, avatar string, role model.Role) (*model.TUser, error) {
 
 	user := &model.User{
 		Name:    name,
@@ -59,19 +64,15 @@ func (c *Ctl) RegisterUser(openID, pn, name, avatar string, role model.Role) (*m
 		OpenID:  openID,
 	}
 
-	err := c.CreateUser(user)
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
+	return c.CreateUser(user)
 }
 
-func (c *Ctl) Login(code string, role model.Role) (*model.User, error) {
+// Login 微信登录，登录成功后签发会话令牌
+func (c *Ctl) Login(code string, role model.Role) (*model.TUser, *Session, error) {
 
 	auth, err := c.wx.GetAuthKey(code, role)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	userInfo, err := c.GetUserByOpenID(auth.OpenID)
@@ -86,35 +87,68 @@ func (c *Ctl) Login(code string, role model.Role) (*model.User, error) {
 		//} else {
 		//	return nil, err
 		//}
-		return nil, err
+		return nil, nil, err
+	}
+
+	if userInfo.Frozen {
+		return nil, nil, ErrUserFrozen
+	}
+
+	session, err := c.IssueSession(userInfo)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return userInfo.User, nil
+	return userInfo, session, nil
+}
+
+// selfRegisterRoles 可自助注册的角色，审核员及管理员仅可由管理员创建
+var selfRegisterRoles = map[model.Role]bool{
+	model.RolePublisher: true,
+	model.RoleWorker:    true,
 }
 
-func (c *Ctl) Register(name, avatar, phoneCode, userCode string, role model.Role) (*model.User, error) {
+// Register 微信注册，仅可注册发布人及接单员，已注册用户直接返回，注册成功后签发会话令牌
+func (c *Ctl) Register(name, avatar, phoneCode, userCode string, role model.Role) (*model.TUser, *Session, error) {
+
+	if !selfRegisterRoles[role] {
+		return nil, nil, ErrRoleNotAllowed
+	}
 
 	// 获取手机号
 	phone, err := c.wx.GetPhoneNumber(phoneCode, role)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 获取 OpenID
 	auth, err := c.wx.GetAuthKey(userCode, role)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	user, err := c.GetUserByOpenID(auth.OpenID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.RegisterUser(auth.OpenID, phone.PhoneNumber, name, avatar, role)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
 		}
-		return nil, err
+
+		user, err = c.RegisterUser(auth.OpenID, phone.PhoneNumber, name, avatar, role)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if user.Frozen {
+		return nil, nil, ErrUserFrozen
+	}
+
+	session, err := c.IssueSession(user)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return user.User, nil
+	return user, session, nil
 }
 
 func (c *Ctl) UpdateUserInfo(openid, name, avatar string) (*model.User, error) {
@@ -133,7 +167,7 @@ func (c *Ctl) UpdateUserInfo(openid, name, avatar string) (*model.User, error) {
 		data["avatar"] = avatar
 	}
 
-	err = c.db.Model(model.TUser{}).Where("openid = ?", openid).Updates(data).Error
+	err = c.store.Users().Update(user.ID, data)
 	if err != nil {
 		return nil, err
 	}
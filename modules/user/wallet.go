@@ -0,0 +1,40 @@
+package user
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+var ErrInsufficientBalance = errors.New("账户余额不足")
+
+// lockWallet 在调用方事务中锁定用户行并读取最新余额，所有余额变动须先经过该锁，
+// 并发的奖励、退费、支付等操作将按锁顺序串行执行
+func (c *Ctl) lockWallet(tx *gorm.DB, userID uint) (*model.TUser, error) {
+
+	return c.store.WithDB(tx).Users().Lock(userID)
+}
+
+// changeBalance 变更用户余额：记账后以增量方式更新余额缓存，调用方需已通过 lockWallet 锁定该用户
+func (c *Ctl) changeBalance(tx *gorm.DB, user *model.TUser, delta int64, entry *trade.Entry) error {
+
+	if user.Balance+delta < 0 {
+		return ErrInsufficientBalance
+	}
+
+	_, err := c.trade.Post(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = c.store.WithDB(tx).Users().AddBalance(user.ID, delta)
+	if err != nil {
+		return err
+	}
+
+	user.Balance += delta
+	return nil
+}
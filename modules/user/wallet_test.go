@@ -0,0 +1,215 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/repo"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+)
+
+// mysqlDSNEnv 行锁测试使用的 MySQL 连接串，测试会清空其中的用户及账本表，须指向专用测试库
+const mysqlDSNEnv = "DISPATCH_TEST_MYSQL_DSN"
+
+const (
+	walletWorkers = 20  // 并发操作数
+	walletPrice   = 200 // 单个任务价格，单位：分
+	walletReward  = 100 // 单个任务奖励，单位：分
+)
+
+// newMySQLCtl 连接 MySQL 测试库并重建相关表。SQLite 不支持 SELECT ... FOR UPDATE，
+// 无法验证 lockWallet 的行锁，未配置测试库时跳过
+func newMySQLCtl(t *testing.T) *Ctl {
+
+	t.Helper()
+
+	dsn := os.Getenv(mysqlDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skip wallet row lock test", mysqlDSNEnv)
+	}
+
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open mysql failed, err=%s", err)
+	}
+
+	tables := []interface{}{
+		&model.TUser{}, &model.TTradeRecord{},
+		&model.TLedgerAccount{}, &model.TJournalEntry{}, &model.TJournalLine{},
+	}
+
+	err = db.Migrator().DropTable(tables...)
+	if err != nil {
+		t.Fatalf("drop tables failed, err=%s", err)
+	}
+
+	err = db.AutoMigrate(tables...)
+	if err != nil {
+		t.Fatalf("migrate tables failed, err=%s", err)
+	}
+
+	logger := &log.Logger{}
+	store := repo.NewMySQL(db)
+	return NewCtl(logger, store, trade.NewCtl(logger, store), nil, nil, model.Session{}, model.WithdrawConf{})
+}
+
+func newWalletUser(t *testing.T, c *Ctl, role model.Role, balance int64) *model.TUser {
+
+	t.Helper()
+
+	user := &model.TUser{User: &model.User{Role: role, Balance: balance}}
+	err := c.db.Create(user).Error
+	if err != nil {
+		t.Fatalf("create user failed, err=%s", err)
+	}
+
+	return user
+}
+
+func newWalletOrder(publisher uint, i int) *model.TMasterOrder {
+
+	return &model.TMasterOrder{
+		Model: &gorm.Model{ID: uint(i + 1)},
+		MasterOrder: &model.MasterOrder{
+			UUID:      fmt.Sprintf("order-%d", i),
+			UserID:    publisher,
+			Reward:    walletReward,
+			UnitPrice: walletPrice,
+		},
+	}
+}
+
+// runWallet 每个操作在独立事务中并发执行，返回各操作的执行结果
+func runWallet(c *Ctl, n int, f func(tx *gorm.DB, i int) error) []error {
+
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.db.Transaction(func(tx *gorm.DB) error {
+				return f(tx, i)
+			})
+		}(i)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+func walletBalance(t *testing.T, c *Ctl, userID uint) int64 {
+
+	t.Helper()
+
+	user, err := c.GetUser(userID)
+	if err != nil {
+		t.Fatalf("get user failed, err=%s", err)
+	}
+
+	return user.Balance
+}
+
+// assertLedger 用户余额缓存与账本钱包一致，且所有凭证借贷平衡
+func assertLedger(t *testing.T, c *Ctl) {
+
+	t.Helper()
+
+	mismatches, err := c.trade.ReconcileWallets()
+	if err != nil {
+		t.Fatalf("reconcile wallets failed, err=%s", err)
+	}
+
+	for _, m := range mismatches {
+		t.Errorf("user %d balance=%d, ledger=%d", m.UserID, m.UserBalance, m.LedgerBalance)
+	}
+
+	unbalanced, err := c.trade.CheckUnbalancedEntries()
+	if err != nil {
+		t.Fatalf("check unbalanced entries failed, err=%s", err)
+	}
+
+	for _, e := range unbalanced {
+		t.Errorf("journal entry %d unbalanced, sum=%d", e.EntryID, e.Sum)
+	}
+}
+
+// TestWalletConcurrentOverdraw 并发支付超出余额时，仅余额足够的部分成功，余额不会为负
+func TestWalletConcurrentOverdraw(t *testing.T) {
+
+	c := newMySQLCtl(t)
+	funded := walletWorkers / 2
+	publisher := newWalletUser(t, c, model.RolePublisher, int64(funded*walletPrice))
+
+	errs := runWallet(c, walletWorkers, func(tx *gorm.DB, i int) error {
+		return c.PayForPublishOrder(tx, newWalletOrder(publisher.ID, i), walletPrice)
+	})
+
+	paid := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			paid++
+		case errors.Is(err, ErrInsufficientBalance):
+		default:
+			t.Errorf("pay order %d failed, err=%s", i, err)
+		}
+	}
+
+	if paid != funded {
+		t.Errorf("paid %d orders, want %d", paid, funded)
+	}
+
+	if got := walletBalance(t, c, publisher.ID); got != 0 {
+		t.Errorf("publisher balance=%d, want 0", got)
+	}
+
+	assertLedger(t, c)
+}
+
+// TestWalletConcurrentSettle 同一发布人并发退费、同一接单员并发结算奖励，所有操作均成功且余额与账本一致
+func TestWalletConcurrentSettle(t *testing.T) {
+
+	c := newMySQLCtl(t)
+	publisher := newWalletUser(t, c, model.RolePublisher, 0)
+	worker := newWalletUser(t, c, model.RoleWorker, 0)
+
+	errs := runWallet(c, walletWorkers*2, func(tx *gorm.DB, i int) error {
+		order := newWalletOrder(publisher.ID, i)
+		if i%2 == 0 {
+			return c.ReturnUnCompleteOrder(tx, order, walletPrice)
+		}
+
+		sub := &model.TSubOrder{SubOrder: &model.SubOrder{
+			MID:    order.ID,
+			UUID:   fmt.Sprintf("sub-order-%d", i),
+			UserID: worker.ID,
+		}}
+		return c.RewardForOrder(tx, order, sub)
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("settle %d failed, err=%s", i, err)
+		}
+	}
+
+	if got := walletBalance(t, c, publisher.ID); got != walletWorkers*walletPrice {
+		t.Errorf("publisher balance=%d, want %d", got, walletWorkers*walletPrice)
+	}
+
+	if got := walletBalance(t, c, worker.ID); got != walletWorkers*walletReward {
+		t.Errorf("worker balance=%d, want %d", got, walletWorkers*walletReward)
+	}
+
+	assertLedger(t, c)
+}
@@ -0,0 +1,250 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/trade"
+	"github.com/mojiQAQ/dispatch/modules/utils"
+)
+
+const (
+	defaultMaxWithdraw      = 20000 // 单位：分
+	defaultWorkerMinBalance = 2000  // 单位：分
+)
+
+// Withdraw 用户发起提现申请
+func (c *Ctl) Withdraw(userID uint, channel model.PayChannel, amount int64, account, name string) (*model.TWithdrawRequest, error) {
+
+	// 未指定渠道时默认微信支付
+	if len(channel) == 0 {
+		channel = model.ChannelWechat
+	}
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	r, err := c.WithdrawBalance(tx, userID, channel, amount, utils.GenerateUUID(), account, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	c.submitWithdraw(r)
+	return r, nil
+}
+
+// checkWithdrawLimits 校验每日及每月提现额度，返回需人工审批的原因，无需审批时为空。
+// 调用方需已锁定用户钱包，同一用户的提现申请串行统计
+func (c *Ctl) checkWithdrawLimits(tx *gorm.DB, user *model.TUser, amount int64) (string, error) {
+
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	daily, _, err := c.trade.SumWithdrawRequests(tx, user.ID, day)
+	if err != nil {
+		return "", err
+	}
+
+	if c.withdraw.DailyLimit > 0 && daily+amount > c.withdraw.DailyLimit {
+		return "", fmt.Errorf("超出每日提现额度，今日剩余 %d", c.withdraw.DailyLimit-daily)
+	}
+
+	monthly, _, err := c.trade.SumWithdrawRequests(tx, user.ID, month)
+	if err != nil {
+		return "", err
+	}
+
+	if c.withdraw.MonthlyLimit > 0 && monthly+amount > c.withdraw.MonthlyLimit {
+		return "", fmt.Errorf("超出每月提现额度，本月剩余 %d", c.withdraw.MonthlyLimit-monthly)
+	}
+
+	_, count, err := c.trade.SumWithdrawRequests(tx, user.ID, now.Add(-24*time.Hour))
+	if err != nil {
+		return "", err
+	}
+
+	reasons := make([]string, 0)
+	if amount > c.withdraw.AutoApproveAmount {
+		reasons = append(reasons, "金额超过自动审批额度")
+	}
+
+	if c.withdraw.FrequentCount > 0 && count >= c.withdraw.FrequentCount {
+		reasons = append(reasons, "24小时内提现次数过多")
+	}
+
+	if user.Credit < c.withdraw.MinCredit {
+		reasons = append(reasons, "信誉分过低")
+	}
+
+	return strings.Join(reasons, "；"), nil
+}
+
+// transferWithdraw 审批通过：创建 INIT 状态的转账记录，冻结资金转入渠道清算，
+// 事务提交后由 submitWithdraw 或转账查询任务提交渠道转账
+func (c *Ctl) transferWithdraw(tx *gorm.DB, user *model.TUser, r *model.TWithdrawRequest, reviewerID uint, remark string) error {
+
+	// 添加预转账记录
+	err := c.trade.AddTransferRecord(tx, r.Channel, user, r.Account, r.Name, r.Amount, r.TradeID)
+	if err != nil {
+		return err
+	}
+
+	// 记账：提现冻结 -> 渠道清算
+	_, err = c.trade.Post(tx, &trade.Entry{
+		TradeID: r.TradeID,
+		Type:    model.TypeWithdrawing,
+		Channel: r.Channel,
+		Lines:   []trade.Line{trade.WithdrawHold(-r.Amount), trade.Clearing(r.Channel, r.Amount)},
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.trade.ReviewWithdrawRequest(tx, r, model.WithdrawStateTransferred, reviewerID, remark)
+}
+
+// submitWithdraw 审批通过的提现立即提交渠道转账，失败时由转账查询任务按退避间隔重试
+func (c *Ctl) submitWithdraw(r *model.TWithdrawRequest) {
+
+	if r.State != model.WithdrawStateTransferred {
+		return
+	}
+
+	record, err := c.trade.GetTransferRecord(r.Channel, r.TradeID)
+	if err != nil {
+		c.Errorf("get transfer record failed, trade_id=%s, err=%s", r.TradeID, err.Error())
+		return
+	}
+
+	err = c.pollTransfer(record)
+	if err != nil {
+		c.Errorf("submit transfer failed, trade_id=%s, err=%s", r.TradeID, err.Error())
+	}
+}
+
+// ApproveWithdraw 管理员审批通过提现申请并发起转账
+func (c *Ctl) ApproveWithdraw(reviewerID, id uint, remark string) (*model.TWithdrawRequest, error) {
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	r, err := c.trade.LockWithdrawRequest(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.State != model.WithdrawStatePending {
+		err = fmt.Errorf("提现申请%s，不可审批", model.WithdrawStateCN[r.State])
+		return nil, err
+	}
+
+	var user *model.TUser
+	user, err = c.GetUser(r.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Frozen {
+		err = ErrUserFrozen
+		return nil, err
+	}
+
+	err = c.transferWithdraw(tx, user, r, reviewerID, remark)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	c.submitWithdraw(r)
+	return r, nil
+}
+
+// RejectWithdraw 管理员驳回提现申请，冻结的余额退回用户钱包
+func (c *Ctl) RejectWithdraw(reviewerID, id uint, reason string) (*model.TWithdrawRequest, error) {
+
+	var err error
+	tx := c.db.Begin()
+	defer func() {
+		if err != nil {
+			rErr := tx.Rollback().Error
+			if rErr != nil {
+				c.Errorf("tx rollback failed, err=%v", rErr)
+			}
+		}
+	}()
+
+	r, err := c.trade.LockWithdrawRequest(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.State != model.WithdrawStatePending {
+		err = fmt.Errorf("提现申请%s，不可审批", model.WithdrawStateCN[r.State])
+		return nil, err
+	}
+
+	err = c.trade.UpdateTradeRecordState(tx, r.TradeID, model.TypeWithdrawFail)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *model.TUser
+	user, err = c.lockWallet(tx, r.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记账：提现冻结 -> 用户钱包，生成提现退回交易记录
+	err = c.changeBalance(tx, user, r.Amount, &trade.Entry{
+		TradeID: r.TradeID,
+		Type:    model.TypeWithdrawRefund,
+		Remark:  "提现审批驳回：" + reason,
+		Channel: r.Channel,
+		Lines:   []trade.Line{trade.WithdrawHold(-r.Amount), trade.Wallet(r.UserID, r.Amount)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.trade.ReviewWithdrawRequest(tx, r, model.WithdrawStateRejected, reviewerID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,199 @@
+package wechat
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/consts"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/option"
+	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+
+	"github.com/mojiQAQ/dispatch/modules/payment"
+)
+
+// billURL 申请账单接口返回的下载地址及摘要
+type billURL struct {
+	HashType    string `json:"hash_type"`
+	HashValue   string `json:"hash_value"`
+	DownloadURL string `json:"download_url"`
+}
+
+// loadBillClient 账单文件下载应答不带签名，需使用跳过应答验签的客户端
+func loadBillClient(mchID, mchCertificateSerialNumber, pKey string) (*core.Client, error) {
+	mchPrivateKey, err := utils.LoadPrivateKeyWithPath(pKey)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []core.ClientOption{
+		option.WithMerchantCredential(mchID, mchCertificateSerialNumber, mchPrivateKey),
+		option.WithoutValidator(),
+	}
+	return core.NewClient(context.Background(), opts...)
+}
+
+// TradeBill 下载交易账单，当日无交易时返回空
+func (c *Pay) TradeBill(date string) ([]*payment.TradeBillRow, error) {
+
+	data, err := c.downloadBill("/v3/bill/tradebill?bill_type=ALL&bill_date=" + url.QueryEscape(date))
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := parseBill(data, "总交易单数")
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*payment.TradeBillRow, 0)
+	for _, r := range records {
+		amount := r["订单金额"]
+		if len(amount) == 0 {
+			amount = r["应结订单金额"]
+		}
+
+		rows = append(rows, &payment.TradeBillRow{
+			TransactionID: r["微信订单号"],
+			OutTradeNo:    r["商户订单号"],
+			TradeState:    r["交易状态"],
+			Amount:        yuanToFen(amount),
+			RefundID:      r["微信退款单号"],
+			OutRefundNo:   r["商户退款单号"],
+			RefundAmount:  yuanToFen(r["退款金额"]),
+			RefundState:   r["退款状态"],
+		})
+	}
+
+	return rows, nil
+}
+
+// FundFlowBill 下载基本账户资金账单，当日无资金变动时返回空
+func (c *Pay) FundFlowBill(date string) ([]*payment.FundFlowRow, error) {
+
+	data, err := c.downloadBill("/v3/bill/fundflowbill?account_type=BASIC&bill_date=" + url.QueryEscape(date))
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := parseBill(data, "资金流水总笔数")
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*payment.FundFlowRow, 0)
+	for _, r := range records {
+		rows = append(rows, &payment.FundFlowRow{
+			BizNo:     r["微信支付业务单号"],
+			FlowID:    r["资金流水单号"],
+			BizName:   r["业务名称"],
+			BizType:   r["业务类型"],
+			Direction: r["收支类型"],
+			Amount:    yuanToFen(r["收支金额(元)"]),
+			VoucherNo: r["业务凭证号"],
+			Remark:    r["备注"],
+		})
+	}
+
+	return rows, nil
+}
+
+// downloadBill 申请账单并下载账单文件，下载后按摘要校验文件完整性
+func (c *Pay) downloadBill(path string) ([]byte, error) {
+
+	ctx := context.Background()
+	result, err := c.wClient.Get(ctx, consts.WechatPayAPIServer+path)
+	if err != nil {
+		if core.IsAPIError(err, "NO_STATEMENT_EXIST") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer result.Response.Body.Close()
+
+	bill := &billURL{}
+	err = json.NewDecoder(result.Response.Body).Decode(bill)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := c.bClient.Get(ctx, bill.DownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Response.Body.Close()
+
+	data, err := io.ReadAll(file.Response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), bill.HashValue) {
+		return nil, fmt.Errorf("bill hash mismatch, expected=%s", bill.HashValue)
+	}
+
+	return data, nil
+}
+
+// parseBill 解析账单文件：首行为表头，明细字段以 ` 开头，summary 开头的行起为汇总信息
+func parseBill(data []byte, summary string) ([]map[string]string, error) {
+
+	rows := make([]map[string]string, 0)
+	if len(data) == 0 {
+		return rows, nil
+	}
+
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff")))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) > 0 && strings.HasPrefix(record[0], summary) {
+			break
+		}
+
+		row := make(map[string]string)
+		for i, v := range record {
+			if i < len(header) {
+				row[strings.TrimSpace(header[i])] = strings.TrimSpace(strings.TrimPrefix(v, "`"))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// yuanToFen 账单金额单位为元，转换为分
+func yuanToFen(s string) int64 {
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(math.Round(v * 100))
+}
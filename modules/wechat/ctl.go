@@ -7,8 +7,6 @@ import (
 	"git.ucloudadmin.com/unetworks/app/pkg/log"
 	"github.com/mojiQAQ/dispatch/model"
 	sts "github.com/tencentyun/qcloud-cos-sts-sdk/go"
-	"github.com/wechatpay-apiv3/wechatpay-go/core"
-	"github.com/wechatpay-apiv3/wechatpay-go/core/notify"
 	"net/http"
 	"time"
 )
@@ -19,9 +17,7 @@ type (
 		Conf   model.WXAuth
 		client *httpclient.HttpClient
 
-		token   *AccessToken
-		wClient *core.Client
-		handle  *notify.Handler
+		token *AccessToken
 	}
 
 	ErrInfo struct {
@@ -60,26 +56,13 @@ type (
 	}
 )
 
+// NewCtl 微信登录、手机号及 COS 临时密钥，支付能力见 Pay
 func NewCtl(logger *log.Logger, client *httpclient.HttpClient, cfg model.WXAuth) *Ctl {
-	ctl := &Ctl{
+	return &Ctl{
 		Logger: logger,
 		client: client,
 		Conf:   cfg,
 	}
-
-	wClient, err := loadPrivateKey(cfg.Mch.MchID, cfg.Mch.CertSN, cfg.Mch.APIV3Key, cfg.Mch.PrivateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	handle, err := certLoader(cfg.Mch.MchID, cfg.Mch.CertSN, cfg.Mch.APIV3Key, cfg.Mch.PrivateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	ctl.wClient = wClient
-	ctl.handle = handle
-	return ctl
 }
 
 func (c *Ctl) GetAuthKey(code string, role model.Role) (*AuthKey, error) {
@@ -2,6 +2,10 @@ package wechat
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+
+	"git.ucloudadmin.com/unetworks/app/pkg/log"
 	"github.com/wechatpay-apiv3/wechatpay-go/core"
 	"github.com/wechatpay-apiv3/wechatpay-go/core/auth/verifiers"
 	"github.com/wechatpay-apiv3/wechatpay-go/core/downloader"
@@ -9,11 +13,66 @@ import (
 	"github.com/wechatpay-apiv3/wechatpay-go/core/option"
 	"github.com/wechatpay-apiv3/wechatpay-go/services/payments"
 	"github.com/wechatpay-apiv3/wechatpay-go/services/payments/jsapi"
+	"github.com/wechatpay-apiv3/wechatpay-go/services/refunddomestic"
 	"github.com/wechatpay-apiv3/wechatpay-go/services/transferbatch"
 	"github.com/wechatpay-apiv3/wechatpay-go/utils"
-	"net/http"
+
+	"github.com/mojiQAQ/dispatch/model"
+	"github.com/mojiQAQ/dispatch/modules/payment"
 )
 
+// refundNotify 退款结果通知解密后的内容
+type refundNotify struct {
+	OutTradeNo   string `json:"out_trade_no"`
+	OutRefundNo  string `json:"out_refund_no"`
+	RefundID     string `json:"refund_id"`
+	RefundStatus string `json:"refund_status"`
+	Amount       struct {
+		Refund int64 `json:"refund"`
+	} `json:"amount"`
+}
+
+// Pay 微信支付 v3 支付渠道
+type Pay struct {
+	*log.Logger
+	Conf      model.WXAuth
+	notifyURL string
+	refundURL string
+
+	wClient *core.Client
+	bClient *core.Client
+	handle  *notify.Handler
+}
+
+// NewPay 加载商户私钥并初始化微信支付客户端，私钥缺失时返回错误
+func NewPay(logger *log.Logger, cfg model.WXAuth, notifyURL, refundURL string) (*Pay, error) {
+
+	wClient, err := loadPrivateKey(cfg.Mch.MchID, cfg.Mch.CertSN, cfg.Mch.APIV3Key, cfg.Mch.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bClient, err := loadBillClient(cfg.Mch.MchID, cfg.Mch.CertSN, cfg.Mch.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := certLoader(cfg.Mch.MchID, cfg.Mch.CertSN, cfg.Mch.APIV3Key, cfg.Mch.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pay{
+		Logger:    logger,
+		Conf:      cfg,
+		notifyURL: notifyURL,
+		refundURL: refundURL,
+		wClient:   wClient,
+		bClient:   bClient,
+		handle:    handle,
+	}, nil
+}
+
 func certLoader(mchID, mchCertificateSerialNumber, mchAPIv3Key, pKey string) (*notify.Handler, error) {
 	mchPrivateKey, err := utils.LoadPrivateKeyWithPath(pKey)
 	if err != nil {
@@ -47,22 +106,33 @@ func loadPrivateKey(mchID, mchCertificateSerialNumber, mchAPIv3Key, pKey string)
 	return core.NewClient(ctx, opts...)
 }
 
-func (c *Ctl) CreateWechatPrePayOrder(openID, tradeNo, desc string, amount int64) (*jsapi.PrepayWithRequestPaymentResponse, error) {
+func strValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (c *Pay) Name() string {
+	return payment.ProviderWechat
+}
+
+func (c *Pay) Prepay(req *payment.PrepayRequest) (*payment.PrepayResult, error) {
 
 	svc := jsapi.JsapiApiService{Client: c.wClient}
 	resp, _, err := svc.PrepayWithRequestPayment(context.Background(), jsapi.PrepayRequest{
 		Appid:       core.String(c.Conf.Publisher.AppID),
 		Mchid:       core.String(c.Conf.Mch.MchID),
-		Description: core.String(desc),
-		OutTradeNo:  core.String(tradeNo),
-		Attach:      core.String(tradeNo),
-		NotifyUrl:   core.String("https://www.todistribute.cn:7443/dispatch/wechat_prepay_callback"),
+		Description: core.String(req.Desc),
+		OutTradeNo:  core.String(req.TradeID),
+		Attach:      core.String(req.TradeID),
+		NotifyUrl:   core.String(c.notifyURL),
 		Amount: &jsapi.Amount{
-			Total:    core.Int64(amount),
+			Total:    core.Int64(req.Amount),
 			Currency: core.String("CNY"),
 		},
 		Payer: &jsapi.Payer{
-			Openid: core.String(openID),
+			Openid: core.String(req.OpenID),
 		},
 	})
 
@@ -70,36 +140,64 @@ func (c *Ctl) CreateWechatPrePayOrder(openID, tradeNo, desc string, amount int64
 		return nil, err
 	}
 
-	return resp, nil
+	return &payment.PrepayResult{
+		PrepayID:  strValue(resp.PrepayId),
+		NonceStr:  strValue(resp.NonceStr),
+		Package:   strValue(resp.Package),
+		SignType:  strValue(resp.SignType),
+		PaySign:   strValue(resp.PaySign),
+		Timestamp: strValue(resp.TimeStamp),
+	}, nil
 }
 
-func (c *Ctl) PrepayCallback(req *http.Request) (*payments.Transaction, error) {
+// ParseNotify 校验并解密支付通知，保留解密后的通知内容用于存档
+func (c *Pay) ParseNotify(req *http.Request) (*payment.Notification, error) {
 
 	transaction := new(payments.Transaction)
-	_, err := c.handle.ParseNotifyRequest(context.Background(), req, transaction)
+	notifyReq, err := c.handle.ParseNotifyRequest(context.Background(), req, transaction)
 	if err != nil {
 		return nil, err
 	}
 
-	return transaction, nil
+	n := &payment.Notification{
+		NotifyID:      notifyReq.ID,
+		EventType:     notifyReq.EventType,
+		OutTradeNo:    strValue(transaction.OutTradeNo),
+		TransactionID: strValue(transaction.TransactionId),
+		TradeState:    strValue(transaction.TradeState),
+	}
+
+	if transaction.Payer != nil {
+		n.PayerOpenID = strValue(transaction.Payer.Openid)
+	}
+
+	if transaction.Amount != nil && transaction.Amount.Total != nil {
+		n.Amount = *transaction.Amount.Total
+	}
+
+	if notifyReq.Resource != nil {
+		n.Payload = notifyReq.Resource.Plaintext
+	}
+
+	return n, nil
 }
 
-func (c *Ctl) TransferToWorker(openid, tradeID, desc string, amount int64) (*transferbatch.InitiateBatchTransferResponse, error) {
+func (c *Pay) Transfer(req *payment.TransferRequest) (*payment.TransferResult, error) {
 
 	svc := transferbatch.TransferBatchApiService{Client: c.wClient}
 	resp, _, err := svc.InitiateBatchTransfer(context.Background(), transferbatch.InitiateBatchTransferRequest{
 		Appid:       core.String(c.Conf.Worker.AppID),
-		OutBatchNo:  core.String(tradeID),
-		BatchName:   core.String(desc),
-		BatchRemark: core.String(desc),
-		TotalAmount: core.Int64(amount),
+		OutBatchNo:  core.String(req.TradeID),
+		BatchName:   core.String(req.Desc),
+		BatchRemark: core.String(req.Desc),
+		TotalAmount: core.Int64(req.Amount),
 		TotalNum:    core.Int64(1),
 		TransferDetailList: []transferbatch.TransferDetailInput{
 			{
-				OutDetailNo:    core.String(tradeID),
-				TransferAmount: core.Int64(amount),
-				TransferRemark: core.String(desc),
-				Openid:         core.String(openid),
+				OutDetailNo:    core.String(req.TradeID),
+				TransferAmount: core.Int64(req.Amount),
+				TransferRemark: core.String(req.Desc),
+				Openid:         core.String(req.Payee),
 			},
 		},
 		TransferSceneId: core.String("1001"),
@@ -108,20 +206,112 @@ func (c *Ctl) TransferToWorker(openid, tradeID, desc string, amount int64) (*tra
 		return nil, err
 	}
 
-	return resp, nil
+	return &payment.TransferResult{BatchID: strValue(resp.BatchId)}, nil
 }
 
-func (c *Ctl) CheckUnCompleteTransferOrder(tradeID string) (*transferbatch.TransferBatchEntity, error) {
+// QueryTransfer 查询转账批次，批次已生成明细时按明细单号查询明细状态
+func (c *Pay) QueryTransfer(tradeID string) (*payment.TransferStatus, error) {
+
+	ctx := context.Background()
 	svc := transferbatch.TransferBatchApiService{Client: c.wClient}
-	resp, _, err := svc.GetTransferBatchByOutNo(context.Background(),
+	resp, _, err := svc.GetTransferBatchByOutNo(ctx,
 		transferbatch.GetTransferBatchByOutNoRequest{
 			OutBatchNo:      core.String(tradeID),
 			NeedQueryDetail: core.Bool(false),
 		},
 	)
 	if err != nil {
+		if core.IsAPIError(err, "NOT_FOUND") {
+			return nil, payment.ErrTransferNotFound
+		}
 		return nil, err
 	}
 
-	return resp, nil
+	if resp.TransferBatch == nil {
+		return nil, fmt.Errorf("transfer batch %s not found", tradeID)
+	}
+
+	status := &payment.TransferStatus{
+		BatchStatus: strValue(resp.TransferBatch.BatchStatus),
+	}
+
+	if resp.TransferBatch.TotalAmount != nil {
+		status.Amount = *resp.TransferBatch.TotalAmount
+	}
+
+	// 批次受理中或已关闭时尚未生成明细
+	if status.BatchStatus == model.WxTransferStateACCEPTED || status.BatchStatus == model.WxTransferStateCLOSED {
+		status.FailReason = strValue((*string)(resp.TransferBatch.CloseReason))
+		return status, nil
+	}
+
+	// 提现批次仅包含一条明细，明细单号与批次单号相同
+	detailSvc := transferbatch.TransferDetailApiService{Client: c.wClient}
+	detail, _, err := detailSvc.GetTransferDetailByOutNo(ctx, transferbatch.GetTransferDetailByOutNoRequest{
+		OutBatchNo:  core.String(tradeID),
+		OutDetailNo: core.String(tradeID),
+	})
+	if err != nil {
+		if core.IsAPIError(err, "NOT_FOUND") {
+			return status, nil
+		}
+		return nil, err
+	}
+
+	status.DetailStatus = strValue(detail.DetailStatus)
+	if detail.FailReason != nil {
+		status.FailReason = string(*detail.FailReason)
+	}
+
+	return status, nil
+}
+
+func (c *Pay) Refund(req *payment.RefundRequest) (*payment.RefundResult, error) {
+
+	svc := refunddomestic.RefundsApiService{Client: c.wClient}
+	resp, _, err := svc.Create(context.Background(), refunddomestic.CreateRequest{
+		OutTradeNo:  core.String(req.TradeID),
+		OutRefundNo: core.String(req.RefundID),
+		Reason:      core.String(req.Reason),
+		NotifyUrl:   core.String(c.refundURL),
+		Amount: &refunddomestic.AmountReq{
+			Refund:   core.Int64(req.Amount),
+			Total:    core.Int64(req.Total),
+			Currency: core.String("CNY"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &payment.RefundResult{RefundID: strValue(resp.RefundId)}
+	if resp.Status != nil {
+		result.Status = string(*resp.Status)
+	}
+
+	return result, nil
+}
+
+// ParseRefundNotify 校验并解密退款结果通知
+func (c *Pay) ParseRefundNotify(req *http.Request) (*payment.RefundNotification, error) {
+
+	content := new(refundNotify)
+	notifyReq, err := c.handle.ParseNotifyRequest(context.Background(), req, content)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &payment.RefundNotification{
+		RefundID:         content.OutRefundNo,
+		ProviderRefundID: content.RefundID,
+		TradeID:          content.OutTradeNo,
+		Status:           content.RefundStatus,
+		Amount:           content.Amount.Refund,
+	}
+
+	if notifyReq.Resource != nil {
+		n.Payload = notifyReq.Resource.Plaintext
+	}
+
+	return n, nil
 }